@@ -6,11 +6,16 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/user"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"agent-workflow/backend/claude"
 	"agent-workflow/backend/config"
 	"agent-workflow/backend/models"
+	"agent-workflow/backend/redact"
+	"agent-workflow/backend/sandbox"
 	"agent-workflow/backend/services"
 	"agent-workflow/backend/store"
 
@@ -22,13 +27,22 @@ type App struct {
 	cfg *config.Config
 
 	// Stores
-	db         *store.DB
-	projects   *store.ProjectStore
-	agents     *store.AgentStore
-	teams      *store.TeamStore
-	tasks      *store.TaskStore
-	sessions   *store.SessionStore
-	mcpServers *store.MCPServerStore
+	db             *store.DB
+	dataDirLock    *services.DataDirLock
+	projects       *store.ProjectStore
+	agents         *store.AgentStore
+	teams          *store.TeamStore
+	tasks          *store.TaskStore
+	sessions       *store.SessionStore
+	mcpServers     *store.MCPServerStore
+	attempts       *store.TaskAttemptStore
+	comparisons    *store.TaskComparisonStore
+	reviewComments *store.ReviewCommentStore
+	messages       *store.TaskMessageStore
+	permRules      *store.PermissionRuleStore
+	auditLog       *store.AuditStore
+	streamLog      *store.StreamEventStore
+	search         *store.SearchStore
 
 	// Services
 	projectMgr     *services.ProjectManager
@@ -41,6 +55,10 @@ type App struct {
 	promptImprover *services.PromptImprover
 	mcpCatalog     *services.MCPCatalog
 	mcpHealth      *services.MCPHealthChecker
+	approvals      *services.ApprovalBroker
+	backups        *services.BackupManager
+	library        *services.LibraryPorter
+	stopBackups    context.CancelFunc
 
 	// Secure vault for API keys
 	vault *config.SecureVault
@@ -63,10 +81,26 @@ func (a *App) shutdown(ctx context.Context) {
 		a.taskEngine.StopAllSessions()
 	}
 
+	// Deny outstanding approval requests and close the broker endpoint
+	if a.approvals != nil {
+		a.approvals.Stop()
+	}
+
+	// Write stream events still queued for the database
+	if a.runner != nil {
+		a.runner.StopPersisting()
+	}
+
+	// Stop the backup schedule before the database goes away
+	if a.stopBackups != nil {
+		a.stopBackups()
+	}
+
 	// Close database
 	if a.db != nil {
 		a.db.Close()
 	}
+	a.dataDirLock.Release()
 
 	log.Println("Shutdown complete")
 }
@@ -81,6 +115,28 @@ func (a *App) startup(ctx context.Context) {
 	}
 	a.cfg = cfg
 
+	// Hold the data directory so the restore command cannot replace files under us
+	if lock, err := services.LockDataDir(cfg.DataDir); err != nil {
+		log.Printf("data directory lock: %v", err)
+	} else {
+		a.dataDirLock = lock
+	}
+
+	// A restore staged by RestoreBackup replaces the files before the database
+	// is opened, unless another process is using them
+	if a.dataDirLock == nil && services.HasPendingRestore(cfg.DataDir) {
+		log.Printf("restore backup postponed: the data directory is in use")
+	} else if restored, err := services.ApplyPendingRestore(cfg.DataDir, config.FilePath()); err != nil {
+		log.Printf("restore backup error: %v", err)
+	} else if restored {
+		if restoredCfg, err := config.Load(); err != nil {
+			log.Printf("config load error after restore: %v", err)
+		} else {
+			cfg = restoredCfg
+			a.cfg = cfg
+		}
+	}
+
 	db, err := store.NewDB(cfg.DataDir)
 	if err != nil {
 		log.Fatalf("database init error: %v", err)
@@ -94,29 +150,80 @@ func (a *App) startup(ctx context.Context) {
 	a.tasks = store.NewTaskStore(db)
 	a.sessions = store.NewSessionStore(db)
 	a.mcpServers = store.NewMCPServerStore(db)
+	a.attempts = store.NewTaskAttemptStore(db)
+	a.comparisons = store.NewTaskComparisonStore(db)
+	a.reviewComments = store.NewReviewCommentStore(db)
+	a.messages = store.NewTaskMessageStore(db)
+	a.permRules = store.NewPermissionRuleStore(db)
+	a.auditLog = store.NewAuditStore(db)
+	a.streamLog = store.NewStreamEventStore(db)
+	a.search = store.NewSearchStore(db)
 
 	// Init secure vault for API keys
 	vault, err := config.NewSecureVault(cfg.DataDir)
 	if err != nil {
 		log.Printf("vault init error: %v, starting with empty vault", err)
+		// Keep the unreadable file (e.g. after a hostname change) instead of overwriting it
+		if moved, mvErr := config.SetAsideVault(cfg.DataDir); mvErr == nil {
+			log.Printf("unreadable vault moved to %s", moved)
+		}
 		vault, _ = config.NewSecureVault(cfg.DataDir)
 	}
 	a.vault = vault
-	envVars := vault.Get()
+
+	if vault.Locked() {
+		log.Printf("vault is passphrase-protected; secrets are unavailable until it is unlocked")
+	} else {
+		a.migrateMCPSecrets()
+	}
+	redact.SetSecrets(vault.Values())
+
+	// Startup migrations above are attributed to the system, everything after to the OS user
+	db.SetAuditActor(auditActor())
 
 	// Init services
 	a.projectMgr = services.NewProjectManager(cfg.WorkspacePath)
-	a.runner = services.NewAgentRunner(cfg.ClaudeCLIPath, envVars)
+	a.runner = services.NewAgentRunner(cfg.ClaudeCLIPath, a.globalEnv())
+	a.runner.SetSecretStore(vault)
 	a.runner.SetWailsContext(ctx)
+	a.runner.SetEventStore(a.streamLog)
 	a.diffTracker = services.NewDiffTracker()
 	a.testRunner = services.NewTestRunner()
-	a.taskEngine = services.NewTaskEngine(a.tasks, a.sessions, a.agents, a.projects, a.mcpServers, a.teams, a.projectMgr, a.runner, a.diffTracker, a.testRunner)
+	a.taskEngine = services.NewTaskEngine(a.tasks, a.attempts, a.messages, a.sessions, a.agents, a.projects, a.mcpServers, a.teams, a.projectMgr, a.runner, a.diffTracker, a.testRunner)
+
+	// Restore CLAUDE.md/.mcp.json left injected by a previous crash before anything runs
+	managed := services.NewManagedFiles(filepath.Join(cfg.DataDir, "injections"), a.diffTracker)
+	managed.RecoverAll()
+	a.taskEngine.SetManagedFiles(managed)
+	a.taskEngine.SetSecretStore(vault)
+	a.taskEngine.SetAuditLog(a.auditLog)
+	a.taskEngine.SetComparisonStore(a.comparisons)
+	a.taskEngine.SetReviewCommentStore(a.reviewComments)
 	a.taskEngine.SetWailsContext(ctx)
+	a.taskEngine.RecoverComparisons()
+	if builtinMCP, err := services.NewBuiltinMCP(filepath.Join(cfg.DataDir, "mcp")); err != nil {
+		log.Printf("built-in MCP server disabled: %v", err)
+	} else {
+		a.taskEngine.SetBuiltinMCP(builtinMCP)
+		a.approvals = services.NewApprovalBroker(a.tasks, a.agents, a.permRules)
+		a.approvals.SetWailsContext(ctx)
+		if err := a.approvals.Start(); err != nil {
+			log.Printf("approval broker disabled, tool permissions will be skipped: %v", err)
+		} else {
+			a.taskEngine.SetApprovalBroker(a.approvals)
+		}
+	}
 	a.sessionMgr = services.NewSessionManager(a.sessions, a.tasks, a.projects, a.projectMgr, a.diffTracker)
-	a.planner = services.NewPlanner(envVars)
-	a.promptImprover = services.NewPromptImprover(envVars)
+	a.planner = services.NewPlanner(a.resolvedGlobalEnv())
+	a.promptImprover = services.NewPromptImprover(a.resolvedGlobalEnv())
 	a.mcpCatalog = services.NewMCPCatalog()
 	a.mcpHealth = services.NewMCPHealthChecker()
+	a.library = services.NewLibraryPorter(db, vault)
+
+	a.backups = services.NewBackupManager(db, cfg.DataDir, config.FilePath())
+	backupCtx, cancel := context.WithCancel(ctx)
+	a.stopBackups = cancel
+	go a.backups.RunSchedule(backupCtx, time.Duration(cfg.BackupIntervalHours)*time.Hour, cfg.BackupKeep)
 }
 
 // ─── Config ────────────────────────────────────────────
@@ -127,7 +234,12 @@ func (a *App) GetConfig() *config.Config {
 
 func (a *App) UpdateConfig(cfg config.Config) error {
 	a.cfg = &cfg
-	return a.cfg.Save()
+	if err := a.cfg.Save(); err != nil {
+		return err
+	}
+	// The global env scope lives in the config
+	a.propagateEnvVars()
+	return nil
 }
 
 // ─── Secure Vault (API Keys) ─────────────────────────
@@ -140,16 +252,188 @@ func (a *App) GetEnvVars() map[string]string {
 // UpdateEnvVars replaces all environment variables in the encrypted vault
 // and propagates changes to all running services.
 func (a *App) UpdateEnvVars(vars map[string]string) error {
+	previous := a.vault.Get()
 	if err := a.vault.Set(vars); err != nil {
 		return fmt.Errorf("save vault: %w", err)
 	}
-	// Propagate to live services
-	a.runner.SetEnvVars(vars)
-	a.planner.SetEnvVars(vars)
-	a.promptImprover.SetEnvVars(vars)
+	a.auditVaultKeys(previous, vars)
+	a.propagateEnvVars()
+	return nil
+}
+
+// auditVaultKeys records which vault keys were added, changed or removed.
+// Values are never logged.
+func (a *App) auditVaultKeys(previous, current map[string]string) {
+	for key, value := range current {
+		old, existed := previous[key]
+		switch {
+		case !existed:
+			a.audit(models.AuditActionCreate, models.AuditEntityVaultKey, key, "added vault key "+key, nil, map[string]string{"key": key})
+		case old != value:
+			a.audit(models.AuditActionUpdate, models.AuditEntityVaultKey, key, "changed vault key "+key, map[string]string{"key": key}, map[string]string{"key": key})
+		}
+	}
+	for key := range previous {
+		if _, kept := current[key]; !kept {
+			a.audit(models.AuditActionDelete, models.AuditEntityVaultKey, key, "removed vault key "+key, map[string]string{"key": key}, nil)
+		}
+	}
+}
+
+// GetVaultStatus reports how the vault is protected and whether it is locked.
+func (a *App) GetVaultStatus() config.VaultStatus {
+	return a.vault.Status()
+}
+
+// UnlockVault decrypts a passphrase-protected vault and makes its variables
+// available to all services.
+func (a *App) UnlockVault(passphrase string) error {
+	if err := a.vault.Unlock(passphrase); err != nil {
+		return err
+	}
+	a.migrateMCPSecrets()
+	a.propagateEnvVars()
+	log.Printf("vault unlocked")
+	return nil
+}
+
+// SetVaultPassphrase enables, changes or (with an empty next) removes the
+// master passphrase. current is required while a passphrase is set.
+func (a *App) SetVaultPassphrase(current, next string) error {
+	before := a.vault.Status().Mode
+	if err := a.vault.SetPassphrase(current, next); err != nil {
+		return fmt.Errorf("set vault passphrase: %w", err)
+	}
+	after := a.vault.Status().Mode
+	a.audit(models.AuditActionSetPassphrase, models.AuditEntityVault, "", "vault protection set to "+after,
+		map[string]string{"mode": before}, map[string]string{"mode": after})
+	return nil
+}
+
+// RotateVaultKey re-encrypts the vault under a newly derived key.
+func (a *App) RotateVaultKey(passphrase string) error {
+	if err := a.vault.RotateKey(passphrase); err != nil {
+		return fmt.Errorf("rotate vault key: %w", err)
+	}
+	a.audit(models.AuditActionRotateKey, models.AuditEntityVault, "", "rotated vault key", nil, nil)
 	return nil
 }
 
+// ExportVault writes the vault's variables, encrypted with passphrase, to a
+// file chosen by the user, for import on another machine.
+func (a *App) ExportVault(passphrase string) (string, error) {
+	data, err := a.vault.Export(passphrase)
+	if err != nil {
+		return "", fmt.Errorf("export vault: %w", err)
+	}
+	path, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		Title:           "Export Vault",
+		DefaultFilename: "shannon-vault.json",
+	})
+	if err != nil || path == "" {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("write export: %w", err)
+	}
+	a.audit(models.AuditActionExport, models.AuditEntityVault, "", "exported vault to "+path, nil, map[string]string{"path": path})
+	return path, nil
+}
+
+// ImportVault reads a bundle made by ExportVault from a file chosen by the
+// user. With replace, the current variables are discarded; otherwise the
+// bundle is merged in. Returns the number of variables imported.
+func (a *App) ImportVault(passphrase string, replace bool) (int, error) {
+	path, err := runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title: "Import Vault",
+	})
+	if err != nil || path == "" {
+		return 0, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("read bundle: %w", err)
+	}
+	previous := a.vault.Get()
+	n, err := a.vault.Import(data, passphrase, replace)
+	if err != nil {
+		return 0, fmt.Errorf("import vault: %w", err)
+	}
+	a.audit(models.AuditActionImport, models.AuditEntityVault, "", fmt.Sprintf("imported %d vault keys from %s", n, path),
+		nil, map[string]any{"path": path, "replace": replace, "count": n})
+	a.auditVaultKeys(previous, a.vault.Get())
+	a.propagateEnvVars()
+	return n, nil
+}
+
+// migrateMCPSecrets moves plaintext MCP server secrets out of the database into the vault.
+func (a *App) migrateMCPSecrets() {
+	if n, err := services.MigrateMCPSecrets(a.mcpServers, a.vault); err != nil {
+		log.Printf("mcp secret migration: %v", err)
+	} else if n > 0 {
+		log.Printf("mcp secret migration: moved secrets of %d server(s) into the vault", n)
+	}
+}
+
+// propagateEnvVars pushes the global env scope to all running services and
+// the vault's values to the redaction filter.
+func (a *App) propagateEnvVars() {
+	redact.SetSecrets(a.vault.Values())
+	a.runner.SetEnvVars(a.globalEnv())
+	resolved := a.resolvedGlobalEnv()
+	a.planner.SetEnvVars(resolved)
+	a.promptImprover.SetEnvVars(resolved)
+}
+
+// globalEnv returns the global env scope: every vault entry (when
+// VaultEnvGlobal is set) plus the configured global variables, with vault
+// references left unresolved. Secrets of MCP servers are never exposed this
+// way; they only reach the agents that use those servers.
+func (a *App) globalEnv() map[string]string {
+	env := make(map[string]string)
+	if a.cfg.VaultEnvGlobal {
+		servers, err := a.mcpServers.List()
+		if err != nil {
+			log.Printf("global env: not exposing vault entries, MCP servers unavailable: %v", err)
+		} else {
+			mcpKeys := services.MCPVaultKeys(servers)
+			for _, key := range a.vault.GetKeys() {
+				if !mcpKeys[key] {
+					env[key] = services.VaultRefPrefix + key
+				}
+			}
+		}
+	}
+	for name, value := range a.cfg.GlobalEnv {
+		env[name] = value
+	}
+	return env
+}
+
+// resolvedGlobalEnv resolves the global scope for services that run Claude
+// outside of a task (planner, prompt improver). Dangling references are skipped.
+func (a *App) resolvedGlobalEnv() map[string]string {
+	env := make(map[string]string)
+	for name, value := range a.globalEnv() {
+		if key, ok := services.VaultRef(value); ok {
+			secret, found := a.vault.Lookup(key)
+			if !found {
+				continue
+			}
+			value = secret
+		}
+		env[name] = value
+	}
+	return env
+}
+
+// GetTaskEnv lists the environment variable names a run of the task would get
+// and the scope each comes from (global, project, agent or task). Values are
+// never returned.
+func (a *App) GetTaskEnv(taskID string) ([]services.EffectiveEnvVar, error) {
+	return a.taskEngine.InspectTaskEnv(taskID)
+}
+
 // ─── Project ───────────────────────────────────────────
 
 func (a *App) ListProjects() ([]models.Project, error) {
@@ -160,7 +444,16 @@ func (a *App) ListProjectsPaginated(page, pageSize int) (*models.PaginatedRespon
 	return a.projects.ListPaginated(page, pageSize)
 }
 
+// QueryProjects returns a filtered, sorted page of projects; pass the
+// previous page's NextCursor in q.Cursor for the next one.
+func (a *App) QueryProjects(q store.ListQuery) (*store.ListPage, error) {
+	return a.projects.Query(q)
+}
+
 func (a *App) CreateProject(p models.Project) (*models.Project, error) {
+	if err := services.ValidateSecretRules(p.SecretRules); err != nil {
+		return nil, err
+	}
 	if err := a.projects.Create(&p); err != nil {
 		return nil, err
 	}
@@ -168,6 +461,9 @@ func (a *App) CreateProject(p models.Project) (*models.Project, error) {
 }
 
 func (a *App) UpdateProject(p models.Project) error {
+	if err := services.ValidateSecretRules(p.SecretRules); err != nil {
+		return err
+	}
 	return a.projects.Update(&p)
 }
 
@@ -191,6 +487,10 @@ func (a *App) ListAgentsPaginated(page, pageSize int) (*models.PaginatedResponse
 	return a.agents.ListPaginated(page, pageSize)
 }
 
+func (a *App) QueryAgents(q store.ListQuery) (*store.ListPage, error) {
+	return a.agents.Query(q)
+}
+
 func (a *App) GetAgent(id string) (*models.Agent, error) {
 	return a.agents.GetByID(id)
 }
@@ -206,10 +506,42 @@ func (a *App) UpdateAgent(agent models.Agent) error {
 	return a.agents.Update(&agent)
 }
 
+// DeleteAgent deletes an agent unless pending, running or waiting tasks are
+// assigned to it. Its permission rules go with it; finished tasks keep their
+// history without the agent.
 func (a *App) DeleteAgent(id string) error {
+	active, err := a.tasks.CountActiveByAgent(id)
+	if err != nil {
+		return err
+	}
+	if active > 0 {
+		return fmt.Errorf("agent is assigned to %d unfinished task(s); reassign or finish them first, or force the delete", active)
+	}
+	return a.agents.Delete(id)
+}
+
+// ForceDeleteAgent deletes an agent even if unfinished tasks use it. Those
+// tasks lose their agent and get one picked when they run.
+func (a *App) ForceDeleteAgent(id string) error {
 	return a.agents.Delete(id)
 }
 
+// SandboxStatus reports whether agent sandbox profiles can be enforced on this machine.
+type SandboxStatus struct {
+	Available bool   `json:"available"`
+	Backend   string `json:"backend,omitempty"` // "bwrap" or "native"
+	Error     string `json:"error,omitempty"`
+}
+
+// GetSandboxStatus tells the UI which sandbox backend agents with a sandbox profile will use.
+func (a *App) GetSandboxStatus() SandboxStatus {
+	backend, err := sandbox.Backend()
+	if err != nil {
+		return SandboxStatus{Error: err.Error()}
+	}
+	return SandboxStatus{Available: true, Backend: backend}
+}
+
 // SeedExampleAgents creates pre-configured complex agents that use
 // the currently installed MCP servers. Auto-detects available MCP servers
 // and creates specialized agents with full feature usage: disallowed tools,
@@ -1056,23 +1388,53 @@ func (a *App) ListMCPServers() ([]models.MCPServer, error) {
 	return a.mcpServers.List()
 }
 
+// QueryMCPServers returns a page of servers; q.AgentID limits it to the
+// servers assigned to that agent.
+func (a *App) QueryMCPServers(q store.ListQuery) (*store.ListPage, error) {
+	return a.mcpServers.Query(q)
+}
+
 func (a *App) GetMCPServer(id string) (*models.MCPServer, error) {
 	return a.mcpServers.GetByID(id)
 }
 
 func (a *App) CreateMCPServer(server models.MCPServer) (*models.MCPServer, error) {
+	if err := a.externalizeMCPSecrets(&server); err != nil {
+		return nil, err
+	}
 	if err := a.mcpServers.Create(&server); err != nil {
 		return nil, err
 	}
+	a.propagateEnvVars() // the vault keys servers use are kept out of the global scope
 	return &server, nil
 }
 
 func (a *App) UpdateMCPServer(server models.MCPServer) error {
-	return a.mcpServers.Update(&server)
+	if err := a.externalizeMCPSecrets(&server); err != nil {
+		return err
+	}
+	if err := a.mcpServers.Update(&server); err != nil {
+		return err
+	}
+	a.propagateEnvVars()
+	return nil
+}
+
+// externalizeMCPSecrets stores literal secrets from the server's env in the
+// vault and replaces them with "vault:KEY" references before it is saved.
+func (a *App) externalizeMCPSecrets(server *models.MCPServer) error {
+	if _, err := services.ExternalizeMCPSecrets(server, a.vault); err != nil {
+		return fmt.Errorf("move secrets to vault: %w", err)
+	}
+	return nil
 }
 
 func (a *App) DeleteMCPServer(id string) error {
-	return a.mcpServers.Delete(id)
+	if err := a.mcpServers.Delete(id); err != nil {
+		return err
+	}
+	a.propagateEnvVars()
+	return nil
 }
 
 // ─── MCP Catalog (Smithery Registry) ──────────────────
@@ -1088,7 +1450,11 @@ func (a *App) GetMCPInstallConfig(qualifiedName string) *services.InstallConfig
 // ─── MCP Health Check ─────────────────────────────────
 
 func (a *App) TestMCPServer(command string, args []string, env map[string]string) *services.MCPHealthResult {
-	return a.mcpHealth.Check(command, args, env)
+	resolved, err := services.ResolveVaultRefs(env, a.vault)
+	if err != nil {
+		return &services.MCPHealthResult{Error: err.Error()}
+	}
+	return a.mcpHealth.Check(command, args, resolved)
 }
 
 // ─── MCP JSON Import ──────────────────────────────────
@@ -1125,7 +1491,7 @@ func (a *App) ImportMCPFromClaude() (string, error) {
 	}
 
 	var claudeConfig struct {
-		MCPServers map[string]claudeServerEntry            `json:"mcpServers"`
+		MCPServers map[string]claudeServerEntry `json:"mcpServers"`
 		Projects   map[string]struct {
 			MCPServers map[string]claudeServerEntry `json:"mcpServers"`
 		} `json:"projects"`
@@ -1218,6 +1584,7 @@ func (a *App) SyncMCPFromJson(jsonStr string) error {
 
 		if ex, ok := existingMap[entry.ServerKey]; ok {
 			// Update existing
+			previous := ex.Env
 			ex.Command = entry.Command
 			ex.Args = entry.Args
 			if entry.Env != nil {
@@ -1225,7 +1592,20 @@ func (a *App) SyncMCPFromJson(jsonStr string) error {
 			} else {
 				ex.Env = make(map[string]string)
 			}
+			// A redacted export round-trips without losing the stored secret
+			for k, v := range ex.Env {
+				if v == services.RedactedValue {
+					if old, ok := previous[k]; ok {
+						ex.Env[k] = old
+					} else {
+						delete(ex.Env, k)
+					}
+				}
+			}
 			ex.Enabled = true
+			if err := a.externalizeMCPSecrets(&ex); err != nil {
+				return fmt.Errorf("update server %s: %w", entry.ServerKey, err)
+			}
 			if err := a.mcpServers.Update(&ex); err != nil {
 				return fmt.Errorf("update server %s: %w", entry.ServerKey, err)
 			}
@@ -1235,6 +1615,11 @@ func (a *App) SyncMCPFromJson(jsonStr string) error {
 			if env == nil {
 				env = make(map[string]string)
 			}
+			for k, v := range env {
+				if v == services.RedactedValue {
+					delete(env, k)
+				}
+			}
 			srv := models.MCPServer{
 				Name:      entry.ServerKey,
 				ServerKey: entry.ServerKey,
@@ -1243,6 +1628,9 @@ func (a *App) SyncMCPFromJson(jsonStr string) error {
 				Env:       env,
 				Enabled:   true,
 			}
+			if err := a.externalizeMCPSecrets(&srv); err != nil {
+				return fmt.Errorf("create server %s: %w", entry.ServerKey, err)
+			}
 			if err := a.mcpServers.Create(&srv); err != nil {
 				return fmt.Errorf("create server %s: %w", entry.ServerKey, err)
 			}
@@ -1262,6 +1650,7 @@ func (a *App) SyncMCPFromJson(jsonStr string) error {
 }
 
 // ExportMCPJson exports all MCP servers from the DB as a .mcp.json format string.
+// Vault references are exported as-is; literal secrets are redacted.
 func (a *App) ExportMCPJson() (string, error) {
 	servers, err := a.mcpServers.List()
 	if err != nil {
@@ -1285,10 +1674,7 @@ func (a *App) ExportMCPJson() (string, error) {
 		if args == nil {
 			args = []string{}
 		}
-		env := srv.Env
-		if env == nil {
-			env = map[string]string{}
-		}
+		env := services.RedactMCPEnv(srv.Env)
 		mcpConfig.MCPServers[srv.ServerKey] = mcpEntry{
 			Command: srv.Command,
 			Args:    args,
@@ -1314,6 +1700,12 @@ func (a *App) ListTeamsPaginated(page, pageSize int) (*models.PaginatedResponse,
 	return a.teams.ListPaginated(page, pageSize)
 }
 
+// QueryTeams returns a page of teams; q.AgentID limits it to teams with
+// that member.
+func (a *App) QueryTeams(q store.ListQuery) (*store.ListPage, error) {
+	return a.teams.Query(q)
+}
+
 func (a *App) GetTeam(id string) (*models.Team, error) {
 	return a.teams.GetByID(id)
 }
@@ -1333,6 +1725,130 @@ func (a *App) DeleteTeam(id string) error {
 	return a.teams.Delete(id)
 }
 
+// ─── Library Bundles ─────────────────────────────────
+
+// ExportAgentBundle saves an agent and its MCP servers as a bundle ("json"
+// or "yaml") chosen in a save dialog. Secrets are left out.
+func (a *App) ExportAgentBundle(agentID, format string) (string, error) {
+	b, err := a.library.ExportAgent(agentID)
+	if err != nil {
+		return "", err
+	}
+	return a.saveBundle(b, "agent-"+bundleFileName(b.Agents[0].Name), format)
+}
+
+// ExportTeamBundle saves a team with its agents and their MCP servers.
+func (a *App) ExportTeamBundle(teamID, format string) (string, error) {
+	b, err := a.library.ExportTeam(teamID)
+	if err != nil {
+		return "", err
+	}
+	return a.saveBundle(b, "team-"+bundleFileName(b.Teams[0].Name), format)
+}
+
+// ExportLibraryBundle saves every agent, team and MCP server.
+func (a *App) ExportLibraryBundle(format string) (string, error) {
+	b, err := a.library.ExportLibrary()
+	if err != nil {
+		return "", err
+	}
+	return a.saveBundle(b, "shannon-library", format)
+}
+
+func (a *App) saveBundle(b *services.LibraryBundle, name, format string) (string, error) {
+	if format == "" {
+		format = "json"
+	}
+	data, err := services.EncodeBundle(b, format)
+	if err != nil {
+		return "", err
+	}
+	path, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		Title:           "Export Bundle",
+		DefaultFilename: name + "." + format,
+	})
+	if err != nil || path == "" {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("write bundle: %w", err)
+	}
+	a.audit(models.AuditActionExport, models.AuditEntityLibrary, "",
+		fmt.Sprintf("exported %d agents, %d teams, %d MCP servers to %s", len(b.Agents), len(b.Teams), len(b.MCPServers), path),
+		nil, map[string]string{"path": path})
+	return path, nil
+}
+
+// bundleFileName turns a display name into a file name stem.
+func bundleFileName(name string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune('-')
+		}
+	}
+	return strings.Trim(sb.String(), "-")
+}
+
+// LibraryImportPreview is a parsed bundle's import plan.
+type LibraryImportPreview struct {
+	Path string               `json:"path"`
+	Plan *services.ImportPlan `json:"plan"`
+}
+
+// PreviewLibraryImport reads a bundle (chosen in a dialog when path is empty)
+// and lists its items with name conflicts, for choosing per item whether to
+// merge, replace or skip.
+func (a *App) PreviewLibraryImport(path string) (*LibraryImportPreview, error) {
+	if path == "" {
+		var err error
+		path, err = runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+			Title:   "Import Bundle",
+			Filters: []runtime.FileFilter{{DisplayName: "Bundles", Pattern: "*.json;*.yaml;*.yml"}},
+		})
+		if err != nil || path == "" {
+			return nil, err
+		}
+	}
+	b, err := readBundle(path)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := a.library.PlanImport(b)
+	if err != nil {
+		return nil, err
+	}
+	return &LibraryImportPreview{Path: path, Plan: plan}, nil
+}
+
+// ImportLibraryBundle imports a previewed bundle. choices maps plan item keys
+// to actions; items left out get the plan's default.
+func (a *App) ImportLibraryBundle(path string, choices map[string]services.ImportAction) (*services.ImportResult, error) {
+	b, err := readBundle(path)
+	if err != nil {
+		return nil, err
+	}
+	result, err := a.library.Import(b, choices)
+	if result != nil {
+		a.propagateEnvVars() // literal secrets may have moved into the vault
+		a.audit(models.AuditActionImport, models.AuditEntityLibrary, "",
+			fmt.Sprintf("imported bundle %s: %d created, %d merged, %d replaced, %d skipped", path, result.Created, result.Merged, result.Replaced, result.Skipped),
+			nil, result)
+	}
+	return result, err
+}
+
+func readBundle(path string) (*services.LibraryBundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read bundle: %w", err)
+	}
+	return services.DecodeBundle(data)
+}
+
 // ─── Session ───────────────────────────────────────────
 
 func (a *App) ListSessions() ([]models.Session, error) {
@@ -1343,6 +1859,10 @@ func (a *App) ListSessionsPaginated(page, pageSize int) (*models.PaginatedRespon
 	return a.sessions.ListPaginated(page, pageSize)
 }
 
+func (a *App) QuerySessions(q store.ListQuery) (*store.ListPage, error) {
+	return a.sessions.Query(q)
+}
+
 func (a *App) GetSession(id string) (*models.Session, error) {
 	return a.sessions.GetByID(id)
 }
@@ -1354,6 +1874,28 @@ func (a *App) CreateSession(sess models.Session) (*models.Session, error) {
 	return &sess, nil
 }
 
+// CloneSession copies a session's tasks, with remapped dependencies, into a
+// new planning session, optionally assigning every task to another agent.
+func (a *App) CloneSession(sessionID string, opts services.CloneOptions) (*models.Session, error) {
+	sess, err := a.sessionMgr.CloneSession(sessionID, opts)
+	if err != nil {
+		return nil, err
+	}
+	a.audit(models.AuditActionClone, models.AuditEntitySession, sess.ID, fmt.Sprintf("cloned session %s as %q", sessionID, sess.Name), nil, sess)
+	return sess, nil
+}
+
+// ForkSessionFromTask creates a new session that starts from the project
+// state after the given task completed.
+func (a *App) ForkSessionFromTask(taskID, name string) (*models.Session, error) {
+	sess, err := a.sessionMgr.ForkFromTask(taskID, name)
+	if err != nil {
+		return nil, err
+	}
+	a.audit(models.AuditActionFork, models.AuditEntitySession, sess.ID, fmt.Sprintf("forked session %q from task %s", sess.Name, taskID), nil, sess)
+	return sess, nil
+}
+
 func (a *App) ListSessionsByProject(projectID string) ([]models.Session, error) {
 	return a.sessions.ListByProject(projectID)
 }
@@ -1361,6 +1903,9 @@ func (a *App) ListSessionsByProject(projectID string) ([]models.Session, error)
 func (a *App) DeleteSession(id string) error {
 	// Cleanup workspaces when deleting a session
 	a.projectMgr.CleanupSession(id)
+	if err := a.sessionMgr.DeleteSessionSnapshots(id); err != nil {
+		log.Printf("session %s: failed to delete snapshots: %v", id, err)
+	}
 	return a.sessions.Delete(id)
 }
 
@@ -1370,11 +1915,20 @@ func (a *App) ListTasks(sessionID string) ([]models.Task, error) {
 	return a.tasks.ListBySession(sessionID)
 }
 
+// QueryTasks returns a page of tasks across sessions, e.g. every failed task
+// of a project this week, newest first.
+func (a *App) QueryTasks(q store.ListQuery) (*store.ListPage, error) {
+	return a.tasks.Query(q)
+}
+
 func (a *App) GetTask(id string) (*models.Task, error) {
 	return a.tasks.GetByID(id)
 }
 
 func (a *App) CreateTask(task models.Task) (*models.Task, error) {
+	if err := a.checkTaskRefs(&task); err != nil {
+		return nil, err
+	}
 	if err := a.tasks.Create(&task); err != nil {
 		return nil, err
 	}
@@ -1382,29 +1936,228 @@ func (a *App) CreateTask(task models.Task) (*models.Task, error) {
 }
 
 func (a *App) UpdateTask(task models.Task) error {
+	if err := a.checkTaskRefs(&task); err != nil {
+		return err
+	}
 	return a.tasks.Update(&task)
 }
 
+// DeleteTask deletes a task; its attempts and messages are deleted with it,
+// and so is its snapshot.
 func (a *App) DeleteTask(id string) error {
+	if err := a.sessionMgr.DeleteTaskSnapshot(id); err != nil {
+		log.Printf("task %s: failed to delete snapshot: %v", id, err)
+	}
 	return a.tasks.Delete(id)
 }
 
+// checkTaskRefs turns a reference to a missing session, agent, team or
+// reviewer into a clear error instead of a foreign key failure.
+func (a *App) checkTaskRefs(task *models.Task) error {
+	if _, err := a.sessions.GetByID(task.SessionID); err != nil {
+		return fmt.Errorf("session %q not found", task.SessionID)
+	}
+	if task.AgentID != "" {
+		if _, err := a.agents.GetByID(string(task.AgentID)); err != nil {
+			return fmt.Errorf("agent %q not found", task.AgentID)
+		}
+	}
+	if task.TeamID != "" {
+		if _, err := a.teams.GetByID(string(task.TeamID)); err != nil {
+			return fmt.Errorf("team %q not found", task.TeamID)
+		}
+	}
+	if task.ReviewerAgentID != "" {
+		if _, err := a.agents.GetByID(string(task.ReviewerAgentID)); err != nil {
+			return fmt.Errorf("reviewer agent %q not found", task.ReviewerAgentID)
+		}
+	}
+	return nil
+}
+
+// ─── Task Attempts ───────────────────────────────────
+
+// ListTaskAttempts returns every recorded run of a task, oldest first.
+func (a *App) ListTaskAttempts(taskID string) ([]models.TaskAttempt, error) {
+	return a.attempts.ListByTask(taskID)
+}
+
+func (a *App) QueryTaskAttempts(q store.ListQuery) (*store.ListPage, error) {
+	return a.attempts.Query(q)
+}
+
+func (a *App) GetTaskAttempt(id string) (*models.TaskAttempt, error) {
+	return a.attempts.GetByID(id)
+}
+
+// CompareTaskAttempts compares attempt B against attempt A (files, duration, tokens, test/build outcome).
+func (a *App) CompareTaskAttempts(attemptAID string, attemptBID string) (*services.AttemptComparison, error) {
+	attemptA, err := a.attempts.GetByID(attemptAID)
+	if err != nil {
+		return nil, fmt.Errorf("attempt %s not found: %w", attemptAID, err)
+	}
+	attemptB, err := a.attempts.GetByID(attemptBID)
+	if err != nil {
+		return nil, fmt.Errorf("attempt %s not found: %w", attemptBID, err)
+	}
+	if attemptA.TaskID != attemptB.TaskID {
+		return nil, fmt.Errorf("attempts belong to different tasks")
+	}
+	return services.CompareAttempts(attemptA, attemptB), nil
+}
+
+// ─── A/B Comparisons ─────────────────────────────────
+
+// StartTaskComparison runs a task's prompt against several agents or models
+// at once, each in its own git worktree. Progress is reported through
+// "task:comparison" events; the task is unchanged until a winner is applied.
+func (a *App) StartTaskComparison(req services.ComparisonRequest) (*models.TaskComparison, error) {
+	cmp, err := a.taskEngine.StartComparison(req)
+	if err != nil {
+		return nil, err
+	}
+	a.audit(models.AuditActionCompare, models.AuditEntityTask, req.TaskID, fmt.Sprintf("started comparison of %d variants", len(req.Variants)), nil, req)
+	return cmp, nil
+}
+
+// GetTaskComparison returns a comparison with its variants, best first.
+func (a *App) GetTaskComparison(id string) (*services.ComparisonReport, error) {
+	return a.taskEngine.GetComparison(id)
+}
+
+func (a *App) ListTaskComparisons(taskID string) ([]models.TaskComparison, error) {
+	return a.comparisons.ListByTask(taskID)
+}
+
+// ApplyComparisonWinner applies the chosen variant's changes to the project
+// and discards the others.
+func (a *App) ApplyComparisonWinner(comparisonID, attemptID string) error {
+	if err := a.taskEngine.ApplyComparisonWinner(comparisonID, attemptID); err != nil {
+		return err
+	}
+	if cmp, err := a.comparisons.GetByID(comparisonID); err == nil {
+		a.audit(models.AuditActionApplyVariant, models.AuditEntityTask, cmp.TaskID, fmt.Sprintf("applied variant %s of comparison %s", attemptID, comparisonID), nil, nil)
+	}
+	return nil
+}
+
+// DiscardComparison drops every variant, cancelling them if still running.
+func (a *App) DiscardComparison(comparisonID string) error {
+	return a.taskEngine.DiscardComparison(comparisonID)
+}
+
+// ─── Code Review ─────────────────────────────────────
+
+// SetSessionCodeReview has reviewerID review the changes of every task in the
+// session, sending its comments back to the implementer up to rounds times.
+// An empty reviewerID turns session code review off; a task's team option
+// then applies.
+func (a *App) SetSessionCodeReview(sessionID, reviewerID string, rounds int) error {
+	sess, err := a.sessions.GetByID(sessionID)
+	if err != nil {
+		return fmt.Errorf("session not found: %w", err)
+	}
+	if reviewerID != "" {
+		if _, err := a.agents.GetByID(reviewerID); err != nil {
+			return fmt.Errorf("agent %q not found", reviewerID)
+		}
+	}
+	if rounds < 0 {
+		rounds = 0
+	}
+	before := *sess
+	sess.CodeReviewerID, sess.CodeReviewRounds = models.OptionalRef(reviewerID), rounds
+	if err := a.sessions.Update(sess); err != nil {
+		return err
+	}
+	a.audit(models.AuditActionUpdate, models.AuditEntitySession, sessionID, "updated session code review", before, sess)
+	return nil
+}
+
+// ListTaskReviewComments returns the code review comments on a task's
+// changes, by round, file and hunk.
+func (a *App) ListTaskReviewComments(taskID string) ([]models.ReviewComment, error) {
+	return a.reviewComments.ListByTask(taskID)
+}
+
+// ─── Tool Approvals ──────────────────────────────────
+
+// ListPendingApprovals returns tool calls currently waiting for the user's decision.
+func (a *App) ListPendingApprovals() []services.ApprovalRequest {
+	if a.approvals == nil {
+		return []services.ApprovalRequest{}
+	}
+	return a.approvals.ListPending()
+}
+
+// RespondToApproval allows or denies a pending tool call. With remember set, the
+// decision is stored as a permission rule for the agent and applied to future calls.
+func (a *App) RespondToApproval(requestID string, allow bool, remember bool, message string) error {
+	if a.approvals == nil {
+		return fmt.Errorf("approval broker is not running")
+	}
+	return a.approvals.Respond(requestID, services.ApprovalResponse{Allow: allow, Remember: remember, Message: message})
+}
+
+func (a *App) ListAgentPermissionRules(agentID string) ([]models.AgentPermissionRule, error) {
+	return a.permRules.ListByAgent(agentID)
+}
+
+func (a *App) CreateAgentPermissionRule(rule models.AgentPermissionRule) (*models.AgentPermissionRule, error) {
+	if rule.Pattern == "" {
+		return nil, fmt.Errorf("pattern is required")
+	}
+	if rule.Decision != models.PermissionAllow && rule.Decision != models.PermissionDeny {
+		return nil, fmt.Errorf("decision must be %q or %q", models.PermissionAllow, models.PermissionDeny)
+	}
+	if _, err := a.agents.GetByID(rule.AgentID); err != nil {
+		return nil, fmt.Errorf("agent not found: %w", err)
+	}
+	if err := a.permRules.Create(&rule); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (a *App) DeleteAgentPermissionRule(id string) error {
+	return a.permRules.Delete(id)
+}
+
 // ─── Execution ─────────────────────────────────────────
 
 func (a *App) StartSession(sessionID string) error {
-	return a.taskEngine.StartSession(sessionID)
+	if err := a.taskEngine.StartSession(sessionID); err != nil {
+		return err
+	}
+	a.audit(models.AuditActionStart, models.AuditEntitySession, sessionID, "started session", nil, nil)
+	return nil
 }
 
 func (a *App) StopSession(sessionID string) error {
-	return a.taskEngine.StopSession(sessionID)
+	if err := a.taskEngine.StopSession(sessionID); err != nil {
+		return err
+	}
+	a.audit(models.AuditActionStop, models.AuditEntitySession, sessionID, "stopped session", nil, nil)
+	return nil
 }
 
 func (a *App) CompleteSession(sessionID string) error {
-	return a.taskEngine.CompleteSession(sessionID)
+	if err := a.taskEngine.CompleteSession(sessionID); err != nil {
+		return err
+	}
+	a.audit(models.AuditActionComplete, models.AuditEntitySession, sessionID, "completed session", nil, nil)
+	return nil
 }
 
 func (a *App) StopTask(taskID string) error {
-	return a.runner.StopTask(taskID)
+	if err := a.runner.StopTask(taskID); err != nil {
+		return err
+	}
+	if a.approvals != nil {
+		a.approvals.CancelTask(taskID, "The task was stopped.")
+	}
+	a.audit(models.AuditActionStop, models.AuditEntityTask, taskID, "stopped task", nil, nil)
+	return nil
 }
 
 func (a *App) GetTaskStreamEvents(taskID string) []claude.TaskStreamEvent {
@@ -1434,12 +2187,18 @@ func (a *App) GetSessionStreamEvents(sessionID string) (map[string][]claude.Task
 }
 
 func (a *App) ApplyTaskChanges(taskID string) error {
-	// No-op: agents work directly on the project directory, changes are already in place.
-	return nil
+	// Agents work directly on the project directory, so the changes are already
+	// in place; accepting them only requires the secret scan to be resolved.
+	return a.taskEngine.CheckSecretFindings(taskID, "", -1)
 }
 
 func (a *App) RejectTaskChanges(taskID string) error {
-	return a.sessionMgr.RejectTaskChanges(taskID)
+	if err := a.sessionMgr.RejectTaskChanges(taskID); err != nil {
+		return err
+	}
+	a.refreshSecretFindings(taskID)
+	a.audit(models.AuditActionRejectChanges, models.AuditEntityTask, taskID, "rejected all task changes", nil, nil)
+	return nil
 }
 
 func (a *App) GetTaskDiff(taskID string) (*services.DiffResult, error) {
@@ -1456,10 +2215,14 @@ func (a *App) GetTaskDiff(taskID string) (*services.DiffResult, error) {
 
 // ─── Hunk Operations ─────────────────────────────────
 
-// AcceptHunk is a no-op since agents work directly on the project directory.
-// Changes are already in place.
+// AcceptHunk changes nothing since agents work directly on the project
+// directory, but fails while the hunk has open secret findings.
 func (a *App) AcceptHunk(taskID string, filePath string, hunkIndex int) error {
-	return nil
+	relPath, err := a.taskRelPath(taskID, filePath)
+	if err != nil {
+		return err
+	}
+	return a.taskEngine.CheckSecretFindings(taskID, relPath, hunkIndex)
 }
 
 // RejectHunk reverts a hunk in the project and optionally sends explanation to Claude.
@@ -1473,6 +2236,17 @@ func (a *App) RejectHunk(taskID string, filePath string, hunkIndex int, reason s
 		return fmt.Errorf("task has no workspace")
 	}
 
+	ws, err := a.taskFS(task)
+	if err != nil {
+		return err
+	}
+	// Symlinks are resolved only to check the path stays in the workspace;
+	// the diff lists the path as git sees it
+	if _, _, err := ws.Resolve(filePath); err != nil {
+		return err
+	}
+	targetFile := filepath.ToSlash(filepath.Clean(filePath))
+
 	diffResult, err := a.diffTracker.ComputeDiff(projectPath)
 	if err != nil {
 		return err
@@ -1480,7 +2254,7 @@ func (a *App) RejectHunk(taskID string, filePath string, hunkIndex int, reason s
 
 	var targetHunk *services.DiffHunk
 	for _, f := range diffResult.Files {
-		if f.Path == filePath && hunkIndex >= 0 && hunkIndex < len(f.Hunks) {
+		if f.Path == targetFile && hunkIndex >= 0 && hunkIndex < len(f.Hunks) {
 			h := f.Hunks[hunkIndex]
 			targetHunk = &h
 			break
@@ -1490,14 +2264,17 @@ func (a *App) RejectHunk(taskID string, filePath string, hunkIndex int, reason s
 		return fmt.Errorf("hunk not found")
 	}
 
-	if err := a.diffTracker.RevertHunk(projectPath, filePath, *targetHunk); err != nil {
+	if err := a.diffTracker.RevertHunk(projectPath, targetFile, *targetHunk); err != nil {
 		return fmt.Errorf("revert hunk: %w", err)
 	}
+	a.refreshSecretFindings(taskID)
+	a.audit(models.AuditActionRejectHunk, models.AuditEntityTask, taskID, fmt.Sprintf("reverted hunk %s in %s", targetHunk.Header, targetFile),
+		map[string]any{"path": targetFile, "hunk": targetHunk}, map[string]any{"path": targetFile, "reason": reason})
 
 	if reason != "" && task.ClaudeSessionID != "" {
 		followUpMsg := fmt.Sprintf(
-			"The following change in %s was reverted:\n```diff\n%s\n%s\n```\nReason: %s\nPlease adjust your approach accordingly.",
-			filePath, targetHunk.Header, targetHunk.Content, reason,
+			"The following change in %s was reverted:\n%s\nReason: %s\nPlease adjust your approach accordingly.",
+			filePath, services.QuoteHunk(*targetHunk), reason,
 		)
 		return a.taskEngine.SendFollowUp(taskID, followUpMsg, "code")
 	}
@@ -1505,10 +2282,14 @@ func (a *App) RejectHunk(taskID string, filePath string, hunkIndex int, reason s
 	return nil
 }
 
-// AcceptFile is a no-op since agents work directly on the project directory.
-// Changes are already in place.
+// AcceptFile changes nothing since agents work directly on the project
+// directory, but fails while the file has open secret findings.
 func (a *App) AcceptFile(taskID string, filePath string) error {
-	return nil
+	relPath, err := a.taskRelPath(taskID, filePath)
+	if err != nil {
+		return err
+	}
+	return a.taskEngine.CheckSecretFindings(taskID, relPath, -1)
 }
 
 // RejectFile reverts an entire file using git and optionally tells Claude.
@@ -1517,14 +2298,21 @@ func (a *App) RejectFile(taskID string, filePath string, reason string) error {
 	if err != nil {
 		return err
 	}
-	projectPath := task.WorkspacePath
-	if projectPath == "" {
-		return fmt.Errorf("task has no workspace")
+	ws, err := a.taskFS(task)
+	if err != nil {
+		return err
+	}
+	_, relPath, err := ws.Resolve(filePath)
+	if err != nil {
+		return err
 	}
 
-	if err := a.diffTracker.RevertFile(projectPath, filePath); err != nil {
+	if err := a.diffTracker.RevertFile(ws.Root(), relPath); err != nil {
 		return err
 	}
+	a.refreshSecretFindings(taskID)
+	a.audit(models.AuditActionRejectFile, models.AuditEntityTask, taskID, "reverted all changes to "+relPath,
+		nil, map[string]any{"path": relPath, "reason": reason})
 
 	if reason != "" && task.ClaudeSessionID != "" {
 		followUpMsg := fmt.Sprintf(
@@ -1536,18 +2324,84 @@ func (a *App) RejectFile(taskID string, filePath string, reason string) error {
 	return nil
 }
 
+// GetTaskSecretFindings rescans the task's current diff and returns its secret
+// findings, with hunk indexes matching GetTaskDiff.
+func (a *App) GetTaskSecretFindings(taskID string) ([]models.SecretFinding, error) {
+	return a.taskEngine.RefreshSecretFindings(taskID)
+}
+
+// DismissSecretFinding marks a secret finding as a false positive so it no
+// longer blocks accepting the task's changes.
+func (a *App) DismissSecretFinding(taskID string, findingID string, reason string) error {
+	if err := a.taskEngine.DismissSecretFinding(taskID, findingID, reason); err != nil {
+		return err
+	}
+	a.audit(models.AuditActionDismissSecret, models.AuditEntityTask, taskID, "dismissed secret finding "+findingID,
+		nil, map[string]string{"finding_id": findingID, "reason": reason})
+	return nil
+}
+
+// refreshSecretFindings resolves findings whose lines a revert removed.
+func (a *App) refreshSecretFindings(taskID string) {
+	if _, err := a.taskEngine.RefreshSecretFindings(taskID); err != nil {
+		log.Printf("task %s: refresh secret findings: %v", taskID, err)
+	}
+}
+
+// taskRelPath resolves a path from the diff view to its workspace-relative form.
+func (a *App) taskRelPath(taskID, filePath string) (string, error) {
+	task, err := a.tasks.GetByID(taskID)
+	if err != nil {
+		return "", err
+	}
+	ws, err := a.taskFS(task)
+	if err != nil {
+		return "", err
+	}
+	_, relPath, err := ws.Resolve(filePath)
+	return relPath, err
+}
+
 // SaveWorkspaceFile saves edited content to a file in the project directory.
+// The write is atomic and refused for paths outside the workspace, inside .git,
+// ignored by git, or protected/read-only for the task's agent.
 func (a *App) SaveWorkspaceFile(taskID string, filePath string, content string) error {
 	task, err := a.tasks.GetByID(taskID)
 	if err != nil {
 		return err
 	}
-	projectPath := task.WorkspacePath
-	if projectPath == "" {
+	if task.WorkspacePath == "" {
 		return fmt.Errorf("task has no workspace")
 	}
-	fullPath := filepath.Join(projectPath, filePath)
-	return os.WriteFile(fullPath, []byte(content), 0644)
+	ws, err := a.taskFS(task)
+	if err != nil {
+		return err
+	}
+	return ws.WriteFile(filePath, []byte(content))
+}
+
+// taskFS opens the file access layer for a task: its workspace (or the project
+// directory before the task has run), guarded by the task agent's path rules.
+func (a *App) taskFS(task *models.Task) (*services.WorkspaceFS, error) {
+	baseDir := task.WorkspacePath
+	if baseDir == "" {
+		session, err := a.sessions.GetByID(task.SessionID)
+		if err != nil {
+			return nil, err
+		}
+		project, err := a.projects.GetByID(session.ProjectID)
+		if err != nil {
+			return nil, err
+		}
+		baseDir = project.Path
+	}
+	var guard *services.PathGuard
+	if task.AgentID != "" {
+		if agent, err := a.agents.GetByID(string(task.AgentID)); err == nil {
+			guard = services.NewPathGuard(agent)
+		}
+	}
+	return services.NewWorkspaceFS(baseDir, guard)
 }
 
 // ─── CLAUDE.md Memory ────────────────────────────────
@@ -1569,6 +2423,100 @@ func (a *App) UpdateProjectClaudeMD(projectID string, content string) error {
 	return a.projects.Update(project)
 }
 
+// ─── Database Integrity ──────────────────────────────
+
+// CheckDatabaseIntegrity runs SQLite's integrity and foreign key checks and
+// looks for dangling references. With repair, those references are removed.
+func (a *App) CheckDatabaseIntegrity(repair bool) (*store.IntegrityReport, error) {
+	return a.db.CheckIntegrity(repair)
+}
+
+// ─── Backup & Restore ────────────────────────────────
+
+// CreateBackup writes the database, config and vault to an archive chosen in
+// a save dialog and returns its path ("" if cancelled).
+func (a *App) CreateBackup() (string, error) {
+	path, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		Title:           "Create Backup",
+		DefaultFilename: filepath.Base(a.backups.ManualBackupPath()),
+	})
+	if err != nil || path == "" {
+		return "", err
+	}
+	manifest, err := a.backups.Create(path)
+	if err != nil {
+		return "", err
+	}
+	a.audit(models.AuditActionBackup, models.AuditEntityBackup, "", "created backup "+path, nil, manifest)
+	return path, nil
+}
+
+// ListBackups returns the archives in the backups directory, newest first.
+func (a *App) ListBackups() ([]services.BackupInfo, error) {
+	return a.backups.List()
+}
+
+// RestoreBackup validates a backup archive (chosen in a dialog when path is
+// empty) and stages it. The current files are replaced on the next start.
+func (a *App) RestoreBackup(path string) (*services.BackupManifest, error) {
+	if path == "" {
+		var err error
+		path, err = runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+			Title:            "Restore Backup",
+			DefaultDirectory: services.BackupDir(a.cfg.DataDir),
+			Filters:          []runtime.FileFilter{{DisplayName: "Shannon backups", Pattern: "*.tar.gz"}},
+		})
+		if err != nil || path == "" {
+			return nil, err
+		}
+	}
+	manifest, err := services.StageRestore(path, a.cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	a.audit(models.AuditActionRestore, models.AuditEntityBackup, "", "staged restore of "+path+" for next start", nil, manifest)
+	return manifest, nil
+}
+
+// ─── Search ──────────────────────────────────────────
+
+// Search runs a full-text search over task titles, prompts, results, errors
+// and test output, agent prompts and persisted stream events. Hits link to
+// the task (and its session) or agent; snippets mark matches with
+// store.SnippetMatchStart and SnippetMatchEnd.
+func (a *App) Search(query store.SearchQuery) ([]store.SearchHit, error) {
+	return a.search.Search(query)
+}
+
+// GetTaskStreamLog returns a task's persisted stream events, which remain
+// after a restart when the live buffer is empty.
+func (a *App) GetTaskStreamLog(taskID string) ([]models.StreamEvent, error) {
+	return a.streamLog.ListByTask(taskID)
+}
+
+// ─── Audit Log ───────────────────────────────────────
+
+// ListAuditEvents returns audit log entries matching filter, newest first.
+func (a *App) ListAuditEvents(filter store.AuditFilter) (*models.PaginatedResponse, error) {
+	return a.auditLog.List(filter)
+}
+
+// audit records an operation made through the app. A failure is logged, not
+// returned, since the operation itself already happened.
+func (a *App) audit(action, entityType, entityID, summary string, before, after any) {
+	if err := a.auditLog.Record("", action, entityType, entityID, summary, before, after); err != nil {
+		log.Printf("audit %s %s %s: %v", action, entityType, entityID, err)
+	}
+}
+
+// auditActor names the OS user, who all changes made in the app are attributed to.
+func auditActor() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return models.AuditActorUser
+}
+
 // ─── Retry & Resume ──────────────────────────────────
 
 // RetryTask manually retries a failed task with a fresh session.
@@ -1590,7 +2538,12 @@ func (a *App) RetryTask(taskID string) error {
 	if task.OriginalPrompt != "" {
 		task.Prompt = task.OriginalPrompt
 	}
-	return a.tasks.Update(task)
+	if err := a.tasks.Update(task); err != nil {
+		return err
+	}
+	a.audit(models.AuditActionRetry, models.AuditEntityTask, taskID, fmt.Sprintf("retried task (retry %d)", task.RetryCount),
+		nil, map[string]any{"retry_count": task.RetryCount})
+	return nil
 }
 
 // ResumeTask resumes a failed/completed task using --resume with the Claude session.
@@ -1609,43 +2562,84 @@ func (a *App) ResumeTask(taskID string, prompt string) error {
 		return err
 	}
 
-	return a.taskEngine.SendFollowUp(taskID, prompt, "code")
+	if err := a.taskEngine.ResumeTask(taskID, prompt); err != nil {
+		return err
+	}
+	a.audit(models.AuditActionResume, models.AuditEntityTask, taskID, "resumed task", nil, map[string]string{"prompt": prompt})
+	return nil
 }
 
 // ─── Follow-up & Chat ────────────────────────────────
 
 func (a *App) SendFollowUp(taskID string, message string, mode string) error {
-	return a.taskEngine.SendFollowUp(taskID, message, mode)
+	if err := a.taskEngine.SendFollowUp(taskID, message, mode); err != nil {
+		return err
+	}
+	a.audit(models.AuditActionFollowUp, models.AuditEntityTask, taskID, "sent follow-up ("+mode+")",
+		nil, map[string]string{"message": message, "mode": mode})
+	return nil
 }
 
-func (a *App) ReadProjectFile(taskID string, filePath string) (string, error) {
+// ListTaskMessages returns the persisted conversation thread of a task.
+func (a *App) ListTaskMessages(taskID string) ([]models.TaskMessage, error) {
+	return a.messages.ListByTask(taskID)
+}
+
+// ExportTaskConversation renders a task's conversation as "markdown" or "json".
+func (a *App) ExportTaskConversation(taskID string, format string) (string, error) {
 	task, err := a.tasks.GetByID(taskID)
 	if err != nil {
 		return "", err
 	}
+	messages, err := a.messages.ListByTask(taskID)
+	if err != nil {
+		return "", err
+	}
+	return services.ExportConversation(task, messages, format)
+}
 
-	// Use workspace if available, otherwise use project dir
-	baseDir := task.WorkspacePath
-	if baseDir == "" {
-		session, err := a.sessions.GetByID(task.SessionID)
-		if err != nil {
-			return "", err
-		}
-		project, err := a.projects.GetByID(session.ProjectID)
-		if err != nil {
-			return "", err
-		}
-		baseDir = project.Path
+// SaveTaskConversation exports a task's conversation to a file chosen by the user.
+func (a *App) SaveTaskConversation(taskID string, format string) (string, error) {
+	content, err := a.ExportTaskConversation(taskID, format)
+	if err != nil {
+		return "", err
+	}
+	ext := "md"
+	if format == "json" {
+		ext = "json"
+	}
+	shortID := taskID
+	if len(shortID) > 8 {
+		shortID = shortID[:8]
 	}
+	path, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		Title:           "Export Conversation",
+		DefaultFilename: fmt.Sprintf("task-%s.%s", shortID, ext),
+	})
+	if err != nil || path == "" {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return "", fmt.Errorf("write export: %w", err)
+	}
+	return path, nil
+}
 
-	fullPath := baseDir + "/" + filePath
-	data, err := os.ReadFile(fullPath)
+func (a *App) ReadProjectFile(taskID string, filePath string) (string, error) {
+	task, err := a.tasks.GetByID(taskID)
 	if err != nil {
-		return "", fmt.Errorf("read file: %w", err)
+		return "", err
+	}
+
+	// Use workspace if available, otherwise use project dir
+	ws, err := a.taskFS(task)
+	if err != nil {
+		return "", err
 	}
 	// Limit to 100KB to avoid huge responses
-	if len(data) > 100*1024 {
-		data = data[:100*1024]
+	data, err := ws.ReadFile(filePath, 100*1024)
+	if err != nil {
+		return "", err
 	}
 	return string(data), nil
 }
@@ -1656,36 +2650,11 @@ func (a *App) ListProjectFiles(taskID string) ([]string, error) {
 		return nil, err
 	}
 
-	baseDir := task.WorkspacePath
-	if baseDir == "" {
-		session, err := a.sessions.GetByID(task.SessionID)
-		if err != nil {
-			return nil, err
-		}
-		project, err := a.projects.GetByID(session.ProjectID)
-		if err != nil {
-			return nil, err
-		}
-		baseDir = project.Path
+	ws, err := a.taskFS(task)
+	if err != nil {
+		return nil, err
 	}
-
-	var files []string
-	err = filepath.Walk(baseDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // skip errors
-		}
-		// Skip hidden dirs and common noise
-		name := info.Name()
-		if info.IsDir() && (name == ".git" || name == "node_modules" || name == ".next" || name == "__pycache__" || name == "vendor" || name == ".venv") {
-			return filepath.SkipDir
-		}
-		if !info.IsDir() {
-			rel, _ := filepath.Rel(baseDir, path)
-			files = append(files, rel)
-		}
-		return nil
-	})
-	return files, err
+	return ws.ListFiles()
 }
 
 // ─── Planner ──────────────────────────────────────────
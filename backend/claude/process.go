@@ -9,6 +9,8 @@ import (
 	"os"
 	"os/exec"
 	"sync"
+
+	"agent-workflow/backend/sandbox"
 )
 
 // Process wraps a running Claude Code CLI process.
@@ -37,8 +39,10 @@ func StartProcess(ctx context.Context, opts ProcessOptions) (*Process, error) {
 
 	log.Printf("[claude] starting: %s %v (workdir: %s)", cliPath, args, opts.WorkDir)
 
-	cmd := exec.CommandContext(ctx, cliPath, args...)
-	cmd.Dir = opts.WorkDir
+	cmd, cleanupSandbox, err := sandbox.Command(ctx, opts.Sandbox, opts.WorkDir, cliPath, args...)
+	if err != nil {
+		return nil, err
+	}
 
 	// Merge extra env vars with parent process environment.
 	if len(opts.Env) > 0 {
@@ -73,6 +77,7 @@ func StartProcess(ctx context.Context, opts ProcessOptions) (*Process, error) {
 	}
 
 	if err := cmd.Start(); err != nil {
+		cleanupSandbox()
 		return nil, fmt.Errorf("start process (%s): %w", cliPath, err)
 	}
 
@@ -109,6 +114,7 @@ func StartProcess(ctx context.Context, opts ProcessOptions) (*Process, error) {
 	go func() {
 		defer close(p.done)
 		defer close(p.events)
+		defer cleanupSandbox()
 
 		log.Printf("[claude] starting stream parser")
 
@@ -229,17 +235,32 @@ func buildArgs(opts ProcessOptions) []string {
 		args = append(args, "--json-schema", opts.JSONSchema)
 	}
 
-	// Explicit MCP config file path — more reliable than auto-discovery.
+	// Explicit MCP config file paths — more reliable than auto-discovery.
+	// --mcp-config is variadic, so all files are passed after a single flag.
+	var mcpConfigs []string
 	if opts.MCPConfigPath != "" {
-		args = append(args, "--mcp-config", opts.MCPConfigPath)
+		mcpConfigs = append(mcpConfigs, opts.MCPConfigPath)
+	}
+	mcpConfigs = append(mcpConfigs, opts.ExtraMCPConfigs...)
+	if len(mcpConfigs) > 0 {
+		args = append(args, "--mcp-config")
+		args = append(args, mcpConfigs...)
 	}
 
 	// In -p (print/non-interactive) mode, stdin is closed so interactive
-	// permission approval is impossible. Always use --dangerously-skip-permissions
+	// permission approval is impossible. Unless a permission prompt tool is
+	// available to route approvals elsewhere, use --dangerously-skip-permissions
 	// to ensure all tools (Bash, Read, Write, Edit, etc.) can execute.
 	// Without this, modes like "acceptEdits" would silently reject Bash/Read
 	// calls since there's no stdin to approve them.
-	args = append(args, "--dangerously-skip-permissions")
+	if opts.PermissionPromptTool != "" && opts.Permissions != "bypassPermissions" {
+		args = append(args, "--permission-prompt-tool", opts.PermissionPromptTool)
+		if opts.Permissions != "" {
+			args = append(args, "--permission-mode", opts.Permissions)
+		}
+	} else {
+		args = append(args, "--dangerously-skip-permissions")
+	}
 
 	// Prompt is the final positional argument.
 	// Use "--" to end option parsing so the prompt text isn't misread as a flag.
@@ -1,6 +1,10 @@
 package claude
 
-import "encoding/json"
+import (
+	"encoding/json"
+
+	"agent-workflow/backend/sandbox"
+)
 
 // StreamEvent represents a single event from Claude Code's stream-json output.
 // Format: newline-delimited JSON, one object per line.
@@ -20,6 +24,8 @@ type StreamEvent struct {
 	NumTurns         int             `json:"num_turns,omitempty"`
 	Result           json.RawMessage `json:"result,omitempty"`
 	StructuredOutput json.RawMessage `json:"structured_output,omitempty"` // --json-schema validated output
+	Usage            *Usage          `json:"usage,omitempty"`
+	TotalCostUSD     float64         `json:"total_cost_usd,omitempty"`
 
 	// Raw JSON for anything we don't parse
 	Raw json.RawMessage `json:"-"`
@@ -54,9 +60,11 @@ type Message struct {
 
 // Usage tracks token usage.
 type Usage struct {
-	InputTokens  int `json:"input_tokens,omitempty"`
-	OutputTokens int `json:"output_tokens,omitempty"`
-	TotalTokens  int `json:"total_tokens,omitempty"`
+	InputTokens              int `json:"input_tokens,omitempty"`
+	OutputTokens             int `json:"output_tokens,omitempty"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+	TotalTokens              int `json:"total_tokens,omitempty"`
 }
 
 // ContentBlock represents a content block in a message.
@@ -70,18 +78,21 @@ type ContentBlock struct {
 
 // ProcessOptions configures how to spawn a Claude Code CLI process.
 type ProcessOptions struct {
-	CLIPath         string // path to claude CLI binary, defaults to "claude"
-	WorkDir         string
-	Model           string
-	SystemPrompt    string
-	AllowedTools    []string
-	DisallowedTools []string // tools to deny (e.g., "Bash(rm *)", "Write(/etc/*)")
-	Permissions     string   // "default", "acceptEdits", "bypassPermissions"
-	Prompt          string
-	SessionID       string            // for resuming sessions
-	JSONSchema      string            // JSON schema for validated structured output (--json-schema)
-	MCPConfigPath   string            // explicit path to .mcp.json (--mcp-config)
-	Env             map[string]string // extra env vars to inject into the subprocess
+	CLIPath              string // path to claude CLI binary, defaults to "claude"
+	WorkDir              string
+	Model                string
+	SystemPrompt         string
+	AllowedTools         []string
+	DisallowedTools      []string // tools to deny (e.g., "Bash(rm *)", "Write(/etc/*)")
+	Permissions          string   // "default", "acceptEdits", "bypassPermissions"
+	Prompt               string
+	SessionID            string            // for resuming sessions
+	JSONSchema           string            // JSON schema for validated structured output (--json-schema)
+	MCPConfigPath        string            // explicit path to .mcp.json (--mcp-config)
+	ExtraMCPConfigs      []string          // additional MCP config files (e.g. Shannon's built-in server)
+	PermissionPromptTool string            // MCP tool that answers permission checks (--permission-prompt-tool)
+	Sandbox              *sandbox.Profile  // run the CLI inside a sandbox (nil = unrestricted)
+	Env                  map[string]string // extra env vars to inject into the subprocess
 }
 
 // TaskStreamEvent is sent to the frontend via Wails events.
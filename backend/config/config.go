@@ -13,6 +13,18 @@ type Config struct {
 	LogLevel      string `json:"log_level"`
 	Theme         string `json:"theme"`
 	Language      string `json:"language"`
+
+	// Global env scope, the base layer under project, agent and task scopes.
+	// Values may be "vault:KEY" references. With VaultEnvGlobal, every vault
+	// entry that no MCP server uses is also exposed globally (the behaviour
+	// before scopes existed); it is off by default.
+	GlobalEnv      map[string]string `json:"global_env,omitempty"`
+	VaultEnvGlobal bool              `json:"vault_env_global"`
+
+	// Automatic backups of the database, config and vault into DataDir/backups.
+	// An interval of 0 disables them; BackupKeep is how many are kept.
+	BackupIntervalHours int `json:"backup_interval_hours"`
+	BackupKeep          int `json:"backup_keep"`
 }
 
 func DefaultConfig() *Config {
@@ -24,14 +36,22 @@ func DefaultConfig() *Config {
 		LogLevel:      "info",
 		Theme:         "dark",
 		Language:      "en",
+
+		BackupIntervalHours: 24,
+		BackupKeep:          7,
 	}
 }
 
+// FilePath returns where the config file is stored. It is always in the
+// default data directory, since it is what may point DataDir elsewhere.
+func FilePath() string {
+	return filepath.Join(DefaultConfig().DataDir, "config.json")
+}
+
 func Load() (*Config, error) {
 	cfg := DefaultConfig()
-	configPath := filepath.Join(cfg.DataDir, "config.json")
 
-	data, err := os.ReadFile(configPath)
+	data, err := os.ReadFile(FilePath())
 	if err != nil {
 		if os.IsNotExist(err) {
 			return cfg, cfg.Save()
@@ -46,13 +66,14 @@ func Load() (*Config, error) {
 }
 
 func (c *Config) Save() error {
-	if err := os.MkdirAll(c.DataDir, 0755); err != nil {
-		return err
+	for _, dir := range []string{c.DataDir, filepath.Dir(FilePath())} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
 	}
 	data, err := json.MarshalIndent(c, "", "  ")
 	if err != nil {
 		return err
 	}
-	configPath := filepath.Join(c.DataDir, "config.json")
-	return os.WriteFile(configPath, data, 0644)
+	return os.WriteFile(FilePath(), data, 0644)
 }
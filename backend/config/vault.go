@@ -1,39 +1,67 @@
 package config
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/rand"
-	"crypto/sha256"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"sync"
-
-	"golang.org/x/crypto/pbkdf2"
+	"time"
 )
 
 const (
 	vaultFileName  = ".vault"
-	pbkdf2Iter     = 100_000
 	saltSize       = 32
 	nonceSize      = 12 // AES-GCM standard nonce size
 	derivedKeySize = 32 // AES-256
+
+	// minPassphraseLen is enforced for the master passphrase and export bundles.
+	minPassphraseLen = 8
+)
+
+// Vault protection modes.
+const (
+	VaultModeMachine    = "machine"    // key derived from host fingerprint, opens without user input
+	VaultModePassphrase = "passphrase" // key derived from a master passphrase, must be unlocked at startup
+)
+
+var (
+	// ErrVaultLocked is returned by writes while a passphrase vault is locked.
+	ErrVaultLocked = errors.New("vault is locked")
+	// ErrWrongPassphrase is returned when a passphrase does not decrypt the vault or bundle.
+	ErrWrongPassphrase = errors.New("wrong passphrase")
 )
 
 // SecureVault provides encrypted storage for sensitive environment variables.
-// Values are encrypted both on disk (AES-256-GCM with machine-bound key)
-// and in memory (XOR with random session key).
+// Values are encrypted both on disk (AES-256-GCM) and in memory (XOR with
+// random session key). The disk key is derived either from a machine
+// fingerprint or, in passphrase mode, from a master passphrase; a passphrase
+// vault starts locked and holds no values until Unlock is called.
 type SecureVault struct {
 	mu         sync.RWMutex
 	dataDir    string
 	sessionKey []byte            // random key generated per app session for memory encryption
 	store      map[string][]byte // key -> XOR-encrypted value in memory
+
+	mode   string
+	locked bool
+	kdf    kdfParams // parameters (incl. salt) of the current disk key
+	key    []byte    // current disk key, XOR-encrypted with sessionKey; nil while locked
+}
+
+// VaultStatus describes how the vault is protected, without revealing values.
+type VaultStatus struct {
+	Mode    string `json:"mode"`
+	Locked  bool   `json:"locked"`
+	KDF     string `json:"kdf"`
+	Version int    `json:"version"`
+	Keys    int    `json:"keys"`
 }
 
 // NewSecureVault creates a new vault and loads existing secrets from disk.
+// A passphrase-protected vault is returned locked rather than as an error.
 func NewSecureVault(dataDir string) (*SecureVault, error) {
 	sessionKey := make([]byte, 32)
 	if _, err := io.ReadFull(rand.Reader, sessionKey); err != nil {
@@ -44,6 +72,7 @@ func NewSecureVault(dataDir string) (*SecureVault, error) {
 		dataDir:    dataDir,
 		sessionKey: sessionKey,
 		store:      make(map[string][]byte),
+		mode:       VaultModeMachine,
 	}
 
 	if err := v.loadFromDisk(); err != nil {
@@ -53,6 +82,26 @@ func NewSecureVault(dataDir string) (*SecureVault, error) {
 	return v, nil
 }
 
+// Status reports the vault's protection mode and lock state.
+func (v *SecureVault) Status() VaultStatus {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return VaultStatus{
+		Mode:    v.mode,
+		Locked:  v.locked,
+		KDF:     v.kdf.Name,
+		Version: vaultFormatVersion,
+		Keys:    len(v.store),
+	}
+}
+
+// Locked reports whether the vault is waiting for its passphrase.
+func (v *SecureVault) Locked() bool {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return v.locked
+}
+
 // Get returns all decrypted environment variables.
 // Values are decrypted from memory only at call time.
 func (v *SecureVault) Get() map[string]string {
@@ -78,11 +127,51 @@ func (v *SecureVault) GetKeys() []string {
 	return keys
 }
 
+// Values returns the decrypted values only, e.g. to build a redaction filter.
+func (v *SecureVault) Values() []string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	values := make([]string, 0, len(v.store))
+	for _, encrypted := range v.store {
+		values = append(values, string(v.xorWithSessionKey(encrypted)))
+	}
+	return values
+}
+
+// Lookup returns a single decrypted value and whether the key exists.
+func (v *SecureVault) Lookup(key string) (string, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	encrypted, ok := v.store[key]
+	if !ok {
+		return "", false
+	}
+	return string(v.xorWithSessionKey(encrypted)), true
+}
+
+// Put adds or replaces one variable, keeping the others, and persists the vault.
+func (v *SecureVault) Put(key, value string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.locked {
+		return ErrVaultLocked
+	}
+	v.store[key] = v.xorWithSessionKey([]byte(value))
+	return v.saveToDisk(v.plainVars())
+}
+
 // Set replaces all environment variables and persists to encrypted disk storage.
 func (v *SecureVault) Set(vars map[string]string) error {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
+	if v.locked {
+		return ErrVaultLocked
+	}
+
 	// Clear old store
 	v.store = make(map[string][]byte, len(vars))
 
@@ -94,6 +183,196 @@ func (v *SecureVault) Set(vars map[string]string) error {
 	return v.saveToDisk(vars)
 }
 
+// Unlock decrypts a passphrase-protected vault. It is a no-op for an
+// unlocked vault.
+func (v *SecureVault) Unlock(passphrase string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if !v.locked {
+		return nil
+	}
+	vf, err := v.readFile()
+	if err != nil {
+		return err
+	}
+	key, err := vf.KDF.derive(passphrase)
+	if err != nil {
+		return err
+	}
+	vars, err := vf.open(key)
+	if err != nil {
+		return ErrWrongPassphrase
+	}
+	v.loadVars(vars)
+	v.kdf = vf.KDF
+	v.key = v.xorWithSessionKey(key)
+	v.locked = false
+	return nil
+}
+
+// SetPassphrase switches the vault's protection and re-encrypts it under a
+// freshly salted key. current must be the existing passphrase in passphrase
+// mode (ignored in machine mode); next "" switches back to machine mode.
+func (v *SecureVault) SetPassphrase(current, next string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.locked {
+		return ErrVaultLocked
+	}
+	if err := v.checkPassphrase(current); err != nil {
+		return err
+	}
+	if next != "" && len(next) < minPassphraseLen {
+		return fmt.Errorf("passphrase must be at least %d characters", minPassphraseLen)
+	}
+
+	var kdf kdfParams
+	var key []byte
+	var err error
+	if next == "" {
+		kdf, err = newMachineKDF()
+		if err == nil {
+			key, err = kdf.derive(v.machineSecret())
+		}
+	} else {
+		kdf, err = newPassphraseKDF()
+		if err == nil {
+			key, err = kdf.derive(next)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	mode := VaultModeMachine
+	if next != "" {
+		mode = VaultModePassphrase
+	}
+	return v.rekey(mode, kdf, key)
+}
+
+// RotateKey re-encrypts the vault under a new random salt, and so a new key,
+// keeping the current protection mode. passphrase is required in passphrase mode.
+func (v *SecureVault) RotateKey(passphrase string) error {
+	v.mu.RLock()
+	mode := v.mode
+	v.mu.RUnlock()
+	if mode == VaultModePassphrase {
+		return v.SetPassphrase(passphrase, passphrase)
+	}
+	return v.SetPassphrase("", "")
+}
+
+// Export returns the vault's variables as an encrypted bundle that can be
+// imported on another machine with the same passphrase.
+func (v *SecureVault) Export(passphrase string) ([]byte, error) {
+	if len(passphrase) < minPassphraseLen {
+		return nil, fmt.Errorf("export passphrase must be at least %d characters", minPassphraseLen)
+	}
+	v.mu.RLock()
+	if v.locked {
+		v.mu.RUnlock()
+		return nil, ErrVaultLocked
+	}
+	vars := v.plainVars()
+	v.mu.RUnlock()
+
+	kdf, err := newPassphraseKDF()
+	if err != nil {
+		return nil, err
+	}
+	key, err := kdf.derive(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	vf, err := seal(vaultModeExport, kdf, key, vars)
+	if err != nil {
+		return nil, err
+	}
+	return vf.marshal()
+}
+
+// Import decrypts a bundle made by Export and adds its variables to the
+// vault. With replace, the vault's current variables are dropped first;
+// otherwise bundle values win on conflicting keys. It returns the number of
+// variables imported.
+func (v *SecureVault) Import(data []byte, passphrase string, replace bool) (int, error) {
+	vf, err := parseVaultFile(data)
+	if err != nil {
+		return 0, err
+	}
+	if vf.Mode != vaultModeExport {
+		return 0, fmt.Errorf("not a vault export bundle")
+	}
+	key, err := vf.KDF.derive(passphrase)
+	if err != nil {
+		return 0, err
+	}
+	vars, err := vf.open(key)
+	if err != nil {
+		return 0, ErrWrongPassphrase
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.locked {
+		return 0, ErrVaultLocked
+	}
+	if replace {
+		v.store = make(map[string][]byte, len(vars))
+	}
+	for k, val := range vars {
+		v.store[k] = v.xorWithSessionKey([]byte(val))
+	}
+	return len(vars), v.saveToDisk(v.plainVars())
+}
+
+// checkPassphrase verifies current against the unlocked passphrase vault by
+// deriving the key again. Callers hold v.mu.
+func (v *SecureVault) checkPassphrase(current string) error {
+	if v.mode != VaultModePassphrase {
+		return nil
+	}
+	key, err := v.kdf.derive(current)
+	if err != nil {
+		return err
+	}
+	if string(key) != string(v.xorWithSessionKey(v.key)) {
+		return ErrWrongPassphrase
+	}
+	return nil
+}
+
+// rekey switches to a new disk key and rewrites the vault. Callers hold v.mu.
+func (v *SecureVault) rekey(mode string, kdf kdfParams, key []byte) error {
+	prevMode, prevKDF, prevKey := v.mode, v.kdf, v.key
+	v.mode, v.kdf, v.key = mode, kdf, v.xorWithSessionKey(key)
+	if err := v.saveToDisk(v.plainVars()); err != nil {
+		v.mode, v.kdf, v.key = prevMode, prevKDF, prevKey
+		return err
+	}
+	return nil
+}
+
+// plainVars decrypts the in-memory store. Callers hold v.mu.
+func (v *SecureVault) plainVars() map[string]string {
+	vars := make(map[string]string, len(v.store))
+	for k, encrypted := range v.store {
+		vars[k] = string(v.xorWithSessionKey(encrypted))
+	}
+	return vars
+}
+
+// loadVars replaces the in-memory store. Callers hold v.mu.
+func (v *SecureVault) loadVars(vars map[string]string) {
+	v.store = make(map[string][]byte, len(vars))
+	for k, val := range vars {
+		v.store[k] = v.xorWithSessionKey([]byte(val))
+	}
+}
+
 // xorWithSessionKey XORs data with the session key (repeating key as needed).
 func (v *SecureVault) xorWithSessionKey(data []byte) []byte {
 	result := make([]byte, len(data))
@@ -103,85 +382,89 @@ func (v *SecureVault) xorWithSessionKey(data []byte) []byte {
 	return result
 }
 
-// machineKey derives a deterministic encryption key bound to this machine.
-func (v *SecureVault) machineKey(salt []byte) []byte {
+// machineSecret is the input of the machine-mode key derivation.
+func (v *SecureVault) machineSecret() string {
 	hostname, _ := os.Hostname()
 	homeDir, _ := os.UserHomeDir()
 
 	// Combine machine-specific identifiers
-	fingerprint := fmt.Sprintf("%s:%s:%s", hostname, homeDir, v.dataDir)
-	seed := sha256.Sum256([]byte(fingerprint))
+	return fmt.Sprintf("%s:%s:%s", hostname, homeDir, v.dataDir)
+}
+
+// SetAsideVault renames an unreadable .vault file so a fresh vault can be
+// created without destroying it, and returns the new path.
+func SetAsideVault(dataDir string) (string, error) {
+	path := VaultPath(dataDir)
+	moved := fmt.Sprintf("%s.unreadable-%d", path, time.Now().Unix())
+	if err := os.Rename(path, moved); err != nil {
+		return "", err
+	}
+	return moved, nil
+}
+
+// VaultPath returns where the vault of a data directory is stored.
+func VaultPath(dataDir string) string {
+	return filepath.Join(dataDir, vaultFileName)
+}
 
-	return pbkdf2.Key(seed[:], salt, pbkdf2Iter, derivedKeySize, sha256.New)
+func (v *SecureVault) vaultPath() string {
+	return VaultPath(v.dataDir)
 }
 
-// vaultFile is the on-disk format: salt + nonce + ciphertext.
-type vaultFile struct {
-	Salt       []byte `json:"s"`
-	Nonce      []byte `json:"n"`
-	Ciphertext []byte `json:"c"`
+// readFile reads and parses the .vault file.
+func (v *SecureVault) readFile() (*vaultFile, error) {
+	data, err := os.ReadFile(v.vaultPath())
+	if err != nil {
+		return nil, fmt.Errorf("read vault: %w", err)
+	}
+	return parseVaultFile(data)
 }
 
-// saveToDisk encrypts vars with AES-256-GCM and writes to .vault file.
+// saveToDisk encrypts vars under the current key and writes the .vault file
+// atomically. Callers hold v.mu.
 func (v *SecureVault) saveToDisk(vars map[string]string) error {
 	if err := os.MkdirAll(v.dataDir, 0755); err != nil {
 		return fmt.Errorf("create data dir: %w", err)
 	}
 
-	// Generate random salt
-	salt := make([]byte, saltSize)
-	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
-		return fmt.Errorf("generate salt: %w", err)
-	}
-
-	// Derive encryption key from machine fingerprint + salt
-	key := v.machineKey(salt)
-
-	// Serialize plaintext
-	plaintext, err := json.Marshal(vars)
-	if err != nil {
-		return fmt.Errorf("marshal vars: %w", err)
+	if v.key == nil {
+		// First save in machine mode: derive a key under a fresh salt
+		kdf, err := newMachineKDF()
+		if err != nil {
+			return err
+		}
+		key, err := kdf.derive(v.machineSecret())
+		if err != nil {
+			return err
+		}
+		v.kdf, v.key = kdf, v.xorWithSessionKey(key)
 	}
 
-	// Encrypt with AES-256-GCM
-	block, err := aes.NewCipher(key)
+	vf, err := seal(v.mode, v.kdf, v.xorWithSessionKey(v.key), vars)
 	if err != nil {
-		return fmt.Errorf("create cipher: %w", err)
+		return err
 	}
-
-	gcm, err := cipher.NewGCM(block)
+	data, err := vf.marshal()
 	if err != nil {
-		return fmt.Errorf("create GCM: %w", err)
+		return err
 	}
 
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return fmt.Errorf("generate nonce: %w", err)
+	tmp := v.vaultPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("write vault: %w", err)
 	}
-
-	ciphertext := gcm.Seal(nil, nonce, plaintext, nil)
-
-	// Wipe plaintext from memory
-	for i := range plaintext {
-		plaintext[i] = 0
-	}
-
-	// Write vault file
-	vf := vaultFile{Salt: salt, Nonce: nonce, Ciphertext: ciphertext}
-	data, err := json.Marshal(vf)
-	if err != nil {
-		return fmt.Errorf("marshal vault: %w", err)
+	if err := os.Rename(tmp, v.vaultPath()); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write vault: %w", err)
 	}
-
-	vaultPath := filepath.Join(v.dataDir, vaultFileName)
-	return os.WriteFile(vaultPath, data, 0600)
+	return nil
 }
 
-// loadFromDisk reads and decrypts the .vault file into memory.
+// loadFromDisk reads and decrypts the .vault file into memory. A passphrase
+// vault is only parsed and left locked. Legacy (unversioned) files are
+// rewritten in the current format once decrypted.
 func (v *SecureVault) loadFromDisk() error {
-	vaultPath := filepath.Join(v.dataDir, vaultFileName)
-
-	data, err := os.ReadFile(vaultPath)
+	data, err := os.ReadFile(v.vaultPath())
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil // no vault yet, start empty
@@ -189,48 +472,39 @@ func (v *SecureVault) loadFromDisk() error {
 		return fmt.Errorf("read vault: %w", err)
 	}
 
-	var vf vaultFile
-	if err := json.Unmarshal(data, &vf); err != nil {
-		return fmt.Errorf("parse vault: %w", err)
-	}
-
-	// Derive key from stored salt
-	key := v.machineKey(vf.Salt)
-
-	// Decrypt
-	block, err := aes.NewCipher(key)
+	vf, err := parseVaultFile(data)
 	if err != nil {
-		return fmt.Errorf("create cipher: %w", err)
+		return err
+	}
+	switch vf.Mode {
+	case VaultModePassphrase:
+		v.mode = VaultModePassphrase
+		v.kdf = vf.KDF
+		v.locked = true
+		return nil
+	case VaultModeMachine:
+	default:
+		return fmt.Errorf("unsupported vault mode %q", vf.Mode)
 	}
 
-	gcm, err := cipher.NewGCM(block)
+	key, err := vf.KDF.derive(v.machineSecret())
 	if err != nil {
-		return fmt.Errorf("create GCM: %w", err)
+		return err
 	}
-
-	plaintext, err := gcm.Open(nil, vf.Nonce, vf.Ciphertext, nil)
+	vars, err := vf.open(key)
 	if err != nil {
 		return fmt.Errorf("decrypt vault: %w", err)
 	}
+	v.loadVars(vars)
 
-	var vars map[string]string
-	if err := json.Unmarshal(plaintext, &vars); err != nil {
-		// Wipe plaintext before returning error
-		for i := range plaintext {
-			plaintext[i] = 0
+	if vf.Version < vaultFormatVersion {
+		// Upgrade the file; the legacy key is not reused
+		if err := v.saveToDisk(vars); err != nil {
+			return fmt.Errorf("upgrade vault format: %w", err)
 		}
-		return fmt.Errorf("parse decrypted data: %w", err)
-	}
-
-	// Wipe plaintext from memory
-	for i := range plaintext {
-		plaintext[i] = 0
+		return nil
 	}
-
-	// Store XOR-encrypted in memory
-	for k, val := range vars {
-		v.store[k] = v.xorWithSessionKey([]byte(val))
-	}
-
+	v.kdf = vf.KDF
+	v.key = v.xorWithSessionKey(key)
 	return nil
 }
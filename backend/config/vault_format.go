@@ -0,0 +1,210 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// On-disk vault format. Version 1 files have no header (just salt, nonce and
+// ciphertext, machine key only); version 2 adds the header below, which is also
+// bound to the ciphertext as AES-GCM additional data.
+const (
+	vaultFormatName    = "shannon-vault"
+	vaultFormatVersion = 2
+	vaultModeExport    = "export" // portable bundle, passphrase-protected
+)
+
+// Key derivation functions.
+const (
+	kdfPBKDF2 = "pbkdf2-sha256"
+	kdfArgon2 = "argon2id"
+
+	pbkdf2Iter = 100_000
+
+	argon2Time      = 3
+	argon2MemoryKiB = 64 * 1024
+	argon2Threads   = 4
+)
+
+// kdfParams records how a vault key was derived, so it can be derived again.
+type kdfParams struct {
+	Name      string `json:"name"`
+	Salt      []byte `json:"salt"`
+	Iter      int    `json:"iter,omitempty"`       // pbkdf2
+	Time      uint32 `json:"time,omitempty"`       // argon2id
+	MemoryKiB uint32 `json:"memory_kib,omitempty"` // argon2id
+	Threads   uint8  `json:"threads,omitempty"`    // argon2id
+}
+
+// vaultFile is the on-disk format of .vault files and export bundles.
+type vaultFile struct {
+	Format     string    `json:"format,omitempty"`
+	Version    int       `json:"v,omitempty"`
+	Mode       string    `json:"mode,omitempty"`
+	KDF        kdfParams `json:"kdf"`
+	Nonce      []byte    `json:"n"`
+	Ciphertext []byte    `json:"c"`
+}
+
+// legacyVaultFile is the version 1 format: salt + nonce + ciphertext.
+type legacyVaultFile struct {
+	Salt       []byte `json:"s"`
+	Nonce      []byte `json:"n"`
+	Ciphertext []byte `json:"c"`
+}
+
+func newSalt() ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("generate salt: %w", err)
+	}
+	return salt, nil
+}
+
+func newMachineKDF() (kdfParams, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return kdfParams{}, err
+	}
+	return kdfParams{Name: kdfPBKDF2, Salt: salt, Iter: pbkdf2Iter}, nil
+}
+
+func newPassphraseKDF() (kdfParams, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return kdfParams{}, err
+	}
+	return kdfParams{Name: kdfArgon2, Salt: salt, Time: argon2Time, MemoryKiB: argon2MemoryKiB, Threads: argon2Threads}, nil
+}
+
+// derive computes the AES-256 key for secret.
+func (k kdfParams) derive(secret string) ([]byte, error) {
+	switch k.Name {
+	case kdfPBKDF2:
+		seed := sha256.Sum256([]byte(secret))
+		return pbkdf2.Key(seed[:], k.Salt, k.Iter, derivedKeySize, sha256.New), nil
+	case kdfArgon2:
+		if k.Time == 0 || k.MemoryKiB == 0 || k.Threads == 0 {
+			return nil, fmt.Errorf("invalid argon2id parameters")
+		}
+		return argon2.IDKey([]byte(secret), k.Salt, k.Time, k.MemoryKiB, k.Threads, derivedKeySize), nil
+	default:
+		return nil, fmt.Errorf("unsupported key derivation %q", k.Name)
+	}
+}
+
+// additionalData binds the header to the ciphertext (none for version 1).
+func (vf *vaultFile) additionalData() []byte {
+	if vf.Version < 2 {
+		return nil
+	}
+	return []byte(fmt.Sprintf("%s:v%d:%s:%s", vaultFormatName, vf.Version, vf.Mode, vf.KDF.Name))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create GCM: %w", err)
+	}
+	return gcm, nil
+}
+
+// seal encrypts vars with AES-256-GCM into a current-version vault file.
+func seal(mode string, kdf kdfParams, key []byte, vars map[string]string) (*vaultFile, error) {
+	plaintext, err := json.Marshal(vars)
+	if err != nil {
+		return nil, fmt.Errorf("marshal vars: %w", err)
+	}
+	// Wipe plaintext from memory
+	defer func() {
+		for i := range plaintext {
+			plaintext[i] = 0
+		}
+	}()
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+
+	vf := &vaultFile{Format: vaultFormatName, Version: vaultFormatVersion, Mode: mode, KDF: kdf, Nonce: nonce}
+	vf.Ciphertext = gcm.Seal(nil, nonce, plaintext, vf.additionalData())
+	return vf, nil
+}
+
+// open decrypts the file's variables with key.
+func (vf *vaultFile) open(key []byte) (map[string]string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, vf.Nonce, vf.Ciphertext, vf.additionalData())
+	if err != nil {
+		return nil, err
+	}
+	// Wipe plaintext from memory
+	defer func() {
+		for i := range plaintext {
+			plaintext[i] = 0
+		}
+	}()
+
+	var vars map[string]string
+	if err := json.Unmarshal(plaintext, &vars); err != nil {
+		return nil, fmt.Errorf("parse decrypted data: %w", err)
+	}
+	return vars, nil
+}
+
+func (vf *vaultFile) marshal() ([]byte, error) {
+	data, err := json.Marshal(vf)
+	if err != nil {
+		return nil, fmt.Errorf("marshal vault: %w", err)
+	}
+	return data, nil
+}
+
+// parseVaultFile reads either format; version 1 files are mapped onto the
+// version 2 structure with their implicit machine-mode PBKDF2 parameters.
+func parseVaultFile(data []byte) (*vaultFile, error) {
+	var vf vaultFile
+	if err := json.Unmarshal(data, &vf); err != nil {
+		return nil, fmt.Errorf("parse vault: %w", err)
+	}
+	if vf.Version == 0 {
+		var legacy legacyVaultFile
+		if err := json.Unmarshal(data, &legacy); err != nil || len(legacy.Salt) == 0 {
+			return nil, fmt.Errorf("parse vault: unrecognized format")
+		}
+		return &vaultFile{
+			Version:    1,
+			Mode:       VaultModeMachine,
+			KDF:        kdfParams{Name: kdfPBKDF2, Salt: legacy.Salt, Iter: pbkdf2Iter},
+			Nonce:      legacy.Nonce,
+			Ciphertext: legacy.Ciphertext,
+		}, nil
+	}
+	if vf.Format != vaultFormatName {
+		return nil, fmt.Errorf("parse vault: unexpected format %q", vf.Format)
+	}
+	if vf.Version > vaultFormatVersion {
+		return nil, fmt.Errorf("vault format version %d is newer than this build supports (%d)", vf.Version, vaultFormatVersion)
+	}
+	return &vf, nil
+}
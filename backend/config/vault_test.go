@@ -0,0 +1,136 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// writeLegacyVault writes vars the way version 1 did: a machine key from
+// PBKDF2 over the host fingerprint, no header and no additional data.
+func writeLegacyVault(t *testing.T, dataDir string, vars map[string]string) {
+	t.Helper()
+	hostname, _ := os.Hostname()
+	homeDir, _ := os.UserHomeDir()
+	seed := sha256.Sum256([]byte(fmt.Sprintf("%s:%s:%s", hostname, homeDir, dataDir)))
+	salt := make([]byte, saltSize)
+	rand.Read(salt)
+	key := pbkdf2.Key(seed[:], salt, pbkdf2Iter, derivedKeySize, sha256.New)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	plaintext, _ := json.Marshal(vars)
+	data, _ := json.Marshal(legacyVaultFile{Salt: salt, Nonce: nonce, Ciphertext: gcm.Seal(nil, nonce, plaintext, nil)})
+	if err := os.WriteFile(VaultPath(dataDir), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVaultUpgradesLegacyFile(t *testing.T) {
+	dir := t.TempDir()
+	vars := map[string]string{"ANTHROPIC_API_KEY": "sk-ant-test", "GITHUB_TOKEN": "ghp_test"}
+	writeLegacyVault(t, dir, vars)
+
+	v, err := NewSecureVault(dir)
+	if err != nil {
+		t.Fatalf("open legacy vault: %v", err)
+	}
+	if got := v.Get(); !reflect.DeepEqual(got, vars) {
+		t.Fatalf("legacy vault values = %v, want %v", got, vars)
+	}
+
+	// The file is rewritten in the current format on open
+	data, err := os.ReadFile(VaultPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vf, err := parseVaultFile(data)
+	if err != nil {
+		t.Fatalf("parse upgraded vault: %v", err)
+	}
+	if vf.Format != vaultFormatName || vf.Version != vaultFormatVersion || vf.Mode != VaultModeMachine {
+		t.Errorf("upgraded header = %q v%d %q, want %q v%d %q", vf.Format, vf.Version, vf.Mode, vaultFormatName, vaultFormatVersion, VaultModeMachine)
+	}
+
+	reopened, err := NewSecureVault(dir)
+	if err != nil {
+		t.Fatalf("reopen upgraded vault: %v", err)
+	}
+	if got := reopened.Get(); !reflect.DeepEqual(got, vars) {
+		t.Errorf("upgraded vault values = %v, want %v", got, vars)
+	}
+}
+
+func TestParseVaultFile(t *testing.T) {
+	legacy, _ := json.Marshal(legacyVaultFile{Salt: []byte("salt"), Nonce: []byte("nonce"), Ciphertext: []byte("c")})
+	current, _ := json.Marshal(vaultFile{Format: vaultFormatName, Version: vaultFormatVersion, Mode: VaultModePassphrase, KDF: kdfParams{Name: kdfArgon2}})
+	newer, _ := json.Marshal(vaultFile{Format: vaultFormatName, Version: vaultFormatVersion + 1})
+	foreign, _ := json.Marshal(vaultFile{Format: "other", Version: 1})
+
+	tests := []struct {
+		name    string
+		data    []byte
+		version int
+		mode    string
+		kdf     string
+		wantErr bool
+	}{
+		{"legacy", legacy, 1, VaultModeMachine, kdfPBKDF2, false},
+		{"current", current, vaultFormatVersion, VaultModePassphrase, kdfArgon2, false},
+		{"newer", newer, 0, "", "", true},
+		{"foreign", foreign, 0, "", "", true},
+		{"no salt", []byte(`{"n":"AA==","c":"AA=="}`), 0, "", "", true},
+		{"not json", []byte("garbage"), 0, "", "", true},
+	}
+	for _, tt := range tests {
+		vf, err := parseVaultFile(tt.data)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if vf.Version != tt.version || vf.Mode != tt.mode || vf.KDF.Name != tt.kdf {
+			t.Errorf("%s: got v%d %q %q, want v%d %q %q", tt.name, vf.Version, vf.Mode, vf.KDF.Name, tt.version, tt.mode, tt.kdf)
+		}
+	}
+}
+
+func TestVaultHeaderIsAuthenticated(t *testing.T) {
+	kdf := kdfParams{Name: kdfPBKDF2, Salt: []byte("0123456789abcdef0123456789abcdef"), Iter: 1000}
+	key, err := kdf.derive("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vf, err := seal(VaultModeMachine, kdf, key, map[string]string{"K": "v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vf.open(key); err != nil {
+		t.Fatalf("open sealed vault: %v", err)
+	}
+	vf.Mode = VaultModePassphrase
+	if _, err := vf.open(key); err == nil {
+		t.Error("a vault whose header was changed still decrypted")
+	}
+}
@@ -0,0 +1,71 @@
+package mcpserver
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"strings"
+)
+
+// Built-in tool names (unqualified).
+const (
+	ToolAskUser = "ask_user"
+)
+
+// AskUserInput is the argument of the ask_user tool. AgentRunner parses the
+// same structure from the tool_use block in Claude's stream.
+type AskUserInput struct {
+	Question string   `json:"question"`
+	Choices  []string `json:"choices,omitempty"`
+	Context  string   `json:"context,omitempty"`
+}
+
+const askUserSchema = `{
+  "type": "object",
+  "required": ["question"],
+  "properties": {
+    "question": { "type": "string", "description": "The question for the user, phrased so it can be answered without reading the rest of the output" },
+    "choices": { "type": "array", "items": { "type": "string" }, "description": "Optional list of answers the user can pick from" },
+    "context": { "type": "string", "description": "Optional background the user needs to answer" }
+  },
+  "additionalProperties": false
+}`
+
+// askUserTool returns the ask_user tool. The tool itself only acknowledges the
+// call: Shannon sees the tool_use in the stream and marks the task as
+// awaiting_input once the agent ends its turn.
+func askUserTool() Tool {
+	return Tool{
+		Name:        ToolAskUser,
+		Description: "Ask the user a question when you need a decision, approval or missing information to continue. After calling this tool, stop and end your turn; the answer arrives as the next user message.",
+		InputSchema: json.RawMessage(askUserSchema),
+		Handler: func(args json.RawMessage) ToolResult {
+			var in AskUserInput
+			if err := json.Unmarshal(args, &in); err != nil || strings.TrimSpace(in.Question) == "" {
+				return ToolResult{Text: "ask_user requires a non-empty \"question\" string.", IsError: true}
+			}
+			return ToolResult{Text: "Your question has been delivered to the user. Do not continue working: end your turn now without further tool calls. The user's answer will arrive as the next message."}
+		},
+	}
+}
+
+// Run starts the built-in server on stdin/stdout. args are the command-line
+// arguments following "mcp-server", e.g. ["--tools", "ask_user"].
+func Run(args []string) error {
+	fs := flag.NewFlagSet("mcp-server", flag.ContinueOnError)
+	toolList := fs.String("tools", ToolAskUser, "comma-separated list of built-in tools to expose")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	srv := NewServer(ServerName, "1.0.0")
+	for _, name := range strings.Split(*toolList, ",") {
+		switch strings.TrimSpace(name) {
+		case ToolAskUser:
+			srv.AddTool(askUserTool())
+		case ToolPermissionPrompt:
+			srv.AddTool(permissionPromptTool())
+		}
+	}
+	return srv.Serve(os.Stdin, os.Stdout)
+}
@@ -0,0 +1,113 @@
+package mcpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// ToolPermissionPrompt is passed to Claude via --permission-prompt-tool.
+const ToolPermissionPrompt = "permission_prompt"
+
+// Environment variables the built-in server reads to reach the running Shannon app.
+const (
+	EnvBrokerURL   = "SHANNON_BROKER_URL"
+	EnvBrokerToken = "SHANNON_BROKER_TOKEN"
+	EnvTaskID      = "SHANNON_TASK_ID"
+)
+
+// PermissionRequest is sent from the built-in server to Shannon's approval broker.
+type PermissionRequest struct {
+	TaskID    string          `json:"task_id"`
+	ToolName  string          `json:"tool_name"`
+	Input     json.RawMessage `json:"input"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+}
+
+// PermissionDecision is the response format Claude expects from a permission prompt tool.
+type PermissionDecision struct {
+	Behavior     string          `json:"behavior"` // "allow" or "deny"
+	UpdatedInput json.RawMessage `json:"updatedInput,omitempty"`
+	Message      string          `json:"message,omitempty"`
+}
+
+const permissionPromptSchema = `{
+  "type": "object",
+  "required": ["tool_name", "input"],
+  "properties": {
+    "tool_name": { "type": "string" },
+    "input": { "type": "object" },
+    "tool_use_id": { "type": "string" }
+  }
+}`
+
+// permissionPromptTool forwards Claude's permission checks to the Shannon app and
+// blocks until someone answers. Any failure to reach the app results in a deny.
+func permissionPromptTool() Tool {
+	return Tool{
+		Name:        ToolPermissionPrompt,
+		Description: "Asks the Shannon user to approve a tool call.",
+		InputSchema: json.RawMessage(permissionPromptSchema),
+		Handler: func(args json.RawMessage) ToolResult {
+			var req PermissionRequest
+			if err := json.Unmarshal(args, &req); err != nil {
+				return denyResult(fmt.Sprintf("invalid permission request: %v", err))
+			}
+			req.TaskID = os.Getenv(EnvTaskID)
+
+			decision, err := requestApproval(os.Getenv(EnvBrokerURL), os.Getenv(EnvBrokerToken), req)
+			if err != nil {
+				return denyResult(fmt.Sprintf("approval unavailable: %v", err))
+			}
+			if decision.Behavior == "allow" && len(decision.UpdatedInput) == 0 {
+				decision.UpdatedInput = req.Input
+			}
+			data, _ := json.Marshal(decision)
+			return ToolResult{Text: string(data)}
+		},
+	}
+}
+
+func requestApproval(brokerURL, token string, req PermissionRequest) (*PermissionDecision, error) {
+	if brokerURL == "" {
+		return nil, fmt.Errorf("%s is not set", EnvBrokerURL)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, brokerURL+"/v1/approvals", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+
+	// No client timeout: the request intentionally blocks until the user answers.
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("broker returned %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	var decision PermissionDecision
+	if err := json.Unmarshal(data, &decision); err != nil {
+		return nil, fmt.Errorf("parse broker response: %w", err)
+	}
+	return &decision, nil
+}
+
+func denyResult(message string) ToolResult {
+	data, _ := json.Marshal(PermissionDecision{Behavior: "deny", Message: message})
+	return ToolResult{Text: string(data)}
+}
@@ -0,0 +1,179 @@
+// Package mcpserver implements Shannon's built-in MCP server.
+//
+// The Shannon binary re-executes itself with the "mcp-server" argument and is
+// registered with Claude Code via --mcp-config. It speaks the minimal subset of
+// MCP (JSON-RPC 2.0 over newline-delimited stdio) needed to expose tools.
+package mcpserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+)
+
+const protocolVersion = "2024-11-05"
+
+// ServerName is the key Shannon's built-in server is registered under in the
+// MCP config. Claude exposes its tools as mcp__<ServerName>__<tool>.
+const ServerName = "shannon"
+
+// ToolName returns the fully-qualified name Claude uses for a built-in tool.
+func ToolName(tool string) string {
+	return "mcp__" + ServerName + "__" + tool
+}
+
+// ToolResult is the content returned from a tool call.
+type ToolResult struct {
+	Text    string
+	IsError bool
+}
+
+// Tool is a single tool exposed by the server.
+type Tool struct {
+	Name        string
+	Description string
+	InputSchema json.RawMessage
+	Handler     func(args json.RawMessage) ToolResult
+}
+
+// Server is a stdio MCP server with a fixed set of tools.
+type Server struct {
+	name    string
+	version string
+	tools   map[string]Tool
+	order   []string
+
+	writeMu sync.Mutex
+}
+
+func NewServer(name, version string) *Server {
+	return &Server{
+		name:    name,
+		version: version,
+		tools:   make(map[string]Tool),
+	}
+}
+
+// AddTool registers a tool. Tools are listed in registration order.
+func (s *Server) AddTool(t Tool) {
+	if _, exists := s.tools[t.Name]; !exists {
+		s.order = append(s.order, t.Name)
+	}
+	s.tools[t.Name] = t
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// Serve reads requests from in and writes responses to out until in is closed.
+// Tool calls are handled concurrently so a blocking tool (e.g. waiting for a
+// user's approval) doesn't stall pings or other calls.
+func (s *Server) Serve(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+
+	var wg sync.WaitGroup
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			s.write(out, rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: -32700, Message: "parse error"}})
+			continue
+		}
+		// Notifications (no id) never get a response.
+		if len(req.ID) == 0 {
+			continue
+		}
+		if req.Method == "tools/call" {
+			wg.Add(1)
+			go func(req rpcRequest) {
+				defer wg.Done()
+				s.write(out, s.handle(req))
+			}(req)
+			continue
+		}
+		s.write(out, s.handle(req))
+	}
+	wg.Wait()
+	return scanner.Err()
+}
+
+func (s *Server) handle(req rpcRequest) rpcResponse {
+	resp := rpcResponse{JSONRPC: "2.0", ID: req.ID}
+	switch req.Method {
+	case "initialize":
+		resp.Result = map[string]any{
+			"protocolVersion": protocolVersion,
+			"capabilities":    map[string]any{"tools": map[string]any{}},
+			"serverInfo":      map[string]any{"name": s.name, "version": s.version},
+		}
+	case "ping":
+		resp.Result = map[string]any{}
+	case "tools/list":
+		tools := make([]map[string]any, 0, len(s.order))
+		for _, name := range s.order {
+			t := s.tools[name]
+			tools = append(tools, map[string]any{
+				"name":        t.Name,
+				"description": t.Description,
+				"inputSchema": t.InputSchema,
+			})
+		}
+		resp.Result = map[string]any{"tools": tools}
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			resp.Error = &rpcError{Code: -32602, Message: "invalid params"}
+			return resp
+		}
+		tool, ok := s.tools[params.Name]
+		if !ok {
+			resp.Error = &rpcError{Code: -32602, Message: fmt.Sprintf("unknown tool %q", params.Name)}
+			return resp
+		}
+		result := tool.Handler(params.Arguments)
+		resp.Result = map[string]any{
+			"content": []map[string]any{{"type": "text", "text": result.Text}},
+			"isError": result.IsError,
+		}
+	default:
+		resp.Error = &rpcError{Code: -32601, Message: fmt.Sprintf("method not found: %s", req.Method)}
+	}
+	return resp
+}
+
+func (s *Server) write(out io.Writer, resp rpcResponse) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("[mcp-server] marshal response: %v", err)
+		return
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	out.Write(append(data, '\n'))
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"database/sql/driver"
 	"encoding/json"
+	"fmt"
 	"time"
 )
 
@@ -57,19 +58,76 @@ func (m *StringMap) Scan(value any) error {
 	return json.Unmarshal(bytes, m)
 }
 
+// OptionalRef is the ID of a referenced row, or empty for none. It is stored
+// as NULL when empty so the column's foreign key accepts it.
+type OptionalRef string
+
+func (r OptionalRef) Value() (driver.Value, error) {
+	if r == "" {
+		return nil, nil
+	}
+	return string(r), nil
+}
+
+func (r *OptionalRef) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		*r = ""
+	case string:
+		*r = OptionalRef(v)
+	case []byte:
+		*r = OptionalRef(v)
+	default:
+		return fmt.Errorf("unsupported reference type %T", value)
+	}
+	return nil
+}
+
+// SandboxProfile restricts the processes run for an agent (Claude, setup
+// commands, tests). Stored as JSON on the agent.
+type SandboxProfile struct {
+	Enabled       bool     `json:"enabled"`
+	Network       string   `json:"network,omitempty"`        // "api" (default), "none" or "full"
+	AllowedHosts  []string `json:"allowed_hosts,omitempty"`  // extra hosts reachable in "api" mode
+	WritablePaths []string `json:"writable_paths,omitempty"` // writable in addition to the project directory
+}
+
+func (p SandboxProfile) Value() (driver.Value, error) {
+	b, err := json.Marshal(p)
+	return string(b), err
+}
+
+func (p *SandboxProfile) Scan(value any) error {
+	*p = SandboxProfile{}
+	var bytes []byte
+	switch v := value.(type) {
+	case string:
+		bytes = []byte(v)
+	case []byte:
+		bytes = v
+	}
+	if len(bytes) == 0 {
+		return nil
+	}
+	return json.Unmarshal(bytes, p)
+}
+
 type Agent struct {
-	ID              string      `json:"id" gorm:"primaryKey"`
-	Name            string      `json:"name"`
-	Description     string      `json:"description"`
-	Model           string      `json:"model"`
-	SystemPrompt    string      `json:"system_prompt"`
-	AllowedTools    StringSlice `json:"allowed_tools" gorm:"type:text"`
-	DisallowedTools StringSlice `json:"disallowed_tools" gorm:"type:text"`  // tool deny patterns (e.g., "Bash(rm *)")
-	MCPServerIDs    StringSlice `json:"mcp_server_ids" gorm:"type:text"`
-	Permissions     string      `json:"permissions"`
-	ProtectedPaths  StringSlice `json:"protected_paths" gorm:"type:text"`   // paths agents cannot modify
-	ReadOnlyPaths   StringSlice `json:"read_only_paths" gorm:"type:text"`   // paths agents can only read
-	MaxRetries      int         `json:"max_retries" gorm:"default:0"`       // default retry count for tasks
-	CreatedAt       time.Time   `json:"created_at"`
-	UpdatedAt       time.Time   `json:"updated_at"`
+	ID              string         `json:"id" gorm:"primaryKey"`
+	Name            string         `json:"name"`
+	Description     string         `json:"description"`
+	Model           string         `json:"model"`
+	SystemPrompt    string         `json:"system_prompt"`
+	AllowedTools    StringSlice    `json:"allowed_tools" gorm:"type:text"`
+	DisallowedTools StringSlice    `json:"disallowed_tools" gorm:"type:text"` // tool deny patterns (e.g., "Bash(rm *)")
+	MCPServerIDs    StringSlice    `json:"mcp_server_ids" gorm:"type:text"`
+	Permissions     string         `json:"permissions"`
+	ProtectedPaths  StringSlice    `json:"protected_paths" gorm:"type:text"`     // paths agents cannot modify
+	ReadOnlyPaths   StringSlice    `json:"read_only_paths" gorm:"type:text"`     // paths agents can only read
+	MaxRetries      int            `json:"max_retries" gorm:"default:0"`         // default retry count for tasks
+	ViolationAction string         `json:"violation_action" gorm:"default:fail"` // "fail" or "flag" when a run modifies protected/read-only paths
+	Sandbox         SandboxProfile `json:"sandbox" gorm:"type:text"`             // optional namespace sandbox for the agent's processes
+	Env             StringMap      `json:"env" gorm:"type:text"`                 // agent env scope; values may be "vault:KEY" references
+	CreatedAt       time.Time      `json:"created_at"`
+	UpdatedAt       time.Time      `json:"updated_at"`
 }
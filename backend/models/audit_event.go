@@ -0,0 +1,65 @@
+package models
+
+import "time"
+
+// AuditEvent is one entry of the append-only audit log: a state-changing
+// operation, who made it, and JSON snapshots of the entity before and after.
+type AuditEvent struct {
+	ID         string    `json:"id" gorm:"primaryKey"`
+	Actor      string    `json:"actor" gorm:"index"`       // OS user for changes made in the app, "system" for automatic ones
+	Action     string    `json:"action" gorm:"index"`      // see AuditAction* constants
+	EntityType string    `json:"entity_type" gorm:"index"` // see AuditEntity* constants
+	EntityID   string    `json:"entity_id" gorm:"index"`
+	Summary    string    `json:"summary,omitempty"`
+	Before     string    `json:"before,omitempty" gorm:"type:text"` // JSON snapshot, empty for creates
+	After      string    `json:"after,omitempty" gorm:"type:text"`  // JSON snapshot, empty for deletes
+	CreatedAt  time.Time `json:"created_at" gorm:"index"`
+}
+
+// Audit actors.
+const (
+	AuditActorSystem = "system"
+	AuditActorUser   = "user" // used when the OS user name is unknown
+)
+
+// Audited entity types.
+const (
+	AuditEntityProject   = "project"
+	AuditEntityAgent     = "agent"
+	AuditEntityTeam      = "team"
+	AuditEntityMCPServer = "mcp_server"
+	AuditEntityVaultKey  = "vault_key"
+	AuditEntityVault     = "vault"
+	AuditEntitySession   = "session"
+	AuditEntityTask      = "task"
+	AuditEntityBackup    = "backup"
+	AuditEntityLibrary   = "library"
+)
+
+// Audit actions.
+const (
+	AuditActionCreate        = "create"
+	AuditActionUpdate        = "update"
+	AuditActionDelete        = "delete"
+	AuditActionRejectHunk    = "reject_hunk"
+	AuditActionRejectFile    = "reject_file"
+	AuditActionRejectChanges = "reject_changes"
+	AuditActionDismissSecret = "dismiss_secret"
+	AuditActionFollowUp      = "follow_up"
+	AuditActionRetry         = "retry"
+	AuditActionResume        = "resume"
+	AuditActionStart         = "start"
+	AuditActionStop          = "stop"
+	AuditActionComplete      = "complete"
+	AuditActionSetupCommand  = "setup_command"
+	AuditActionImport        = "import"
+	AuditActionExport        = "export"
+	AuditActionRotateKey     = "rotate_key"
+	AuditActionSetPassphrase = "set_passphrase"
+	AuditActionBackup        = "backup"
+	AuditActionRestore       = "restore"
+	AuditActionClone         = "clone"
+	AuditActionFork          = "fork"
+	AuditActionCompare       = "compare"
+	AuditActionApplyVariant  = "apply_variant"
+)
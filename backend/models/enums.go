@@ -12,6 +12,19 @@ const (
 	TaskStatusAwaitingInput TaskStatus = "awaiting_input"
 )
 
+// Values for Agent.ViolationAction.
+const (
+	ViolationActionFail = "fail" // reverted violations fail the task
+	ViolationActionFlag = "flag" // reverted violations are reported but the task keeps its status
+)
+
+// Values for SecretFinding.Status.
+const (
+	SecretFindingOpen      = "open"
+	SecretFindingDismissed = "dismissed" // marked as a false positive or accepted by the user
+	SecretFindingReverted  = "reverted"  // the line is no longer in the diff
+)
+
 type SessionStatus string
 
 const (
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+type PermissionDecision string
+
+const (
+	PermissionAllow PermissionDecision = "allow"
+	PermissionDeny  PermissionDecision = "deny"
+)
+
+// AgentPermissionRule is a remembered answer to a tool approval request.
+// Pattern uses the Claude CLI tool syntax, e.g. "Bash(npm test:*)", "Edit(src/*)" or "WebFetch".
+type AgentPermissionRule struct {
+	ID        string             `json:"id" gorm:"primaryKey"`
+	AgentID   string             `json:"agent_id" gorm:"index"`
+	Pattern   string             `json:"pattern"`
+	Decision  PermissionDecision `json:"decision"`
+	CreatedAt time.Time          `json:"created_at"`
+}
@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"time"
+)
 
 type Project struct {
 	ID            string      `json:"id" gorm:"primaryKey"`
@@ -10,6 +14,41 @@ type Project struct {
 	BuildCommand  string      `json:"build_command,omitempty"`
 	SetupCommands StringSlice `json:"setup_commands" gorm:"type:text"`
 	ClaudeMD      string      `json:"claude_md,omitempty" gorm:"type:text"` // CLAUDE.md content injected into workspace
+	Env           StringMap   `json:"env" gorm:"type:text"`                 // project env scope; values may be "vault:KEY" references
+	SecretRules   SecretRules `json:"secret_rules" gorm:"type:text"`        // custom secret scanning rules, on top of the built-in ones
 	CreatedAt     time.Time   `json:"created_at"`
 	UpdatedAt     time.Time   `json:"updated_at"`
 }
+
+// SecretRule is a custom secret scanning rule: a regular expression matched
+// against the lines a task adds.
+type SecretRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+// SecretRules is a []SecretRule that serializes to JSON for GORM storage.
+type SecretRules []SecretRule
+
+func (r SecretRules) Value() (driver.Value, error) {
+	if r == nil {
+		return "[]", nil
+	}
+	b, err := json.Marshal(r)
+	return string(b), err
+}
+
+func (r *SecretRules) Scan(value any) error {
+	*r = SecretRules{}
+	var bytes []byte
+	switch v := value.(type) {
+	case string:
+		bytes = []byte(v)
+	case []byte:
+		bytes = v
+	}
+	if len(bytes) == 0 {
+		return nil
+	}
+	return json.Unmarshal(bytes, r)
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+type ReviewSeverity string
+
+const (
+	ReviewSeverityInfo     ReviewSeverity = "info"     // a remark; never sent back to the implementer
+	ReviewSeverityMinor    ReviewSeverity = "minor"    // style or small improvements
+	ReviewSeverityMajor    ReviewSeverity = "major"    // bugs or missing pieces that should be fixed
+	ReviewSeverityCritical ReviewSeverity = "critical" // broken, unsafe or wrong changes
+)
+
+// ReviewComment is a code reviewer's remark on a task's changes, anchored to
+// a hunk of a file's diff as it was when the review ran.
+type ReviewComment struct {
+	ID              string         `json:"id" gorm:"primaryKey"`
+	TaskID          string         `json:"task_id" gorm:"index"`
+	AttemptID       string         `json:"attempt_id,omitempty"` // the run whose changes were reviewed
+	Round           int            `json:"round"`                // 1-based review round of the task
+	ReviewerAgentID OptionalRef    `json:"reviewer_agent_id,omitempty"`
+	FilePath        string         `json:"file_path"`
+	HunkIndex       int            `json:"hunk_index"` // -1 for the file as a whole
+	HunkHeader      string         `json:"hunk_header,omitempty"`
+	Severity        ReviewSeverity `json:"severity"`
+	Body            string         `json:"body" gorm:"type:text"`
+	CreatedAt       time.Time      `json:"created_at"`
+}
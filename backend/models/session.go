@@ -10,4 +10,13 @@ type Session struct {
 	CreatedAt   time.Time     `json:"created_at" gorm:"index:idx_session_project_created"`
 	StartedAt   *time.Time    `json:"started_at,omitempty"`
 	CompletedAt *time.Time    `json:"completed_at,omitempty"`
+
+	// Cloning and forking
+	SourceSessionID  string `json:"source_session_id,omitempty"`   // session this one was cloned or forked from
+	ForkedFromTaskID string `json:"forked_from_task_id,omitempty"` // task whose snapshot BaseRef is
+	BaseRef          string `json:"base_ref,omitempty"`            // snapshot commit restored into the project when the session first starts
+
+	// Code review of every task's changes; overrides the option of a task's team
+	CodeReviewerID   OptionalRef `json:"code_reviewer_id,omitempty"`
+	CodeReviewRounds int         `json:"code_review_rounds" gorm:"default:0"` // fix rounds sent back to the implementer
 }
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// StreamEvent is a persisted entry of a task's live output stream (text,
+// tool calls, results). Consecutive text chunks are stored merged. The
+// events are kept so runs stay searchable after the in-memory buffer is gone.
+type StreamEvent struct {
+	ID        int64     `json:"id" gorm:"primaryKey;autoIncrement"`
+	TaskID    string    `json:"task_id" gorm:"index"`
+	Type      string    `json:"type"`
+	Content   string    `json:"content" gorm:"type:text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (StreamEvent) TableName() string { return "task_stream_events" }
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Task struct {
 	ID              string      `json:"id" gorm:"primaryKey"`
@@ -9,11 +12,12 @@ type Task struct {
 	Prompt          string      `json:"prompt"`
 	OriginalPrompt  string      `json:"original_prompt,omitempty" gorm:"type:text"` // preserved for retry
 	Status          TaskStatus  `json:"status" gorm:"index;index:idx_task_session_status;default:pending"`
-	AgentID         string      `json:"agent_id,omitempty"`
-	TeamID          string      `json:"team_id,omitempty"`
+	AgentID         OptionalRef `json:"agent_id,omitempty"`
+	TeamID          OptionalRef `json:"team_id,omitempty"`
 	Dependencies    StringSlice `json:"dependencies" gorm:"type:text"`
 	WorkspacePath   string      `json:"workspace_path,omitempty"`
 	MCPConfigPath   string      `json:"mcp_config_path,omitempty"`
+	Env             StringMap   `json:"env" gorm:"type:text"` // task env scope, applied on top of global → project → agent
 	ClaudeSessionID string      `json:"claude_session_id,omitempty"`
 
 	// Retry & Resume
@@ -29,12 +33,39 @@ type Task struct {
 	// Agent interaction - set when agent needs user input to continue
 	PendingInputData string `json:"pending_input_data,omitempty" gorm:"type:text"`
 
+	// Stage of a sequential team's pipeline that is waiting for input, 0 if
+	// none; the rest of the pipeline runs once a follow-up answers it
+	PipelineStage int `json:"pipeline_stage,omitempty" gorm:"default:0"`
+
+	// Protected/read-only path changes detected (and reverted) after the last run, as JSON []PathViolation
+	PathViolations string `json:"path_violations,omitempty" gorm:"type:text"`
+
+	// Possible secrets found in the task's diff, as JSON []SecretFinding
+	SecretFindings string `json:"secret_findings,omitempty" gorm:"type:text"`
+
 	// Test/Build
 	TestPassed  *bool  `json:"test_passed,omitempty"`
 	TestOutput  string `json:"test_output,omitempty"`
 	BuildPassed *bool  `json:"build_passed,omitempty"`
 	BuildOutput string `json:"build_output,omitempty"`
 
+	// Acceptance review: when AcceptanceCriteria is set, a reviewer agent (the
+	// task's own agent if ReviewerAgentID is empty) checks each run that would
+	// complete. A rejection is sent back as a follow-up up to MaxReviewRounds
+	// times before the task fails.
+	AcceptanceCriteria string      `json:"acceptance_criteria,omitempty" gorm:"type:text"`
+	ReviewerAgentID    OptionalRef `json:"reviewer_agent_id,omitempty"`
+	MaxReviewRounds    int         `json:"max_review_rounds" gorm:"default:0"`
+	ReviewRound        int         `json:"review_round" gorm:"default:0"`
+	AcceptanceVerdict  string      `json:"acceptance_verdict,omitempty" gorm:"type:text"` // latest verdict, as JSON AcceptanceVerdict
+
+	// Code review rounds spent on the current run (see Session/Team.CodeReviewerID)
+	CodeReviewRound int `json:"code_review_round" gorm:"default:0"`
+
+	// Working tree after the task completed, as a commit kept by a ref under
+	// refs/shannon/snapshots; sessions can be forked from it
+	SnapshotCommit string `json:"snapshot_commit,omitempty"`
+
 	// Timestamps
 	CreatedAt   time.Time  `json:"created_at"`
 	StartedAt   *time.Time `json:"started_at,omitempty"`
@@ -42,3 +73,78 @@ type Task struct {
 
 	Error string `json:"error,omitempty"`
 }
+
+// PendingInput is the structured question stored (as JSON) in Task.PendingInputData
+// when a task is awaiting_input.
+type PendingInput struct {
+	Source   string   `json:"source"` // "ask_user" (explicit tool call) or "heuristic" (guessed from output)
+	Question string   `json:"question"`
+	Choices  []string `json:"choices,omitempty"`
+	Context  string   `json:"context,omitempty"`
+	Text     string   `json:"text,omitempty"` // the agent's last output, for display
+}
+
+const (
+	PendingInputSourceAskUser   = "ask_user"
+	PendingInputSourceHeuristic = "heuristic"
+)
+
+// PathViolation is a change to a protected or read-only path found after a run.
+type PathViolation struct {
+	Path     string `json:"path"`
+	Change   string `json:"change"`   // "added", "modified", "deleted", "renamed"
+	Rule     string `json:"rule"`     // "protected" or "read_only"
+	Pattern  string `json:"pattern"`  // the agent path pattern that matched
+	Reverted bool   `json:"reverted"` // false if the automatic revert failed
+	Error    string `json:"error,omitempty"`
+}
+
+// AcceptanceVerdict is a reviewer's judgement of a run against the task's
+// acceptance criteria.
+type AcceptanceVerdict struct {
+	Passed     bool      `json:"passed"`
+	Summary    string    `json:"summary"`
+	Reasons    []string  `json:"reasons"` // unmet criteria or other problems; empty when passed
+	ReviewerID string    `json:"reviewer_id"`
+	Round      int       `json:"round"` // review rounds already spent when the verdict was given
+	ReviewedAt time.Time `json:"reviewed_at"`
+}
+
+// SecretFinding is a possible secret in a line a task added. Open findings
+// block accepting the task's changes until they are dismissed or reverted.
+type SecretFinding struct {
+	ID          string     `json:"id"` // stable across scans: rule, file and fingerprint
+	Path        string     `json:"path"`
+	HunkIndex   int        `json:"hunk_index"` // index in the current diff of the file, -1 once reverted
+	Line        int        `json:"line"`       // line number in the new file
+	Rule        string     `json:"rule"`
+	Preview     string     `json:"preview"`     // the match with most of it masked
+	Fingerprint string     `json:"fingerprint"` // sha256 of the matched text
+	Status      string     `json:"status"`      // "open", "dismissed" or "reverted"
+	Reason      string     `json:"reason,omitempty"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+}
+
+// Encode serializes the question for storage in Task.PendingInputData.
+func (p *PendingInput) Encode() string {
+	if p == nil {
+		return ""
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return p.Question
+	}
+	return string(b)
+}
+
+// Encode serializes the verdict for storage in Task.AcceptanceVerdict.
+func (v *AcceptanceVerdict) Encode() string {
+	if v == nil {
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
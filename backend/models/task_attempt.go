@@ -0,0 +1,71 @@
+package models
+
+import "time"
+
+type AttemptKind string
+
+const (
+	AttemptKindInitial  AttemptKind = "initial"
+	AttemptKindRetry    AttemptKind = "retry"
+	AttemptKindResume   AttemptKind = "resume"
+	AttemptKindFollowUp AttemptKind = "follow_up"
+	AttemptKindVariant  AttemptKind = "variant" // one side of a TaskComparison
+	AttemptKindReview   AttemptKind = "review"  // follow-up sent because a reviewer rejected the work
+	AttemptKindStage    AttemptKind = "stage"   // a later agent of a sequential team's pipeline
+)
+
+// TaskAttempt records a single Claude run for a task. Retries, resumes and
+// follow-ups each create a new attempt, so earlier results are never lost
+// when the Task's own result fields are overwritten by the latest run.
+type TaskAttempt struct {
+	ID              string      `json:"id" gorm:"primaryKey"`
+	TaskID          string      `json:"task_id" gorm:"index;uniqueIndex:idx_attempt_task_number"`
+	Number          int         `json:"number" gorm:"uniqueIndex:idx_attempt_task_number"` // 1-based, per task
+	Kind            AttemptKind `json:"kind"`
+	Prompt          string      `json:"prompt" gorm:"type:text"`
+	AgentID         OptionalRef `json:"agent_id,omitempty"`
+	AgentName       string      `json:"agent_name,omitempty"`
+	Model           string      `json:"model,omitempty"`
+	ClaudeSessionID string      `json:"claude_session_id,omitempty"`
+	Status          TaskStatus  `json:"status"`
+	Stage           int         `json:"stage,omitempty"` // 1-based position in a sequential team's pipeline; 0 otherwise
+
+	// Results
+	ExitCode     int         `json:"exit_code"`
+	ResultText   string      `json:"result_text,omitempty" gorm:"type:text"`
+	Error        string      `json:"error,omitempty" gorm:"type:text"`
+	FilesChanged StringSlice `json:"files_changed" gorm:"type:text"`
+	DiffSnapshot string      `json:"diff_snapshot,omitempty" gorm:"type:text"` // unified diff of the workspace after the run
+
+	// Test/Build
+	TestPassed  *bool  `json:"test_passed,omitempty"`
+	TestOutput  string `json:"test_output,omitempty" gorm:"type:text"`
+	BuildPassed *bool  `json:"build_passed,omitempty"`
+	BuildOutput string `json:"build_output,omitempty" gorm:"type:text"`
+
+	// Token usage reported by the Claude result event
+	InputTokens         int     `json:"input_tokens"`
+	OutputTokens        int     `json:"output_tokens"`
+	CacheReadTokens     int     `json:"cache_read_tokens"`
+	CacheCreationTokens int     `json:"cache_creation_tokens"`
+	CostUSD             float64 `json:"cost_usd"`
+	NumTurns            int     `json:"num_turns"`
+
+	// Acceptance review of this run, as JSON AcceptanceVerdict
+	AcceptanceVerdict string `json:"acceptance_verdict,omitempty" gorm:"type:text"`
+
+	// A/B comparison (variant attempts only)
+	ComparisonID OptionalRef `json:"comparison_id,omitempty"`
+	Workspace    string      `json:"workspace,omitempty"` // git worktree the variant ran in, removed once a winner is chosen
+	JudgeScore   *float64    `json:"judge_score,omitempty"`
+	JudgeReason  string      `json:"judge_reason,omitempty" gorm:"type:text"`
+
+	// Guarded paths the variant changed, as JSON []PathViolation (the task
+	// records its own runs' violations in Task.PathViolations)
+	PathViolations string `json:"path_violations,omitempty" gorm:"type:text"`
+
+	// Timestamps
+	StartedAt   time.Time  `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	DurationMS  int64      `json:"duration_ms"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+type ComparisonStatus string
+
+const (
+	ComparisonStatusRunning   ComparisonStatus = "running"
+	ComparisonStatusReady     ComparisonStatus = "ready"     // every variant finished; waiting for a winner
+	ComparisonStatusApplied   ComparisonStatus = "applied"   // the winner's changes were applied to the project
+	ComparisonStatusDiscarded ComparisonStatus = "discarded" // no changes were applied
+)
+
+// TaskComparison is an A/B run of one task prompt against several agents or
+// models. Each variant is a TaskAttempt (kind "variant") that runs in its own
+// git worktree, checked out from a snapshot of the project taken when the
+// comparison started.
+type TaskComparison struct {
+	ID              string           `json:"id" gorm:"primaryKey"`
+	TaskID          string           `json:"task_id" gorm:"index"`
+	Prompt          string           `json:"prompt" gorm:"type:text"`
+	BaseCommit      string           `json:"base_commit"` // snapshot the variants start from
+	Status          ComparisonStatus `json:"status"`
+	JudgeAgentID    OptionalRef      `json:"judge_agent_id,omitempty"`
+	JudgeSummary    string           `json:"judge_summary,omitempty" gorm:"type:text"`
+	Error           string           `json:"error,omitempty"`
+	SuggestedWinner string           `json:"suggested_winner,omitempty"` // attempt ID ranked best
+	WinnerAttemptID string           `json:"winner_attempt_id,omitempty"`
+	CreatedAt       time.Time        `json:"created_at"`
+	CompletedAt     *time.Time       `json:"completed_at,omitempty"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+type MessageRole string
+
+const (
+	MessageRoleUser  MessageRole = "user"
+	MessageRoleAgent MessageRole = "agent"
+)
+
+// TaskMessage is one turn of the conversation between the user and a task's agent.
+// The initial task prompt, every follow-up and every agent reply are stored so the
+// chat survives restarts and can be exported.
+type TaskMessage struct {
+	ID        string      `json:"id" gorm:"primaryKey"`
+	TaskID    string      `json:"task_id" gorm:"index"`
+	AttemptID string      `json:"attempt_id,omitempty"` // the TaskAttempt this message belongs to
+	Role      MessageRole `json:"role"`
+	Mode      string      `json:"mode,omitempty"` // follow-up mode: "plan", "auto" or "code"; empty for the initial prompt
+	Content   string      `json:"content" gorm:"type:text"`
+	IsError   bool        `json:"is_error,omitempty"` // agent turn ended with an error instead of a reply
+	CreatedAt time.Time   `json:"created_at"`
+}
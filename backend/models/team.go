@@ -81,4 +81,8 @@ type Team struct {
 	Edges       EdgeSlice    `json:"edges" gorm:"type:text"`
 	CreatedAt   time.Time    `json:"created_at"`
 	UpdatedAt   time.Time    `json:"updated_at"`
+
+	// Code review of the changes of tasks run by this team
+	CodeReviewerID   OptionalRef `json:"code_reviewer_id,omitempty"`
+	CodeReviewRounds int         `json:"code_review_rounds" gorm:"default:0"` // fix rounds sent back to the implementer
 }
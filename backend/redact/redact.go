@@ -0,0 +1,172 @@
+// Package redact masks secrets in text before it leaves the process: in stream
+// events sent to the frontend, in task output stored in the database and in
+// log lines. It sits below store and services so both can use it without an
+// import cycle.
+package redact
+
+import (
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Placeholder replaces every redacted secret.
+const Placeholder = "[REDACTED]"
+
+// minSecretLen keeps short vault values ("1", "true", a region name) from
+// turning ordinary words into placeholders.
+const minSecretLen = 8
+
+// TokenPattern is a well-known credential format.
+type TokenPattern struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// tokenPatterns match well-known credential formats even when the value is
+// not in the vault (e.g. a token the agent read from a file).
+var tokenPatterns = []TokenPattern{
+	{"github_token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{20,}`)},
+	{"github_pat", regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{20,}`)},
+	{"gitlab_pat", regexp.MustCompile(`\bglpat-[A-Za-z0-9_\-]{20,}`)},
+	{"anthropic_api_key", regexp.MustCompile(`\bsk-ant-[A-Za-z0-9_\-]{20,}`)},
+	{"openai_api_key", regexp.MustCompile(`\bsk-(?:proj-)?[A-Za-z0-9_\-]{32,}`)},
+	{"slack_token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9\-]{10,}`)},
+	{"aws_access_key_id", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"google_api_key", regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{35}`)},
+	{"npm_token", regexp.MustCompile(`\bnpm_[A-Za-z0-9]{36}\b`)},
+}
+
+// TokenPatterns returns the built-in credential formats, e.g. for scanning.
+func TokenPatterns() []TokenPattern {
+	return append([]TokenPattern(nil), tokenPatterns...)
+}
+
+// Filter replaces known secret values and common token formats with Placeholder.
+// It is safe for concurrent use; SetSecrets may be called at any time.
+type Filter struct {
+	mu       sync.RWMutex
+	replacer *strings.Replacer // nil when there are no secrets
+}
+
+// New returns a filter that only masks the built-in token patterns.
+func New() *Filter {
+	return &Filter{}
+}
+
+// Default is the process-wide filter, fed with the vault's current values.
+var Default = New()
+
+// SetSecrets replaces the literal values to mask. Values shorter than 8
+// characters are ignored.
+func (f *Filter) SetSecrets(values []string) {
+	var secrets []string
+	seen := make(map[string]bool, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if len(v) < minSecretLen || seen[v] {
+			continue
+		}
+		seen[v] = true
+		secrets = append(secrets, v)
+	}
+	// Longest first so a secret containing another is masked as a whole
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+
+	var r *strings.Replacer
+	if len(secrets) > 0 {
+		pairs := make([]string, 0, 2*len(secrets))
+		for _, s := range secrets {
+			pairs = append(pairs, s, Placeholder)
+		}
+		r = strings.NewReplacer(pairs...)
+	}
+
+	f.mu.Lock()
+	f.replacer = r
+	f.mu.Unlock()
+}
+
+// String returns s with all secrets masked.
+func (f *Filter) String(s string) string {
+	if s == "" {
+		return s
+	}
+	f.mu.RLock()
+	r := f.replacer
+	f.mu.RUnlock()
+	if r != nil {
+		s = r.Replace(s)
+	}
+	for _, tp := range tokenPatterns {
+		s = tp.Pattern.ReplaceAllString(s, Placeholder)
+	}
+	return s
+}
+
+// Value returns a copy of v with every string inside maps and slices masked.
+// Other values are returned unchanged.
+func (f *Filter) Value(v any) any {
+	switch t := v.(type) {
+	case string:
+		return f.String(t)
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, val := range t {
+			out[k] = f.Value(val)
+		}
+		return out
+	case map[string]string:
+		out := make(map[string]string, len(t))
+		for k, val := range t {
+			out[k] = f.String(val)
+		}
+		return out
+	case []any:
+		out := make([]any, len(t))
+		for i, val := range t {
+			out[i] = f.Value(val)
+		}
+		return out
+	case []string:
+		out := make([]string, len(t))
+		for i, val := range t {
+			out[i] = f.String(val)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// Writer wraps w so everything written through it is masked first. The log
+// package writes one line per call, so secrets are never split across writes.
+func (f *Filter) Writer(w io.Writer) io.Writer {
+	return &writer{f: f, w: w}
+}
+
+type writer struct {
+	f *Filter
+	w io.Writer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, w.f.String(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// SetSecrets sets the secrets of the Default filter.
+func SetSecrets(values []string) { Default.SetSecrets(values) }
+
+// String masks s with the Default filter.
+func String(s string) string { return Default.String(s) }
+
+// Value masks v with the Default filter.
+func Value(v any) any { return Default.Value(v) }
+
+// Writer wraps w with the Default filter.
+func Writer(w io.Writer) io.Writer { return Default.Writer(w) }
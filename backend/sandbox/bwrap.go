@@ -0,0 +1,49 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+)
+
+// bwrapArgs builds the bubblewrap command line for a spec. The root filesystem
+// (including HOME) is mounted read-only; /tmp is a private tmpfs; the work
+// directory and extra writable paths are bind-mounted read-write on top.
+func bwrapArgs(spec initSpec, self string) []string {
+	args := []string{
+		"--die-with-parent",
+		"--new-session",
+		"--unshare-user-try",
+		"--unshare-ipc",
+		"--unshare-pid",
+		"--unshare-uts",
+		"--unshare-cgroup-try",
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--tmpfs", "/tmp",
+	}
+	if spec.Network != NetworkFull {
+		args = append(args, "--unshare-net")
+	}
+	// The helper binary may live under /tmp (e.g. during development).
+	if strings.HasPrefix(self, "/tmp/") {
+		args = append(args, "--ro-bind", self, self)
+	}
+	for _, w := range append([]string{spec.WorkDir}, spec.Writable...) {
+		args = append(args, "--bind", w, w)
+	}
+	args = append(args, "--chdir", spec.WorkDir, "--")
+
+	// Without host networking, the bridge provides the proxy and forwarded ports.
+	if spec.ProxySocket != "" {
+		data, _ := json.Marshal(initSpec{
+			ProxySocket:  spec.ProxySocket,
+			ForwardPorts: spec.ForwardPorts,
+			Network:      spec.Network,
+			WorkDir:      filepath.Clean(spec.WorkDir),
+		})
+		args = append(args, self, "sandbox-bridge", "--spec", string(data), "--")
+	}
+	return append(args, spec.Command...)
+}
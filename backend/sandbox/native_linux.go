@@ -0,0 +1,232 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const oPath = 0x200000 // O_PATH, missing from package syscall
+
+// Statfs flags that must be preserved when remounting (locked in user namespaces).
+const preservedMountFlags = syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC |
+	syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME
+
+func nativeSupported() error {
+	for _, f := range []string{"/proc/sys/kernel/unprivileged_userns_clone", "/proc/sys/user/max_user_namespaces"} {
+		if data, err := os.ReadFile(f); err == nil && strings.TrimSpace(string(data)) == "0" {
+			return fmt.Errorf("user namespaces are disabled (%s = 0)", f)
+		}
+	}
+	return nil
+}
+
+// nativeCommand re-executes Shannon as "sandbox-init" in new user, mount, pid,
+// ipc, uts and (unless the network is unrestricted) network namespaces.
+func nativeCommand(ctx context.Context, spec initSpec, self string) (*exec.Cmd, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, self, "sandbox-init", "--spec", string(data))
+	flags := syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+	if spec.Network != NetworkFull {
+		flags |= syscall.CLONE_NEWNET
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:                 uintptr(flags),
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: spec.HostUID, Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: spec.HostGID, Size: 1}},
+		GidMappingsEnableSetgroups: false,
+		Pdeathsig:                  syscall.SIGKILL,
+	}
+	return cmd, nil
+}
+
+// setupNamespace runs as root of the new user namespace: it makes every mount
+// read-only except the writable paths, gives the sandbox a private /tmp and
+// brings up loopback for the network bridge.
+func setupNamespace(spec initSpec) error {
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make mounts private: %w", err)
+	}
+	mounts, err := mountPoints()
+	if err != nil {
+		return err
+	}
+
+	// Keep handles to the writable paths: the tmpfs on /tmp may hide some of them.
+	writable := append([]string{spec.WorkDir}, spec.Writable...)
+	handles := make([]int, len(writable))
+	for i, w := range writable {
+		fd, err := syscall.Open(w, oPath|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("open %s: %w", w, err)
+		}
+		handles[i] = fd
+	}
+
+	for _, mp := range mounts {
+		if mp == "/proc" || strings.HasPrefix(mp, "/proc/") || mp == "/sys" || strings.HasPrefix(mp, "/sys/") || mp == "/dev" || strings.HasPrefix(mp, "/dev/") {
+			continue
+		}
+		if err := remount(mp, true); err != nil {
+			return fmt.Errorf("remount %s read-only: %w", mp, err)
+		}
+	}
+
+	if err := syscall.Mount("tmpfs", "/tmp", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777"); err != nil {
+		return fmt.Errorf("mount /tmp: %w", err)
+	}
+
+	for i, w := range writable {
+		if err := ensureMountPoint(w, handles[i]); err != nil {
+			return err
+		}
+		src := "/proc/self/fd/" + strconv.Itoa(handles[i])
+		if err := syscall.Mount(src, w, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("bind %s: %w", w, err)
+		}
+		if err := remount(w, false); err != nil {
+			return fmt.Errorf("remount %s read-write: %w", w, err)
+		}
+		syscall.Close(handles[i])
+	}
+
+	// A fresh /proc for the new pid namespace; not permitted everywhere (e.g.
+	// inside containers with masked /proc paths), in which case the old one stays.
+	syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+
+	if spec.Network != NetworkFull {
+		if err := loopbackUp(); err != nil {
+			return fmt.Errorf("bring up loopback: %w", err)
+		}
+	}
+	return nil
+}
+
+// innerUserNamespace returns a hook that runs the command as the original user
+// (in a nested user namespace) instead of as root of the sandbox namespace.
+func innerUserNamespace(spec initSpec) func(*exec.Cmd) {
+	if spec.HostUID == 0 {
+		return nil
+	}
+	return func(cmd *exec.Cmd) {
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Cloneflags:                 syscall.CLONE_NEWUSER,
+			UidMappings:                []syscall.SysProcIDMap{{ContainerID: spec.HostUID, HostID: 0, Size: 1}},
+			GidMappings:                []syscall.SysProcIDMap{{ContainerID: spec.HostGID, HostID: 0, Size: 1}},
+			GidMappingsEnableSetgroups: false,
+			Credential:                 &syscall.Credential{Uid: uint32(spec.HostUID), Gid: uint32(spec.HostGID), NoSetGroups: true},
+		}
+	}
+}
+
+func remount(mp string, readOnly bool) error {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(mp, &st); err != nil {
+		return err
+	}
+	flags := uintptr(st.Flags)&preservedMountFlags | syscall.MS_REMOUNT | syscall.MS_BIND
+	if readOnly {
+		flags |= syscall.MS_RDONLY
+	}
+	return syscall.Mount("", mp, "", flags, "")
+}
+
+// ensureMountPoint recreates a writable path that is hidden by the /tmp tmpfs.
+func ensureMountPoint(path string, fd int) error {
+	if _, err := os.Lstat(path); err == nil {
+		return nil
+	}
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return err
+	}
+	if st.Mode&syscall.S_IFMT == syscall.S_IFDIR {
+		return os.MkdirAll(path, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// mountPoints lists mount points from /proc/self/mountinfo, parents first.
+func mountPoints() ([]string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	seen := map[string]bool{}
+	var mounts []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mp := unescapeMountPath(fields[4])
+		if !seen[mp] {
+			seen[mp] = true
+			mounts = append(mounts, mp)
+		}
+	}
+	sort.Strings(mounts)
+	return mounts, sc.Err()
+}
+
+// unescapeMountPath decodes the octal escapes (\040 etc.) used in mountinfo.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				sb.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
+
+func loopbackUp() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, 0)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	var ifr [40]byte // struct ifreq: 16-byte name followed by the flags union
+	copy(ifr[:], "lo")
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&ifr[0]))); errno != 0 {
+		return errno
+	}
+	flags := (*uint16)(unsafe.Pointer(&ifr[16]))
+	*flags |= syscall.IFF_UP | syscall.IFF_RUNNING
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&ifr[0]))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+)
+
+var errUnsupported = errors.New("namespaces are only available on Linux")
+
+func nativeSupported() error {
+	return errUnsupported
+}
+
+func nativeCommand(ctx context.Context, spec initSpec, self string) (*exec.Cmd, error) {
+	return nil, errUnsupported
+}
+
+func setupNamespace(spec initSpec) error {
+	return errUnsupported
+}
+
+func innerUserNamespace(spec initSpec) func(*exec.Cmd) {
+	return nil
+}
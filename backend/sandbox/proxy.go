@@ -0,0 +1,298 @@
+package sandbox
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// proxy is the host side of the sandbox network: an HTTP CONNECT proxy on a
+// unix socket that only dials allowlisted hosts and forwarded loopback ports.
+type proxy struct {
+	dir        string
+	socketPath string
+	ln         net.Listener
+	hosts      []string
+	ports      map[int]bool
+	closeOnce  sync.Once
+}
+
+func startProxy(hosts []string, forwardPorts []int) (*proxy, error) {
+	dir, err := os.MkdirTemp("", "shannon-sandbox-")
+	if err != nil {
+		return nil, err
+	}
+	sock := filepath.Join(dir, "proxy.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	p := &proxy{dir: dir, socketPath: sock, ln: ln, hosts: hosts, ports: make(map[int]bool)}
+	for _, port := range forwardPorts {
+		p.ports[port] = true
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.handle(conn)
+		}
+	}()
+	return p, nil
+}
+
+// Close stops the proxy and removes its socket directory.
+func (p *proxy) Close() {
+	p.closeOnce.Do(func() {
+		p.ln.Close()
+		os.RemoveAll(p.dir)
+	})
+}
+
+func (p *proxy) handle(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return
+	}
+	if req.Method != http.MethodConnect {
+		io.WriteString(conn, "HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\n\r\n")
+		return
+	}
+	host, portStr, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n")
+		return
+	}
+	port, _ := strconv.Atoi(portStr)
+	if !p.allowed(host, port) {
+		log.Printf("[sandbox] blocked connection to %s", req.Host)
+		io.WriteString(conn, "HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\n\r\n")
+		return
+	}
+
+	target, err := net.DialTimeout("tcp", req.Host, 15*time.Second)
+	if err != nil {
+		io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+		return
+	}
+	defer target.Close()
+	io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+	pipe(&bufferedConn{Conn: conn, r: br}, target)
+}
+
+func (p *proxy) allowed(host string, port int) bool {
+	if isLoopback(host) {
+		return p.ports[port]
+	}
+	return hostAllowed(p.hosts, host)
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// hostAllowed matches a host against "example.com" and "*.example.com" entries.
+func hostAllowed(allowed []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, a := range allowed {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if a == host {
+			return true
+		}
+		if strings.HasPrefix(a, "*.") && strings.HasSuffix(host, a[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedConn reads through the bufio.Reader used to parse the request so
+// bytes the client sent right after CONNECT aren't lost.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	cp := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+		done <- struct{}{}
+	}
+	go cp(a, b)
+	go cp(b, a)
+	<-done
+	<-done
+}
+
+// ─── In-sandbox side ───────────────────────────────────
+
+// Main runs a sandbox helper subcommand ("sandbox-init" or "sandbox-bridge")
+// and returns the process exit code. args[0] is the subcommand.
+func Main(args []string) int {
+	if len(args) == 0 {
+		return 2
+	}
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	specJSON := fs.String("spec", "", "sandbox spec (JSON)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	var spec initSpec
+	if err := json.Unmarshal([]byte(*specJSON), &spec); err != nil {
+		fmt.Fprintln(os.Stderr, "sandbox: invalid spec:", err)
+		return 2
+	}
+	// Under bwrap the command follows "--"; the native init carries it in the spec.
+	if rest := fs.Args(); len(rest) > 0 {
+		spec.Command = rest
+	}
+	if len(spec.Command) == 0 {
+		fmt.Fprintln(os.Stderr, "sandbox: no command")
+		return 2
+	}
+
+	switch args[0] {
+	case "sandbox-init":
+		if err := setupNamespace(spec); err != nil {
+			fmt.Fprintln(os.Stderr, "sandbox: setup failed:", err)
+			return 126
+		}
+		return runBridged(spec, innerUserNamespace(spec))
+	case "sandbox-bridge":
+		return runBridged(spec, nil)
+	}
+	fmt.Fprintln(os.Stderr, "sandbox: unknown subcommand", args[0])
+	return 2
+}
+
+// runBridged starts the network bridge (if any), runs the command and returns its exit code.
+func runBridged(spec initSpec, prepare func(*exec.Cmd)) int {
+	env := os.Environ()
+	if spec.ProxySocket != "" {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "sandbox: proxy bridge:", err)
+			return 126
+		}
+		go serveBridge(ln, spec.ProxySocket, "")
+		proxyURL := "http://" + ln.Addr().String()
+		env = withoutProxyEnv(env)
+		env = append(env,
+			"HTTPS_PROXY="+proxyURL, "https_proxy="+proxyURL,
+			"HTTP_PROXY="+proxyURL, "http_proxy="+proxyURL,
+			"NO_PROXY=localhost,127.0.0.1", "no_proxy=localhost,127.0.0.1",
+		)
+		for _, port := range spec.ForwardPorts {
+			fwd, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "sandbox: forward port %d: %v\n", port, err)
+				continue
+			}
+			go serveBridge(fwd, spec.ProxySocket, fmt.Sprintf("127.0.0.1:%d", port))
+		}
+	}
+
+	cmd := exec.Command(spec.Command[0], spec.Command[1:]...)
+	cmd.Dir = spec.WorkDir
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if prepare != nil {
+		prepare(cmd)
+	}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintln(os.Stderr, "sandbox:", err)
+		return 127
+	}
+
+	sigs := make(chan os.Signal, 4)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	go func() {
+		for s := range sigs {
+			cmd.Process.Signal(s)
+		}
+	}()
+
+	err := cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// serveBridge forwards local TCP connections to the host proxy. With a target,
+// each connection is first tunnelled to it via CONNECT (forwarded ports);
+// otherwise the client speaks the proxy protocol itself.
+func serveBridge(ln net.Listener, socketPath, target string) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			upstream, err := net.Dial("unix", socketPath)
+			if err != nil {
+				return
+			}
+			defer upstream.Close()
+			if target == "" {
+				pipe(conn, upstream)
+				return
+			}
+			fmt.Fprintf(upstream, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+			br := bufio.NewReader(upstream)
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil || resp.StatusCode != http.StatusOK {
+				return
+			}
+			pipe(conn, &bufferedConn{Conn: upstream, r: br})
+		}()
+	}
+}
+
+func withoutProxyEnv(env []string) []string {
+	var out []string
+	for _, kv := range env {
+		key := strings.ToUpper(strings.SplitN(kv, "=", 2)[0])
+		switch key {
+		case "HTTPS_PROXY", "HTTP_PROXY", "ALL_PROXY", "NO_PROXY":
+			continue
+		}
+		out = append(out, kv)
+	}
+	return out
+}
@@ -0,0 +1,56 @@
+package sandbox
+
+import "testing"
+
+func TestHostAllowed(t *testing.T) {
+	allowed := []string{"api.anthropic.com", "*.github.com", " Registry.NPMJS.org "}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"api.anthropic.com", true},
+		{"API.Anthropic.com", true},
+		{"api.anthropic.com.", true},
+		{"anthropic.com", false},
+		{"evil-api.anthropic.com", false},
+		{"api.github.com", true},
+		{"codeload.api.github.com", true},
+		{"github.com", false}, // "*." needs a subdomain
+		{"evilgithub.com", false},
+		{"github.com.evil.com", false},
+		{"registry.npmjs.org", true},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := hostAllowed(allowed, tt.host); got != tt.want {
+			t.Errorf("hostAllowed(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+	if hostAllowed(nil, "api.anthropic.com") {
+		t.Error("an empty allowlist allowed a host")
+	}
+}
+
+func TestProxyAllowed(t *testing.T) {
+	p := &proxy{hosts: []string{"*.example.com"}, ports: map[int]bool{5432: true}}
+	tests := []struct {
+		host string
+		port int
+		want bool
+	}{
+		{"localhost", 5432, true},
+		{"127.0.0.1", 5432, true},
+		{"::1", 5432, true},
+		{"127.0.0.1", 22, false}, // loopback only on forwarded ports
+		{"localhost", 443, false},
+		{"www.example.com", 443, true},
+		{"www.example.com", 22, true},
+		{"example.org", 443, false},
+		{"10.0.0.1", 5432, false},
+	}
+	for _, tt := range tests {
+		if got := p.allowed(tt.host, tt.port); got != tt.want {
+			t.Errorf("allowed(%q, %d) = %v, want %v", tt.host, tt.port, got, tt.want)
+		}
+	}
+}
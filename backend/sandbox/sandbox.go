@@ -0,0 +1,171 @@
+// Package sandbox runs agent processes (Claude, setup commands, tests) inside
+// Linux namespaces so only the task workspace is writable and network access is
+// limited to an allowlist of hosts.
+//
+// Two backends are supported: bubblewrap ("bwrap") when it is installed, and a
+// native fallback in which the Shannon binary re-executes itself as the
+// namespace init ("sandbox-init"). Either way, filtered network access goes
+// through an allowlisting CONNECT proxy on the host, reached over a unix
+// socket by a small bridge running inside the sandbox.
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Network modes.
+const (
+	NetworkAPI  = "api"  // only AllowedHosts (default: the model API) via proxy
+	NetworkNone = "none" // no network except forwarded loopback ports
+	NetworkFull = "full" // unrestricted host network
+)
+
+// DefaultAPIHosts are reachable in NetworkAPI mode in addition to Profile.AllowedHosts.
+var DefaultAPIHosts = []string{"api.anthropic.com", "*.anthropic.com", "claude.ai", "*.claude.ai"}
+
+// Profile describes the restrictions applied to a sandboxed command.
+type Profile struct {
+	Network      string   // NetworkAPI (default), NetworkNone or NetworkFull
+	AllowedHosts []string // extra hosts for NetworkAPI ("example.com" or "*.example.com")
+	Writable     []string // paths writable in addition to the working directory
+	ForwardPorts []int    // host loopback ports reachable at the same port inside the sandbox
+}
+
+func (p *Profile) network() string {
+	switch p.Network {
+	case NetworkNone, NetworkFull:
+		return p.Network
+	}
+	return NetworkAPI
+}
+
+// Backend returns the backend that will be used ("bwrap" or "native"), or an
+// error explaining why sandboxing isn't available on this machine.
+func Backend() (string, error) {
+	if _, err := exec.LookPath("bwrap"); err == nil {
+		return "bwrap", nil
+	}
+	if err := nativeSupported(); err != nil {
+		return "", fmt.Errorf("sandbox unavailable: bwrap not installed and %v", err)
+	}
+	return "native", nil
+}
+
+// Command returns an exec.Cmd running name/args in workDir under the profile.
+// With a nil profile it is a plain exec.CommandContext. The returned cleanup
+// must be called after the command has exited (it stops the network proxy).
+// Sandboxing never silently degrades: if it can't be set up, an error is returned.
+func Command(ctx context.Context, p *Profile, workDir string, name string, args ...string) (*exec.Cmd, func(), error) {
+	if p == nil {
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Dir = workDir
+		return cmd, func() {}, nil
+	}
+
+	backend, err := Backend()
+	if err != nil {
+		return nil, nil, err
+	}
+	self, err := executable()
+	if err != nil {
+		return nil, nil, err
+	}
+	if resolved, err := exec.LookPath(name); err == nil {
+		name = resolved
+	}
+
+	spec := initSpec{
+		WorkDir:      absPath(workDir),
+		Network:      p.network(),
+		ForwardPorts: p.ForwardPorts,
+		Command:      append([]string{name}, args...),
+		HostUID:      os.Getuid(),
+		HostGID:      os.Getgid(),
+	}
+	for _, w := range p.Writable {
+		if w = absPath(expandHome(w)); w != "" {
+			if _, err := os.Stat(w); err == nil {
+				spec.Writable = append(spec.Writable, w)
+			}
+		}
+	}
+
+	cleanup := func() {}
+	if spec.Network != NetworkFull {
+		var hosts []string
+		if spec.Network == NetworkAPI {
+			hosts = append(append(hosts, DefaultAPIHosts...), p.AllowedHosts...)
+		}
+		proxy, err := startProxy(hosts, p.ForwardPorts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("start sandbox network proxy: %w", err)
+		}
+		spec.ProxySocket = proxy.socketPath
+		spec.Writable = append(spec.Writable, proxy.dir)
+		cleanup = proxy.Close
+	}
+
+	var cmd *exec.Cmd
+	switch backend {
+	case "bwrap":
+		cmd = exec.CommandContext(ctx, "bwrap", bwrapArgs(spec, self)...)
+	default:
+		cmd, err = nativeCommand(ctx, spec, self)
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+	}
+	cmd.Dir = spec.WorkDir
+	return cmd, cleanup, nil
+}
+
+// initSpec is everything the in-sandbox helper needs, passed as JSON.
+type initSpec struct {
+	WorkDir      string   `json:"work_dir"`
+	Writable     []string `json:"writable,omitempty"`
+	Network      string   `json:"network"`
+	ProxySocket  string   `json:"proxy_socket,omitempty"`
+	ForwardPorts []int    `json:"forward_ports,omitempty"`
+	Command      []string `json:"command"`
+	HostUID      int      `json:"host_uid"`
+	HostGID      int      `json:"host_gid"`
+}
+
+func executable() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("resolve executable: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	return exe, nil
+}
+
+func expandHome(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, strings.TrimPrefix(p, "~"))
+		}
+	}
+	return p
+}
+
+func absPath(p string) string {
+	if p == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		p = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(p); err == nil {
+		p = resolved
+	}
+	return p
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"agent-workflow/backend/models"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// maxReviewOutput caps the test and build output in the acceptance review prompt.
+const maxReviewOutput = 5000
+
+func acceptanceVerdictJSONSchema() string {
+	return `{
+  "type": "object",
+  "required": ["passed", "summary", "reasons"],
+  "properties": {
+    "passed": { "type": "boolean", "description": "True only if every acceptance criterion is met" },
+    "summary": { "type": "string", "description": "The verdict in one or two sentences" },
+    "reasons": {
+      "type": "array",
+      "description": "Each unmet criterion or other problem, specific enough to act on; empty when passed",
+      "items": { "type": "string" }
+    }
+  },
+  "additionalProperties": false
+}`
+}
+
+// reviewRun decides the status of a run that would otherwise complete: it
+// must meet the task's acceptance criteria, if any, and then goes through
+// code review if that is enabled and the run changed anything. When a
+// reviewer asks for changes and rounds are left, the task stays running and
+// the follow-up to send is returned.
+func (te *TaskEngine) reviewRun(ctx context.Context, task *models.Task, project *models.Project, agent *models.Agent, attempt *models.TaskAttempt, diffResult *DiffResult, changed bool) string {
+	if strings.TrimSpace(task.AcceptanceCriteria) != "" {
+		if fix, ok := te.acceptanceReview(ctx, task, project, agent, attempt, diffResult); !ok {
+			return fix
+		}
+	}
+	task.Status = models.TaskStatusCompleted
+	if !changed {
+		return ""
+	}
+	return te.codeReview(ctx, task, project, attempt, diffResult)
+}
+
+// reviewsApply reports whether a completed run of task goes through
+// acceptance or code review.
+func (te *TaskEngine) reviewsApply(task *models.Task) bool {
+	if strings.TrimSpace(task.AcceptanceCriteria) != "" {
+		return true
+	}
+	reviewerID, _ := te.codeReviewer(task)
+	return reviewerID != "" && te.reviewComments != nil
+}
+
+// acceptanceReview stores the reviewer's verdict on the task and attempt and
+// reports whether the run passed. A failed run either fails the task or,
+// with review rounds left, keeps it running and returns the fix prompt.
+func (te *TaskEngine) acceptanceReview(ctx context.Context, task *models.Task, project *models.Project, agent *models.Agent, attempt *models.TaskAttempt, diffResult *DiffResult) (string, bool) {
+	te.emitStreamEvent(task.ID, "init", "Reviewing the changes against the acceptance criteria")
+	verdict, err := te.reviewAcceptance(ctx, task, project, agent, diffResult)
+	if err != nil {
+		log.Printf("task %s: acceptance review failed: %v", task.ID, err)
+		te.emitStreamEvent(task.ID, "error", fmt.Sprintf("Acceptance review failed: %v", err))
+		task.Status = models.TaskStatusFailed
+		task.Error = fmt.Sprintf("acceptance review failed: %v", err)
+		return "", false
+	}
+	task.AcceptanceVerdict = verdict.Encode()
+	if attempt != nil {
+		attempt.AcceptanceVerdict = task.AcceptanceVerdict
+	}
+	if te.wailsCtx != nil {
+		emitEvent(te.wailsCtx, "task:acceptance", map[string]any{
+			"task_id": task.ID,
+			"verdict": verdict,
+		})
+	}
+
+	switch {
+	case verdict.Passed:
+		log.Printf("task %s: acceptance criteria met", task.ID)
+		return "", true
+	case task.ReviewRound < task.MaxReviewRounds && task.ClaudeSessionID != "":
+		task.ReviewRound++
+		log.Printf("task %s: acceptance criteria not met, sending fixes (round %d/%d)", task.ID, task.ReviewRound, task.MaxReviewRounds)
+		task.Status = models.TaskStatusRunning
+		task.CompletedAt = nil
+		task.Error = fmt.Sprintf("Acceptance criteria not met (review round %d/%d): %s", task.ReviewRound, task.MaxReviewRounds, verdict.Summary)
+		return acceptanceFixPrompt(verdict), false
+	default:
+		log.Printf("task %s: acceptance criteria not met", task.ID)
+		task.Status = models.TaskStatusFailed
+		task.Error = "Acceptance criteria not met: " + verdict.Summary
+		return "", false
+	}
+}
+
+// reviewAcceptance asks the task's reviewer agent, or the agent that did the
+// work if none is set, whether the run meets the acceptance criteria.
+func (te *TaskEngine) reviewAcceptance(ctx context.Context, task *models.Task, project *models.Project, agent *models.Agent, diffResult *DiffResult) (*models.AcceptanceVerdict, error) {
+	reviewer := agent
+	if task.ReviewerAgentID != "" {
+		r, err := te.agents.GetByID(string(task.ReviewerAgentID))
+		if err != nil {
+			return nil, fmt.Errorf("reviewer agent not found: %w", err)
+		}
+		reviewer = r
+	}
+
+	prompt := task.OriginalPrompt
+	if prompt == "" {
+		prompt = task.Prompt
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "An agent was given the task below. Review its changes and decide whether they meet every acceptance criterion. You may read files in the project for context. Do not modify any files.\n\nTask:\n<task>\n%s\n</task>\n\nAcceptance criteria:\n<criteria>\n%s\n</criteria>\n", prompt, task.AcceptanceCriteria)
+
+	diff := diffSnapshot(diffResult)
+	if diff == "" {
+		diff = "(no changes)\n"
+	}
+	fmt.Fprintf(&sb, "\nChanges:\n<diff>\n%s</diff>\n", truncate(diff, maxJudgeDiff))
+	fmt.Fprintf(&sb, "\n<tests result=\"%s\">\n%s\n</tests>\n", outcomeLabel(task.TestPassed), truncate(task.TestOutput, maxReviewOutput))
+	fmt.Fprintf(&sb, "\n<build result=\"%s\">\n%s\n</build>\n", outcomeLabel(task.BuildPassed), truncate(task.BuildOutput, maxReviewOutput))
+
+	workDir := task.WorkspacePath
+	if workDir == "" {
+		workDir = project.Path
+	}
+	var verdict models.AcceptanceVerdict
+	if err := te.runStructured(ctx, reviewer, project, workDir, sb.String(), acceptanceVerdictJSONSchema(), &verdict); err != nil {
+		return nil, err
+	}
+	if verdict.Passed {
+		verdict.Reasons = nil
+	} else if verdict.Summary == "" && len(verdict.Reasons) > 0 {
+		verdict.Summary = verdict.Reasons[0]
+	}
+	verdict.ReviewerID = reviewer.ID
+	verdict.Round = task.ReviewRound
+	verdict.ReviewedAt = time.Now()
+	return &verdict, nil
+}
+
+// acceptanceFixPrompt is the follow-up that sends a rejection back to the agent.
+func acceptanceFixPrompt(verdict *models.AcceptanceVerdict) string {
+	var sb strings.Builder
+	sb.WriteString("A reviewer checked your changes against the acceptance criteria and found they are not met yet.\n\n")
+	sb.WriteString(verdict.Summary + "\n")
+	for _, r := range verdict.Reasons {
+		sb.WriteString("\n- " + r)
+	}
+	sb.WriteString("\n\nPlease address these points.")
+	return sb.String()
+}
+
+// runReviewRounds sends fix prompts as follow-ups, one after another, until
+// the reviewers accept the work or their rounds run out. The caller must hold
+// the task's mutex.
+func (te *TaskEngine) runReviewRounds(ctx context.Context, taskID string, project *models.Project, fix string) {
+	for fix != "" {
+		task, err := te.tasks.GetByID(taskID)
+		if err != nil {
+			log.Printf("task %s: failed to re-read task for review round: %v", taskID, err)
+			return
+		}
+		agent, err := te.agents.GetByID(string(task.AgentID))
+		if err != nil {
+			te.failTask(task, fmt.Sprintf("agent not found: %v", err))
+			return
+		}
+		workDir := task.WorkspacePath
+		if workDir == "" {
+			workDir = project.Path
+		}
+		task.Error = ""
+		if err := te.tasks.Update(task); err != nil {
+			log.Printf("task %s: failed to update task for review round: %v", taskID, err)
+		}
+		te.emitStreamEvent(taskID, "init", "Sending review feedback to the agent")
+		fix = te.runFollowUp(ctx, task, project, agent, workDir, fix, "code", models.AttemptKindReview)
+	}
+}
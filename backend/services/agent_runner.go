@@ -2,15 +2,18 @@ package services
 
 import (
 	"agent-workflow/backend/claude"
+	"agent-workflow/backend/mcpserver"
 	"agent-workflow/backend/models"
+	"agent-workflow/backend/redact"
+	"agent-workflow/backend/sandbox"
+	"agent-workflow/backend/store"
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"strings"
 	"sync"
 	"time"
-
-	wailsRuntime "github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 // AgentRunner manages concurrent Claude Code CLI processes.
@@ -19,7 +22,8 @@ type AgentRunner struct {
 	mu        sync.RWMutex
 	wailsCtx  context.Context
 	cliPath   string
-	envVars   map[string]string // env vars to inject into Claude subprocesses
+	envVars   map[string]string // global env scope for Claude subprocesses (may hold vault refs)
+	secrets   SecretStore       // resolves "vault:KEY" references in env scopes
 
 	// Event buffer: keeps all emitted events per task for later retrieval
 	eventBuf   map[string][]claude.TaskStreamEvent
@@ -28,6 +32,13 @@ type AgentRunner struct {
 	// Async event dispatch queue — decouples event production from Wails emission
 	emitQueue chan claude.TaskStreamEvent
 	emitOnce  sync.Once
+
+	// Persistence of published events (nil until SetEventStore)
+	eventStore   *store.StreamEventStore
+	persistQueue chan claude.TaskStreamEvent
+	persistDone  chan struct{}
+	persistMu    sync.RWMutex // guards closing persistQueue against concurrent publish
+	persistOff   bool
 }
 
 func NewAgentRunner(cliPath string, envVars map[string]string) *AgentRunner {
@@ -61,7 +72,7 @@ func (ar *AgentRunner) startEmitLoop() {
 					return
 				}
 				if ar.wailsCtx != nil {
-					wailsRuntime.EventsEmit(ar.wailsCtx, "task:stream", *pending)
+					emitEvent(ar.wailsCtx, "task:stream", *pending)
 				}
 				pending = nil
 			}
@@ -88,7 +99,7 @@ func (ar *AgentRunner) startEmitLoop() {
 					}
 					// Non-text events are dispatched immediately
 					if ar.wailsCtx != nil {
-						wailsRuntime.EventsEmit(ar.wailsCtx, "task:stream", evt)
+						emitEvent(ar.wailsCtx, "task:stream", evt)
 					}
 
 				case <-timer.C:
@@ -99,13 +110,36 @@ func (ar *AgentRunner) startEmitLoop() {
 	})
 }
 
-// SetEnvVars updates the environment variables injected into Claude subprocesses.
+// SetEnvVars updates the global env scope injected into Claude subprocesses.
+// Project, agent and task scopes are layered on top per run.
 func (ar *AgentRunner) SetEnvVars(envVars map[string]string) {
 	ar.mu.Lock()
 	defer ar.mu.Unlock()
 	ar.envVars = envVars
 }
 
+// SetSecretStore sets the vault that env scope references are resolved against.
+func (ar *AgentRunner) SetSecretStore(s SecretStore) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	ar.secrets = s
+}
+
+// taskEnvScopes returns the env scopes of a run, starting from the global scope.
+func (ar *AgentRunner) taskEnvScopes(project *models.Project, agent *models.Agent, task *models.Task) ([]EnvScope, SecretStore) {
+	ar.mu.RLock()
+	global, secrets := ar.envVars, ar.secrets
+	ar.mu.RUnlock()
+	return TaskEnvScopes(global, project, agent, task), secrets
+}
+
+// InspectTaskEnv lists the variable names a run of task would get, with the
+// scope each comes from. Values are never returned.
+func (ar *AgentRunner) InspectTaskEnv(project *models.Project, agent *models.Agent, task *models.Task) []EffectiveEnvVar {
+	scopes, secrets := ar.taskEnvScopes(project, agent, task)
+	return InspectEnvScopes(scopes, secrets)
+}
+
 // SetWailsContext sets the Wails runtime context for event emission.
 func (ar *AgentRunner) SetWailsContext(ctx context.Context) {
 	ar.wailsCtx = ctx
@@ -190,21 +224,108 @@ func (ar *AgentRunner) bufferEvent(taskID string, event claude.TaskStreamEvent)
 	ar.eventBuf[taskID] = append(buf, event)
 }
 
+// SetEventStore makes published stream events persistent from now on, so
+// they outlive the in-memory buffer and can be searched.
+func (ar *AgentRunner) SetEventStore(s *store.StreamEventStore) {
+	ar.eventStore = s
+	ar.persistQueue = make(chan claude.TaskStreamEvent, 4096)
+	ar.persistDone = make(chan struct{})
+	go ar.persistLoop()
+}
+
+// StopPersisting writes the queued events and stops persisting. Call it
+// before the database is closed.
+func (ar *AgentRunner) StopPersisting() {
+	ar.persistMu.Lock()
+	if ar.persistQueue == nil || ar.persistOff {
+		ar.persistMu.Unlock()
+		return
+	}
+	ar.persistOff = true
+	close(ar.persistQueue)
+	ar.persistMu.Unlock()
+	<-ar.persistDone
+}
+
+func (ar *AgentRunner) persistEvent(evt claude.TaskStreamEvent) {
+	ar.persistMu.RLock()
+	defer ar.persistMu.RUnlock()
+	if ar.persistQueue == nil || ar.persistOff || evt.Content == "" {
+		return
+	}
+	select {
+	case ar.persistQueue <- evt:
+	default:
+		log.Printf("task %s: stream event queue full, event not persisted", evt.TaskID)
+	}
+}
+
+// persistLoop writes queued events in batches, merging consecutive text
+// chunks of the same task into one row.
+func (ar *AgentRunner) persistLoop() {
+	defer close(ar.persistDone)
+	const flushInterval = 500 * time.Millisecond
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []models.StreamEvent
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := ar.eventStore.CreateBatch(batch); err != nil {
+			log.Printf("persist stream events: %v", err)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case evt, ok := <-ar.persistQueue:
+			if !ok {
+				flush()
+				return
+			}
+			if n := len(batch); n > 0 && evt.Type == "text" && batch[n-1].Type == "text" && batch[n-1].TaskID == evt.TaskID {
+				batch[n-1].Content += evt.Content
+				continue
+			}
+			batch = append(batch, models.StreamEvent{TaskID: evt.TaskID, Type: evt.Type, Content: evt.Content, CreatedAt: time.Now()})
+			if len(batch) >= 200 {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
 // RunTaskOptions configures a RunTask invocation.
 type RunTaskOptions struct {
-	SessionID     string                 // Claude session ID for --resume (empty = new session)
-	Prompt        string                 // Override task prompt (used for follow-ups)
-	MCPConfigPath string                 // Explicit path to .mcp.json for --mcp-config
-	OnSessionID   func(sessionID string) // Callback when Claude session_id is received
+	SessionID            string                 // Claude session ID for --resume (empty = new session)
+	Prompt               string                 // Override task prompt (used for follow-ups)
+	MCPConfigPath        string                 // Explicit path to .mcp.json for --mcp-config
+	ExtraMCPConfigs      []string               // Additional MCP config files (built-in Shannon server)
+	PermissionPromptTool string                 // MCP tool that answers permission checks (approval broker)
+	Sandbox              *sandbox.Profile       // Run Claude inside this sandbox (nil = unrestricted)
+	Project              *models.Project        // Supplies the project env scope (nil = none)
+	OnSessionID          func(sessionID string) // Callback when Claude session_id is received
 }
 
 // RunResult carries information about how the task run completed.
 type RunResult struct {
-	NeedsInput bool   // true if the agent's output indicates it needs user input
-	LastText   string // the last text output from the agent (for displaying in the UI)
-	EventCount int    // number of stream events received from Claude
-	ExitCode   int    // process exit code
-	Stderr     string // captured stderr output (useful for diagnosing silent failures)
+	NeedsInput   bool                 // true if the agent asked for user input
+	PendingInput *models.PendingInput // the structured question when NeedsInput is set
+	LastText     string               // the last text output from the agent (for displaying in the UI)
+	EventCount   int                  // number of stream events received from Claude
+	ExitCode     int                  // process exit code
+	Stderr       string               // captured stderr output (useful for diagnosing silent failures)
+	EditedPaths  []string             // files the agent's file tools wrote to, as the tool calls named them
+
+	// Usage reported by the final result event (zero if none was received)
+	Usage      claude.Usage
+	CostUSD    float64
+	NumTurns   int
+	DurationMS float64
 }
 
 // RunTask starts a Claude Code process for a task with the given agent configuration.
@@ -220,18 +341,28 @@ func (ar *AgentRunner) RunTask(ctx context.Context, task *models.Task, agent *mo
 		prompt = runOpts.Prompt
 	}
 
+	// Layer global → project → agent → task env and resolve vault references for this run
+	scopes, secrets := ar.taskEnvScopes(runOpts.Project, agent, task)
+	env, err := MergeEnvScopes(scopes, secrets)
+	if err != nil {
+		return nil, err
+	}
+
 	proc, err := claude.StartProcess(ctx, claude.ProcessOptions{
-		CLIPath:         ar.cliPath,
-		WorkDir:         workDir,
-		Model:           agent.Model,
-		SystemPrompt:    agent.SystemPrompt,
-		AllowedTools:    agent.AllowedTools,
-		DisallowedTools: agent.DisallowedTools,
-		Permissions:     agent.Permissions,
-		Prompt:          prompt,
-		SessionID:       runOpts.SessionID,
-		MCPConfigPath:   runOpts.MCPConfigPath,
-		Env:             ar.envVars,
+		CLIPath:              ar.cliPath,
+		WorkDir:              workDir,
+		Model:                agent.Model,
+		SystemPrompt:         agent.SystemPrompt,
+		AllowedTools:         agent.AllowedTools,
+		DisallowedTools:      agent.DisallowedTools,
+		Permissions:          agent.Permissions,
+		Prompt:               prompt,
+		SessionID:            runOpts.SessionID,
+		MCPConfigPath:        runOpts.MCPConfigPath,
+		ExtraMCPConfigs:      runOpts.ExtraMCPConfigs,
+		PermissionPromptTool: runOpts.PermissionPromptTool,
+		Sandbox:              withModelAPIHost(runOpts.Sandbox, env),
+		Env:                  env,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("start claude (%s): %w", ar.cliPath, err)
@@ -250,6 +381,9 @@ func (ar *AgentRunner) RunTask(ctx context.Context, task *models.Task, agent *mo
 	// Stream events to frontend, track last text for question detection
 	eventCount := 0
 	var lastText string
+	var resultEvent *claude.StreamEvent
+	var askedQuestion *models.PendingInput
+	var editedPaths []string
 	for event := range proc.Events() {
 		eventCount++
 		if eventCount <= 3 || eventCount%10 == 0 {
@@ -269,6 +403,18 @@ func (ar *AgentRunner) RunTask(ctx context.Context, task *models.Task, agent *mo
 			if text != "" {
 				lastText = text
 			}
+			editedPaths = append(editedPaths, extractEditedPaths(event)...)
+			// Explicit contract: the agent called the built-in ask_user tool
+			if q := extractAskUser(event); q != nil {
+				askedQuestion = q
+				log.Printf("[runner] task %s: agent asked the user: %s", task.ID[:8], q.Question)
+				ar.publish(claude.TaskStreamEvent{
+					TaskID:  task.ID,
+					Type:    "question",
+					Content: q.Question,
+					Data:    q,
+				})
+			}
 		}
 
 		// Capture result text via lastText — avoid writing directly to task struct
@@ -277,6 +423,8 @@ func (ar *AgentRunner) RunTask(ctx context.Context, task *models.Task, agent *mo
 			if text := event.ResultText(); text != "" {
 				lastText = text
 			}
+			ev := event
+			resultEvent = &ev
 		}
 	}
 	log.Printf("[runner] task %s: stream ended after %d events", task.ID[:8], eventCount)
@@ -290,15 +438,7 @@ func (ar *AgentRunner) RunTask(ctx context.Context, task *models.Task, agent *mo
 			"exit_code": proc.ExitCode(),
 		},
 	}
-	ar.bufferEvent(task.ID, doneEvent)
-	ar.startEmitLoop()
-	select {
-	case ar.emitQueue <- doneEvent:
-	default:
-		if ar.wailsCtx != nil {
-			wailsRuntime.EventsEmit(ar.wailsCtx, "task:stream", doneEvent)
-		}
-	}
+	ar.publish(doneEvent)
 
 	stderrOutput := proc.Stderr()
 
@@ -318,30 +458,122 @@ func (ar *AgentRunner) RunTask(ctx context.Context, task *models.Task, agent *mo
 		return nil, fmt.Errorf("%s", errMsg)
 	}
 
-	// Detect if the agent's output indicates it needs user input
+	// Prefer the structured ask_user call; fall back to guessing from the output.
+	pendingInput := askedQuestion
+	if pendingInput != nil {
+		pendingInput.Text = lastText
+	} else if detectNeedsInput(lastText) {
+		pendingInput = &models.PendingInput{
+			Source:   models.PendingInputSourceHeuristic,
+			Question: lastParagraph(lastText),
+			Text:     lastText,
+		}
+	}
+
 	result := &RunResult{
-		LastText:   lastText,
-		NeedsInput: detectNeedsInput(lastText),
-		EventCount: eventCount,
-		ExitCode:   proc.ExitCode(),
-		Stderr:     stderrOutput,
+		LastText:     lastText,
+		NeedsInput:   pendingInput != nil,
+		PendingInput: pendingInput,
+		EventCount:   eventCount,
+		ExitCode:     proc.ExitCode(),
+		Stderr:       stderrOutput,
+		EditedPaths:  editedPaths,
+	}
+	if resultEvent != nil {
+		if resultEvent.Usage != nil {
+			result.Usage = *resultEvent.Usage
+		}
+		result.CostUSD = resultEvent.TotalCostUSD
+		result.NumTurns = resultEvent.NumTurns
+		result.DurationMS = resultEvent.DurationMS
 	}
 	return result, nil
 }
 
-// detectNeedsInput checks if the agent's last output looks like it's asking for user input.
+// extractAskUser returns the question if the event contains a call to the
+// built-in ask_user tool.
+func extractAskUser(event claude.StreamEvent) *models.PendingInput {
+	if event.Message == nil || event.Message.Content == nil {
+		return nil
+	}
+	var blocks []claude.ContentBlock
+	if err := json.Unmarshal(event.Message.Content, &blocks); err != nil {
+		return nil
+	}
+	askUser := mcpserver.ToolName(mcpserver.ToolAskUser)
+	for _, block := range blocks {
+		if block.Type != "tool_use" || block.Name != askUser {
+			continue
+		}
+		raw, err := json.Marshal(block.Input)
+		if err != nil {
+			continue
+		}
+		var in mcpserver.AskUserInput
+		if err := json.Unmarshal(raw, &in); err != nil || strings.TrimSpace(in.Question) == "" {
+			continue
+		}
+		return &models.PendingInput{
+			Source:   models.PendingInputSourceAskUser,
+			Question: strings.TrimSpace(in.Question),
+			Choices:  in.Choices,
+			Context:  in.Context,
+		}
+	}
+	return nil
+}
+
+// extractEditedPaths returns the files named by the file-writing tool calls
+// in an event.
+func extractEditedPaths(event claude.StreamEvent) []string {
+	if event.Message == nil || event.Message.Content == nil {
+		return nil
+	}
+	var blocks []claude.ContentBlock
+	if err := json.Unmarshal(event.Message.Content, &blocks); err != nil {
+		return nil
+	}
+	var paths []string
+	for _, block := range blocks {
+		if block.Type != "tool_use" {
+			continue
+		}
+		input, _ := block.Input.(map[string]any)
+		key := "file_path"
+		switch block.Name {
+		case "Write", "Edit", "MultiEdit":
+		case "NotebookEdit":
+			key = "notebook_path"
+		default:
+			continue
+		}
+		if p, ok := input[key].(string); ok && p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// lastParagraph returns the text after the last blank line.
+func lastParagraph(text string) string {
+	trimmed := strings.TrimSpace(text)
+	if idx := strings.LastIndex(trimmed, "\n\n"); idx >= 0 {
+		trimmed = strings.TrimSpace(trimmed[idx:])
+	}
+	return trimmed
+}
+
+// detectNeedsInput is the fallback used when the agent didn't call ask_user: it
+// checks if the agent's last output looks like it's asking for user input.
 // Only checks the last paragraph to avoid false positives from questions in the middle of output.
 func detectNeedsInput(text string) bool {
 	if text == "" {
 		return false
 	}
-	trimmed := strings.TrimSpace(text)
 
 	// Only look at the last paragraph (after last double newline) to reduce false positives.
 	// Agents often have questions mid-output but the final paragraph is what matters.
-	if idx := strings.LastIndex(trimmed, "\n\n"); idx >= 0 {
-		trimmed = strings.TrimSpace(trimmed[idx:])
-	}
+	trimmed := lastParagraph(text)
 
 	// Check if the last paragraph ends with a question mark
 	if strings.HasSuffix(trimmed, "?") {
@@ -451,8 +683,18 @@ func (ar *AgentRunner) emitTaskEvent(taskID string, event claude.StreamEvent) {
 		}
 	}
 
+	ar.publish(taskEvent)
+}
+
+// publish buffers an event for later retrieval and emits it to the frontend.
+// Secrets are masked first, so neither the buffer nor the UI ever holds them.
+func (ar *AgentRunner) publish(taskEvent claude.TaskStreamEvent) {
+	taskEvent.Content = redact.String(taskEvent.Content)
+	taskEvent.Data = redact.Value(taskEvent.Data)
+
 	// Buffer event for later retrieval
-	ar.bufferEvent(taskID, taskEvent)
+	ar.bufferEvent(taskEvent.TaskID, taskEvent)
+	ar.persistEvent(taskEvent)
 
 	// Async emit to frontend via Wails — non-blocking
 	ar.startEmitLoop()
@@ -461,7 +703,7 @@ func (ar *AgentRunner) emitTaskEvent(taskID string, event claude.StreamEvent) {
 	default:
 		// Queue full — emit directly to avoid dropping events
 		if ar.wailsCtx != nil {
-			wailsRuntime.EventsEmit(ar.wailsCtx, "task:stream", taskEvent)
+			emitEvent(ar.wailsCtx, "task:stream", taskEvent)
 		}
 	}
 }
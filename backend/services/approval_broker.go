@@ -0,0 +1,530 @@
+package services
+
+import (
+	"agent-workflow/backend/mcpserver"
+	"agent-workflow/backend/models"
+	"agent-workflow/backend/store"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ApprovalRequest is a tool call waiting for the user's decision.
+type ApprovalRequest struct {
+	ID        string          `json:"id"`
+	TaskID    string          `json:"task_id"`
+	AgentID   string          `json:"agent_id"`
+	AgentName string          `json:"agent_name"`
+	ToolName  string          `json:"tool_name"`
+	Input     json.RawMessage `json:"input"`
+	Summary   string          `json:"summary"`        // e.g. the Bash command or file path
+	Suggested string          `json:"suggested_rule"` // pattern stored when the decision is remembered
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// ApprovalResponse is the user's answer to an ApprovalRequest.
+type ApprovalResponse struct {
+	Allow    bool   `json:"allow"`
+	Remember bool   `json:"remember"`          // store the decision as an agent permission rule
+	Pattern  string `json:"pattern,omitempty"` // override the suggested rule pattern
+	Message  string `json:"message,omitempty"` // reason shown to the agent on deny
+}
+
+// approvalTimeout is how long a tool call waits for the user before it is denied.
+const approvalTimeout = 10 * time.Minute
+
+type pendingApproval struct {
+	req      ApprovalRequest
+	response chan ApprovalResponse
+}
+
+// ApprovalBroker receives permission checks from the built-in MCP server over a
+// loopback HTTP endpoint, applies the agent's policy and remembered rules, and
+// forwards everything else to the UI (or HTTP API) as approval requests.
+type ApprovalBroker struct {
+	tasks  *store.TaskStore
+	agents *store.AgentStore
+	rules  *store.PermissionRuleStore
+
+	token    string
+	listener net.Listener
+	server   *http.Server
+
+	pending  map[string]*pendingApproval
+	runs     map[string]approvalRun // by task ID
+	timeout  time.Duration
+	mu       sync.Mutex
+	wailsCtx context.Context
+}
+
+// approvalRun is the agent a task is currently running with, as passed to the
+// Claude CLI (effective permissions, MCP and built-in tool patterns), and the
+// directory it runs in.
+type approvalRun struct {
+	agent   models.Agent
+	workDir string
+}
+
+func NewApprovalBroker(tasks *store.TaskStore, agents *store.AgentStore, rules *store.PermissionRuleStore) *ApprovalBroker {
+	return &ApprovalBroker{
+		tasks:   tasks,
+		agents:  agents,
+		rules:   rules,
+		pending: make(map[string]*pendingApproval),
+		runs:    make(map[string]approvalRun),
+		timeout: approvalTimeout,
+	}
+}
+
+// beginRun makes the broker decide a task's permission checks against agent,
+// the copy the task's current run was started with, until endRun.
+func (b *ApprovalBroker) beginRun(taskID string, agent models.Agent, workDir string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.runs[taskID] = approvalRun{agent: agent, workDir: workDir}
+}
+
+// endRun forgets the run agent of a task and denies its calls still waiting
+// for an answer, which the finished run no longer needs.
+func (b *ApprovalBroker) endRun(taskID string) {
+	b.mu.Lock()
+	delete(b.runs, taskID)
+	b.mu.Unlock()
+	b.CancelTask(taskID, "The run ended before the user answered.")
+}
+
+// CancelTask denies every call of a task still waiting for an answer, with
+// reason as the message shown to the agent.
+func (b *ApprovalBroker) CancelTask(taskID, reason string) {
+	b.mu.Lock()
+	var cancelled []*pendingApproval
+	for id, p := range b.pending {
+		if p.req.TaskID == taskID {
+			delete(b.pending, id)
+			cancelled = append(cancelled, p)
+		}
+	}
+	b.mu.Unlock()
+	for _, p := range cancelled {
+		p.response <- ApprovalResponse{Allow: false, Message: reason}
+		b.emit("task:approval_resolved", map[string]any{"id": p.req.ID, "task_id": taskID, "cancelled": true})
+	}
+}
+
+// withdraw removes a pending request nobody answered, reporting false if an
+// answer was already taken and is on its way.
+func (b *ApprovalBroker) withdraw(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.pending[id]; !ok {
+		return false
+	}
+	delete(b.pending, id)
+	return true
+}
+
+// SetWailsContext sets the Wails runtime context for event emission.
+func (b *ApprovalBroker) SetWailsContext(ctx context.Context) {
+	b.wailsCtx = ctx
+}
+
+// Start listens on a random loopback port. Requests must carry the broker's
+// random bearer token, which is only handed to the built-in MCP server.
+func (b *ApprovalBroker) Start() error {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return fmt.Errorf("generate broker token: %w", err)
+	}
+	b.token = hex.EncodeToString(tokenBytes)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	b.listener = ln
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/approvals", b.authorized(b.handleApprovals))
+	mux.HandleFunc("/v1/approvals/", b.authorized(b.handleDecision))
+	b.server = &http.Server{Handler: mux}
+
+	go func() {
+		if err := b.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("[approvals] server error: %v", err)
+		}
+	}()
+	log.Printf("[approvals] broker listening on %s", ln.Addr())
+	return nil
+}
+
+// Stop shuts the HTTP server down and denies everything still pending.
+func (b *ApprovalBroker) Stop() {
+	if b.server != nil {
+		b.server.Close()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for id, p := range b.pending {
+		select {
+		case p.response <- ApprovalResponse{Allow: false, Message: "Shannon is shutting down"}:
+		default:
+		}
+		delete(b.pending, id)
+	}
+}
+
+// URL returns the base URL of the broker, or "" if it isn't running.
+func (b *ApprovalBroker) URL() string {
+	if b.listener == nil {
+		return ""
+	}
+	return "http://" + b.listener.Addr().String()
+}
+
+// Port returns the broker's loopback port, or 0 if it isn't running.
+func (b *ApprovalBroker) Port() int {
+	if b.listener == nil {
+		return 0
+	}
+	u, err := url.Parse(b.URL())
+	if err != nil {
+		return 0
+	}
+	port, _ := strconv.Atoi(u.Port())
+	return port
+}
+
+// Token returns the bearer token clients must send.
+func (b *ApprovalBroker) Token() string {
+	return b.token
+}
+
+// ListPending returns the approval requests waiting for an answer, oldest first.
+func (b *ApprovalBroker) ListPending() []ApprovalRequest {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	result := make([]ApprovalRequest, 0, len(b.pending))
+	for _, p := range b.pending {
+		result = append(result, p.req)
+	}
+	for i := 1; i < len(result); i++ {
+		for j := i; j > 0 && result[j].CreatedAt.Before(result[j-1].CreatedAt); j-- {
+			result[j], result[j-1] = result[j-1], result[j]
+		}
+	}
+	return result
+}
+
+// Respond answers a pending request and optionally remembers the decision for the agent.
+func (b *ApprovalBroker) Respond(requestID string, resp ApprovalResponse) error {
+	b.mu.Lock()
+	p, ok := b.pending[requestID]
+	if ok {
+		delete(b.pending, requestID)
+	}
+	b.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("approval request %s not found (already answered or cancelled)", requestID)
+	}
+
+	if resp.Remember && p.req.AgentID != "" {
+		pattern := resp.Pattern
+		if pattern == "" {
+			pattern = p.req.Suggested
+		}
+		decision := models.PermissionDeny
+		if resp.Allow {
+			decision = models.PermissionAllow
+		}
+		rule := &models.AgentPermissionRule{AgentID: p.req.AgentID, Pattern: pattern, Decision: decision}
+		if err := b.rules.Create(rule); err != nil {
+			log.Printf("[approvals] failed to remember rule %q for agent %s: %v", pattern, p.req.AgentID, err)
+		}
+	}
+
+	p.response <- resp
+	b.emit("task:approval_resolved", map[string]any{"id": requestID, "task_id": p.req.TaskID, "allow": resp.Allow})
+	return nil
+}
+
+func (b *ApprovalBroker) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if b.token == "" || subtle.ConstantTimeCompare([]byte(auth), []byte(b.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleApprovals serves GET (list pending) and POST (new request from the MCP server).
+func (b *ApprovalBroker) handleApprovals(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, b.ListPending())
+	case http.MethodPost:
+		var req mcpserver.PermissionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, b.decide(r.Context(), req))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleDecision serves POST /v1/approvals/{id} with an ApprovalResponse body.
+func (b *ApprovalBroker) handleDecision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/v1/approvals/")
+	var resp ApprovalResponse
+	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := b.Respond(id, resp); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decide resolves a permission request, blocking until the user answers when
+// neither the agent's policy nor a remembered rule covers it.
+func (b *ApprovalBroker) decide(ctx context.Context, req mcpserver.PermissionRequest) mcpserver.PermissionDecision {
+	var input map[string]any
+	json.Unmarshal(req.Input, &input)
+
+	allow := mcpserver.PermissionDecision{Behavior: "allow", UpdatedInput: req.Input}
+	deny := func(msg string) mcpserver.PermissionDecision {
+		return mcpserver.PermissionDecision{Behavior: "deny", Message: msg}
+	}
+
+	task, err := b.tasks.GetByID(req.TaskID)
+	if err != nil {
+		return deny(fmt.Sprintf("unknown task %q", req.TaskID))
+	}
+	b.mu.Lock()
+	run, running := b.runs[task.ID]
+	b.mu.Unlock()
+	agent := &run.agent
+	if !running {
+		if agent, err = b.agents.GetByID(string(task.AgentID)); err != nil {
+			return deny(fmt.Sprintf("agent %q not found", task.AgentID))
+		}
+		run.workDir = task.WorkspacePath
+	}
+
+	// Patterns name paths relative to the project; absolute ones still match.
+	relInput := relativeToolInput(req.ToolName, input, run.workDir)
+	match := func(pattern string) bool {
+		return matchToolPattern(pattern, req.ToolName, input) || matchToolPattern(pattern, req.ToolName, relInput)
+	}
+
+	// Policy: explicit denials win, then remembered rules, then the agent's whitelist.
+	for _, pattern := range agent.DisallowedTools {
+		if match(pattern) {
+			return deny(fmt.Sprintf("%s is not allowed for agent %q (%s)", req.ToolName, agent.Name, pattern))
+		}
+	}
+	rules, _ := b.rules.ListByAgent(agent.ID)
+	for _, rule := range rules {
+		if rule.Decision == models.PermissionDeny && match(rule.Pattern) {
+			return deny(fmt.Sprintf("%s was denied by a remembered rule (%s)", req.ToolName, rule.Pattern))
+		}
+	}
+	for _, rule := range rules {
+		if rule.Decision == models.PermissionAllow && match(rule.Pattern) {
+			return allow
+		}
+	}
+	for _, pattern := range agent.AllowedTools {
+		if match(pattern) {
+			return allow
+		}
+	}
+
+	// Ask the user.
+	summary := permissionSubject(req.ToolName, relInput)
+	suggested := req.ToolName
+	if summary != "" {
+		suggested = fmt.Sprintf("%s(%s)", req.ToolName, summary)
+	}
+	p := &pendingApproval{
+		req: ApprovalRequest{
+			ID:        uuid.New().String(),
+			TaskID:    task.ID,
+			AgentID:   agent.ID,
+			AgentName: agent.Name,
+			ToolName:  req.ToolName,
+			Input:     req.Input,
+			Summary:   summary,
+			Suggested: suggested,
+			CreatedAt: time.Now(),
+		},
+		response: make(chan ApprovalResponse, 1),
+	}
+	b.mu.Lock()
+	b.pending[p.req.ID] = p
+	b.mu.Unlock()
+
+	log.Printf("[approvals] task %s: waiting for approval of %s %s", task.ID, req.ToolName, truncate(summary, 120))
+	b.emit("task:approval", p.req)
+
+	timer := time.NewTimer(b.timeout)
+	defer timer.Stop()
+	var resp ApprovalResponse
+	select {
+	case resp = <-p.response:
+	case <-ctx.Done():
+		// The MCP server went away (task stopped or finished) before anyone answered.
+		if b.withdraw(p.req.ID) {
+			b.emit("task:approval_resolved", map[string]any{"id": p.req.ID, "task_id": task.ID, "cancelled": true})
+			return deny("approval request cancelled")
+		}
+		resp = <-p.response
+	case <-timer.C:
+		if b.withdraw(p.req.ID) {
+			log.Printf("[approvals] task %s: no answer for %s within %s, denying", task.ID, req.ToolName, b.timeout)
+			b.emit("task:approval_resolved", map[string]any{"id": p.req.ID, "task_id": task.ID, "timed_out": true})
+			return deny(fmt.Sprintf("Nobody answered the approval request within %s, so this tool call was denied.", b.timeout))
+		}
+		resp = <-p.response
+	}
+	if resp.Allow {
+		return allow
+	}
+	msg := resp.Message
+	if msg == "" {
+		msg = "The user denied this tool call."
+	}
+	return deny(msg)
+}
+
+func (b *ApprovalBroker) emit(name string, data any) {
+	if b.wailsCtx != nil {
+		emitEvent(b.wailsCtx, name, data)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// permissionSubject returns the part of a tool's input that permission patterns
+// match against: the command for Bash, the path for file tools, the URL for WebFetch.
+func permissionSubject(toolName string, input map[string]any) string {
+	key := ""
+	switch toolName {
+	case "Bash":
+		key = "command"
+	case "Read", "Write", "Edit", "MultiEdit":
+		key = "file_path"
+	case "NotebookEdit":
+		key = "notebook_path"
+	case "WebFetch":
+		key = "url"
+	case "Glob", "Grep":
+		key = "path"
+	default:
+		return ""
+	}
+	if v, ok := input[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// relativeToolInput returns input with the path a file tool acts on made
+// relative to workDir, when it lies inside it.
+func relativeToolInput(toolName string, input map[string]any, workDir string) map[string]any {
+	key := ""
+	switch toolName {
+	case "Read", "Write", "Edit", "MultiEdit":
+		key = "file_path"
+	case "NotebookEdit":
+		key = "notebook_path"
+	case "Glob", "Grep":
+		key = "path"
+	}
+	path, _ := input[key].(string)
+	if key == "" || workDir == "" || !filepath.IsAbs(path) {
+		return input
+	}
+	rel, err := filepath.Rel(workDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return input
+	}
+	out := make(map[string]any, len(input))
+	for k, v := range input {
+		out[k] = v
+	}
+	out[key] = filepath.ToSlash(rel)
+	return out
+}
+
+// matchToolPattern reports whether a Claude CLI tool pattern ("Bash", "Bash(npm test:*)",
+// "mcp__github__*", "Edit(src/*)") matches a tool call.
+func matchToolPattern(pattern, toolName string, input map[string]any) bool {
+	pattern = strings.TrimSpace(pattern)
+	name, spec := pattern, ""
+	if i := strings.Index(pattern, "("); i > 0 && strings.HasSuffix(pattern, ")") {
+		name, spec = pattern[:i], pattern[i+1:len(pattern)-1]
+	}
+	if !wildcardMatch(name, toolName) {
+		return false
+	}
+	if spec == "" || spec == "*" {
+		return true
+	}
+	subject := permissionSubject(toolName, input)
+	if subject == "" {
+		return false
+	}
+	// Claude's prefix syntax "cmd:*" is equivalent to "cmd*".
+	if strings.HasSuffix(spec, ":*") {
+		spec = strings.TrimSuffix(spec, ":*") + "*"
+	}
+	return wildcardMatch(spec, subject)
+}
+
+// wildcardMatch matches s against a pattern where '*' matches any run of characters
+// (including '/'). All other characters match literally.
+func wildcardMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		idx := strings.Index(s, part)
+		if idx < 0 {
+			return false
+		}
+		s = s[idx+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
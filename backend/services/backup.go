@@ -0,0 +1,485 @@
+package services
+
+import (
+	"agent-workflow/backend/config"
+	"agent-workflow/backend/store"
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Backup archive layout: a gzipped tar with a manifest, the database snapshot,
+// config.json and the (still encrypted) vault file.
+const (
+	backupFormat        = "shannon-backup"
+	backupFormatVersion = 1
+
+	backupManifestName = "manifest.json"
+	backupDBName       = "agent-workflow.db"
+	backupConfigName   = "config.json"
+	backupVaultName    = ".vault"
+
+	autoBackupPrefix    = "shannon-auto-"
+	manualBackupPrefix  = "shannon-backup-"
+	backupExt           = ".tar.gz"
+	restorePendingDir   = "restore-pending"
+	maxBackupMemberSize = 4 << 30
+)
+
+// BackupManifest describes a backup archive.
+type BackupManifest struct {
+	Format        string    `json:"format"`
+	Version       int       `json:"version"`
+	CreatedAt     time.Time `json:"created_at"`
+	SchemaVersion int       `json:"schema_version"`
+	Files         []string  `json:"files"`
+}
+
+// BackupInfo is a backup archive in the backups directory.
+type BackupInfo struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+	Auto      bool      `json:"auto"` // made by the schedule, subject to rotation
+}
+
+// BackupManager creates backup archives of the data directory and runs the
+// automatic backup schedule.
+type BackupManager struct {
+	db         *store.DB
+	dataDir    string
+	configPath string
+	mu         sync.Mutex // one backup at a time
+}
+
+func NewBackupManager(db *store.DB, dataDir, configPath string) *BackupManager {
+	return &BackupManager{db: db, dataDir: dataDir, configPath: configPath}
+}
+
+// BackupDir is where automatic backups (and pre-migration copies) are kept.
+func BackupDir(dataDir string) string {
+	return filepath.Join(dataDir, "backups")
+}
+
+// Create writes a backup archive to dest.
+func (m *BackupManager) Create(dest string) (*BackupManifest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tmpDir, err := os.MkdirTemp(m.dataDir, ".backup-")
+	if err != nil {
+		return nil, fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dbCopy := filepath.Join(tmpDir, backupDBName)
+	if err := m.db.BackupTo(dbCopy); err != nil {
+		return nil, fmt.Errorf("snapshot database: %w", err)
+	}
+	version, err := store.FileSchemaVersion(dbCopy)
+	if err != nil {
+		return nil, fmt.Errorf("check snapshot: %w", err)
+	}
+
+	members := map[string]string{backupDBName: dbCopy}
+	if fileExists(m.configPath) {
+		members[backupConfigName] = m.configPath
+	}
+	if vault := config.VaultPath(m.dataDir); fileExists(vault) {
+		members[backupVaultName] = vault
+	}
+	manifest := &BackupManifest{
+		Format:        backupFormat,
+		Version:       backupFormatVersion,
+		CreatedAt:     time.Now(),
+		SchemaVersion: version,
+	}
+	for name := range members {
+		manifest.Files = append(manifest.Files, name)
+	}
+	sort.Strings(manifest.Files)
+
+	if err := writeBackupArchive(dest, manifest, members); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// CreateAuto writes an automatic backup into BackupDir and deletes the oldest
+// automatic backups beyond keep.
+func (m *BackupManager) CreateAuto(keep int) (string, error) {
+	dir := BackupDir(m.dataDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, autoBackupPrefix+time.Now().Format("20060102-150405")+backupExt)
+	if _, err := m.Create(path); err != nil {
+		return "", err
+	}
+	if err := m.rotate(keep); err != nil {
+		log.Printf("[backup] rotation failed: %v", err)
+	}
+	return path, nil
+}
+
+// ManualBackupPath returns a default path in BackupDir for a backup made on request.
+func (m *BackupManager) ManualBackupPath() string {
+	return filepath.Join(BackupDir(m.dataDir), manualBackupPrefix+time.Now().Format("20060102-150405")+backupExt)
+}
+
+// List returns the archives in BackupDir, newest first.
+func (m *BackupManager) List() ([]BackupInfo, error) {
+	entries, err := os.ReadDir(BackupDir(m.dataDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var backups []BackupInfo
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), backupExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, BackupInfo{
+			Path:      filepath.Join(BackupDir(m.dataDir), e.Name()),
+			Size:      info.Size(),
+			CreatedAt: info.ModTime(),
+			Auto:      strings.HasPrefix(e.Name(), autoBackupPrefix),
+		})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.After(backups[j].CreatedAt) })
+	return backups, nil
+}
+
+func (m *BackupManager) rotate(keep int) error {
+	if keep < 1 {
+		keep = 1
+	}
+	backups, err := m.List()
+	if err != nil {
+		return err
+	}
+	kept := 0
+	for _, b := range backups {
+		if !b.Auto {
+			continue
+		}
+		if kept < keep {
+			kept++
+			continue
+		}
+		if err := os.Remove(b.Path); err != nil {
+			return err
+		}
+		log.Printf("[backup] removed old backup %s", filepath.Base(b.Path))
+	}
+	return nil
+}
+
+// RunSchedule makes an automatic backup whenever the newest one is older than
+// interval, checking hourly until ctx is done.
+func (m *BackupManager) RunSchedule(ctx context.Context, interval time.Duration, keep int) {
+	if interval <= 0 {
+		return
+	}
+	check := func() {
+		backups, err := m.List()
+		if err != nil {
+			log.Printf("[backup] list backups: %v", err)
+			return
+		}
+		for _, b := range backups {
+			if b.Auto {
+				if time.Since(b.CreatedAt) < interval {
+					return
+				}
+				break
+			}
+		}
+		path, err := m.CreateAuto(keep)
+		if err != nil {
+			log.Printf("[backup] automatic backup failed: %v", err)
+			return
+		}
+		log.Printf("[backup] automatic backup written to %s", path)
+	}
+
+	check()
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			check()
+		}
+	}
+}
+
+func writeBackupArchive(dest string, manifest *BackupManifest, members map[string]string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+	tmp := dest + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("create archive: %w", err)
+	}
+	defer os.Remove(tmp)
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	err = func() error {
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := writeTarMember(tw, backupManifestName, data); err != nil {
+			return err
+		}
+		for _, name := range manifest.Files {
+			data, err := os.ReadFile(members[name])
+			if err != nil {
+				return fmt.Errorf("read %s: %w", name, err)
+			}
+			if err := writeTarMember(tw, name, data); err != nil {
+				return err
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return gz.Close()
+	}()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write archive: %w", err)
+	}
+	return os.Rename(tmp, dest)
+}
+
+func writeTarMember(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// extractBackup unpacks an archive into dir and returns its manifest. Only the
+// known member names are accepted.
+func extractBackup(archive, dir string) (*BackupManifest, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("not a backup archive: %w", err)
+	}
+	defer gz.Close()
+
+	known := map[string]bool{backupManifestName: true, backupDBName: true, backupConfigName: true, backupVaultName: true}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg || !known[hdr.Name] {
+			return nil, fmt.Errorf("unexpected archive member %q", hdr.Name)
+		}
+		out, err := os.OpenFile(filepath.Join(dir, hdr.Name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return nil, err
+		}
+		// Copy one byte past the limit to tell an oversized member from one
+		// that fits exactly
+		n, err := io.Copy(out, io.LimitReader(tr, maxBackupMemberSize+1))
+		out.Close()
+		if err != nil {
+			return nil, fmt.Errorf("extract %s: %w", hdr.Name, err)
+		}
+		if n > maxBackupMemberSize {
+			return nil, fmt.Errorf("archive member %s is larger than %d bytes", hdr.Name, int64(maxBackupMemberSize))
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, backupManifestName))
+	if err != nil {
+		return nil, fmt.Errorf("archive has no manifest")
+	}
+	var manifest BackupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	if manifest.Format != backupFormat {
+		return nil, fmt.Errorf("not a Shannon backup (format %q)", manifest.Format)
+	}
+	if manifest.Version > backupFormatVersion {
+		return nil, fmt.Errorf("backup format version %d is newer than this build supports (%d)", manifest.Version, backupFormatVersion)
+	}
+	return &manifest, nil
+}
+
+// StageRestore validates a backup archive and unpacks it into the data
+// directory's restore area. The files replace the current ones the next time
+// ApplyPendingRestore runs, before the database is opened. The archive's
+// schema must not be newer than this build.
+func StageRestore(archive, dataDir string) (*BackupManifest, error) {
+	staging := filepath.Join(dataDir, restorePendingDir)
+	if err := os.RemoveAll(staging); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(staging, 0700); err != nil {
+		return nil, err
+	}
+	manifest, err := extractBackup(archive, staging)
+	if err == nil {
+		err = validateStagedDB(staging, manifest)
+	}
+	if err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func validateStagedDB(staging string, manifest *BackupManifest) error {
+	dbPath := filepath.Join(staging, backupDBName)
+	if !fileExists(dbPath) {
+		return fmt.Errorf("archive has no database")
+	}
+	version, err := store.FileSchemaVersion(dbPath)
+	if err != nil {
+		return fmt.Errorf("backup database: %w", err)
+	}
+	if version > store.LatestSchemaVersion() {
+		return fmt.Errorf("%w: backup is at version %d, this build knows up to %d", store.ErrSchemaTooNew, version, store.LatestSchemaVersion())
+	}
+	if version != manifest.SchemaVersion {
+		return fmt.Errorf("backup database is at schema version %d but the manifest says %d", version, manifest.SchemaVersion)
+	}
+	return nil
+}
+
+// HasPendingRestore reports whether a staged restore is waiting.
+func HasPendingRestore(dataDir string) bool {
+	return fileExists(filepath.Join(dataDir, restorePendingDir, backupDBName))
+}
+
+// ApplyPendingRestore replaces the database, config and vault with a staged
+// restore, if there is one. The current files are moved into BackupDir first.
+// It must run while the database is closed.
+func ApplyPendingRestore(dataDir, configPath string) (bool, error) {
+	staging := filepath.Join(dataDir, restorePendingDir)
+	if !HasPendingRestore(dataDir) {
+		return false, nil
+	}
+
+	saved := filepath.Join(BackupDir(dataDir), "pre-restore-"+time.Now().Format("20060102-150405"))
+	if err := os.MkdirAll(saved, 0700); err != nil {
+		return false, err
+	}
+	dbPath := filepath.Join(dataDir, backupDBName)
+	current := map[string]string{
+		dbPath:                    backupDBName,
+		dbPath + "-wal":           backupDBName + "-wal",
+		dbPath + "-shm":           backupDBName + "-shm",
+		configPath:                backupConfigName,
+		config.VaultPath(dataDir): backupVaultName,
+	}
+	for path, name := range current {
+		if !fileExists(path) {
+			continue
+		}
+		if err := os.Rename(path, filepath.Join(saved, name)); err != nil {
+			return false, fmt.Errorf("set aside %s: %w", name, err)
+		}
+	}
+
+	restored := map[string]string{
+		backupDBName:     dbPath,
+		backupConfigName: configPath,
+		backupVaultName:  config.VaultPath(dataDir),
+	}
+	for name, dest := range restored {
+		src := filepath.Join(staging, name)
+		if !fileExists(src) {
+			continue
+		}
+		if err := os.Rename(src, dest); err != nil {
+			return false, fmt.Errorf("restore %s (previous files are in %s): %w", name, saved, err)
+		}
+	}
+	os.RemoveAll(staging)
+	log.Printf("[backup] restored backup; previous files moved to %s", saved)
+	return true, nil
+}
+
+// ErrDataDirInUse is returned by LockDataDir while another Shannon process
+// uses the data directory.
+var ErrDataDirInUse = errors.New("the data directory is in use by another Shannon process")
+
+const dataDirLockName = "shannon.lock"
+
+// DataDirLock is held by the process that uses a data directory: the app for
+// as long as it runs, or a maintenance command that replaces its files.
+type DataDirLock struct {
+	f *os.File
+}
+
+// LockDataDir takes the data directory's lock without waiting. The lock goes
+// away with the process, so a crash never leaves it behind.
+func LockDataDir(dataDir string) (*DataDirLock, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dataDir, dataDirLockName), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &DataDirLock{f: f}, nil
+}
+
+// Release gives the lock up.
+func (l *DataDirLock) Release() {
+	if l != nil && l.f != nil {
+		unlockFile(l.f)
+		l.f.Close()
+		l.f = nil
+	}
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
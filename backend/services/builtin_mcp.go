@@ -0,0 +1,170 @@
+package services
+
+import (
+	"agent-workflow/backend/mcpserver"
+	"agent-workflow/backend/models"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// askUserInstruction is appended to the system prompt of every new task session
+// so agents use the structured ask_user tool instead of ending with a question.
+var askUserInstruction = fmt.Sprintf(`
+
+<user_interaction>
+If you need a decision, approval or missing information from the user, call the %s tool with a clear question (and choices when there is a fixed set of answers), then end your turn. Do not end your output with a question instead of calling the tool.
+</user_interaction>`, mcpserver.ToolName(mcpserver.ToolAskUser))
+
+// BuiltinMCP launches Shannon's own MCP server (the Shannon binary re-run with
+// "mcp-server") and writes the per-task config files that register it with Claude.
+// Config files live outside the project directory so they never show up as changes.
+type BuiltinMCP struct {
+	Command   string
+	ConfigDir string
+}
+
+// NewBuiltinMCP uses the currently running executable as the server command.
+func NewBuiltinMCP(configDir string) (*BuiltinMCP, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("resolve executable: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	return &BuiltinMCP{Command: exe, ConfigDir: configDir}, nil
+}
+
+// WriteConfig writes an MCP config for a task exposing the given built-in tools
+// and returns its path. env is passed to the server process.
+func (b *BuiltinMCP) WriteConfig(taskID string, tools []string, env map[string]string) (string, error) {
+	if err := os.MkdirAll(b.ConfigDir, 0700); err != nil {
+		return "", fmt.Errorf("create MCP config dir: %w", err)
+	}
+	if env == nil {
+		env = map[string]string{}
+	}
+
+	type mcpServerEntry struct {
+		Command string            `json:"command"`
+		Args    []string          `json:"args"`
+		Env     map[string]string `json:"env"`
+	}
+	cfg := struct {
+		MCPServers map[string]mcpServerEntry `json:"mcpServers"`
+	}{
+		MCPServers: map[string]mcpServerEntry{
+			mcpserver.ServerName: {
+				Command: b.Command,
+				Args:    []string{"mcp-server", "--tools", strings.Join(tools, ",")},
+				Env:     env,
+			},
+		},
+	}
+
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal built-in MCP config: %w", err)
+	}
+	path := filepath.Join(b.ConfigDir, fmt.Sprintf("shannon-%s.json", taskID))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", fmt.Errorf("write built-in MCP config: %w", err)
+	}
+	return path, nil
+}
+
+// RemoveConfig deletes the config file written for a task, if any.
+func (b *BuiltinMCP) RemoveConfig(taskID string) {
+	os.Remove(filepath.Join(b.ConfigDir, fmt.Sprintf("shannon-%s.json", taskID)))
+}
+
+// builtinToolPatterns returns the --allowedTools entries needed for the built-in
+// tools. Like mcpToolPatterns, nothing is needed when the agent has no whitelist.
+func builtinToolPatterns(agentAllowedTools []string, tools []string) []string {
+	if len(agentAllowedTools) == 0 {
+		return nil
+	}
+	var patterns []string
+	for _, t := range tools {
+		patterns = append(patterns, mcpserver.ToolName(t))
+	}
+	return patterns
+}
+
+// SetBuiltinMCP enables Shannon's built-in MCP server (ask_user, permission_prompt) for task runs.
+func (te *TaskEngine) SetBuiltinMCP(b *BuiltinMCP) {
+	te.builtinMCP = b
+}
+
+// SetApprovalBroker routes tool permission checks of task runs to the broker
+// instead of skipping them. Requires the built-in MCP server.
+func (te *TaskEngine) SetApprovalBroker(b *ApprovalBroker) {
+	te.approvals = b
+}
+
+// builtinTools returns the built-in tools exposed to task runs.
+func (te *TaskEngine) builtinTools() []string {
+	if te.builtinMCP == nil {
+		return nil
+	}
+	tools := []string{mcpserver.ToolAskUser}
+	if te.approvals != nil && te.approvals.URL() != "" {
+		tools = append(tools, mcpserver.ToolPermissionPrompt)
+	}
+	return tools
+}
+
+// permissionPromptTool returns the --permission-prompt-tool value for a run, or
+// "" when the built-in server isn't registered (permissions are then skipped).
+func (te *TaskEngine) permissionPromptTool(builtinConfigs []string) string {
+	if len(builtinConfigs) == 0 || te.approvals == nil || te.approvals.URL() == "" {
+		return ""
+	}
+	return mcpserver.ToolName(mcpserver.ToolPermissionPrompt)
+}
+
+// beginApprovalRun has the approval broker decide the permission checks of
+// a task's run against agentForRun, the agent as passed to the Claude CLI.
+func (te *TaskEngine) beginApprovalRun(taskID string, agentForRun models.Agent, workDir string) {
+	if te.approvals != nil {
+		te.approvals.beginRun(taskID, agentForRun, workDir)
+	}
+}
+
+// endApprovalRun ends what beginApprovalRun started once the run is over.
+func (te *TaskEngine) endApprovalRun(taskID string) {
+	if te.approvals != nil {
+		te.approvals.endRun(taskID)
+	}
+}
+
+// cancelApprovals denies the calls of a task still waiting for the user.
+func (te *TaskEngine) cancelApprovals(taskID, reason string) {
+	if te.approvals != nil {
+		te.approvals.CancelTask(taskID, reason)
+	}
+}
+
+// builtinMCPConfigs writes the built-in server config for a task and returns the
+// paths to pass via --mcp-config. Failures are logged and disable the built-in
+// tools for this run; the heuristic input detection still applies.
+func (te *TaskEngine) builtinMCPConfigs(task *models.Task) []string {
+	if te.builtinMCP == nil {
+		return nil
+	}
+	env := map[string]string{mcpserver.EnvTaskID: task.ID}
+	if te.approvals != nil && te.approvals.URL() != "" {
+		env[mcpserver.EnvBrokerURL] = te.approvals.URL()
+		env[mcpserver.EnvBrokerToken] = te.approvals.Token()
+	}
+	path, err := te.builtinMCP.WriteConfig(task.ID, te.builtinTools(), env)
+	if err != nil {
+		log.Printf("task %s: warning: built-in MCP server unavailable: %v", task.ID, err)
+		return nil
+	}
+	return []string{path}
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"agent-workflow/backend/models"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// maxReviewHunk caps each hunk in the code review prompt.
+const maxReviewHunk = 8000
+
+func codeReviewJSONSchema() string {
+	return `{
+  "type": "object",
+  "required": ["summary", "comments"],
+  "properties": {
+    "summary": { "type": "string", "description": "Overall assessment in one or two sentences" },
+    "comments": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["file", "hunk", "severity", "comment"],
+        "properties": {
+          "file": { "type": "string", "description": "Path of the file, as given" },
+          "hunk": { "type": "integer", "description": "Index of the hunk, or -1 for the file as a whole" },
+          "severity": { "type": "string", "enum": ["info", "minor", "major", "critical"] },
+          "comment": { "type": "string" }
+        },
+        "additionalProperties": false
+      }
+    }
+  },
+  "additionalProperties": false
+}`
+}
+
+// QuoteHunk formats a hunk for a follow-up message to the agent.
+func QuoteHunk(h DiffHunk) string {
+	return fmt.Sprintf("```diff\n%s\n%s\n```", h.Header, h.Content)
+}
+
+// codeReviewer returns the code reviewer and fix rounds that apply to task:
+// its session's, or else its team's. The ID is empty when code review is off.
+func (te *TaskEngine) codeReviewer(task *models.Task) (string, int) {
+	if sess, err := te.sessions.GetByID(task.SessionID); err == nil && sess.CodeReviewerID != "" {
+		return string(sess.CodeReviewerID), sess.CodeReviewRounds
+	}
+	if task.TeamID != "" {
+		if team, err := te.teams.GetByID(string(task.TeamID)); err == nil && team.CodeReviewerID != "" {
+			return string(team.CodeReviewerID), team.CodeReviewRounds
+		}
+	}
+	return "", 0
+}
+
+// codeReview has the code reviewer comment on the hunks of a run that
+// completed and stores the comments. If any need fixing and rounds are left,
+// the task is put back to running and the follow-up to send is returned.
+// A review that cannot run is logged; it never fails the task.
+func (te *TaskEngine) codeReview(ctx context.Context, task *models.Task, project *models.Project, attempt *models.TaskAttempt, diffResult *DiffResult) string {
+	if te.reviewComments == nil || diffResult == nil || len(diffResult.Files) == 0 {
+		return ""
+	}
+	reviewerID, rounds := te.codeReviewer(task)
+	if reviewerID == "" {
+		return ""
+	}
+	reviewer, err := te.agents.GetByID(reviewerID)
+	if err != nil {
+		log.Printf("task %s: code reviewer %s not found: %v", task.ID, reviewerID, err)
+		return ""
+	}
+
+	round := task.CodeReviewRound + 1
+	te.emitStreamEvent(task.ID, "init", fmt.Sprintf("Code review by %s (round %d)", reviewer.Name, round))
+	summary, comments, err := te.reviewHunks(ctx, task, project, reviewer, diffResult)
+	if err != nil {
+		log.Printf("task %s: code review failed: %v", task.ID, err)
+		te.emitStreamEvent(task.ID, "error", fmt.Sprintf("Code review failed: %v", err))
+		return ""
+	}
+	for i := range comments {
+		comments[i].TaskID = task.ID
+		comments[i].Round = round
+		comments[i].ReviewerAgentID = models.OptionalRef(reviewer.ID)
+		if attempt != nil {
+			comments[i].AttemptID = attempt.ID
+		}
+	}
+	if err := te.reviewComments.CreateBatch(comments); err != nil {
+		log.Printf("task %s: failed to store review comments: %v", task.ID, err)
+	}
+	if te.wailsCtx != nil {
+		emitEvent(te.wailsCtx, "task:review", map[string]any{
+			"task_id":  task.ID,
+			"round":    round,
+			"summary":  summary,
+			"comments": comments,
+		})
+	}
+
+	var actionable []models.ReviewComment
+	for _, c := range comments {
+		if c.Severity != models.ReviewSeverityInfo {
+			actionable = append(actionable, c)
+		}
+	}
+	log.Printf("task %s: code review round %d: %d comments, %d to fix", task.ID, round, len(comments), len(actionable))
+	if len(actionable) == 0 || task.CodeReviewRound >= rounds || task.ClaudeSessionID == "" {
+		return ""
+	}
+	task.CodeReviewRound = round
+	task.Status = models.TaskStatusRunning
+	task.CompletedAt = nil
+	task.Error = fmt.Sprintf("Code review requested changes (round %d/%d): %s", round, rounds, summary)
+	return codeReviewFixPrompt(actionable, diffResult)
+}
+
+// reviewHunks asks reviewer for comments on every hunk of the diff. Comments
+// on unknown hunks are kept as comments on their file.
+func (te *TaskEngine) reviewHunks(ctx context.Context, task *models.Task, project *models.Project, reviewer *models.Agent, diffResult *DiffResult) (string, []models.ReviewComment, error) {
+	prompt := task.OriginalPrompt
+	if prompt == "" {
+		prompt = task.Prompt
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Review the changes an agent made for the task below. Comment on specific hunks, identified by file path and hunk index, and give each comment a severity: info (remark only), minor, major or critical. Only comment where something should change or is worth noting; no comments means the changes are fine. You may read files in the project for context. Do not modify any files.\n\nTask:\n<task>\n%s\n</task>\n", prompt)
+	for _, f := range diffResult.Files {
+		fmt.Fprintf(&sb, "\n<file path=%q status=%q>\n", f.Path, f.Status)
+		for i, h := range f.Hunks {
+			fmt.Fprintf(&sb, "<hunk index=\"%d\">\n%s\n%s\n</hunk>\n", i, h.Header, truncate(h.Content, maxReviewHunk))
+		}
+		sb.WriteString("</file>\n")
+	}
+
+	workDir := task.WorkspacePath
+	if workDir == "" {
+		workDir = project.Path
+	}
+	var review struct {
+		Summary  string `json:"summary"`
+		Comments []struct {
+			File     string `json:"file"`
+			Hunk     int    `json:"hunk"`
+			Severity string `json:"severity"`
+			Comment  string `json:"comment"`
+		} `json:"comments"`
+	}
+	if err := te.runStructured(ctx, reviewer, project, workDir, sb.String(), codeReviewJSONSchema(), &review); err != nil {
+		return "", nil, err
+	}
+
+	files := make(map[string]*FileDiff, len(diffResult.Files))
+	for i := range diffResult.Files {
+		files[diffResult.Files[i].Path] = &diffResult.Files[i]
+	}
+	comments := make([]models.ReviewComment, 0, len(review.Comments))
+	for _, c := range review.Comments {
+		if strings.TrimSpace(c.Comment) == "" {
+			continue
+		}
+		rc := models.ReviewComment{FilePath: c.File, HunkIndex: -1, Severity: models.ReviewSeverity(c.Severity), Body: c.Comment}
+		switch rc.Severity {
+		case models.ReviewSeverityInfo, models.ReviewSeverityMinor, models.ReviewSeverityMajor, models.ReviewSeverityCritical:
+		default:
+			rc.Severity = models.ReviewSeverityMinor
+		}
+		if f, ok := files[c.File]; ok && c.Hunk >= 0 && c.Hunk < len(f.Hunks) {
+			rc.HunkIndex = c.Hunk
+			rc.HunkHeader = f.Hunks[c.Hunk].Header
+		}
+		comments = append(comments, rc)
+	}
+	return review.Summary, comments, nil
+}
+
+// codeReviewFixPrompt sends review comments back to the implementer, quoting
+// each hunk the way a rejected hunk is.
+func codeReviewFixPrompt(comments []models.ReviewComment, diffResult *DiffResult) string {
+	hunks := make(map[string][]DiffHunk, len(diffResult.Files))
+	for _, f := range diffResult.Files {
+		hunks[f.Path] = f.Hunks
+	}
+	var sb strings.Builder
+	sb.WriteString("A code reviewer commented on your changes:\n")
+	for _, c := range comments {
+		if fileHunks := hunks[c.FilePath]; c.HunkIndex >= 0 && c.HunkIndex < len(fileHunks) {
+			fmt.Fprintf(&sb, "\n[%s] On this change in %s:\n%s\n%s\n", c.Severity, c.FilePath, QuoteHunk(fileHunks[c.HunkIndex]), c.Body)
+		} else {
+			fmt.Fprintf(&sb, "\n[%s] On %s:\n%s\n", c.Severity, c.FilePath, c.Body)
+		}
+	}
+	sb.WriteString("\nPlease address these comments and adjust your changes accordingly.")
+	return sb.String()
+}
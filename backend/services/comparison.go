@@ -0,0 +1,757 @@
+package services
+
+import (
+	"agent-workflow/backend/claude"
+	"agent-workflow/backend/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VariantSpec is one side of an A/B run.
+type VariantSpec struct {
+	AgentID string `json:"agent_id"`
+	Model   string `json:"model,omitempty"` // overrides the agent's model for this run
+}
+
+// ComparisonRequest launches a task's prompt against several agents or models.
+type ComparisonRequest struct {
+	TaskID       string        `json:"task_id"`
+	Variants     []VariantSpec `json:"variants"`
+	JudgeAgentID string        `json:"judge_agent_id,omitempty"` // optional agent that scores the variants
+}
+
+// VariantResult is a variant attempt with the measures it is ranked on.
+type VariantResult struct {
+	Attempt      models.TaskAttempt `json:"attempt"`
+	LinesAdded   int                `json:"lines_added"`
+	LinesRemoved int                `json:"lines_removed"`
+	Tokens       int                `json:"tokens"` // input + output
+	Rank         int                `json:"rank"`   // 1 is best
+}
+
+// ComparisonReport is a comparison with its variants, best first.
+type ComparisonReport struct {
+	Comparison models.TaskComparison `json:"comparison"`
+	Variants   []VariantResult       `json:"variants"`
+}
+
+// maxJudgeDiff caps each variant's diff in the judge prompt.
+const maxJudgeDiff = 30000
+
+// judgeDisallowedTools keep the judge from changing the project it reads.
+var judgeDisallowedTools = []string{"Edit", "MultiEdit", "Write", "NotebookEdit", "Bash"}
+
+// comparisonRef names the snapshots of a comparison; they are deleted with
+// its worktrees.
+func comparisonRef(comparisonID, name string) string {
+	return "comparisons/" + comparisonID + "/" + name
+}
+
+// StartComparison runs the task's prompt once per variant, concurrently and
+// each in its own worktree, and returns right away. The task itself is not
+// changed until a winner is applied.
+func (te *TaskEngine) StartComparison(req ComparisonRequest) (*models.TaskComparison, error) {
+	if te.comparisons == nil {
+		return nil, fmt.Errorf("comparisons are not available")
+	}
+	if len(req.Variants) < 2 {
+		return nil, fmt.Errorf("a comparison needs at least two variants")
+	}
+	task, err := te.tasks.GetByID(req.TaskID)
+	if err != nil {
+		return nil, fmt.Errorf("task not found: %w", err)
+	}
+	if task.Status == models.TaskStatusRunning || task.Status == models.TaskStatusQueued {
+		return nil, fmt.Errorf("task is %s", task.Status)
+	}
+	project, err := te.taskProject(task)
+	if err != nil {
+		return nil, err
+	}
+	if !hasGit(project.Path) {
+		return nil, fmt.Errorf("comparisons need the project to be a git repository")
+	}
+
+	agents := make([]*models.Agent, len(req.Variants))
+	for i, v := range req.Variants {
+		agent, err := te.agents.GetByID(v.AgentID)
+		if err != nil {
+			return nil, fmt.Errorf("variant %d: agent not found: %w", i+1, err)
+		}
+		if v.Model != "" {
+			agent.Model = v.Model
+		}
+		agents[i] = agent
+	}
+	var judge *models.Agent
+	if req.JudgeAgentID != "" {
+		if judge, err = te.agents.GetByID(req.JudgeAgentID); err != nil {
+			return nil, fmt.Errorf("judge agent not found: %w", err)
+		}
+	}
+
+	prompt := task.Prompt
+	if task.OriginalPrompt != "" {
+		prompt = task.OriginalPrompt
+	}
+	if prompt == "" {
+		return nil, fmt.Errorf("task has no prompt")
+	}
+	cmp := &models.TaskComparison{
+		ID:           uuid.New().String(),
+		TaskID:       task.ID,
+		Prompt:       prompt,
+		JudgeAgentID: models.OptionalRef(req.JudgeAgentID),
+	}
+	// Variants start from the project as it is now, uncommitted changes included
+	if cmp.BaseCommit, err = TakeSnapshot(project.Path, comparisonRef(cmp.ID, "base"), "Base of comparison "+cmp.ID, te.managed.Injected(project.Path)...); err != nil {
+		return nil, err
+	}
+	if err := te.comparisons.Create(cmp); err != nil {
+		return nil, fmt.Errorf("create comparison: %w", err)
+	}
+
+	run := *task
+	run.ClaudeSessionID = "" // every variant starts a fresh conversation
+	attempts := make([]*models.TaskAttempt, len(agents))
+	for i, agent := range agents {
+		dir := te.projectMgr.ComparisonWorkspace(cmp.ID, i+1)
+		if err := te.projectMgr.AddWorktree(project.Path, dir, cmp.BaseCommit); err != nil {
+			te.closeComparison(cmp, project.Path, models.ComparisonStatusDiscarded, err.Error())
+			return nil, err
+		}
+		attempt := te.beginAttempt(&run, agent, models.AttemptKindVariant, prompt)
+		attempt.ComparisonID = models.OptionalRef(cmp.ID)
+		attempt.Workspace = dir
+		if err := te.attempts.Update(attempt); err != nil {
+			log.Printf("task %s: failed to record variant %d: %v", task.ID, i+1, err)
+		}
+		attempts[i] = attempt
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	te.mu.Lock()
+	te.comparisonCancels[cmp.ID] = cancel
+	te.mu.Unlock()
+
+	log.Printf("task %s: comparison %s started with %d variants", task.ID, cmp.ID, len(agents))
+	te.emitComparison(cmp)
+	go te.runComparison(ctx, cmp, task, project, agents, judge, attempts)
+	return cmp, nil
+}
+
+// runComparison waits for every variant, asks the judge if there is one and
+// marks the comparison ready with a suggested winner.
+func (te *TaskEngine) runComparison(ctx context.Context, cmp *models.TaskComparison, task *models.Task, project *models.Project, agents []*models.Agent, judge *models.Agent, attempts []*models.TaskAttempt) {
+	defer func() {
+		te.mu.Lock()
+		if cancel, ok := te.comparisonCancels[cmp.ID]; ok {
+			cancel()
+			delete(te.comparisonCancels, cmp.ID)
+		}
+		te.mu.Unlock()
+	}()
+
+	var wg sync.WaitGroup
+	for i := range attempts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("PANIC in comparison %s variant %d: %v", cmp.ID, i+1, r)
+				}
+			}()
+			te.runVariant(ctx, task, project, agents[i], attempts[i])
+		}(i)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		te.closeComparison(cmp, project.Path, models.ComparisonStatusDiscarded, "")
+		return
+	}
+
+	if judge != nil {
+		if err := te.judgeVariants(ctx, cmp, project, judge, attempts); err != nil {
+			log.Printf("task %s: comparison %s: judge failed: %v", task.ID, cmp.ID, err)
+			cmp.Error = "judge failed: " + err.Error()
+		}
+	}
+
+	results := rankVariants(attempts)
+	cmp.SuggestedWinner = results[0].Attempt.ID
+	cmp.Status = models.ComparisonStatusReady
+	now := time.Now()
+	cmp.CompletedAt = &now
+	if err := te.comparisons.Update(cmp); err != nil {
+		log.Printf("task %s: failed to update comparison %s: %v", task.ID, cmp.ID, err)
+	}
+	log.Printf("task %s: comparison %s ready, suggested winner attempt %d", task.ID, cmp.ID, results[0].Attempt.Number)
+	te.emitComparison(cmp)
+}
+
+// runVariant runs one variant in its worktree the way executeTask runs a
+// task, then tests and builds the result. Variants cannot ask the user
+// questions: nobody is there to pick which one to answer.
+func (te *TaskEngine) runVariant(ctx context.Context, task *models.Task, project *models.Project, agent *models.Agent, attempt *models.TaskAttempt) {
+	// Stream events and the running process are keyed by the attempt
+	run := *task
+	run.ID = attempt.ID
+	run.Prompt = attempt.Prompt
+	run.ClaudeSessionID = ""
+	run.WorkspacePath = attempt.Workspace
+	run.TestPassed, run.BuildPassed = nil, nil
+	run.TestOutput, run.BuildOutput, run.Error = "", "", ""
+
+	runResult, violations, runErr := te.runVariantAgent(ctx, task.ID, &run, project, agent)
+	diffResult, _ := te.diffTracker.ComputeDiff(run.WorkspacePath)
+
+	status := models.TaskStatusCompleted
+	if runErr != nil {
+		status = models.TaskStatusFailed
+	} else if violationFails(agent, violations) {
+		status, run.Error = models.TaskStatusFailed, violationSummary(violations)
+	} else {
+		if r := te.testRunner.RunTest(run.WorkspacePath, project.TestCommand, te.sandboxProfile(agent, false)); r != nil {
+			run.TestPassed, run.TestOutput = &r.Passed, r.Output
+		}
+		if r := te.testRunner.RunBuild(run.WorkspacePath, project.BuildCommand, te.sandboxProfile(agent, false)); r != nil {
+			run.BuildPassed, run.BuildOutput = &r.Passed, r.Output
+		}
+		if run.TestPassed != nil && !*run.TestPassed {
+			status, run.Error = models.TaskStatusFailed, "Tests failed"
+		} else if run.BuildPassed != nil && !*run.BuildPassed {
+			status, run.Error = models.TaskStatusFailed, "Build failed"
+		}
+	}
+	attempt.PathViolations = run.PathViolations
+	te.finishAttempt(attempt, &run, status, runResult, runErr, diffResult)
+}
+
+// runVariantAgent prepares the worktree and runs the agent in it. Changes to
+// the agent's guarded paths are reverted afterwards, as for a task, and
+// recorded in run.PathViolations.
+func (te *TaskEngine) runVariantAgent(ctx context.Context, taskID string, run *models.Task, project *models.Project, agent *models.Agent) (*RunResult, []models.PathViolation, error) {
+	workDir := run.WorkspacePath
+	defer te.managed.Release(run.ID)
+	if project.ClaudeMD != "" {
+		if err := te.injectClaudeMD(run.ID, workDir, project.ClaudeMD); err != nil {
+			log.Printf("task %s: variant %s: failed to inject CLAUDE.md: %v", taskID, run.ID, err)
+		}
+	}
+	mcpConfigPath, mcpServerKeys, err := te.injectMCPConfig(run.ID, agent, workDir)
+	if err != nil {
+		log.Printf("task %s: variant %s: failed to inject .mcp.json: %v", taskID, run.ID, err)
+	}
+
+	// The worktree has no ignored files (dependencies, build output), so
+	// setup commands matter even more here
+	for i, cmd := range project.SetupCommands {
+		cmd = strings.TrimSpace(cmd)
+		if cmd == "" {
+			continue
+		}
+		output, setupErr := te.runSetupCommand(ctx, cmd, run, project, agent)
+		te.auditSetupCommand(taskID, i+1, cmd, output, setupErr)
+		if setupErr != nil {
+			te.emitStreamEvent(run.ID, "error", fmt.Sprintf("Setup command [%d] failed: %v\n%s", i+1, setupErr, string(output)))
+		}
+	}
+
+	agentForRun := *agent
+	agentForRun.DisallowedTools = models.StringSlice(te.buildEffectivePermissions(agent))
+	if mcpConfigPath != "" {
+		if extra := mcpToolPatterns(agent.AllowedTools, mcpServerKeys); len(extra) > 0 {
+			merged := make([]string, len(agent.AllowedTools), len(agent.AllowedTools)+len(extra))
+			copy(merged, agent.AllowedTools)
+			agentForRun.AllowedTools = append(merged, extra...)
+		}
+	}
+
+	guardRun := te.beginGuardRun(NewPathGuard(agent), workDir)
+
+	log.Printf("task %s: variant %s starting (agent=%s, model=%s, workdir=%s)", taskID, run.ID, agent.Name, agent.Model, workDir)
+	result, err := te.runner.RunTask(ctx, run, &agentForRun, workDir, RunTaskOptions{
+		MCPConfigPath: mcpConfigPath,
+		Sandbox:       te.sandboxProfile(agent, true),
+		Project:       project,
+	})
+	violations, violationData := te.enforcePathGuard(run.ID, guardRun, result)
+	run.PathViolations = violationData
+	return result, violations, err
+}
+
+// judgeVerdict is the judge's structured answer.
+type judgeVerdict struct {
+	Summary string `json:"summary"`
+	Scores  []struct {
+		Variant int     `json:"variant"`
+		Score   float64 `json:"score"`
+		Reason  string  `json:"reason"`
+	} `json:"scores"`
+}
+
+func judgeVerdictJSONSchema() string {
+	return `{
+  "type": "object",
+  "required": ["summary", "scores"],
+  "properties": {
+    "summary": { "type": "string", "description": "Which variant is best and why, in a few sentences" },
+    "scores": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["variant", "score", "reason"],
+        "properties": {
+          "variant": { "type": "integer", "description": "Variant number" },
+          "score": { "type": "number", "minimum": 0, "maximum": 10 },
+          "reason": { "type": "string" }
+        },
+        "additionalProperties": false
+      }
+    }
+  },
+  "additionalProperties": false
+}`
+}
+
+// judgeVariants asks the judge agent to score every variant from 0 to 10 and
+// stores the scores on the attempts.
+func (te *TaskEngine) judgeVariants(ctx context.Context, cmp *models.TaskComparison, project *models.Project, judge *models.Agent, attempts []*models.TaskAttempt) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Several agents were given the same task. Compare their changes and score each variant from 0 (unusable) to 10 (ideal) on correctness, completeness and code quality. Do not modify any files.\n\nTask:\n<task>\n%s\n</task>\n", cmp.Prompt)
+	for i, a := range attempts {
+		fmt.Fprintf(&sb, "\n<variant number=\"%d\" status=\"%s\" tests=\"%s\" build=\"%s\">\n", i+1, a.Status, outcomeLabel(a.TestPassed), outcomeLabel(a.BuildPassed))
+		if a.Error != "" {
+			fmt.Fprintf(&sb, "Error: %s\n", truncate(a.Error, 2000))
+		}
+		if a.DiffSnapshot == "" {
+			sb.WriteString("(no changes)\n")
+		} else {
+			sb.WriteString(truncate(a.DiffSnapshot, maxJudgeDiff) + "\n")
+		}
+		sb.WriteString("</variant>\n")
+	}
+
+	var verdict judgeVerdict
+	if err := te.runStructured(ctx, judge, project, project.Path, sb.String(), judgeVerdictJSONSchema(), &verdict); err != nil {
+		return err
+	}
+	cmp.JudgeSummary = verdict.Summary
+	for _, s := range verdict.Scores {
+		if s.Variant < 1 || s.Variant > len(attempts) {
+			continue
+		}
+		a := attempts[s.Variant-1]
+		score := s.Score
+		a.JudgeScore, a.JudgeReason = &score, s.Reason
+		if err := te.attempts.Update(a); err != nil {
+			log.Printf("task %s: failed to store judge score for attempt %d: %v", a.TaskID, a.Number, err)
+		}
+	}
+	return nil
+}
+
+// runStructured runs agent once, read-only, and decodes its --json-schema
+// validated answer into out.
+func (te *TaskEngine) runStructured(ctx context.Context, agent *models.Agent, project *models.Project, workDir, prompt, schema string, out any) error {
+	scopes, secrets := te.runner.taskEnvScopes(project, agent, nil)
+	env, err := MergeEnvScopes(scopes, secrets)
+	if err != nil {
+		return err
+	}
+	proc, err := claude.StartProcess(ctx, claude.ProcessOptions{
+		CLIPath:         te.runner.cliPath,
+		WorkDir:         workDir,
+		Model:           agent.Model,
+		SystemPrompt:    agent.SystemPrompt,
+		DisallowedTools: judgeDisallowedTools,
+		Permissions:     "default",
+		Prompt:          prompt,
+		JSONSchema:      schema,
+		Sandbox:         withModelAPIHost(te.sandboxProfile(agent, true), env),
+		Env:             env,
+	})
+	if err != nil {
+		return fmt.Errorf("start %s: %w", agent.Name, err)
+	}
+
+	var resultJSON string
+	var assistantText strings.Builder
+	for event := range proc.Events() {
+		switch event.Type {
+		case "result":
+			resultJSON = event.ResultText()
+		case "assistant":
+			assistantText.WriteString(claude.ExtractTextContent(event))
+		}
+	}
+	<-proc.Done()
+	if proc.Err() != nil {
+		return fmt.Errorf("%s: %w", agent.Name, proc.Err())
+	}
+
+	raw := resultJSON
+	if raw == "" {
+		raw = extractJSON(assistantText.String())
+	}
+	if err := json.Unmarshal([]byte(raw), out); err != nil {
+		return fmt.Errorf("parse %s response: %w (raw: %s)", agent.Name, err, truncate(raw, 500))
+	}
+	return nil
+}
+
+// rankVariants orders variants best first: finished before failed, passing
+// tests and build first, then the judge's score, then a change over none,
+// then the smallest diff, the fastest run and the fewest tokens.
+func rankVariants(attempts []*models.TaskAttempt) []VariantResult {
+	results := make([]VariantResult, len(attempts))
+	for i, a := range attempts {
+		added, removed := diffLineCounts(a.DiffSnapshot)
+		results[i] = VariantResult{
+			Attempt:      *a,
+			LinesAdded:   added,
+			LinesRemoved: removed,
+			Tokens:       a.InputTokens + a.OutputTokens,
+		}
+	}
+	outcome := func(v *bool) float64 {
+		switch {
+		case v == nil:
+			return 0
+		case *v:
+			return 1
+		default:
+			return -1
+		}
+	}
+	keys := func(r VariantResult) []float64 {
+		a := r.Attempt
+		judge := -1.0
+		if a.JudgeScore != nil {
+			judge = *a.JudgeScore
+		}
+		changed, size := 0.0, float64(r.LinesAdded+r.LinesRemoved)
+		if size > 0 {
+			changed = 1
+		}
+		finished := 0.0
+		if a.Status == models.TaskStatusCompleted {
+			finished = 1
+		}
+		return []float64{finished, outcome(a.TestPassed), outcome(a.BuildPassed), judge, changed, -size, -float64(a.DurationMS), -float64(r.Tokens)}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		ki, kj := keys(results[i]), keys(results[j])
+		for n := range ki {
+			if ki[n] != kj[n] {
+				return ki[n] > kj[n]
+			}
+		}
+		return false
+	})
+	for i := range results {
+		results[i].Rank = i + 1
+	}
+	return results
+}
+
+// diffLineCounts counts added and removed lines in a unified diff.
+func diffLineCounts(diff string) (added, removed int) {
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		case strings.HasPrefix(line, "+"):
+			added++
+		case strings.HasPrefix(line, "-"):
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// GetComparison returns a comparison with its variants ranked.
+func (te *TaskEngine) GetComparison(comparisonID string) (*ComparisonReport, error) {
+	cmp, err := te.comparisons.GetByID(comparisonID)
+	if err != nil {
+		return nil, fmt.Errorf("comparison not found: %w", err)
+	}
+	attempts, err := te.attempts.ListByComparison(comparisonID)
+	if err != nil {
+		return nil, err
+	}
+	ptrs := make([]*models.TaskAttempt, len(attempts))
+	for i := range attempts {
+		ptrs[i] = &attempts[i]
+	}
+	return &ComparisonReport{Comparison: *cmp, Variants: rankVariants(ptrs)}, nil
+}
+
+// ApplyComparisonWinner applies the winning variant's changes to the project
+// and records its results on the task; the other variants are discarded. The
+// changes are applied as a patch against the comparison's base, so it fails
+// if the project has since changed the same lines.
+func (te *TaskEngine) ApplyComparisonWinner(comparisonID, attemptID string) error {
+	cmp, err := te.comparisons.GetByID(comparisonID)
+	if err != nil {
+		return fmt.Errorf("comparison not found: %w", err)
+	}
+	if cmp.Status != models.ComparisonStatusReady {
+		return fmt.Errorf("comparison is %s", cmp.Status)
+	}
+	winner, err := te.attempts.GetByID(attemptID)
+	if err != nil || string(winner.ComparisonID) != cmp.ID {
+		return fmt.Errorf("attempt %s is not a variant of this comparison", attemptID)
+	}
+
+	// The task's own runs write the same project and task fields
+	taskMu := te.taskMutex(cmp.TaskID)
+	taskMu.Lock()
+	defer taskMu.Unlock()
+
+	task, err := te.tasks.GetByID(cmp.TaskID)
+	if err != nil {
+		return fmt.Errorf("task not found: %w", err)
+	}
+	switch task.Status {
+	case models.TaskStatusRunning, models.TaskStatusQueued, models.TaskStatusAwaitingInput:
+		return fmt.Errorf("task is %s; apply the winner once it has stopped", task.Status)
+	}
+	project, err := te.taskProject(task)
+	if err != nil {
+		return err
+	}
+
+	// Claim the comparison so a concurrent apply or discard cannot also close it
+	if ok, err := te.comparisons.UpdateStatusIf(cmp.ID, models.ComparisonStatusReady, models.ComparisonStatusApplied); err != nil {
+		return fmt.Errorf("update comparison: %w", err)
+	} else if !ok {
+		return fmt.Errorf("comparison is no longer ready")
+	}
+	release := func(err error) error {
+		if _, resetErr := te.comparisons.UpdateStatusIf(cmp.ID, models.ComparisonStatusApplied, models.ComparisonStatusReady); resetErr != nil {
+			log.Printf("comparison %s: failed to reset status: %v", cmp.ID, resetErr)
+		}
+		return err
+	}
+
+	commit, err := TakeSnapshot(winner.Workspace, comparisonRef(cmp.ID, strconv.Itoa(winner.Number)), fmt.Sprintf("Variant %d of comparison %s", winner.Number, cmp.ID))
+	if err != nil {
+		return release(err)
+	}
+	if err := te.checkVariantGuard(winner, cmp.BaseCommit, commit, project.Path); err != nil {
+		return release(err)
+	}
+	if err := applyCommitRange(project.Path, cmp.BaseCommit, commit); err != nil {
+		return release(err)
+	}
+
+	now := time.Now()
+	task.AgentID = winner.AgentID
+	task.Status = winner.Status
+	task.ResultText = winner.ResultText
+	task.Error = winner.Error
+	task.ExitCode = winner.ExitCode
+	task.FilesChanged = winner.FilesChanged
+	task.TestPassed, task.TestOutput = winner.TestPassed, winner.TestOutput
+	task.BuildPassed, task.BuildOutput = winner.BuildPassed, winner.BuildOutput
+	task.WorkspacePath = project.Path
+	task.ClaudeSessionID = "" // the conversation belongs to the removed worktree
+	task.CompletedAt = &now
+	if err := te.tasks.Update(task); err != nil {
+		return fmt.Errorf("update task: %w", err)
+	}
+	te.snapshotTask(task.ID, project.Path)
+	te.emitTaskStatus(task.ID, string(task.Status))
+	te.notifyTaskDone(task.SessionID)
+
+	cmp.WinnerAttemptID = winner.ID
+	te.closeComparison(cmp, project.Path, models.ComparisonStatusApplied, "")
+	log.Printf("task %s: applied variant %d of comparison %s", task.ID, winner.Number, cmp.ID)
+	return nil
+}
+
+// checkVariantGuard refuses a variant whose changes from base to commit
+// touch paths its agent guards. The guard reverts them after the run, so
+// this only catches what it could not revert.
+func (te *TaskEngine) checkVariantGuard(winner *models.TaskAttempt, base, commit, projectPath string) error {
+	if winner.AgentID == "" {
+		return nil
+	}
+	agent, err := te.agents.GetByID(string(winner.AgentID))
+	if err != nil {
+		return nil // agent deleted since; its guard is gone with it
+	}
+	guard := NewPathGuard(agent)
+	if guard == nil {
+		return nil
+	}
+	changed, err := runGit(projectPath, nil, "diff", "--name-only", "-z", "--no-renames", base, commit)
+	if err != nil {
+		return err
+	}
+	var guarded []string
+	for _, p := range strings.Split(changed, "\x00") {
+		if rule, pattern, ok := guard.Match(p); ok {
+			guarded = append(guarded, fmt.Sprintf("%s (%s %s)", p, strings.ReplaceAll(rule, "_", "-"), pattern))
+		}
+	}
+	if len(guarded) > 0 {
+		return fmt.Errorf("variant %d changes guarded paths: %s", winner.Number, strings.Join(guarded, ", "))
+	}
+	return nil
+}
+
+// applyCommitRange applies the changes from one commit to another to the
+// working tree of projectPath, leaving the index alone.
+func applyCommitRange(projectPath, from, to string) error {
+	patch, err := runGit(projectPath, nil, "diff", "--binary", from, to)
+	if err != nil {
+		return err
+	}
+	if patch == "" {
+		return nil
+	}
+	f, err := os.CreateTemp("", "shannon-variant-*.patch")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(patch + "\n")
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if _, err := runGit(projectPath, nil, "apply", "--binary", "--whitespace=nowarn", f.Name()); err != nil {
+		return fmt.Errorf("apply winning changes (has the project changed since the comparison started?): %w", err)
+	}
+	return nil
+}
+
+// DiscardComparison drops every variant without touching the project. A
+// running comparison is cancelled first.
+func (te *TaskEngine) DiscardComparison(comparisonID string) error {
+	te.mu.Lock()
+	cancel, running := te.comparisonCancels[comparisonID]
+	te.mu.Unlock()
+	if running {
+		cancel() // runComparison cleans up once the variants have stopped
+		return nil
+	}
+
+	cmp, err := te.comparisons.GetByID(comparisonID)
+	if err != nil {
+		return fmt.Errorf("comparison not found: %w", err)
+	}
+	if cmp.Status != models.ComparisonStatusReady && cmp.Status != models.ComparisonStatusRunning {
+		return fmt.Errorf("comparison is already %s", cmp.Status)
+	}
+	if ok, err := te.comparisons.UpdateStatusIf(cmp.ID, cmp.Status, models.ComparisonStatusDiscarded); err != nil {
+		return fmt.Errorf("update comparison: %w", err)
+	} else if !ok {
+		return fmt.Errorf("comparison changed meanwhile")
+	}
+	task, err := te.tasks.GetByID(cmp.TaskID)
+	if err != nil {
+		return fmt.Errorf("task not found: %w", err)
+	}
+	project, err := te.taskProject(task)
+	if err != nil {
+		return err
+	}
+	te.closeComparison(cmp, project.Path, models.ComparisonStatusDiscarded, "")
+	return nil
+}
+
+// RecoverComparisons discards comparisons left running by a previous run of
+// the app; their agents are gone.
+func (te *TaskEngine) RecoverComparisons() {
+	if te.comparisons == nil {
+		return
+	}
+	active, err := te.comparisons.ListActive()
+	if err != nil {
+		log.Printf("failed to list comparisons: %v", err)
+		return
+	}
+	for i := range active {
+		cmp := &active[i]
+		if cmp.Status != models.ComparisonStatusRunning {
+			continue
+		}
+		task, err := te.tasks.GetByID(cmp.TaskID)
+		if err != nil {
+			continue
+		}
+		project, err := te.taskProject(task)
+		if err != nil {
+			continue
+		}
+		te.closeComparison(cmp, project.Path, models.ComparisonStatusDiscarded, "interrupted")
+	}
+}
+
+// closeComparison removes the comparison's worktrees and snapshots and
+// records its final status.
+func (te *TaskEngine) closeComparison(cmp *models.TaskComparison, projectPath string, status models.ComparisonStatus, errMsg string) {
+	attempts, err := te.attempts.ListByComparison(cmp.ID)
+	if err != nil {
+		log.Printf("comparison %s: failed to list variants: %v", cmp.ID, err)
+	}
+	for i := range attempts {
+		a := &attempts[i]
+		if a.Workspace == "" {
+			continue
+		}
+		if err := te.projectMgr.RemoveWorktree(projectPath, a.Workspace); err != nil {
+			log.Printf("comparison %s: %v", cmp.ID, err)
+			continue
+		}
+		a.Workspace = ""
+		if a.Status == models.TaskStatusRunning {
+			a.Status = models.TaskStatusCancelled
+		}
+		if err := te.attempts.Update(a); err != nil {
+			log.Printf("comparison %s: failed to update attempt %d: %v", cmp.ID, a.Number, err)
+		}
+	}
+	if refs, err := runGit(projectPath, nil, "for-each-ref", "--format=%(refname)", strings.TrimSuffix(SnapshotRef(comparisonRef(cmp.ID, "")), "/")); err == nil {
+		for _, ref := range strings.Fields(refs) {
+			runGit(projectPath, nil, "update-ref", "-d", ref)
+		}
+	}
+
+	cmp.Status = status
+	if errMsg != "" {
+		cmp.Error = errMsg
+	}
+	if cmp.CompletedAt == nil {
+		now := time.Now()
+		cmp.CompletedAt = &now
+	}
+	if err := te.comparisons.Update(cmp); err != nil {
+		log.Printf("comparison %s: failed to update: %v", cmp.ID, err)
+	}
+	te.emitComparison(cmp)
+}
+
+func (te *TaskEngine) emitComparison(cmp *models.TaskComparison) {
+	if te.wailsCtx != nil {
+		emitEvent(te.wailsCtx, "task:comparison", map[string]any{
+			"comparison_id": cmp.ID,
+			"task_id":       cmp.TaskID,
+			"status":        cmp.Status,
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"agent-workflow/backend/models"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ConversationExport is the JSON export format of a task's chat thread.
+type ConversationExport struct {
+	TaskID     string               `json:"task_id"`
+	Title      string               `json:"title"`
+	SessionID  string               `json:"session_id"`
+	ExportedAt time.Time            `json:"exported_at"`
+	Messages   []models.TaskMessage `json:"messages"`
+}
+
+// ExportConversation renders a task's messages as "markdown" (default) or "json".
+func ExportConversation(task *models.Task, messages []models.TaskMessage, format string) (string, error) {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(ConversationExport{
+			TaskID:     task.ID,
+			Title:      task.Title,
+			SessionID:  task.SessionID,
+			ExportedAt: time.Now(),
+			Messages:   messages,
+		}, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("marshal conversation: %w", err)
+		}
+		return string(data), nil
+	case "", "markdown", "md":
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "# %s\n\n", task.Title)
+		for _, m := range messages {
+			speaker := "User"
+			if m.Role == models.MessageRoleAgent {
+				speaker = "Agent"
+			}
+			header := fmt.Sprintf("## %s — %s", speaker, m.CreatedAt.Format("2006-01-02 15:04:05"))
+			if m.Mode != "" {
+				header += fmt.Sprintf(" (%s)", m.Mode)
+			}
+			if m.IsError {
+				header += " [error]"
+			}
+			sb.WriteString(header + "\n\n")
+			sb.WriteString(strings.TrimSpace(m.Content))
+			sb.WriteString("\n\n")
+		}
+		return sb.String(), nil
+	default:
+		return "", fmt.Errorf("unsupported export format %q (use markdown or json)", format)
+	}
+}
+
+// recordMessage appends a turn to the task's conversation thread.
+// Errors are logged rather than returned so chat persistence never blocks execution.
+func (te *TaskEngine) recordMessage(taskID string, attempt *models.TaskAttempt, role models.MessageRole, mode string, content string, isError bool) {
+	if content == "" {
+		return
+	}
+	msg := &models.TaskMessage{
+		TaskID:  taskID,
+		Role:    role,
+		Mode:    mode,
+		Content: content,
+		IsError: isError,
+	}
+	if attempt != nil {
+		msg.AttemptID = attempt.ID
+	}
+	if err := te.messages.Create(msg); err != nil {
+		log.Printf("task %s: failed to store %s message: %v", taskID, role, err)
+	}
+}
+
+// recordAgentReply stores the agent's side of a run: its final text, or the error it ended with.
+func (te *TaskEngine) recordAgentReply(taskID string, attempt *models.TaskAttempt, runResult *RunResult, runErr error) {
+	switch {
+	case runErr != nil:
+		te.recordMessage(taskID, attempt, models.MessageRoleAgent, "", runErr.Error(), true)
+	case runResult != nil:
+		te.recordMessage(taskID, attempt, models.MessageRoleAgent, "", runResult.LastText, false)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 // DiffHunk represents a single hunk within a unified diff.
@@ -23,10 +24,11 @@ type DiffHunk struct {
 
 // FileDiff represents changes to a single file.
 type FileDiff struct {
-	Path   string     `json:"path"`
-	Status string     `json:"status"` // "added", "modified", "deleted", "renamed"
-	Diff   string     `json:"diff"`   // unified diff content
-	Hunks  []DiffHunk `json:"hunks"`  // parsed structured hunks
+	Path    string     `json:"path"`
+	OldPath string     `json:"old_path,omitempty"` // original path of a renamed file
+	Status  string     `json:"status"`             // "added", "modified", "deleted", "renamed"
+	Diff    string     `json:"diff"`               // unified diff content
+	Hunks   []DiffHunk `json:"hunks"`              // parsed structured hunks
 }
 
 // DiffResult contains all changes from a task.
@@ -35,11 +37,47 @@ type DiffResult struct {
 	Total int        `json:"total"`
 }
 
+// DiffMask turns the current content of a file into the content its diff
+// shows, and reports whether the file should show as existing at all.
+type DiffMask func(current []byte) (view []byte, exists bool, err error)
+
 // DiffTracker computes file differences using git.
-type DiffTracker struct{}
+type DiffTracker struct {
+	mu    sync.RWMutex
+	masks map[string]map[string]DiffMask // project path -> relative path -> mask
+}
 
 func NewDiffTracker() *DiffTracker {
-	return &DiffTracker{}
+	return &DiffTracker{masks: make(map[string]map[string]DiffMask)}
+}
+
+// Mask makes ComputeDiff show a project-relative path as mask turns its
+// current content, e.g. without what Shannon has injected into it.
+func (dt *DiffTracker) Mask(projectPath, relPath string, mask DiffMask) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	key := filepath.Clean(projectPath)
+	if dt.masks[key] == nil {
+		dt.masks[key] = make(map[string]DiffMask)
+	}
+	dt.masks[key][filepath.ToSlash(relPath)] = mask
+}
+
+// Unmask reverses Mask.
+func (dt *DiffTracker) Unmask(projectPath, relPath string) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	key := filepath.Clean(projectPath)
+	delete(dt.masks[key], filepath.ToSlash(relPath))
+	if len(dt.masks[key]) == 0 {
+		delete(dt.masks, key)
+	}
+}
+
+func (dt *DiffTracker) mask(projectPath, relPath string) DiffMask {
+	dt.mu.RLock()
+	defer dt.mu.RUnlock()
+	return dt.masks[filepath.Clean(projectPath)][relPath]
 }
 
 var hunkHeaderRe = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
@@ -131,7 +169,8 @@ func (dt *DiffTracker) ComputeDiff(projectPath string) (*DiffResult, error) {
 		return nil, fmt.Errorf("git status: %w", err)
 	}
 
-	statusOutput := strings.TrimSpace(string(out))
+	// Only trim trailing newlines: a leading space is part of the first line's XY status.
+	statusOutput := strings.TrimRight(string(out), "\n")
 	if statusOutput == "" {
 		return result, nil
 	}
@@ -148,13 +187,22 @@ func (dt *DiffTracker) ComputeDiff(projectPath string) (*DiffResult, error) {
 		path := strings.TrimSpace(line[3:])
 
 		// Handle renamed files: "R  old -> new"
+		var oldPath string
 		if strings.Contains(path, " -> ") {
 			parts := strings.SplitN(path, " -> ", 2)
-			path = parts[1]
+			oldPath, path = parts[0], parts[1]
+		}
+
+		if mask := dt.mask(projectPath, path); mask != nil {
+			if fd, ok := maskedFileDiff(projectPath, path, mask); ok {
+				result.Files = append(result.Files, fd)
+			}
+			continue
 		}
 
 		var fd FileDiff
 		fd.Path = path
+		fd.OldPath = oldPath
 
 		switch {
 		case xy == "??" || xy[0] == 'A' || xy[1] == 'A':
@@ -199,9 +247,12 @@ func (dt *DiffTracker) getGitFileDiff(projectPath, relPath string) string {
 		return ""
 	}
 
-	// Build a synthetic unified diff for the new file
-	content := string(data)
-	lines := strings.Split(content, "\n")
+	return newFileDiff(relPath, data)
+}
+
+// newFileDiff builds a synthetic unified diff adding a file with content data.
+func newFileDiff(relPath string, data []byte) string {
+	lines := strings.Split(string(data), "\n")
 	var sb strings.Builder
 	sb.WriteString(fmt.Sprintf("--- /dev/null\n+++ b/%s\n", relPath))
 	sb.WriteString(fmt.Sprintf("@@ -0,0 +1,%d @@\n", len(lines)))
@@ -211,6 +262,67 @@ func (dt *DiffTracker) getGitFileDiff(projectPath, relPath string) string {
 	return sb.String()
 }
 
+// maskedFileDiff diffs the masked view of relPath against HEAD; ok is false
+// when the view has no changes. A file the mask can't read (e.g. one the
+// agent left unparseable) shows as modified without content, so nothing the
+// mask hides leaks into the diff.
+func maskedFileDiff(projectPath, relPath string, mask DiffMask) (fd FileDiff, ok bool) {
+	fd = FileDiff{Path: relPath, Status: "modified"}
+	cmd := exec.Command("git", "cat-file", "blob", "HEAD:"+relPath)
+	cmd.Dir = projectPath
+	head, headErr := cmd.Output()
+	inHead := headErr == nil
+
+	current, err := os.ReadFile(filepath.Join(projectPath, relPath))
+	var view []byte
+	exists := false
+	if err == nil {
+		if view, exists, err = mask(current); err != nil {
+			return fd, true
+		}
+	} else if !os.IsNotExist(err) {
+		return fd, true
+	}
+
+	switch {
+	case !exists && !inHead:
+		return fd, false
+	case !exists:
+		fd.Status = "deleted"
+		return fd, true
+	case !inHead:
+		fd.Status = "added"
+		fd.Diff = newFileDiff(relPath, view)
+	case string(view) == string(head):
+		return fd, false
+	default:
+		fd.Diff = contentDiff(relPath, head, view)
+	}
+	fd.Hunks = ParseHunks(fd.Diff)
+	return fd, true
+}
+
+// contentDiff returns the unified diff of relPath from content a to b.
+func contentDiff(relPath string, a, b []byte) string {
+	dir, err := os.MkdirTemp("", "shannon-diff-*")
+	if err != nil {
+		return ""
+	}
+	defer os.RemoveAll(dir)
+	aPath, bPath := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	if os.WriteFile(aPath, a, 0600) != nil || os.WriteFile(bPath, b, 0600) != nil {
+		return ""
+	}
+	// Exits with 1 when the files differ
+	out, _ := exec.Command("git", "diff", "--no-index", "--", aPath, bPath).Output()
+	diff := string(out)
+	i := strings.Index(diff, "\n@@")
+	if i < 0 {
+		return ""
+	}
+	return fmt.Sprintf("--- a/%s\n+++ b/%s%s", relPath, relPath, diff[i:])
+}
+
 // GetChangedFiles returns the list of changed file paths using git.
 func (dt *DiffTracker) GetChangedFiles(projectPath string) ([]string, error) {
 	result, err := dt.ComputeDiff(projectPath)
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"agent-workflow/backend/models"
+	"fmt"
+	"sort"
+)
+
+// Env scope names, from lowest to highest precedence.
+const (
+	EnvScopeGlobal  = "global"
+	EnvScopeProject = "project"
+	EnvScopeAgent   = "agent"
+	EnvScopeTask    = "task"
+)
+
+// EnvScope is one layer of environment variables. Values may be "vault:KEY"
+// references, resolved only when a run starts.
+type EnvScope struct {
+	Name string
+	Vars map[string]string
+}
+
+// EffectiveEnvVar describes where a variable of a run's environment comes
+// from, without its value.
+type EffectiveEnvVar struct {
+	Name       string   `json:"name"`
+	Scope      string   `json:"scope"`                // scope that supplies the value
+	VaultKey   string   `json:"vault_key,omitempty"`  // set when the value is a vault reference
+	Missing    bool     `json:"missing,omitempty"`    // the referenced vault key does not exist
+	Overridden []string `json:"overridden,omitempty"` // lower scopes that also define the variable
+}
+
+// TaskEnvScopes returns the scopes of a run in precedence order: global →
+// project → agent → task. project and agent may be nil.
+func TaskEnvScopes(global map[string]string, project *models.Project, agent *models.Agent, task *models.Task) []EnvScope {
+	scopes := []EnvScope{{Name: EnvScopeGlobal, Vars: global}}
+	if project != nil {
+		scopes = append(scopes, EnvScope{Name: EnvScopeProject, Vars: project.Env})
+	}
+	if agent != nil {
+		scopes = append(scopes, EnvScope{Name: EnvScopeAgent, Vars: agent.Env})
+	}
+	if task != nil {
+		scopes = append(scopes, EnvScope{Name: EnvScopeTask, Vars: task.Env})
+	}
+	return scopes
+}
+
+// MergeEnvScopes layers the scopes (later ones win) and resolves vault
+// references. A reference to a missing vault key is an error, so a run never
+// starts with a silently empty credential.
+func MergeEnvScopes(scopes []EnvScope, secrets SecretStore) (map[string]string, error) {
+	merged := make(map[string]string)
+	for _, scope := range scopes {
+		for name, value := range scope.Vars {
+			merged[name] = value
+		}
+	}
+	resolved, err := ResolveVaultRefs(merged, secrets)
+	if err != nil {
+		return nil, fmt.Errorf("env: %w", err)
+	}
+	return resolved, nil
+}
+
+// InspectEnvScopes reports the variable names a run would get and which scope
+// each one comes from. Values are never included.
+func InspectEnvScopes(scopes []EnvScope, secrets SecretStore) []EffectiveEnvVar {
+	byName := make(map[string]*EffectiveEnvVar)
+	for _, scope := range scopes {
+		for name, value := range scope.Vars {
+			v, ok := byName[name]
+			if !ok {
+				v = &EffectiveEnvVar{Name: name}
+				byName[name] = v
+			} else {
+				v.Overridden = append(v.Overridden, v.Scope)
+			}
+			v.Scope = scope.Name
+			v.VaultKey, v.Missing = "", false
+			if key, isRef := VaultRef(value); isRef {
+				v.VaultKey = key
+				if secrets == nil {
+					v.Missing = true
+				} else {
+					_, found := secrets.Lookup(key)
+					v.Missing = !found
+				}
+			}
+		}
+	}
+
+	vars := make([]EffectiveEnvVar, 0, len(byName))
+	for _, v := range byName {
+		vars = append(vars, *v)
+	}
+	sort.Slice(vars, func(i, j int) bool { return vars[i].Name < vars[j].Name })
+	return vars
+}
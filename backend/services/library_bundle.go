@@ -0,0 +1,873 @@
+package services
+
+import (
+	"agent-workflow/backend/models"
+	"agent-workflow/backend/store"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Library bundles carry agents, teams and the MCP servers they use between
+// installations. IDs in a bundle are only references inside the bundle; import
+// maps them to new or existing local IDs.
+const (
+	libraryBundleFormat  = "shannon-library"
+	libraryBundleVersion = 1
+)
+
+// LibraryBundle is the portable export format. Secrets are never included:
+// vault references keep their key name, literal secrets are redacted.
+type LibraryBundle struct {
+	Format     string            `json:"format"`
+	Version    int               `json:"version"`
+	ExportedAt time.Time         `json:"exported_at"`
+	MCPServers []BundleMCPServer `json:"mcp_servers,omitempty"`
+	Agents     []BundleAgent     `json:"agents,omitempty"`
+	Teams      []BundleTeam      `json:"teams,omitempty"`
+}
+
+type BundleMCPServer struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	ServerKey   string            `json:"server_key"`
+	Description string            `json:"description,omitempty"`
+	Command     string            `json:"command"`
+	Args        []string          `json:"args,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	Enabled     bool              `json:"enabled"`
+}
+
+type BundleAgent struct {
+	ID              string                `json:"id"`
+	Name            string                `json:"name"`
+	Description     string                `json:"description,omitempty"`
+	Model           string                `json:"model,omitempty"`
+	SystemPrompt    string                `json:"system_prompt,omitempty"`
+	AllowedTools    []string              `json:"allowed_tools,omitempty"`
+	DisallowedTools []string              `json:"disallowed_tools,omitempty"`
+	MCPServers      []string              `json:"mcp_servers,omitempty"` // bundle IDs of MCPServers
+	Permissions     string                `json:"permissions,omitempty"`
+	ProtectedPaths  []string              `json:"protected_paths,omitempty"`
+	ReadOnlyPaths   []string              `json:"read_only_paths,omitempty"`
+	MaxRetries      int                   `json:"max_retries,omitempty"`
+	ViolationAction string                `json:"violation_action,omitempty"`
+	Sandbox         models.SandboxProfile `json:"sandbox"`
+	Env             map[string]string     `json:"env,omitempty"`
+}
+
+type BundleTeam struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Strategy    string            `json:"strategy,omitempty"`
+	Agents      []string          `json:"agents,omitempty"` // bundle IDs of Agents
+	Nodes       []models.TeamNode `json:"nodes,omitempty"`  // AgentID is a bundle ID
+	Edges       []models.TeamEdge `json:"edges,omitempty"`  // Source/Target are bundle IDs
+
+	CodeReviewer     string `json:"code_reviewer,omitempty"` // bundle ID of an Agent
+	CodeReviewRounds int    `json:"code_review_rounds,omitempty"`
+}
+
+// ImportAction is what happens to one bundle item on import.
+type ImportAction string
+
+const (
+	ImportCreate  ImportAction = "create"  // add as a new item (no conflict)
+	ImportMerge   ImportAction = "merge"   // fill in the existing item: add list entries and env keys, set empty fields
+	ImportReplace ImportAction = "replace" // overwrite the existing item, keeping its ID
+	ImportSkip    ImportAction = "skip"    // leave as is; references resolve to the existing item, if any
+)
+
+// ImportItem is one bundle item in an import plan.
+type ImportItem struct {
+	Key          string         `json:"key"`  // "<kind>:<bundle id>", used to pass choices back
+	Kind         string         `json:"kind"` // models.AuditEntityMCPServer, AuditEntityAgent or AuditEntityTeam
+	Name         string         `json:"name"`
+	ConflictID   string         `json:"conflict_id,omitempty"` // existing item with the same name (server key for MCP servers)
+	ConflictName string         `json:"conflict_name,omitempty"`
+	Actions      []ImportAction `json:"actions"` // the choices allowed for this item
+	Action       ImportAction   `json:"action"`  // the default choice
+}
+
+// ImportPlan lists what an import would do, for the user to adjust per item.
+type ImportPlan struct {
+	Items    []ImportItem `json:"items"`
+	Warnings []string     `json:"warnings,omitempty"`
+}
+
+// ImportResult summarizes an applied import.
+type ImportResult struct {
+	Created  int `json:"created"`
+	Merged   int `json:"merged"`
+	Replaced int `json:"replaced"`
+	Skipped  int `json:"skipped"`
+	// MissingSecrets lists env vars that need a value: redacted literals and
+	// vault references to keys this vault does not have.
+	MissingSecrets []string `json:"missing_secrets,omitempty"`
+}
+
+// LibraryPorter exports and imports library bundles.
+type LibraryPorter struct {
+	db      *store.DB
+	agents  *store.AgentStore
+	teams   *store.TeamStore
+	servers *store.MCPServerStore
+	secrets SecretStore
+}
+
+func NewLibraryPorter(db *store.DB, secrets SecretStore) *LibraryPorter {
+	return &LibraryPorter{
+		db:      db,
+		agents:  store.NewAgentStore(db),
+		teams:   store.NewTeamStore(db),
+		servers: store.NewMCPServerStore(db),
+		secrets: secrets,
+	}
+}
+
+// ExportAgent bundles one agent with its MCP servers.
+func (p *LibraryPorter) ExportAgent(id string) (*LibraryBundle, error) {
+	agent, err := p.agents.GetByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("agent not found: %w", err)
+	}
+	return p.bundle([]models.Agent{*agent}, nil)
+}
+
+// ExportTeam bundles a team with its agents and their MCP servers.
+func (p *LibraryPorter) ExportTeam(id string) (*LibraryBundle, error) {
+	team, err := p.teams.GetByID(id)
+	if err != nil {
+		return nil, fmt.Errorf("team not found: %w", err)
+	}
+	var agents []models.Agent
+	for _, agentID := range teamAgentIDs(team) {
+		agent, err := p.agents.GetByID(agentID)
+		if err != nil {
+			continue // dangling reference; CheckIntegrity reports those
+		}
+		agents = append(agents, *agent)
+	}
+	return p.bundle(agents, []models.Team{*team})
+}
+
+// ExportLibrary bundles every agent, team and MCP server.
+func (p *LibraryPorter) ExportLibrary() (*LibraryBundle, error) {
+	agents, err := p.agents.List()
+	if err != nil {
+		return nil, fmt.Errorf("list agents: %w", err)
+	}
+	teams, err := p.teams.List()
+	if err != nil {
+		return nil, fmt.Errorf("list teams: %w", err)
+	}
+	b, err := p.bundle(agents, teams)
+	if err != nil {
+		return nil, err
+	}
+	// Servers no agent uses are part of the library too
+	servers, err := p.servers.List()
+	if err != nil {
+		return nil, fmt.Errorf("list MCP servers: %w", err)
+	}
+	included := make(map[string]bool, len(b.MCPServers))
+	for _, s := range b.MCPServers {
+		included[s.ID] = true
+	}
+	for _, s := range servers {
+		if !included[s.ID] {
+			b.MCPServers = append(b.MCPServers, bundleMCPServer(s))
+		}
+	}
+	return b, nil
+}
+
+func (p *LibraryPorter) bundle(agents []models.Agent, teams []models.Team) (*LibraryBundle, error) {
+	b := &LibraryBundle{Format: libraryBundleFormat, Version: libraryBundleVersion, ExportedAt: time.Now()}
+
+	var serverIDs []string
+	seen := make(map[string]bool)
+	for _, agent := range agents {
+		for _, id := range agent.MCPServerIDs {
+			if !seen[id] {
+				seen[id] = true
+				serverIDs = append(serverIDs, id)
+			}
+		}
+	}
+	servers, err := p.servers.ListByIDs(serverIDs)
+	if err != nil {
+		return nil, fmt.Errorf("list MCP servers: %w", err)
+	}
+	exported := make(map[string]bool, len(servers))
+	for _, s := range servers {
+		b.MCPServers = append(b.MCPServers, bundleMCPServer(s))
+		exported[s.ID] = true
+	}
+
+	for _, agent := range agents {
+		ba := BundleAgent{
+			ID:              agent.ID,
+			Name:            agent.Name,
+			Description:     agent.Description,
+			Model:           agent.Model,
+			SystemPrompt:    agent.SystemPrompt,
+			AllowedTools:    agent.AllowedTools,
+			DisallowedTools: agent.DisallowedTools,
+			Permissions:     agent.Permissions,
+			ProtectedPaths:  agent.ProtectedPaths,
+			ReadOnlyPaths:   agent.ReadOnlyPaths,
+			MaxRetries:      agent.MaxRetries,
+			ViolationAction: agent.ViolationAction,
+			Sandbox:         agent.Sandbox,
+			Env:             RedactMCPEnv(agent.Env),
+		}
+		for _, id := range agent.MCPServerIDs {
+			if exported[id] {
+				ba.MCPServers = append(ba.MCPServers, id)
+			}
+		}
+		b.Agents = append(b.Agents, ba)
+	}
+
+	for _, team := range teams {
+		b.Teams = append(b.Teams, BundleTeam{
+			ID:          team.ID,
+			Name:        team.Name,
+			Description: team.Description,
+			Strategy:    string(team.Strategy),
+			Agents:      team.AgentIDs,
+			Nodes:       team.Nodes,
+			Edges:       team.Edges,
+
+			CodeReviewer:     string(team.CodeReviewerID),
+			CodeReviewRounds: team.CodeReviewRounds,
+		})
+	}
+	return b, nil
+}
+
+func bundleMCPServer(s models.MCPServer) BundleMCPServer {
+	return BundleMCPServer{
+		ID:          s.ID,
+		Name:        s.Name,
+		ServerKey:   s.ServerKey,
+		Description: s.Description,
+		Command:     s.Command,
+		Args:        s.Args,
+		Env:         RedactMCPEnv(s.Env),
+		Enabled:     s.Enabled,
+	}
+}
+
+// teamAgentIDs returns the agents a team uses, from its agent list, canvas
+// and code reviewer.
+func teamAgentIDs(team *models.Team) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	add := func(id string) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range team.AgentIDs {
+		add(id)
+	}
+	for _, n := range team.Nodes {
+		add(n.AgentID)
+	}
+	add(string(team.CodeReviewerID))
+	return ids
+}
+
+// EncodeBundle serializes a bundle as "json" or "yaml".
+func EncodeBundle(b *LibraryBundle, format string) ([]byte, error) {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case "", "json":
+		return data, nil
+	case "yaml", "yml":
+		// JSON is YAML; re-emit it in block style so field names and order
+		// follow the JSON tags.
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return nil, err
+		}
+		blockStyle(&node)
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(&node); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), enc.Close()
+	default:
+		return nil, fmt.Errorf("unknown bundle format %q", format)
+	}
+}
+
+func blockStyle(n *yaml.Node) {
+	n.Style &^= yaml.FlowStyle | yaml.DoubleQuotedStyle
+	if n.Kind == yaml.ScalarNode && strings.Contains(n.Value, "\n") {
+		n.Style |= yaml.LiteralStyle
+	}
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
+
+// DecodeBundle parses a JSON or YAML bundle and checks its format.
+func DecodeBundle(data []byte) (*LibraryBundle, error) {
+	trimmed := bytes.TrimSpace(data)
+	if !bytes.HasPrefix(trimmed, []byte("{")) {
+		var doc any
+		if err := yaml.Unmarshal(trimmed, &doc); err != nil {
+			return nil, fmt.Errorf("parse bundle: %w", err)
+		}
+		converted, err := json.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("parse bundle: %w", err)
+		}
+		trimmed = converted
+	}
+	var b LibraryBundle
+	if err := json.Unmarshal(trimmed, &b); err != nil {
+		return nil, fmt.Errorf("parse bundle: %w", err)
+	}
+	if b.Format != libraryBundleFormat {
+		return nil, fmt.Errorf("not a Shannon library bundle (format %q)", b.Format)
+	}
+	if b.Version > libraryBundleVersion {
+		return nil, fmt.Errorf("bundle version %d is newer than this build supports (%d)", b.Version, libraryBundleVersion)
+	}
+	return &b, validateBundle(&b)
+}
+
+func validateBundle(b *LibraryBundle) error {
+	seen := make(map[string]bool)
+	check := func(kind, id, name string) error {
+		if id == "" {
+			return fmt.Errorf("%s %q has no id", kind, name)
+		}
+		if seen[kind+":"+id] {
+			return fmt.Errorf("duplicate %s id %q", kind, id)
+		}
+		seen[kind+":"+id] = true
+		return nil
+	}
+	for _, s := range b.MCPServers {
+		if s.ServerKey == "" || s.Command == "" {
+			return fmt.Errorf("MCP server %q needs a server_key and command", s.Name)
+		}
+		if err := check(models.AuditEntityMCPServer, s.ID, s.Name); err != nil {
+			return err
+		}
+	}
+	for _, a := range b.Agents {
+		if strings.TrimSpace(a.Name) == "" {
+			return fmt.Errorf("agent %q has no name", a.ID)
+		}
+		if err := check(models.AuditEntityAgent, a.ID, a.Name); err != nil {
+			return err
+		}
+	}
+	for _, t := range b.Teams {
+		if strings.TrimSpace(t.Name) == "" {
+			return fmt.Errorf("team %q has no name", t.ID)
+		}
+		if err := check(models.AuditEntityTeam, t.ID, t.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func importKey(kind, id string) string {
+	return kind + ":" + id
+}
+
+func sameName(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+// existingLibrary is the local state an import is planned against.
+type existingLibrary struct {
+	servers []models.MCPServer
+	agents  []models.Agent
+	teams   []models.Team
+}
+
+func (p *LibraryPorter) loadExisting() (*existingLibrary, error) {
+	servers, err := p.servers.List()
+	if err != nil {
+		return nil, fmt.Errorf("list MCP servers: %w", err)
+	}
+	agents, err := p.agents.List()
+	if err != nil {
+		return nil, fmt.Errorf("list agents: %w", err)
+	}
+	teams, err := p.teams.List()
+	if err != nil {
+		return nil, fmt.Errorf("list teams: %w", err)
+	}
+	return &existingLibrary{servers: servers, agents: agents, teams: teams}, nil
+}
+
+// MCP servers conflict on server key, since that is their name in .mcp.json.
+func (e *existingLibrary) server(s BundleMCPServer) *models.MCPServer {
+	for i := range e.servers {
+		if sameName(e.servers[i].ServerKey, s.ServerKey) {
+			return &e.servers[i]
+		}
+	}
+	return nil
+}
+
+func (e *existingLibrary) agent(a BundleAgent) *models.Agent {
+	for i := range e.agents {
+		if sameName(e.agents[i].Name, a.Name) {
+			return &e.agents[i]
+		}
+	}
+	return nil
+}
+
+func (e *existingLibrary) team(t BundleTeam) *models.Team {
+	for i := range e.teams {
+		if sameName(e.teams[i].Name, t.Name) {
+			return &e.teams[i]
+		}
+	}
+	return nil
+}
+
+// PlanImport lists the bundle's items with their name conflicts. Items
+// without a conflict default to create, conflicting ones to skip.
+func (p *LibraryPorter) PlanImport(b *LibraryBundle) (*ImportPlan, error) {
+	existing, err := p.loadExisting()
+	if err != nil {
+		return nil, err
+	}
+	plan := &ImportPlan{}
+	add := func(kind, id, name, conflictID, conflictName string) {
+		item := ImportItem{Key: importKey(kind, id), Kind: kind, Name: name}
+		if conflictID == "" {
+			item.Actions = []ImportAction{ImportCreate, ImportSkip}
+			item.Action = ImportCreate
+		} else {
+			item.ConflictID, item.ConflictName = conflictID, conflictName
+			item.Actions = []ImportAction{ImportMerge, ImportReplace, ImportSkip}
+			item.Action = ImportSkip
+		}
+		plan.Items = append(plan.Items, item)
+	}
+	for _, s := range b.MCPServers {
+		if c := existing.server(s); c != nil {
+			add(models.AuditEntityMCPServer, s.ID, s.Name, c.ID, c.Name)
+		} else {
+			add(models.AuditEntityMCPServer, s.ID, s.Name, "", "")
+		}
+	}
+	for _, a := range b.Agents {
+		if c := existing.agent(a); c != nil {
+			add(models.AuditEntityAgent, a.ID, a.Name, c.ID, c.Name)
+		} else {
+			add(models.AuditEntityAgent, a.ID, a.Name, "", "")
+		}
+	}
+	for _, t := range b.Teams {
+		if c := existing.team(t); c != nil {
+			add(models.AuditEntityTeam, t.ID, t.Name, c.ID, c.Name)
+		} else {
+			add(models.AuditEntityTeam, t.ID, t.Name, "", "")
+		}
+	}
+	plan.Warnings = danglingBundleRefs(b)
+	return plan, nil
+}
+
+// danglingBundleRefs reports references to items the bundle does not contain.
+// They are dropped on import.
+func danglingBundleRefs(b *LibraryBundle) []string {
+	servers := make(map[string]bool)
+	for _, s := range b.MCPServers {
+		servers[s.ID] = true
+	}
+	agents := make(map[string]bool)
+	for _, a := range b.Agents {
+		agents[a.ID] = true
+	}
+	var warnings []string
+	for _, a := range b.Agents {
+		for _, id := range a.MCPServers {
+			if !servers[id] {
+				warnings = append(warnings, fmt.Sprintf("agent %q uses MCP server %s, which is not in the bundle", a.Name, id))
+			}
+		}
+	}
+	for _, t := range b.Teams {
+		for _, id := range teamAgentIDs(&models.Team{AgentIDs: t.Agents, Nodes: t.Nodes, CodeReviewerID: models.OptionalRef(t.CodeReviewer)}) {
+			if !agents[id] {
+				warnings = append(warnings, fmt.Sprintf("team %q uses agent %s, which is not in the bundle", t.Name, id))
+			}
+		}
+	}
+	return warnings
+}
+
+// Import applies a bundle in one transaction, so a failure leaves the library
+// as it was. choices maps ImportItem keys to actions; items without a choice
+// get the plan's default. MCP servers are imported first, then agents and
+// teams, so their references can be mapped to local IDs. Literal secrets in
+// env are moved into the vault.
+func (p *LibraryPorter) Import(b *LibraryBundle, choices map[string]ImportAction) (*ImportResult, error) {
+	var result *ImportResult
+	err := p.db.Tx(func(tx *store.DB) error {
+		var err error
+		result, err = NewLibraryPorter(tx, p.secrets).importBundle(b, choices)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (p *LibraryPorter) importBundle(b *LibraryBundle, choices map[string]ImportAction) (*ImportResult, error) {
+	plan, err := p.PlanImport(b)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := p.loadExisting()
+	if err != nil {
+		return nil, err
+	}
+	actions := make(map[string]ImportAction, len(plan.Items))
+	for _, item := range plan.Items {
+		action := item.Action
+		if chosen, ok := choices[item.Key]; ok {
+			allowed := false
+			for _, a := range item.Actions {
+				allowed = allowed || a == chosen
+			}
+			if !allowed {
+				return nil, fmt.Errorf("%s %q: %s is not possible", item.Kind, item.Name, chosen)
+			}
+			action = chosen
+		}
+		actions[item.Key] = action
+	}
+
+	result := &ImportResult{}
+	count := func(action ImportAction) {
+		switch action {
+		case ImportCreate:
+			result.Created++
+		case ImportMerge:
+			result.Merged++
+		case ImportReplace:
+			result.Replaced++
+		case ImportSkip:
+			result.Skipped++
+		}
+	}
+
+	serverIDs := make(map[string]string)
+	for _, bs := range b.MCPServers {
+		action := actions[importKey(models.AuditEntityMCPServer, bs.ID)]
+		target := existing.server(bs)
+		id, err := p.importServer(bs, action, target, result)
+		if err != nil {
+			return result, fmt.Errorf("MCP server %q: %w", bs.Name, err)
+		}
+		if id != "" {
+			serverIDs[bs.ID] = id
+		}
+		count(action)
+	}
+
+	agentIDs := make(map[string]string)
+	for _, ba := range b.Agents {
+		action := actions[importKey(models.AuditEntityAgent, ba.ID)]
+		target := existing.agent(ba)
+		id, err := p.importAgent(ba, action, target, serverIDs, result)
+		if err != nil {
+			return result, fmt.Errorf("agent %q: %w", ba.Name, err)
+		}
+		if id != "" {
+			agentIDs[ba.ID] = id
+		}
+		count(action)
+	}
+
+	for _, bt := range b.Teams {
+		action := actions[importKey(models.AuditEntityTeam, bt.ID)]
+		if err := p.importTeam(bt, action, existing.team(bt), agentIDs); err != nil {
+			return result, fmt.Errorf("team %q: %w", bt.Name, err)
+		}
+		count(action)
+	}
+	sort.Strings(result.MissingSecrets)
+	return result, nil
+}
+
+// importEnv resolves a bundle env against the existing one: redacted values
+// keep the existing value if there is one and are dropped otherwise. Missing
+// values and vault references this vault lacks are reported under owner.
+func (p *LibraryPorter) importEnv(owner string, env, current map[string]string, result *ImportResult) models.StringMap {
+	out := models.StringMap{}
+	for name, value := range env {
+		if value == RedactedValue {
+			if cur, ok := current[name]; ok && cur != RedactedValue {
+				out[name] = cur
+			} else {
+				result.MissingSecrets = append(result.MissingSecrets, fmt.Sprintf("%s: %s", owner, name))
+			}
+			continue
+		}
+		if key, ok := VaultRef(value); ok && p.secrets != nil {
+			if _, found := p.secrets.Lookup(key); !found {
+				result.MissingSecrets = append(result.MissingSecrets, fmt.Sprintf("%s: %s (vault key %s)", owner, name, key))
+			}
+		}
+		out[name] = value
+	}
+	return out
+}
+
+func (p *LibraryPorter) importServer(bs BundleMCPServer, action ImportAction, target *models.MCPServer, result *ImportResult) (string, error) {
+	owner := "MCP server " + bs.ServerKey
+	switch action {
+	case ImportSkip:
+		if target != nil {
+			return target.ID, nil
+		}
+		return "", nil
+	case ImportCreate:
+		srv := models.MCPServer{
+			Name:        bs.Name,
+			ServerKey:   bs.ServerKey,
+			Description: bs.Description,
+			Command:     bs.Command,
+			Args:        bs.Args,
+			Env:         p.importEnv(owner, bs.Env, nil, result),
+			Enabled:     bs.Enabled,
+		}
+		if _, err := ExternalizeMCPSecrets(&srv, p.secrets); err != nil {
+			return "", err
+		}
+		if err := p.servers.Create(&srv); err != nil {
+			return "", err
+		}
+		return srv.ID, nil
+	}
+
+	srv := *target
+	env := p.importEnv(owner, bs.Env, target.Env, result)
+	if action == ImportReplace {
+		srv.Name, srv.Description, srv.Command, srv.Args, srv.Enabled = bs.Name, bs.Description, bs.Command, bs.Args, bs.Enabled
+		srv.Env = env
+	} else {
+		srv.Description = fillEmpty(srv.Description, bs.Description)
+		if len(srv.Args) == 0 {
+			srv.Args = bs.Args
+		}
+		srv.Env = mergeEnv(srv.Env, env)
+	}
+	if _, err := ExternalizeMCPSecrets(&srv, p.secrets); err != nil {
+		return "", err
+	}
+	if err := p.servers.Update(&srv); err != nil {
+		return "", err
+	}
+	return srv.ID, nil
+}
+
+func (p *LibraryPorter) importAgent(ba BundleAgent, action ImportAction, target *models.Agent, serverIDs map[string]string, result *ImportResult) (string, error) {
+	servers := models.StringSlice{}
+	for _, id := range ba.MCPServers {
+		if local, ok := serverIDs[id]; ok {
+			servers = append(servers, local)
+		}
+	}
+	owner := "agent " + ba.Name
+	switch action {
+	case ImportSkip:
+		if target != nil {
+			return target.ID, nil
+		}
+		return "", nil
+	case ImportCreate:
+		agent := models.Agent{
+			Name:            ba.Name,
+			Description:     ba.Description,
+			Model:           ba.Model,
+			SystemPrompt:    ba.SystemPrompt,
+			AllowedTools:    ba.AllowedTools,
+			DisallowedTools: ba.DisallowedTools,
+			MCPServerIDs:    servers,
+			Permissions:     ba.Permissions,
+			ProtectedPaths:  ba.ProtectedPaths,
+			ReadOnlyPaths:   ba.ReadOnlyPaths,
+			MaxRetries:      ba.MaxRetries,
+			ViolationAction: fillEmpty(ba.ViolationAction, "fail"),
+			Sandbox:         ba.Sandbox,
+			Env:             p.importEnv(owner, ba.Env, nil, result),
+		}
+		if _, err := ExternalizeEnvSecrets(agent.Env, agent.Name, p.secrets); err != nil {
+			return "", err
+		}
+		if err := p.agents.Create(&agent); err != nil {
+			return "", err
+		}
+		return agent.ID, nil
+	}
+
+	agent := *target
+	env := p.importEnv(owner, ba.Env, target.Env, result)
+	if action == ImportReplace {
+		agent.Description, agent.Model, agent.SystemPrompt = ba.Description, fillEmpty(ba.Model, agent.Model), ba.SystemPrompt
+		agent.AllowedTools, agent.DisallowedTools, agent.MCPServerIDs = ba.AllowedTools, ba.DisallowedTools, servers
+		agent.Permissions = fillEmpty(ba.Permissions, agent.Permissions)
+		agent.ProtectedPaths, agent.ReadOnlyPaths = ba.ProtectedPaths, ba.ReadOnlyPaths
+		agent.MaxRetries, agent.ViolationAction = ba.MaxRetries, fillEmpty(ba.ViolationAction, "fail")
+		agent.Sandbox, agent.Env = ba.Sandbox, env
+	} else {
+		agent.Description = fillEmpty(agent.Description, ba.Description)
+		agent.SystemPrompt = fillEmpty(agent.SystemPrompt, ba.SystemPrompt)
+		agent.AllowedTools = unionStrings(agent.AllowedTools, ba.AllowedTools)
+		agent.DisallowedTools = unionStrings(agent.DisallowedTools, ba.DisallowedTools)
+		agent.MCPServerIDs = unionStrings(agent.MCPServerIDs, servers)
+		agent.ProtectedPaths = unionStrings(agent.ProtectedPaths, ba.ProtectedPaths)
+		agent.ReadOnlyPaths = unionStrings(agent.ReadOnlyPaths, ba.ReadOnlyPaths)
+		agent.Env = mergeEnv(agent.Env, env)
+	}
+	if _, err := ExternalizeEnvSecrets(agent.Env, agent.Name, p.secrets); err != nil {
+		return "", err
+	}
+	if err := p.agents.Update(&agent); err != nil {
+		return "", err
+	}
+	return agent.ID, nil
+}
+
+func (p *LibraryPorter) importTeam(bt BundleTeam, action ImportAction, target *models.Team, agentIDs map[string]string) error {
+	if action == ImportSkip {
+		return nil
+	}
+	ids := models.StringSlice{}
+	for _, id := range bt.Agents {
+		if local, ok := agentIDs[id]; ok {
+			ids = append(ids, local)
+		}
+	}
+	nodes := models.NodeSlice{}
+	for _, n := range bt.Nodes {
+		if local, ok := agentIDs[n.AgentID]; ok {
+			nodes = append(nodes, models.TeamNode{AgentID: local, X: n.X, Y: n.Y})
+		}
+	}
+	edges := models.EdgeSlice{}
+	for _, e := range bt.Edges {
+		source, ok1 := agentIDs[e.Source]
+		target, ok2 := agentIDs[e.Target]
+		if ok1 && ok2 {
+			edges = append(edges, models.TeamEdge{Source: source, Target: target})
+		}
+	}
+
+	reviewer := models.OptionalRef(agentIDs[bt.CodeReviewer])
+
+	switch action {
+	case ImportCreate:
+		team := models.Team{
+			Name:             bt.Name,
+			Description:      bt.Description,
+			Strategy:         models.TeamStrategy(bt.Strategy),
+			AgentIDs:         ids,
+			Nodes:            nodes,
+			Edges:            edges,
+			CodeReviewerID:   reviewer,
+			CodeReviewRounds: bt.CodeReviewRounds,
+		}
+		return p.teams.Create(&team)
+	case ImportReplace:
+		team := *target
+		team.Description, team.AgentIDs, team.Nodes, team.Edges = bt.Description, ids, nodes, edges
+		team.CodeReviewerID, team.CodeReviewRounds = reviewer, bt.CodeReviewRounds
+		if bt.Strategy != "" {
+			team.Strategy = models.TeamStrategy(bt.Strategy)
+		}
+		return p.teams.Update(&team)
+	default: // merge
+		team := *target
+		team.Description = fillEmpty(team.Description, bt.Description)
+		if team.CodeReviewerID == "" {
+			team.CodeReviewerID, team.CodeReviewRounds = reviewer, bt.CodeReviewRounds
+		}
+		team.AgentIDs = unionStrings(team.AgentIDs, ids)
+		placed := make(map[string]bool, len(team.Nodes))
+		for _, n := range team.Nodes {
+			placed[n.AgentID] = true
+		}
+		for _, n := range nodes {
+			if !placed[n.AgentID] {
+				team.Nodes = append(team.Nodes, n)
+			}
+		}
+		linked := make(map[models.TeamEdge]bool, len(team.Edges))
+		for _, e := range team.Edges {
+			linked[e] = true
+		}
+		for _, e := range edges {
+			if !linked[e] {
+				team.Edges = append(team.Edges, e)
+			}
+		}
+		return p.teams.Update(&team)
+	}
+}
+
+func fillEmpty(current, fallback string) string {
+	if strings.TrimSpace(current) == "" {
+		return fallback
+	}
+	return current
+}
+
+func unionStrings(current, extra []string) models.StringSlice {
+	out := append(models.StringSlice{}, current...)
+	seen := make(map[string]bool, len(current))
+	for _, s := range current {
+		seen[s] = true
+	}
+	for _, s := range extra {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// mergeEnv adds the keys of extra that current lacks.
+func mergeEnv(current, extra map[string]string) models.StringMap {
+	out := models.StringMap{}
+	for k, v := range extra {
+		out[k] = v
+	}
+	for k, v := range current {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,447 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Paths (relative to the project) of files Shannon injects content into.
+const (
+	managedClaudeMD = ".claude/CLAUDE.md"
+	managedMCPJson  = ".mcp.json"
+)
+
+// Markers delimiting Shannon's section in CLAUDE.md.
+const (
+	claudeMDBegin = "<!-- BEGIN SHANNON MANAGED SECTION: injected for the running task and removed afterwards; do not edit -->"
+	claudeMDEnd   = "<!-- END SHANNON MANAGED SECTION -->"
+)
+
+// ManagedFiles injects Shannon-managed content into project files without
+// destroying what is already there. CLAUDE.md gets a delimited section appended
+// and .mcp.json gets the agent's servers merged in. The original content is
+// backed up (in memory and in a manifest on disk, so it survives a crash) and
+// restored once the last task run using the project releases it. While
+// injected, DiffTracker shows the files as they will be once restored, so
+// diffs carry the agent's own edits but none of the injected content.
+type ManagedFiles struct {
+	backupDir   string // "" = no on-disk manifests
+	diffTracker *DiffTracker
+
+	mu       sync.Mutex
+	projects map[string]*managedProject // by project path
+}
+
+type managedProject struct {
+	ProjectPath string                  `json:"project_path"`
+	Files       map[string]*managedFile `json:"files"` // by relative path
+	holders     map[string]bool         // task IDs currently using the injection
+}
+
+// managedFile records what a file looked like before injection and what was
+// written. Written holds resolved vault values, so it is kept in memory only;
+// a manifest records that the file was injected and what to undo.
+type managedFile struct {
+	Existed     bool              `json:"existed"`
+	Original    []byte            `json:"original,omitempty"`
+	Written     []byte            `json:"written,omitempty"` // never persisted; read from older manifests
+	Injected    bool              `json:"injected,omitempty"`
+	CreatedDir  bool              `json:"created_dir,omitempty"`  // .claude didn't exist before
+	ManagedKeys []string          `json:"managed_keys,omitempty"` // .mcp.json servers added or replaced
+	Replaced    map[string][]byte `json:"replaced,omitempty"`     // original entries of replaced servers
+}
+
+func NewManagedFiles(backupDir string, diffTracker *DiffTracker) *ManagedFiles {
+	return &ManagedFiles{
+		backupDir:   backupDir,
+		diffTracker: diffTracker,
+		projects:    make(map[string]*managedProject),
+	}
+}
+
+// RecoverAll restores projects left injected by a previous run of Shannon
+// (e.g. after a crash). Call once at startup, before any task runs.
+func (m *ManagedFiles) RecoverAll() {
+	if m.backupDir == "" {
+		return
+	}
+	entries, err := os.ReadDir(m.backupDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(m.backupDir, e.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var mp managedProject
+		if err := json.Unmarshal(data, &mp); err != nil || mp.ProjectPath == "" {
+			log.Printf("[managed] ignoring unreadable manifest %s: %v", path, err)
+			continue
+		}
+		log.Printf("[managed] restoring files injected into %s by a previous run", mp.ProjectPath)
+		m.restore(&mp)
+		os.Remove(path)
+	}
+}
+
+// InjectClaudeMD places content in the managed section of the project's
+// .claude/CLAUDE.md on behalf of holder (a task ID).
+func (m *ManagedFiles) InjectClaudeMD(projectPath, holder, content string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mp := m.project(projectPath)
+	full := filepath.Join(projectPath, managedClaudeMD)
+	mf, err := m.track(mp, managedClaudeMD)
+	if err != nil {
+		return err
+	}
+	if !mf.Existed {
+		if _, err := os.Stat(filepath.Dir(full)); os.IsNotExist(err) {
+			mf.CreatedDir = true
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return fmt.Errorf("create .claude dir: %w", err)
+	}
+
+	current := mf.Original
+	if data, err := os.ReadFile(full); err == nil {
+		current = data
+	}
+	merged := withClaudeMDSection(stripClaudeMDSection(current), content)
+	if err := os.WriteFile(full, merged, 0644); err != nil {
+		return fmt.Errorf("write CLAUDE.md: %w", err)
+	}
+	mf.Written, mf.Injected = merged, true
+	return m.commit(mp, holder)
+}
+
+// InjectMCPServers merges servers into the project's .mcp.json on behalf of
+// holder and returns the file's path. Servers the project already defines under
+// the same key are replaced for the duration of the run.
+func (m *ManagedFiles) InjectMCPServers(projectPath, holder string, servers map[string]any) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mp := m.project(projectPath)
+	full := filepath.Join(projectPath, managedMCPJson)
+	mf, err := m.track(mp, managedMCPJson)
+	if err != nil {
+		return "", err
+	}
+
+	doc := map[string]json.RawMessage{}
+	current := mf.Original
+	if data, err := os.ReadFile(full); err == nil {
+		current = data
+	}
+	if len(bytes.TrimSpace(current)) > 0 {
+		if err := json.Unmarshal(current, &doc); err != nil {
+			return "", fmt.Errorf("existing .mcp.json is not valid JSON, refusing to modify it: %w", err)
+		}
+	}
+	existing := map[string]json.RawMessage{}
+	if raw, ok := doc["mcpServers"]; ok {
+		if err := json.Unmarshal(raw, &existing); err != nil {
+			return "", fmt.Errorf("existing .mcp.json has an invalid mcpServers object: %w", err)
+		}
+	}
+
+	managed := make(map[string]bool)
+	for _, k := range mf.ManagedKeys {
+		managed[k] = true
+	}
+	if mf.Replaced == nil {
+		mf.Replaced = map[string][]byte{}
+	}
+	for key, entry := range servers {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return "", fmt.Errorf("marshal MCP server %q: %w", key, err)
+		}
+		if orig, ok := existing[key]; ok && !managed[key] {
+			mf.Replaced[key] = orig
+		}
+		existing[key] = data
+		if !managed[key] {
+			managed[key] = true
+			mf.ManagedKeys = append(mf.ManagedKeys, key)
+		}
+	}
+
+	serversJSON, err := json.Marshal(existing)
+	if err != nil {
+		return "", fmt.Errorf("marshal .mcp.json: %w", err)
+	}
+	doc["mcpServers"] = serversJSON
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("marshal .mcp.json: %w", err)
+	}
+	if err := os.WriteFile(full, out, 0600); err != nil {
+		return "", fmt.Errorf("write .mcp.json: %w", err)
+	}
+	mf.Written, mf.Injected = out, true
+	return full, m.commit(mp, holder)
+}
+
+// Release drops holder's claim on every project; projects nobody holds any
+// more get their files restored.
+func (m *ManagedFiles) Release(holder string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for path, mp := range m.projects {
+		if !mp.holders[holder] {
+			continue
+		}
+		delete(mp.holders, holder)
+		if len(mp.holders) > 0 {
+			continue
+		}
+		m.restore(mp)
+		delete(m.projects, path)
+		if m.backupDir != "" {
+			os.Remove(m.manifestPath(path))
+		}
+	}
+}
+
+// Injected returns the files of projectPath (relative paths) that currently
+// hold injected content.
+func (m *ManagedFiles) Injected(projectPath string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mp, ok := m.projects[projectPath]
+	if !ok {
+		return nil
+	}
+	rels := make([]string, 0, len(mp.Files))
+	for rel := range mp.Files {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	return rels
+}
+
+func (m *ManagedFiles) project(projectPath string) *managedProject {
+	mp, ok := m.projects[projectPath]
+	if !ok {
+		mp = &managedProject{ProjectPath: projectPath, Files: map[string]*managedFile{}, holders: map[string]bool{}}
+		m.projects[projectPath] = mp
+	}
+	return mp
+}
+
+// track backs up a file the first time it is injected into.
+func (m *ManagedFiles) track(mp *managedProject, rel string) (*managedFile, error) {
+	if mf, ok := mp.Files[rel]; ok {
+		return mf, nil
+	}
+	mf := &managedFile{}
+	data, err := os.ReadFile(filepath.Join(mp.ProjectPath, rel))
+	switch {
+	case err == nil:
+		mf.Existed = true
+		mf.Original = data
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("back up %s: %w", rel, err)
+	}
+	mp.Files[rel] = mf
+	return mf, nil
+}
+
+// commit records the holder, masks the injected content in diffs and persists the manifest.
+func (m *ManagedFiles) commit(mp *managedProject, holder string) error {
+	mp.holders[holder] = true
+	if m.diffTracker != nil {
+		for rel, mf := range mp.Files {
+			if mf.Written != nil {
+				m.diffTracker.Mask(mp.ProjectPath, rel, m.diffMask(rel, mf))
+			}
+		}
+	}
+	if m.backupDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(m.backupDir, 0700); err != nil {
+		return fmt.Errorf("create backup dir: %w", err)
+	}
+	files := make(map[string]*managedFile, len(mp.Files))
+	for rel, mf := range mp.Files {
+		persisted := *mf
+		persisted.Written = nil
+		files[rel] = &persisted
+	}
+	data, err := json.Marshal(managedProject{ProjectPath: mp.ProjectPath, Files: files})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.manifestPath(mp.ProjectPath), data, 0600)
+}
+
+// diffMask shows a managed file in diffs as restoring it would leave it.
+func (m *ManagedFiles) diffMask(rel string, mf *managedFile) DiffMask {
+	return func(current []byte) ([]byte, bool, error) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return restoredContent(current, rel, mf)
+	}
+}
+
+func (m *ManagedFiles) manifestPath(projectPath string) string {
+	sum := sha1.Sum([]byte(projectPath))
+	return filepath.Join(m.backupDir, hex.EncodeToString(sum[:8])+".json")
+}
+
+// restore undoes the injection. A file nobody touched since is put back byte
+// for byte; otherwise, and when recovering from a manifest, only Shannon's
+// section/servers are removed so edits made in the meantime survive.
+func (m *ManagedFiles) restore(mp *managedProject) {
+	rels := make([]string, 0, len(mp.Files))
+	for rel := range mp.Files {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	for _, rel := range rels {
+		mf := mp.Files[rel]
+		full := filepath.Join(mp.ProjectPath, rel)
+		if err := restoreManagedFile(full, rel, mf); err != nil {
+			log.Printf("[managed] failed to restore %s: %v", full, err)
+		}
+		if mf.CreatedDir {
+			os.Remove(filepath.Dir(full)) // only succeeds if empty
+		}
+		if m.diffTracker != nil {
+			m.diffTracker.Unmask(mp.ProjectPath, rel)
+		}
+	}
+}
+
+func restoreManagedFile(full, rel string, mf *managedFile) error {
+	if mf.Written == nil && !mf.Injected {
+		return nil // injection failed before anything was written
+	}
+	current, err := os.ReadFile(full)
+	if os.IsNotExist(err) {
+		return nil // removed in the meantime; nothing to undo
+	}
+	if err != nil {
+		return err
+	}
+
+	restored, exists, err := restoredContent(current, rel, mf)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return os.Remove(full)
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(full); err == nil {
+		mode = info.Mode().Perm()
+	}
+	return os.WriteFile(full, restored, mode)
+}
+
+// restoredContent returns what undoing the injection makes of a file holding
+// current, and whether the file is kept: one Shannon created is removed when
+// nothing else is left in it.
+func restoredContent(current []byte, rel string, mf *managedFile) ([]byte, bool, error) {
+	var restored []byte
+	switch {
+	case mf.Written != nil && bytes.Equal(current, mf.Written):
+		restored = mf.Original
+	case rel == managedClaudeMD:
+		restored = stripClaudeMDSection(current)
+	case rel == managedMCPJson:
+		var err error
+		if restored, err = unmergeMCPServers(current, mf); err != nil {
+			return nil, false, err
+		}
+	}
+	return restored, mf.Existed || len(bytes.TrimSpace(restored)) > 0, nil
+}
+
+// withClaudeMDSection appends the managed section to base.
+func withClaudeMDSection(base []byte, content string) []byte {
+	var sb strings.Builder
+	sb.Write(base)
+	if len(base) > 0 {
+		if !bytes.HasSuffix(base, []byte("\n")) {
+			sb.WriteString("\n")
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString(claudeMDBegin + "\n")
+	sb.WriteString(strings.TrimRight(content, "\n") + "\n")
+	sb.WriteString(claudeMDEnd + "\n")
+	return []byte(sb.String())
+}
+
+// stripClaudeMDSection removes the managed section (and the blank line added before it).
+func stripClaudeMDSection(data []byte) []byte {
+	s := string(data)
+	start := strings.Index(s, claudeMDBegin)
+	if start < 0 {
+		return data
+	}
+	end := strings.Index(s[start:], claudeMDEnd)
+	if end < 0 {
+		return data
+	}
+	end += start + len(claudeMDEnd)
+	if end < len(s) && s[end] == '\n' {
+		end++
+	}
+	before := s[:start]
+	if strings.HasSuffix(before, "\n\n") {
+		before = before[:len(before)-1]
+	}
+	return []byte(before + s[end:])
+}
+
+// unmergeMCPServers removes Shannon's servers from .mcp.json, putting back any
+// project entries they replaced.
+func unmergeMCPServers(current []byte, mf *managedFile) ([]byte, error) {
+	doc := map[string]json.RawMessage{}
+	if err := json.Unmarshal(current, &doc); err != nil {
+		return nil, fmt.Errorf("parse .mcp.json: %w", err)
+	}
+	servers := map[string]json.RawMessage{}
+	if raw, ok := doc["mcpServers"]; ok {
+		if err := json.Unmarshal(raw, &servers); err != nil {
+			return nil, fmt.Errorf("parse mcpServers: %w", err)
+		}
+	}
+	for _, key := range mf.ManagedKeys {
+		if orig, ok := mf.Replaced[key]; ok {
+			servers[key] = orig
+		} else {
+			delete(servers, key)
+		}
+	}
+	if len(servers) == 0 && len(doc) == 1 && !mf.Existed {
+		return nil, nil
+	}
+	data, err := json.Marshal(servers)
+	if err != nil {
+		return nil, err
+	}
+	doc["mcpServers"] = data
+	return json.MarshalIndent(doc, "", "  ")
+}
@@ -0,0 +1,195 @@
+package services
+
+import (
+	"agent-workflow/backend/models"
+	"agent-workflow/backend/store"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VaultRefPrefix marks an MCP server env value as a reference to a vault entry,
+// e.g. "vault:GITHUB_TOKEN". References are resolved only when the server is
+// injected into a workspace, so the database never holds the secret itself.
+const VaultRefPrefix = "vault:"
+
+// RedactedValue replaces literal secrets in exported MCP configs.
+const RedactedValue = "<redacted>"
+
+// SecretStore is the part of the secure vault that MCP env references use.
+type SecretStore interface {
+	Lookup(key string) (string, bool)
+	Put(key, value string) error
+}
+
+// VaultRef returns the vault key referenced by an env value, if it is a reference.
+func VaultRef(value string) (string, bool) {
+	if !strings.HasPrefix(value, VaultRefPrefix) {
+		return "", false
+	}
+	key := strings.TrimSpace(strings.TrimPrefix(value, VaultRefPrefix))
+	return key, key != ""
+}
+
+// ResolveVaultRefs returns env with every vault reference replaced by its value.
+// A reference to a missing key is an error rather than an empty variable.
+func ResolveVaultRefs(env map[string]string, secrets SecretStore) (map[string]string, error) {
+	resolved := make(map[string]string, len(env))
+	for name, value := range env {
+		key, ok := VaultRef(value)
+		if !ok {
+			resolved[name] = value
+			continue
+		}
+		if secrets == nil {
+			return nil, fmt.Errorf("%s references vault key %s but no vault is available", name, key)
+		}
+		secret, found := secrets.Lookup(key)
+		if !found {
+			return nil, fmt.Errorf("%s references vault key %s, which is not set", name, key)
+		}
+		resolved[name] = secret
+	}
+	return resolved, nil
+}
+
+// RedactMCPEnv returns a copy of env safe to show or export: vault references
+// are kept, literal values that look like secrets are replaced.
+func RedactMCPEnv(env map[string]string) map[string]string {
+	redacted := make(map[string]string, len(env))
+	for name, value := range env {
+		if _, ok := VaultRef(value); !ok && looksLikeSecret(name, value) {
+			value = RedactedValue
+		}
+		redacted[name] = value
+	}
+	return redacted
+}
+
+// secretNameHints are substrings of env var names that usually hold credentials.
+var secretNameHints = []string{"TOKEN", "SECRET", "PASSWORD", "PASSWD", "API_KEY", "APIKEY", "ACCESS_KEY", "PRIVATE_KEY", "CREDENTIAL", "AUTH", "_PAT", "_KEY"}
+
+// secretValuePattern matches well-known credential formats regardless of the var name.
+var secretValuePattern = regexp.MustCompile(`^(ghp_|gho_|ghu_|ghs_|ghr_|github_pat_|glpat-|sk-|xox[abpr]-|AKIA|AIza)`)
+
+// looksLikeSecret decides whether a literal env value should live in the vault.
+func looksLikeSecret(name, value string) bool {
+	if strings.TrimSpace(value) == "" || value == RedactedValue || strings.HasPrefix(value, "${") {
+		return false // empty, already redacted, or expanded by the Claude CLI itself
+	}
+	if secretValuePattern.MatchString(value) {
+		return true
+	}
+	upper := strings.ToUpper(name)
+	for _, hint := range secretNameHints {
+		if strings.Contains(upper, hint) || upper == strings.TrimPrefix(hint, "_") {
+			return true
+		}
+	}
+	return false
+}
+
+var nonVaultKeyChars = regexp.MustCompile(`[^A-Z0-9_]+`)
+
+// ExternalizeMCPSecrets moves literal secrets in srv.Env into the vault and
+// replaces them with vault references. It reports whether srv was changed.
+// An existing vault entry with the same value is reused; otherwise the entry is
+// named after the variable, prefixed with the server key on a clash.
+func ExternalizeMCPSecrets(srv *models.MCPServer, secrets SecretStore) (bool, error) {
+	return ExternalizeEnvSecrets(srv.Env, srv.ServerKey, secrets)
+}
+
+// ExternalizeEnvSecrets does the same for any env, such as an agent's; owner
+// prefixes the vault key on a clash.
+func ExternalizeEnvSecrets(env map[string]string, owner string, secrets SecretStore) (bool, error) {
+	if secrets == nil {
+		return false, nil
+	}
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	changed := false
+	for _, name := range names {
+		value := env[name]
+		if _, ok := VaultRef(value); ok || !looksLikeSecret(name, value) {
+			continue
+		}
+		key, err := storeSecret(owner, name, value, secrets)
+		if err != nil {
+			return changed, err
+		}
+		env[name] = VaultRefPrefix + key
+		changed = true
+	}
+	return changed, nil
+}
+
+func storeSecret(owner, name, value string, secrets SecretStore) (string, error) {
+	base := nonVaultKeyChars.ReplaceAllString(strings.ToUpper(name), "_")
+	candidates := []string{base}
+	if prefix := strings.Trim(nonVaultKeyChars.ReplaceAllString(strings.ToUpper(owner), "_"), "_"); prefix != "" {
+		base = prefix + "_" + base
+		candidates = append(candidates, base)
+	}
+	for i := 2; i < 100; i++ {
+		candidates = append(candidates, base+"_"+strconv.Itoa(i))
+	}
+	for _, key := range candidates {
+		existing, found := secrets.Lookup(key)
+		if found && existing != value {
+			continue
+		}
+		if !found {
+			if err := secrets.Put(key, value); err != nil {
+				return "", fmt.Errorf("store %s in vault: %w", name, err)
+			}
+		}
+		return key, nil
+	}
+	return "", fmt.Errorf("no free vault key for %s", name)
+}
+
+// MCPVaultKeys returns the vault keys the env of any of servers refers to.
+func MCPVaultKeys(servers []models.MCPServer) map[string]bool {
+	keys := make(map[string]bool)
+	for _, srv := range servers {
+		for _, value := range srv.Env {
+			if key, ok := VaultRef(value); ok {
+				keys[key] = true
+			}
+		}
+	}
+	return keys
+}
+
+// MigrateMCPSecrets externalizes literal secrets of every stored MCP server.
+// It is idempotent and returns the number of servers it rewrote.
+func MigrateMCPSecrets(servers *store.MCPServerStore, secrets SecretStore) (int, error) {
+	list, err := servers.List()
+	if err != nil {
+		return 0, fmt.Errorf("list MCP servers: %w", err)
+	}
+	migrated := 0
+	for i := range list {
+		srv := &list[i]
+		changed, err := ExternalizeMCPSecrets(srv, secrets)
+		if err != nil {
+			return migrated, fmt.Errorf("server %s: %w", srv.ServerKey, err)
+		}
+		if !changed {
+			continue
+		}
+		if err := servers.Update(srv); err != nil {
+			return migrated, fmt.Errorf("update server %s: %w", srv.ServerKey, err)
+		}
+		migrated++
+		log.Printf("[mcp] moved secrets of server %q into the vault", srv.ServerKey)
+	}
+	return migrated, nil
+}
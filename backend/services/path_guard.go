@@ -0,0 +1,384 @@
+package services
+
+import (
+	"agent-workflow/backend/models"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Rule names reported in models.PathViolation.
+const (
+	pathRuleProtected = "protected"
+	pathRuleReadOnly  = "read_only"
+)
+
+// PathGuard matches project-relative paths against an agent's ProtectedPaths and
+// ReadOnlyPaths. The Write()/Edit() deny patterns only stop Claude's file tools;
+// the guard is checked against the actual diff after each run so changes made
+// through Bash (sed, redirects, git checkout...) are caught too.
+//
+// Pattern syntax:
+//   - "dir/"          everything below dir
+//   - "*.go"          no slash: matched against every path segment (any depth)
+//   - "src/*.ts"      with slash: matched from the project root; a match on a
+//     directory covers everything below it
+//   - "**"            matches across directories, e.g. "docs/**/*.md"
+type PathGuard struct {
+	Protected []string
+	ReadOnly  []string
+}
+
+// NewPathGuard builds the guard for an agent. Returns nil if the agent has no guarded paths.
+func NewPathGuard(agent *models.Agent) *PathGuard {
+	if agent == nil || (len(agent.ProtectedPaths) == 0 && len(agent.ReadOnlyPaths) == 0) {
+		return nil
+	}
+	return &PathGuard{Protected: agent.ProtectedPaths, ReadOnly: agent.ReadOnlyPaths}
+}
+
+// Match returns the rule and pattern guarding relPath, if any.
+func (g *PathGuard) Match(relPath string) (rule string, pattern string, ok bool) {
+	if g == nil {
+		return "", "", false
+	}
+	for _, p := range g.Protected {
+		if matchPathPattern(p, relPath) {
+			return pathRuleProtected, p, true
+		}
+	}
+	for _, p := range g.ReadOnly {
+		if matchPathPattern(p, relPath) {
+			return pathRuleReadOnly, p, true
+		}
+	}
+	return "", "", false
+}
+
+// matchPathPattern reports whether a project-relative path is covered by a guard pattern.
+func matchPathPattern(pattern, relPath string) bool {
+	pattern = normalizeGuardPath(pattern)
+	relPath = normalizeGuardPath(relPath)
+	if pattern == "" || relPath == "" {
+		return false
+	}
+
+	// "dir/" — the directory and everything below it
+	if strings.HasSuffix(pattern, "/") {
+		dir := strings.TrimSuffix(pattern, "/")
+		return relPath == dir || strings.HasPrefix(relPath, dir+"/") || matchPathPattern(dir, relPath)
+	}
+
+	segments := strings.Split(relPath, "/")
+
+	// No slash: match any single segment (file name or a directory on the way)
+	if !strings.Contains(pattern, "/") && !strings.Contains(pattern, "**") {
+		for _, seg := range segments {
+			if ok, _ := path.Match(pattern, seg); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Rooted pattern: match the path or any of its parent directories
+	re := globRegexp(pattern)
+	for i := len(segments); i > 0; i-- {
+		if re.MatchString(strings.Join(segments[:i], "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeGuardPath(p string) string {
+	p = filepath.ToSlash(strings.TrimSpace(p))
+	for strings.HasPrefix(p, "./") {
+		p = p[2:]
+	}
+	return strings.TrimPrefix(p, "/")
+}
+
+// globRegexp converts a glob with "**" support into an anchored regexp.
+func globRegexp(pattern string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+// guardedFileState is the pre-run content of a guarded file that already had
+// uncommitted changes, so the user's own edits are never reverted.
+type guardedFileState struct {
+	exists  bool
+	content []byte
+}
+
+// guardBaseline records guarded files that were already dirty before a run.
+type guardBaseline map[string]guardedFileState
+
+// Snapshot captures the guarded files that differ from HEAD before a run starts.
+func (g *PathGuard) Snapshot(dt *DiffTracker, projectPath string) guardBaseline {
+	baseline := guardBaseline{}
+	if g == nil {
+		return baseline
+	}
+	diff, err := dt.ComputeDiff(projectPath)
+	if err != nil || diff == nil {
+		return baseline
+	}
+	for _, f := range diff.Files {
+		for _, p := range []string{f.Path, f.OldPath} {
+			if p == "" {
+				continue
+			}
+			if _, _, ok := g.Match(p); ok {
+				baseline[p] = readGuardedFile(projectPath, p)
+			}
+		}
+	}
+	return baseline
+}
+
+func readGuardedFile(projectPath, relPath string) guardedFileState {
+	data, err := os.ReadFile(filepath.Join(projectPath, relPath))
+	if err != nil {
+		return guardedFileState{}
+	}
+	return guardedFileState{exists: true, content: data}
+}
+
+// Enforce compares the working tree against the guard and reverts every guarded
+// path changed during the run: files that were dirty before the run go back to
+// their snapshot, everything else back to HEAD. Changes to paths owns rejects
+// were made by someone else and are left alone.
+func (g *PathGuard) Enforce(dt *DiffTracker, projectPath string, baseline guardBaseline, owns func(relPath string) bool) []models.PathViolation {
+	if g == nil {
+		return nil
+	}
+	diff, err := dt.ComputeDiff(projectPath)
+	if err != nil || diff == nil {
+		return nil
+	}
+
+	var violations []models.PathViolation
+	for _, f := range diff.Files {
+		// A rename touches two paths; it is reverted as a whole if either is guarded.
+		paths := []string{f.Path}
+		if f.OldPath != "" {
+			paths = append(paths, f.OldPath)
+		}
+
+		var matched []models.PathViolation
+		for _, p := range paths {
+			rule, pattern, ok := g.Match(p)
+			if !ok {
+				continue
+			}
+			if before, wasDirty := baseline[p]; wasDirty {
+				now := readGuardedFile(projectPath, p)
+				if now.exists == before.exists && bytes.Equal(now.content, before.content) {
+					continue // unchanged since before the run
+				}
+			}
+			matched = append(matched, models.PathViolation{Path: p, Change: f.Status, Rule: rule, Pattern: pattern})
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		owned := false
+		for _, p := range paths {
+			owned = owned || owns(p)
+		}
+		if !owned {
+			log.Printf("path guard: %s changed in %s while another task was running there; leaving it alone", f.Path, projectPath)
+			continue
+		}
+
+		var revertErr error
+		for _, p := range paths {
+			if before, wasDirty := baseline[p]; wasDirty {
+				revertErr = restoreGuardedFile(projectPath, p, before)
+			} else {
+				revertErr = restorePathFromHead(projectPath, p)
+			}
+			if revertErr != nil {
+				break
+			}
+		}
+		for i := range matched {
+			matched[i].Reverted = revertErr == nil
+			if revertErr != nil {
+				matched[i].Error = revertErr.Error()
+			}
+		}
+		violations = append(violations, matched...)
+	}
+	return violations
+}
+
+func restoreGuardedFile(projectPath, relPath string, state guardedFileState) error {
+	full := filepath.Join(projectPath, relPath)
+	if !state.exists {
+		if err := os.Remove(full); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(full, state.content, 0644)
+}
+
+// restorePathFromHead puts a path (and its index entry) back to its HEAD state,
+// deleting it if it doesn't exist in HEAD.
+func restorePathFromHead(projectPath, relPath string) error {
+	cmd := exec.Command("git", "cat-file", "-e", "HEAD:"+relPath)
+	cmd.Dir = projectPath
+	if cmd.Run() == nil {
+		cmd = exec.Command("git", "checkout", "HEAD", "--", relPath)
+		cmd.Dir = projectPath
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git checkout failed: %w (output: %s)", err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+
+	cmd = exec.Command("git", "rm", "--cached", "-q", "--ignore-unmatch", "--", relPath)
+	cmd.Dir = projectPath
+	cmd.Run() // ignore error — the file may not be staged
+	if err := os.Remove(filepath.Join(projectPath, relPath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// violationSummary formats violations as a one-line task error.
+func violationSummary(violations []models.PathViolation) string {
+	var parts []string
+	failed := false
+	for _, v := range violations {
+		parts = append(parts, fmt.Sprintf("%s (%s %s)", v.Path, strings.ReplaceAll(v.Rule, "_", "-"), v.Pattern))
+		if !v.Reverted {
+			failed = true
+		}
+	}
+	if failed {
+		return "Modified guarded paths, revert FAILED for some: " + strings.Join(parts, ", ")
+	}
+	return "Modified guarded paths (reverted): " + strings.Join(parts, ", ")
+}
+
+// guardRun is one run's use of a working directory: the guarded files that
+// were dirty when it started, and whether another run used the directory
+// meanwhile. While the directory is shared, only changes the run's own file
+// tools made are known to be its own.
+type guardRun struct {
+	guard    *PathGuard
+	workDir  string
+	baseline guardBaseline
+	shared   bool // guarded by TaskEngine.mu
+}
+
+// beginGuardRun snapshots the guarded files before a run starts in workDir
+// and registers the run until enforcePathGuard. Every run registers, guarded
+// or not, so guarded runs know when they share the directory.
+func (te *TaskEngine) beginGuardRun(guard *PathGuard, workDir string) *guardRun {
+	run := &guardRun{guard: guard, workDir: workDir, baseline: guard.Snapshot(te.diffTracker, workDir)}
+	te.mu.Lock()
+	defer te.mu.Unlock()
+	runs := te.guardRuns[workDir]
+	if runs == nil {
+		runs = make(map[*guardRun]bool)
+		te.guardRuns[workDir] = runs
+	}
+	for other := range runs {
+		other.shared = true
+		run.shared = true
+	}
+	runs[run] = true
+	return run
+}
+
+// enforcePathGuard runs the post-run verifier for a task, logs and emits the
+// violations, and returns them encoded for Task.PathViolations ("" if none).
+// result names the files the run's tools edited; it may be nil.
+func (te *TaskEngine) enforcePathGuard(taskID string, run *guardRun, result *RunResult) ([]models.PathViolation, string) {
+	te.mu.Lock()
+	shared := run.shared
+	delete(te.guardRuns[run.workDir], run)
+	if len(te.guardRuns[run.workDir]) == 0 {
+		delete(te.guardRuns, run.workDir)
+	}
+	te.mu.Unlock()
+
+	edited := make(map[string]bool)
+	if result != nil {
+		for _, p := range result.EditedPaths {
+			if filepath.IsAbs(p) {
+				rel, err := filepath.Rel(run.workDir, p)
+				if err != nil {
+					continue
+				}
+				p = rel
+			}
+			edited[normalizeGuardPath(p)] = true
+		}
+	}
+	owns := func(relPath string) bool {
+		return !shared || edited[normalizeGuardPath(relPath)]
+	}
+	violations := run.guard.Enforce(te.diffTracker, run.workDir, run.baseline, owns)
+	if len(violations) == 0 {
+		return nil, ""
+	}
+	for _, v := range violations {
+		log.Printf("task %s: %s path %s modified (%s, pattern %q), reverted=%v %s", taskID, v.Rule, v.Path, v.Change, v.Pattern, v.Reverted, v.Error)
+	}
+	if te.wailsCtx != nil {
+		emitEvent(te.wailsCtx, "task:violations", map[string]any{
+			"task_id":    taskID,
+			"violations": violations,
+		})
+	}
+	return violations, encodeViolations(violations)
+}
+
+// encodeViolations serializes violations for Task.PathViolations, "" if there are none.
+func encodeViolations(violations []models.PathViolation) string {
+	if len(violations) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(violations)
+	return string(data)
+}
+
+// violationFails reports whether violations should fail the task for this agent.
+func violationFails(agent *models.Agent, violations []models.PathViolation) bool {
+	return len(violations) > 0 && agent.ViolationAction != models.ViolationActionFlag
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"agent-workflow/backend/models"
+	"context"
+	"fmt"
+	"log"
+)
+
+// maxStageDiff caps the changes so far in a pipeline stage's prompt.
+const maxStageDiff = 30000
+
+// pipelineOrder returns a sequential team's agents in the order they run:
+// along its edges, with agents that are not ordered by an edge kept in the
+// order the team lists them.
+func pipelineOrder(team *models.Team) ([]string, error) {
+	var ids []string
+	index := make(map[string]int)
+	add := func(id string) {
+		if _, ok := index[id]; id != "" && !ok {
+			index[id] = len(ids)
+			ids = append(ids, id)
+		}
+	}
+	for _, id := range team.AgentIDs {
+		add(id)
+	}
+	for _, e := range team.Edges {
+		add(e.Source)
+		add(e.Target)
+	}
+
+	indegree := make([]int, len(ids))
+	next := make([][]int, len(ids))
+	for _, e := range team.Edges {
+		if e.Source == "" || e.Target == "" {
+			continue // a dangling edge orders nothing
+		}
+		s, t := index[e.Source], index[e.Target]
+		next[s] = append(next[s], t)
+		indegree[t]++
+	}
+
+	order := make([]string, 0, len(ids))
+	done := make([]bool, len(ids))
+	for len(order) < len(ids) {
+		// Take the first agent in list order whose predecessors have all run
+		pick := -1
+		for i := range ids {
+			if !done[i] && indegree[i] == 0 {
+				pick = i
+				break
+			}
+		}
+		if pick < 0 {
+			return nil, fmt.Errorf("team %q has a cycle in its edges", team.Name)
+		}
+		done[pick] = true
+		order = append(order, ids[pick])
+		for _, t := range next[pick] {
+			indegree[t]--
+		}
+	}
+	return order, nil
+}
+
+// teamPipeline returns the agents a task runs through, in order, when it is
+// assigned to a sequential team, and nil otherwise.
+func (te *TaskEngine) teamPipeline(task *models.Task) ([]string, error) {
+	if task.TeamID == "" {
+		return nil, nil
+	}
+	team, err := te.teams.GetByID(string(task.TeamID))
+	if err != nil {
+		return nil, fmt.Errorf("team not found: %w", err)
+	}
+	if team.Strategy != models.TeamStrategySequential {
+		return nil, nil
+	}
+	order, err := pipelineOrder(team)
+	if err != nil {
+		return nil, err
+	}
+	if len(order) == 0 {
+		return nil, fmt.Errorf("team %q has no agents", team.Name)
+	}
+	return order, nil
+}
+
+// runPipelineStages runs the agents of a sequential team's pipeline from
+// stages[from] on, one after another in workDir. Each stage starts a fresh
+// Claude session whose prompt carries the task, the previous stage's result
+// and the changes so far, and is recorded as its own attempt; the task is
+// handed to each stage's agent so follow-ups continue the latest session.
+// Each stage's guarded paths are enforced with its own agent's rules right
+// after it runs. It stops at the first stage that fails or needs input, noting
+// the latter in task.PipelineStage, and returns that stage's agent, attempt,
+// result and error with the violations of every stage it ran; the caller
+// finishes that attempt.
+func (te *TaskEngine) runPipelineStages(ctx context.Context, task *models.Task, project *models.Project, workDir string, stages []string, from int, agent *models.Agent, attempt *models.TaskAttempt, result *RunResult) (*models.Agent, *models.TaskAttempt, *RunResult, []models.PathViolation, error) {
+	var violations []models.PathViolation
+	for i := from; i < len(stages); i++ {
+		stage := i + 1
+
+		// Close the previous stage
+		te.recordAgentReply(task.ID, attempt, result, nil)
+		diffResult, _ := te.diffTracker.ComputeDiff(workDir)
+		te.finishAttempt(attempt, task, models.TaskStatusCompleted, result, nil, diffResult)
+		if err := ctx.Err(); err != nil {
+			return agent, attempt, nil, violations, err
+		}
+
+		next, err := te.agents.GetByID(stages[i])
+		if err != nil {
+			return agent, attempt, nil, violations, fmt.Errorf("pipeline stage %d: agent not found (id=%s): %w", stage, stages[i], err)
+		}
+		var previousResult string
+		if result != nil {
+			previousResult = result.LastText
+		}
+		prompt := stagePrompt(task.Prompt, stage, len(stages), agent.Name, previousResult, diffSnapshot(diffResult))
+		agent = next
+
+		// Swap in the injected .mcp.json of this stage's agent
+		te.managed.Release(task.ID)
+		task.MCPConfigPath = te.reinjectFiles(task, project, agent, workDir)
+		task.AgentID = models.OptionalRef(agent.ID)
+		task.ClaudeSessionID = "" // every stage starts its own session
+		te.tasks.Update(task)
+
+		agentForRun, builtinConfigs := te.runAgent(task, *agent)
+		if len(builtinConfigs) > 0 {
+			agentForRun.SystemPrompt += askUserInstruction
+		}
+		attempt = te.beginAttempt(task, &agentForRun, models.AttemptKindStage, prompt)
+		attempt.Stage = stage
+		te.recordMessage(task.ID, attempt, models.MessageRoleUser, "", prompt, false)
+
+		guardRun := te.beginGuardRun(NewPathGuard(agent), workDir)
+
+		log.Printf("task %s: starting pipeline stage %d/%d (agent=%s, model=%s)", task.ID, stage, len(stages), agent.Name, agent.Model)
+		te.emitStreamEvent(task.ID, "init", fmt.Sprintf("Pipeline stage %d/%d: %s", stage, len(stages), agent.Name))
+		var runErr error
+		te.beginApprovalRun(task.ID, agentForRun, workDir)
+		result, runErr = te.runner.RunTask(ctx, task, &agentForRun, workDir, RunTaskOptions{
+			Prompt:               prompt,
+			MCPConfigPath:        task.MCPConfigPath,
+			ExtraMCPConfigs:      builtinConfigs,
+			PermissionPromptTool: te.permissionPromptTool(builtinConfigs),
+			Sandbox:              te.sandboxProfile(agent, true),
+			Project:              project,
+			OnSessionID: func(sessionID string) {
+				log.Printf("task %s: captured claude session_id for stage %d: %s", task.ID, stage, sessionID)
+				task.ClaudeSessionID = sessionID
+				te.tasks.Update(task)
+			},
+		})
+
+		stageViolations, _ := te.enforcePathGuard(task.ID, guardRun, result)
+		violations = append(violations, stageViolations...)
+		if runErr == nil && violationFails(agent, stageViolations) {
+			runErr = fmt.Errorf("pipeline stage %d (%s): %s", stage, agent.Name, violationSummary(stageViolations))
+		}
+		if runErr != nil {
+			return agent, attempt, result, violations, runErr
+		}
+		if result != nil && result.NeedsInput {
+			task.PipelineStage = pausedStage(stage, len(stages))
+			return agent, attempt, result, violations, nil
+		}
+	}
+	return agent, attempt, result, violations, nil
+}
+
+// pausedStage is the PipelineStage of a task whose pipeline of total stages
+// is waiting for input in stage: 0 in the last stage, which has nothing to
+// run after it.
+func pausedStage(stage, total int) int {
+	if stage >= total {
+		return 0
+	}
+	return stage
+}
+
+// resumePipeline runs the rest of a sequential team's pipeline after a
+// follow-up answered the stage that was waiting for input. agent, attempt
+// and result are the follow-up's; the return values are as for
+// runPipelineStages.
+func (te *TaskEngine) resumePipeline(ctx context.Context, task *models.Task, project *models.Project, workDir string, agent *models.Agent, attempt *models.TaskAttempt, result *RunResult) (*models.Agent, *models.TaskAttempt, *RunResult, []models.PathViolation, error) {
+	from := task.PipelineStage
+	task.PipelineStage = 0
+	stages, err := te.teamPipeline(task)
+	if err != nil {
+		return agent, attempt, result, nil, fmt.Errorf("team pipeline failed: %w", err)
+	}
+	if from >= len(stages) || stages[from-1] != agent.ID {
+		log.Printf("task %s: team %s no longer runs agent %s as pipeline stage %d, not resuming the pipeline", task.ID, task.TeamID, agent.Name, from)
+		return agent, attempt, result, nil, nil
+	}
+	log.Printf("task %s: resuming the pipeline of team %s at stage %d/%d", task.ID, task.TeamID, from+1, len(stages))
+	return te.runPipelineStages(ctx, task, project, workDir, stages, from, agent, attempt, result)
+}
+
+// stagePrompt is the prompt of a pipeline stage after the first.
+func stagePrompt(taskPrompt string, stage, total int, previousAgent, previousResult, diff string) string {
+	if previousResult == "" {
+		previousResult = "(no result text)"
+	}
+	if diff == "" {
+		diff = "(no changes)\n"
+	}
+	return fmt.Sprintf("%s\n\n[PIPELINE STAGE %d/%d]\nThe previous stage (%s) has already worked on this task in the same workspace.\n\nIts result:\n<result>\n%s\n</result>\n\nChanges in the workspace so far:\n<diff>\n%s</diff>\n\nContinue from there: build on these changes rather than starting over.",
+		taskPrompt, stage, total, previousAgent, previousResult, truncate(diff, maxStageDiff))
+}
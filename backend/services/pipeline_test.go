@@ -0,0 +1,63 @@
+package services
+
+import (
+	"agent-workflow/backend/models"
+	"reflect"
+	"testing"
+)
+
+func TestPipelineOrder(t *testing.T) {
+	edge := func(source, target string) models.TeamEdge {
+		return models.TeamEdge{Source: source, Target: target}
+	}
+	tests := []struct {
+		name    string
+		agents  []string
+		edges   []models.TeamEdge
+		want    []string
+		wantErr bool
+	}{
+		{"no edges keeps list order", []string{"a", "b", "c"}, nil, []string{"a", "b", "c"}, false},
+		{"chain", []string{"a", "b", "c"}, []models.TeamEdge{edge("a", "b"), edge("b", "c")}, []string{"a", "b", "c"}, false},
+		{"edges override list order", []string{"a", "b", "c"}, []models.TeamEdge{edge("c", "a")}, []string{"b", "c", "a"}, false},
+		{"diamond", []string{"a", "b", "c", "d"}, []models.TeamEdge{edge("a", "c"), edge("a", "b"), edge("b", "d"), edge("c", "d")}, []string{"a", "b", "c", "d"}, false},
+		{"unlisted edge agents are added", []string{"a"}, []models.TeamEdge{edge("a", "x"), edge("y", "a")}, []string{"y", "a", "x"}, false},
+		{"duplicates run once", []string{"a", "b", "a"}, []models.TeamEdge{edge("a", "b"), edge("a", "b")}, []string{"a", "b"}, false},
+		{"empty IDs are ignored", []string{"", "a", "b"}, []models.TeamEdge{edge("", "a"), edge("b", "")}, []string{"a", "b"}, false},
+		{"no agents", nil, nil, []string{}, false},
+		{"cycle", []string{"a", "b", "c"}, []models.TeamEdge{edge("a", "b"), edge("b", "c"), edge("c", "a")}, nil, true},
+		{"self loop", []string{"a", "b"}, []models.TeamEdge{edge("b", "b")}, nil, true},
+		{"cycle among unlisted agents", []string{"a"}, []models.TeamEdge{edge("x", "y"), edge("y", "x")}, nil, true},
+	}
+	for _, tt := range tests {
+		team := &models.Team{Name: tt.name, AgentIDs: tt.agents, Edges: tt.edges}
+		got, err := pipelineOrder(team)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected a cycle error, got %v", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: order = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPausedStage(t *testing.T) {
+	tests := []struct{ stage, total, want int }{
+		{1, 3, 1},
+		{2, 3, 2},
+		{3, 3, 0}, // nothing runs after the last stage
+		{1, 1, 0},
+	}
+	for _, tt := range tests {
+		if got := pausedStage(tt.stage, tt.total); got != tt.want {
+			t.Errorf("pausedStage(%d, %d) = %d, want %d", tt.stage, tt.total, got, tt.want)
+		}
+	}
+}
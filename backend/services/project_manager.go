@@ -1,8 +1,10 @@
 package services
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 )
 
 // ProjectManager handles workspace setup for task isolation.
@@ -44,3 +46,36 @@ func (pm *ProjectManager) EnsureClaudeDir(projectPath string) error {
 	claudeDir := filepath.Join(projectPath, ".claude")
 	return os.MkdirAll(claudeDir, 0755)
 }
+
+// ComparisonWorkspace is where variant n of a comparison runs.
+func (pm *ProjectManager) ComparisonWorkspace(comparisonID string, n int) string {
+	return filepath.Join(pm.workspacesDir, "comparisons", comparisonID, strconv.Itoa(n))
+}
+
+// AddWorktree checks commit out into dir as a detached git worktree of the
+// project, so a variant can change files without touching the project.
+func (pm *ProjectManager) AddWorktree(projectPath, dir, commit string) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	if _, err := runGit(projectPath, nil, "worktree", "add", "--detach", dir, commit); err != nil {
+		return fmt.Errorf("create worktree: %w", err)
+	}
+	return nil
+}
+
+// RemoveWorktree deletes a worktree made by AddWorktree, including its
+// uncommitted changes.
+func (pm *ProjectManager) RemoveWorktree(projectPath, dir string) error {
+	if _, err := runGit(projectPath, nil, "worktree", "remove", "--force", dir); err != nil {
+		// Already gone from disk: drop git's record of it
+		if _, statErr := os.Stat(dir); os.IsNotExist(statErr) {
+			_, err = runGit(projectPath, nil, "worktree", "prune")
+		}
+		if err != nil {
+			return fmt.Errorf("remove worktree: %w", err)
+		}
+	}
+	os.Remove(filepath.Dir(dir)) // the comparison directory, once empty
+	return nil
+}
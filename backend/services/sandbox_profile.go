@@ -0,0 +1,71 @@
+package services
+
+import (
+	"agent-workflow/backend/models"
+	"agent-workflow/backend/sandbox"
+	"context"
+	"net/url"
+	"os"
+)
+
+// claudeWritablePaths are kept writable for the Claude CLI itself (session
+// transcripts for --resume, settings) when it runs in a sandbox.
+var claudeWritablePaths = []string{"~/.claude", "~/.claude.json"}
+
+// sandboxProfile returns the sandbox for an agent's processes, or nil when the
+// agent has none. forClaude adds what the Claude CLI needs on top of the
+// agent's profile: its config directory and the approval broker port.
+func (te *TaskEngine) sandboxProfile(agent *models.Agent, forClaude bool) *sandbox.Profile {
+	if agent == nil || !agent.Sandbox.Enabled {
+		return nil
+	}
+	p := &sandbox.Profile{
+		Network:      agent.Sandbox.Network,
+		AllowedHosts: append([]string(nil), agent.Sandbox.AllowedHosts...),
+		Writable:     append([]string(nil), agent.Sandbox.WritablePaths...),
+	}
+	if forClaude {
+		p.Writable = append(p.Writable, claudeWritablePaths...)
+		if te.approvals != nil {
+			if port := te.approvals.Port(); port > 0 {
+				p.ForwardPorts = append(p.ForwardPorts, port)
+			}
+		}
+	}
+	return p
+}
+
+// runSetupCommand runs a project setup command in the task's working directory,
+// under the agent's sandbox profile if it has one.
+func (te *TaskEngine) runSetupCommand(ctx context.Context, command string, task *models.Task, project *models.Project, agent *models.Agent) ([]byte, error) {
+	cmd, cleanup, err := sandbox.Command(ctx, te.sandboxProfile(agent, false), task.WorkspacePath, "sh", "-c", command)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	cmd.Env = append(os.Environ(),
+		"WORKSPACE_PATH="+task.WorkspacePath,
+		"PROJECT_PATH="+project.Path,
+		"TASK_ID="+task.ID,
+		"SESSION_ID="+task.SessionID,
+	)
+	return cmd.CombinedOutput()
+}
+
+// withModelAPIHost allows a custom ANTHROPIC_BASE_URL host through the sandbox
+// proxy, so agents configured for a gateway keep working in "api" mode.
+func withModelAPIHost(p *sandbox.Profile, env map[string]string) *sandbox.Profile {
+	if p == nil {
+		return nil
+	}
+	baseURL := env["ANTHROPIC_BASE_URL"]
+	if baseURL == "" {
+		baseURL = os.Getenv("ANTHROPIC_BASE_URL")
+	}
+	if u, err := url.Parse(baseURL); err == nil && u.Hostname() != "" {
+		cp := *p
+		cp.AllowedHosts = append(append([]string(nil), p.AllowedHosts...), u.Hostname())
+		return &cp
+	}
+	return p
+}
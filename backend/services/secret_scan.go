@@ -0,0 +1,383 @@
+package services
+
+import (
+	"agent-workflow/backend/models"
+	"agent-workflow/backend/redact"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Built-in secret rule names besides the token formats from the redact package.
+const (
+	SecretRulePrivateKey  = "private_key"
+	SecretRuleHighEntropy = "high_entropy"
+)
+
+// High-entropy detection: long tokens mixing upper case, lower case and digits
+// whose Shannon entropy is close to that of random base64. Hex-only tokens
+// (hashes, commit IDs) are only reported on lines that name a credential.
+const (
+	entropyMinLen       = 20
+	entropyThreshold    = 4.0
+	hexEntropyMinLen    = 32
+	hexEntropyThreshold = 3.0
+)
+
+var (
+	privateKeyRe   = regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----`)
+	entropyTokenRe = regexp.MustCompile(`[A-Za-z0-9+/_\-=]{20,}`)
+	hexTokenRe     = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+	credentialLine = regexp.MustCompile(`(?i)(secret|token|passw(or)?d|api[_\-]?key|private[_\-]?key|access[_\-]?key|credential|auth)`)
+)
+
+// secretScanSkipFiles are lock files full of integrity hashes.
+var secretScanSkipFiles = map[string]bool{
+	"go.sum": true, "package-lock.json": true, "yarn.lock": true, "pnpm-lock.yaml": true,
+	"Cargo.lock": true, "poetry.lock": true, "composer.lock": true, "Gemfile.lock": true,
+}
+
+type secretRule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// SecretScanner looks for credentials in the lines a diff adds.
+type SecretScanner struct {
+	rules []secretRule
+}
+
+// NewSecretScanner returns a scanner with the built-in rules plus custom ones.
+// An invalid custom pattern is an error.
+func NewSecretScanner(custom []models.SecretRule) (*SecretScanner, error) {
+	s := &SecretScanner{}
+	s.rules = append(s.rules, secretRule{name: SecretRulePrivateKey, re: privateKeyRe})
+	for _, tp := range redact.TokenPatterns() {
+		s.rules = append(s.rules, secretRule{name: tp.Name, re: tp.Pattern})
+	}
+	for _, r := range custom {
+		if strings.TrimSpace(r.Pattern) == "" {
+			continue
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("secret rule %q: %w", r.Name, err)
+		}
+		name := strings.TrimSpace(r.Name)
+		if name == "" {
+			name = "custom"
+		}
+		s.rules = append(s.rules, secretRule{name: name, re: re})
+	}
+	return s, nil
+}
+
+// ValidateSecretRules reports the first custom rule that does not compile.
+func ValidateSecretRules(rules []models.SecretRule) error {
+	_, err := NewSecretScanner(rules)
+	return err
+}
+
+// Scan returns the open findings in diff's added lines, one per rule, file and
+// matched text.
+func (s *SecretScanner) Scan(diff *DiffResult) []models.SecretFinding {
+	if diff == nil {
+		return nil
+	}
+	var findings []models.SecretFinding
+	seen := make(map[string]bool)
+	for _, f := range diff.Files {
+		if secretScanSkipFiles[path.Base(f.Path)] {
+			continue
+		}
+		for _, h := range f.Hunks {
+			line := h.NewStart
+			for _, l := range strings.Split(h.Content, "\n") {
+				switch {
+				case strings.HasPrefix(l, "+"):
+					for _, m := range s.scanLine(l[1:]) {
+						finding := newSecretFinding(f.Path, h.Index, line, m.rule, m.text)
+						if !seen[finding.ID] {
+							seen[finding.ID] = true
+							findings = append(findings, finding)
+						}
+					}
+					line++
+				case strings.HasPrefix(l, " "):
+					line++
+				}
+			}
+		}
+	}
+	return findings
+}
+
+type secretMatch struct {
+	rule string
+	text string
+}
+
+func (s *SecretScanner) scanLine(line string) []secretMatch {
+	var matches []secretMatch
+	covered := make(map[string]bool)
+	for _, r := range s.rules {
+		for _, m := range r.re.FindAllString(line, -1) {
+			matches = append(matches, secretMatch{rule: r.name, text: m})
+			covered[m] = true
+		}
+	}
+	for _, tok := range entropyTokenRe.FindAllString(line, -1) {
+		if !highEntropy(tok, line) {
+			continue
+		}
+		// Skip tokens a named rule already reported
+		dup := false
+		for m := range covered {
+			if strings.Contains(tok, m) || strings.Contains(m, tok) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			matches = append(matches, secretMatch{rule: SecretRuleHighEntropy, text: tok})
+		}
+	}
+	return matches
+}
+
+func highEntropy(tok, line string) bool {
+	tok = strings.TrimRight(tok, "=")
+	if hexTokenRe.MatchString(tok) {
+		return len(tok) >= hexEntropyMinLen && credentialLine.MatchString(line) && shannonEntropy(tok) >= hexEntropyThreshold
+	}
+	if len(tok) < entropyMinLen || !strings.ContainsAny(tok, "0123456789") ||
+		strings.ToLower(tok) == tok || strings.ToUpper(tok) == tok {
+		return false
+	}
+	return shannonEntropy(tok) >= entropyThreshold
+}
+
+// shannonEntropy returns the entropy of s in bits per character.
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	n := float64(len([]rune(s)))
+	var h float64
+	for _, c := range counts {
+		p := float64(c) / n
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+func newSecretFinding(filePath string, hunk, line int, rule, text string) models.SecretFinding {
+	sum := sha256.Sum256([]byte(text))
+	fingerprint := hex.EncodeToString(sum[:])
+	id := sha256.Sum256([]byte(rule + "\x00" + filePath + "\x00" + fingerprint))
+	return models.SecretFinding{
+		ID:          hex.EncodeToString(id[:8]),
+		Path:        filePath,
+		HunkIndex:   hunk,
+		Line:        line,
+		Rule:        rule,
+		Preview:     secretPreview(rule, text),
+		Fingerprint: fingerprint,
+		Status:      models.SecretFindingOpen,
+	}
+}
+
+// secretPreview keeps enough of the match to recognize it. Private key headers
+// are not secret themselves.
+func secretPreview(rule, text string) string {
+	if rule == SecretRulePrivateKey {
+		return text
+	}
+	const keep = 4
+	if len(text) <= 2*keep {
+		return strings.Repeat("*", len(text))
+	}
+	return text[:keep] + strings.Repeat("*", 8)
+}
+
+// MergeSecretFindings combines the findings of a new scan with earlier ones:
+// dismissed findings stay dismissed, and earlier findings missing from the new
+// scan are marked reverted.
+func MergeSecretFindings(previous, current []models.SecretFinding, now time.Time) []models.SecretFinding {
+	byID := make(map[string]models.SecretFinding, len(previous))
+	for _, f := range previous {
+		byID[f.ID] = f
+	}
+
+	merged := make([]models.SecretFinding, 0, len(current)+len(previous))
+	inScan := make(map[string]bool, len(current))
+	for _, f := range current {
+		inScan[f.ID] = true
+		if old, ok := byID[f.ID]; ok && old.Status == models.SecretFindingDismissed {
+			f.Status, f.Reason, f.ResolvedAt = old.Status, old.Reason, old.ResolvedAt
+		}
+		merged = append(merged, f)
+	}
+	for _, f := range previous {
+		if inScan[f.ID] {
+			continue
+		}
+		if f.Status == models.SecretFindingOpen {
+			f.Status = models.SecretFindingReverted
+			f.ResolvedAt = &now
+		}
+		f.HunkIndex = -1
+		merged = append(merged, f)
+	}
+	return merged
+}
+
+// DecodeSecretFindings parses Task.SecretFindings.
+func DecodeSecretFindings(data string) []models.SecretFinding {
+	if data == "" {
+		return nil
+	}
+	var findings []models.SecretFinding
+	if err := json.Unmarshal([]byte(data), &findings); err != nil {
+		return nil
+	}
+	return findings
+}
+
+func encodeSecretFindings(findings []models.SecretFinding) string {
+	if len(findings) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(findings)
+	return string(data)
+}
+
+// OpenSecretFindings filters the open findings in filePath ("" for all files)
+// and hunk (-1 for the whole file).
+func OpenSecretFindings(findings []models.SecretFinding, filePath string, hunk int) []models.SecretFinding {
+	var open []models.SecretFinding
+	for _, f := range findings {
+		if f.Status != models.SecretFindingOpen {
+			continue
+		}
+		if filePath != "" && f.Path != filePath {
+			continue
+		}
+		if hunk >= 0 && f.HunkIndex != hunk {
+			continue
+		}
+		open = append(open, f)
+	}
+	return open
+}
+
+// scanSecrets scans a task's diff with the project's rules, merges the result
+// with the task's earlier findings, and returns them encoded for
+// Task.SecretFindings ("" if none).
+func (te *TaskEngine) scanSecrets(taskID string, project *models.Project, previous string, diff *DiffResult) string {
+	if diff == nil {
+		return previous
+	}
+	var custom []models.SecretRule
+	if project != nil {
+		custom = project.SecretRules
+	}
+	scanner, err := NewSecretScanner(custom)
+	if err != nil {
+		log.Printf("task %s: %v, using built-in secret rules only", taskID, err)
+		scanner, _ = NewSecretScanner(nil)
+	}
+	findings := MergeSecretFindings(DecodeSecretFindings(previous), scanner.Scan(diff), time.Now())
+	open := OpenSecretFindings(findings, "", -1)
+	for _, f := range open {
+		log.Printf("task %s: possible secret (%s) in %s:%d", taskID, f.Rule, f.Path, f.Line)
+	}
+	if len(findings) > 0 && te.wailsCtx != nil {
+		emitEvent(te.wailsCtx, "task:secrets", map[string]any{
+			"task_id":  taskID,
+			"findings": findings,
+		})
+	}
+	return encodeSecretFindings(findings)
+}
+
+// RefreshSecretFindings rescans the task's current diff, so findings whose
+// lines were reverted are resolved and hunk indexes match the diff the user
+// sees, and stores the result.
+func (te *TaskEngine) RefreshSecretFindings(taskID string) ([]models.SecretFinding, error) {
+	task, err := te.tasks.GetByID(taskID)
+	if err != nil {
+		return nil, fmt.Errorf("task not found: %w", err)
+	}
+	if task.WorkspacePath == "" {
+		return DecodeSecretFindings(task.SecretFindings), nil
+	}
+	project, err := te.taskProject(task)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := te.diffTracker.ComputeDiff(task.WorkspacePath)
+	if err != nil {
+		return nil, fmt.Errorf("compute diff: %w", err)
+	}
+	data := te.scanSecrets(taskID, project, task.SecretFindings, diff)
+	if data != task.SecretFindings {
+		if err := te.tasks.UpdateField(taskID, "secret_findings", data); err != nil {
+			return nil, fmt.Errorf("store secret findings: %w", err)
+		}
+	}
+	return DecodeSecretFindings(data), nil
+}
+
+// DismissSecretFinding marks a finding as a false positive (or an accepted
+// risk) so it no longer blocks accepting the task's changes.
+func (te *TaskEngine) DismissSecretFinding(taskID, findingID, reason string) error {
+	findings, err := te.RefreshSecretFindings(taskID)
+	if err != nil {
+		return err
+	}
+	found := false
+	now := time.Now()
+	for i := range findings {
+		if findings[i].ID != findingID {
+			continue
+		}
+		found = true
+		if findings[i].Status == models.SecretFindingOpen {
+			findings[i].Status = models.SecretFindingDismissed
+			findings[i].Reason = reason
+			findings[i].ResolvedAt = &now
+		}
+	}
+	if !found {
+		return fmt.Errorf("secret finding %s not found", findingID)
+	}
+	return te.tasks.UpdateField(taskID, "secret_findings", encodeSecretFindings(findings))
+}
+
+// CheckSecretFindings returns an error while the task has open findings in
+// filePath ("" for all files) and hunk (-1 for the whole file). Accepting or
+// committing a task's changes goes through this check first.
+func (te *TaskEngine) CheckSecretFindings(taskID, filePath string, hunk int) error {
+	findings, err := te.RefreshSecretFindings(taskID)
+	if err != nil {
+		return err
+	}
+	open := OpenSecretFindings(findings, filePath, hunk)
+	if len(open) == 0 {
+		return nil
+	}
+	where := make([]string, 0, len(open))
+	for _, f := range open {
+		where = append(where, fmt.Sprintf("%s:%d (%s)", f.Path, f.Line, f.Rule))
+	}
+	return fmt.Errorf("%d possible secret(s) must be dismissed or reverted first: %s", len(open), strings.Join(where, ", "))
+}
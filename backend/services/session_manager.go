@@ -1,8 +1,11 @@
 package services
 
 import (
+	"agent-workflow/backend/models"
 	"agent-workflow/backend/store"
 	"fmt"
+
+	"github.com/google/uuid"
 )
 
 // SessionManager handles session lifecycle and change application.
@@ -56,3 +59,181 @@ func (sm *SessionManager) RejectTaskChanges(taskID string) error {
 
 	return nil
 }
+
+// CloneOptions adjusts a cloned session.
+type CloneOptions struct {
+	Name    string `json:"name,omitempty"`     // default: the source name with " (copy)"
+	AgentID string `json:"agent_id,omitempty"` // run every task with this agent instead of the original assignments
+}
+
+// CloneSession copies a session's task DAG into a new planning session:
+// prompts, agent and team assignments, env, retry limits and review options,
+// with dependencies remapped to the new task IDs. Results are not copied. A
+// clone of a fork starts from the same snapshot.
+func (sm *SessionManager) CloneSession(sessionID string, opts CloneOptions) (*models.Session, error) {
+	src, err := sm.sessions.GetByID(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("session not found: %w", err)
+	}
+	tasks, err := sm.tasks.ListBySession(sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("list tasks: %w", err)
+	}
+
+	ids := make(map[string]string, len(tasks))
+	for _, t := range tasks {
+		ids[t.ID] = uuid.New().String()
+	}
+	clones := make([]models.Task, 0, len(tasks))
+	for _, t := range tasks {
+		prompt := t.Prompt
+		if t.OriginalPrompt != "" {
+			prompt = t.OriginalPrompt // Prompt may carry retry context
+		}
+		deps := make(models.StringSlice, 0, len(t.Dependencies))
+		for _, dep := range t.Dependencies {
+			if id, ok := ids[dep]; ok {
+				deps = append(deps, id)
+			}
+		}
+		clone := models.Task{
+			ID:                 ids[t.ID],
+			Title:              t.Title,
+			Prompt:             prompt,
+			AgentID:            t.AgentID,
+			TeamID:             t.TeamID,
+			Dependencies:       deps,
+			Env:                t.Env,
+			MaxRetries:         t.MaxRetries,
+			AcceptanceCriteria: t.AcceptanceCriteria,
+			ReviewerAgentID:    t.ReviewerAgentID,
+			MaxReviewRounds:    t.MaxReviewRounds,
+		}
+		if opts.AgentID != "" {
+			clone.AgentID, clone.TeamID = models.OptionalRef(opts.AgentID), ""
+		}
+		clones = append(clones, clone)
+	}
+
+	name := opts.Name
+	if name == "" {
+		name = src.Name + " (copy)"
+	}
+	sess := &models.Session{
+		ID:               uuid.New().String(),
+		ProjectID:        src.ProjectID,
+		Name:             name,
+		SourceSessionID:  src.ID,
+		ForkedFromTaskID: src.ForkedFromTaskID,
+		BaseRef:          src.BaseRef,
+		CodeReviewerID:   src.CodeReviewerID,
+		CodeReviewRounds: src.CodeReviewRounds,
+	}
+	if err := sm.createWithBase(sess, clones); err != nil {
+		return nil, fmt.Errorf("clone session: %w", err)
+	}
+	return sess, nil
+}
+
+// ForkFromTask creates an empty planning session that starts from the
+// project state right after taskID completed: its snapshot is restored into
+// the project when the new session first runs.
+func (sm *SessionManager) ForkFromTask(taskID, name string) (*models.Session, error) {
+	task, err := sm.tasks.GetByID(taskID)
+	if err != nil {
+		return nil, fmt.Errorf("task not found: %w", err)
+	}
+	if task.SnapshotCommit == "" {
+		return nil, fmt.Errorf("task %q has no snapshot: only tasks that completed in a git project have one", task.Title)
+	}
+	src, err := sm.sessions.GetByID(task.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("session not found: %w", err)
+	}
+	if name == "" {
+		name = fmt.Sprintf("%s (fork after %s)", src.Name, task.Title)
+	}
+	sess := &models.Session{
+		ID:               uuid.New().String(),
+		ProjectID:        src.ProjectID,
+		Name:             name,
+		SourceSessionID:  src.ID,
+		ForkedFromTaskID: task.ID,
+		BaseRef:          task.SnapshotCommit,
+	}
+	if err := sm.createWithBase(sess, nil); err != nil {
+		return nil, fmt.Errorf("fork session: %w", err)
+	}
+	return sess, nil
+}
+
+// createWithBase creates sess and its tasks. A session with a BaseRef gets its
+// own ref to it first, so deleting the task the snapshot came from doesn't
+// lose the commit.
+func (sm *SessionManager) createWithBase(sess *models.Session, tasks []models.Task) error {
+	if sess.BaseRef == "" {
+		return sm.sessions.CreateWithTasks(sess, tasks)
+	}
+	project, err := sm.projects.GetByID(sess.ProjectID)
+	if err != nil {
+		return fmt.Errorf("project not found: %w", err)
+	}
+	if _, err := runGit(project.Path, nil, "update-ref", SnapshotRef(baseSnapshotName(sess.ID)), sess.BaseRef); err != nil {
+		return fmt.Errorf("keep base snapshot: %w", err)
+	}
+	if err := sm.sessions.CreateWithTasks(sess, tasks); err != nil {
+		DeleteSnapshots(project.Path, baseSnapshotName(sess.ID))
+		return err
+	}
+	return nil
+}
+
+// DeleteTaskSnapshot deletes the ref keeping a task's snapshot. Call it
+// before deleting the task.
+func (sm *SessionManager) DeleteTaskSnapshot(taskID string) error {
+	task, err := sm.tasks.GetByID(taskID)
+	if err != nil {
+		return fmt.Errorf("task not found: %w", err)
+	}
+	if task.SnapshotCommit == "" {
+		return nil
+	}
+	projectPath, err := sm.sessionProjectPath(task.SessionID)
+	if err != nil {
+		return err
+	}
+	return DeleteSnapshots(projectPath, task.ID)
+}
+
+// DeleteSessionSnapshots deletes the refs of a session's snapshots: its base,
+// the working trees it replaced and its tasks' snapshots. Call it before
+// deleting the session.
+func (sm *SessionManager) DeleteSessionSnapshots(sessionID string) error {
+	projectPath, err := sm.sessionProjectPath(sessionID)
+	if err != nil {
+		return err
+	}
+	tasks, err := sm.tasks.ListBySession(sessionID)
+	if err != nil {
+		return fmt.Errorf("list tasks: %w", err)
+	}
+	var taskIDs []string
+	for _, t := range tasks {
+		if t.SnapshotCommit != "" {
+			taskIDs = append(taskIDs, t.ID)
+		}
+	}
+	return DeleteSnapshots(projectPath, sessionSnapshotNames(sessionID, taskIDs)...)
+}
+
+func (sm *SessionManager) sessionProjectPath(sessionID string) (string, error) {
+	sess, err := sm.sessions.GetByID(sessionID)
+	if err != nil {
+		return "", fmt.Errorf("session not found: %w", err)
+	}
+	project, err := sm.projects.GetByID(sess.ProjectID)
+	if err != nil {
+		return "", fmt.Errorf("project not found: %w", err)
+	}
+	return project.Path, nil
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Snapshots record the working tree after a task as a git commit, so a later
+// session can start from exactly that state. Agents leave their changes
+// uncommitted, so the commit is built from a temporary index: HEAD, the real
+// index and the branch are never touched. Commits are kept alive by refs
+// outside refs/heads, which branch listings and pushes ignore.
+const snapshotRefPrefix = "refs/shannon/snapshots/"
+
+// snapshotIdentity authors snapshot commits, so they work in repositories
+// without a configured user.
+var snapshotIdentity = []string{
+	"GIT_AUTHOR_NAME=Shannon", "GIT_AUTHOR_EMAIL=shannon@localhost",
+	"GIT_COMMITTER_NAME=Shannon", "GIT_COMMITTER_EMAIL=shannon@localhost",
+}
+
+// SnapshotRef is the ref that keeps the snapshot named name.
+func SnapshotRef(name string) string {
+	return snapshotRefPrefix + name
+}
+
+// runGit runs git in dir with extra environment variables and returns its
+// trimmed stdout.
+func runGit(dir string, env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed: %w (output: %s)", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// withTempIndex calls fn with the environment for a throwaway index file.
+func withTempIndex(fn func(env []string) error) error {
+	f, err := os.CreateTemp("", "shannon-index-*")
+	if err != nil {
+		return err
+	}
+	path := f.Name()
+	f.Close()
+	// git refuses an empty file as an index; it creates the file itself
+	os.Remove(path)
+	defer os.Remove(path)
+	return fn([]string{"GIT_INDEX_FILE=" + path})
+}
+
+// TakeSnapshot commits the current working tree of projectPath, including
+// untracked files that are not ignored, and points SnapshotRef(name) at it.
+// Paths in exclude (relative, e.g. files holding injected content) keep their
+// HEAD version. It returns the commit hash, or "" if projectPath is not a git
+// repository.
+func TakeSnapshot(projectPath, name, message string, exclude ...string) (string, error) {
+	if !hasGit(projectPath) {
+		return "", nil
+	}
+	head, headErr := runGit(projectPath, nil, "rev-parse", "--verify", "-q", "HEAD")
+
+	var commit string
+	err := withTempIndex(func(env []string) error {
+		if headErr == nil {
+			if _, err := runGit(projectPath, env, "read-tree", head); err != nil {
+				return err
+			}
+		}
+		add := []string{"add", "-A", "--", "."}
+		for _, p := range exclude {
+			add = append(add, ":(exclude,literal)"+filepath.ToSlash(p))
+		}
+		if _, err := runGit(projectPath, env, add...); err != nil {
+			return err
+		}
+		tree, err := runGit(projectPath, env, "write-tree")
+		if err != nil {
+			return err
+		}
+		args := []string{"commit-tree", tree, "-m", message}
+		if headErr == nil {
+			args = append(args, "-p", head)
+		}
+		commit, err = runGit(projectPath, append(env, snapshotIdentity...), args...)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("snapshot %s: %w", name, err)
+	}
+	if _, err := runGit(projectPath, nil, "update-ref", SnapshotRef(name), commit); err != nil {
+		return "", fmt.Errorf("snapshot %s: %w", name, err)
+	}
+	return commit, nil
+}
+
+// RestoreSnapshot makes the working tree of projectPath match commit: files
+// are written from the snapshot and files it does not have are deleted.
+// Ignored files, HEAD and the index are left alone, so the restored state
+// shows up as uncommitted changes like an agent's own edits. The state being
+// replaced is saved first; its commit is returned.
+func RestoreSnapshot(projectPath, commit, backupName string) (string, error) {
+	if !hasGit(projectPath) {
+		return "", fmt.Errorf("%s is not a git repository", projectPath)
+	}
+	if _, err := runGit(projectPath, nil, "cat-file", "-e", commit+"^{commit}"); err != nil {
+		return "", fmt.Errorf("snapshot %s not found: %w", commit, err)
+	}
+	backup, err := TakeSnapshot(projectPath, backupName, "Working tree before restoring "+commit)
+	if err != nil {
+		return "", err
+	}
+
+	current, err := runGit(projectPath, nil, "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	if err != nil {
+		return backup, err
+	}
+	wanted, err := runGit(projectPath, nil, "ls-tree", "-r", "-z", "--name-only", commit)
+	if err != nil {
+		return backup, err
+	}
+	keep := make(map[string]bool)
+	for _, p := range strings.Split(wanted, "\x00") {
+		keep[p] = true
+	}
+	for _, p := range strings.Split(current, "\x00") {
+		if p == "" || keep[p] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(projectPath, p)); err != nil && !os.IsNotExist(err) {
+			return backup, fmt.Errorf("remove %s: %w", p, err)
+		}
+		removeEmptyParents(projectPath, filepath.Dir(filepath.Join(projectPath, p)))
+	}
+
+	err = withTempIndex(func(env []string) error {
+		if _, err := runGit(projectPath, env, "read-tree", commit); err != nil {
+			return err
+		}
+		_, err := runGit(projectPath, env, "checkout-index", "-a", "-f")
+		return err
+	})
+	if err != nil {
+		return backup, fmt.Errorf("restore %s: %w", commit, err)
+	}
+	return backup, nil
+}
+
+// DeleteSnapshots deletes the refs of the snapshots matching names, which
+// may be for-each-ref patterns such as "pre-restore/<id>-*". Commits only
+// they kept are left to git's garbage collection.
+func DeleteSnapshots(projectPath string, names ...string) error {
+	if len(names) == 0 || !hasGit(projectPath) {
+		return nil
+	}
+	patterns := make([]string, 0, len(names))
+	for _, name := range names {
+		patterns = append(patterns, SnapshotRef(name))
+	}
+	refs, err := runGit(projectPath, nil, append([]string{"for-each-ref", "--format=%(refname)"}, patterns...)...)
+	if err != nil {
+		return err
+	}
+	for _, ref := range strings.Fields(refs) {
+		if _, err := runGit(projectPath, nil, "update-ref", "-d", ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// workingTreeDirty reports whether projectPath has uncommitted changes,
+// including untracked files that are not ignored.
+func workingTreeDirty(projectPath string) (bool, error) {
+	out, err := runGit(projectPath, nil, "status", "--porcelain")
+	if err != nil {
+		return false, err
+	}
+	return out != "", nil
+}
+
+// removeEmptyParents deletes dir and its parents up to root while they are empty.
+func removeEmptyParents(root, dir string) {
+	for dir != root && strings.HasPrefix(dir, root) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// preRestoreSnapshotName names the snapshot of the working tree a session
+// replaced when it restored its base.
+func preRestoreSnapshotName(sessionID string) string {
+	return fmt.Sprintf("pre-restore/%s-%s", sessionID, time.Now().Format("20060102-150405"))
+}
+
+// baseSnapshotName names the ref keeping a session's BaseRef, so the commit
+// survives the task it was taken from being deleted.
+func baseSnapshotName(sessionID string) string {
+	return "base/" + sessionID
+}
+
+// sessionSnapshotNames returns the snapshot names owned by a session: its
+// base, the working trees it replaced and its tasks' snapshots.
+func sessionSnapshotNames(sessionID string, taskIDs []string) []string {
+	names := []string{baseSnapshotName(sessionID), "pre-restore/" + sessionID + "-*"}
+	return append(names, taskIDs...)
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"agent-workflow/backend/models"
+	"log"
+	"strings"
+	"time"
+)
+
+// AttemptComparison summarises the differences between two attempts of a task.
+type AttemptComparison struct {
+	A *models.TaskAttempt `json:"a"`
+	B *models.TaskAttempt `json:"b"`
+
+	FilesOnlyInA []string `json:"files_only_in_a"`
+	FilesOnlyInB []string `json:"files_only_in_b"`
+	FilesInBoth  []string `json:"files_in_both"`
+
+	DurationDeltaMS    int64   `json:"duration_delta_ms"`   // B - A
+	InputTokensDelta   int     `json:"input_tokens_delta"`  // B - A
+	OutputTokensDelta  int     `json:"output_tokens_delta"` // B - A
+	CostDeltaUSD       float64 `json:"cost_delta_usd"`      // B - A
+	TestOutcomeChange  string  `json:"test_outcome_change"` // e.g. "failed -> passed"
+	BuildOutcomeChange string  `json:"build_outcome_change"`
+}
+
+// CompareAttempts builds an AttemptComparison of b relative to a.
+func CompareAttempts(a, b *models.TaskAttempt) *AttemptComparison {
+	cmp := &AttemptComparison{
+		A:                  a,
+		B:                  b,
+		FilesOnlyInA:       []string{},
+		FilesOnlyInB:       []string{},
+		FilesInBoth:        []string{},
+		DurationDeltaMS:    b.DurationMS - a.DurationMS,
+		InputTokensDelta:   b.InputTokens - a.InputTokens,
+		OutputTokensDelta:  b.OutputTokens - a.OutputTokens,
+		CostDeltaUSD:       b.CostUSD - a.CostUSD,
+		TestOutcomeChange:  outcomeChange(a.TestPassed, b.TestPassed),
+		BuildOutcomeChange: outcomeChange(a.BuildPassed, b.BuildPassed),
+	}
+
+	inB := make(map[string]bool, len(b.FilesChanged))
+	for _, f := range b.FilesChanged {
+		inB[f] = true
+	}
+	inA := make(map[string]bool, len(a.FilesChanged))
+	for _, f := range a.FilesChanged {
+		inA[f] = true
+		if inB[f] {
+			cmp.FilesInBoth = append(cmp.FilesInBoth, f)
+		} else {
+			cmp.FilesOnlyInA = append(cmp.FilesOnlyInA, f)
+		}
+	}
+	for _, f := range b.FilesChanged {
+		if !inA[f] {
+			cmp.FilesOnlyInB = append(cmp.FilesOnlyInB, f)
+		}
+	}
+	return cmp
+}
+
+func outcomeChange(a, b *bool) string {
+	la, lb := outcomeLabel(a), outcomeLabel(b)
+	if la == lb {
+		return la
+	}
+	return la + " -> " + lb
+}
+
+// outcomeLabel describes a test or build result.
+func outcomeLabel(v *bool) string {
+	switch {
+	case v == nil:
+		return "not run"
+	case *v:
+		return "passed"
+	default:
+		return "failed"
+	}
+}
+
+// diffSnapshot flattens a DiffResult into a single unified diff string
+// suitable for storing alongside an attempt.
+func diffSnapshot(result *DiffResult) string {
+	if result == nil {
+		return ""
+	}
+	var sb strings.Builder
+	for _, f := range result.Files {
+		if f.Diff == "" {
+			// Deleted files have no diff body; record the path so the snapshot is complete.
+			sb.WriteString("--- a/" + f.Path + "\n+++ /dev/null\n")
+			continue
+		}
+		sb.WriteString(f.Diff)
+		if !strings.HasSuffix(f.Diff, "\n") {
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
+
+// beginAttempt records the start of a Claude run for a task.
+// Errors are logged rather than returned so history tracking never blocks execution.
+func (te *TaskEngine) beginAttempt(task *models.Task, agent *models.Agent, kind models.AttemptKind, prompt string) *models.TaskAttempt {
+	attempt := &models.TaskAttempt{
+		TaskID:          task.ID,
+		Kind:            kind,
+		Prompt:          prompt,
+		AgentID:         models.OptionalRef(agent.ID),
+		AgentName:       agent.Name,
+		Model:           agent.Model,
+		ClaudeSessionID: task.ClaudeSessionID,
+		Status:          models.TaskStatusRunning,
+		StartedAt:       time.Now(),
+	}
+	if err := te.attempts.Create(attempt); err != nil {
+		log.Printf("task %s: failed to record attempt: %v", task.ID, err)
+	}
+	return attempt
+}
+
+// finishAttempt fills in the outcome of a run and persists it.
+// task must already carry the test/build results and result text of this run.
+func (te *TaskEngine) finishAttempt(attempt *models.TaskAttempt, task *models.Task, status models.TaskStatus, runResult *RunResult, runErr error, diffResult *DiffResult) {
+	if attempt == nil {
+		return
+	}
+	now := time.Now()
+	attempt.CompletedAt = &now
+	attempt.DurationMS = now.Sub(attempt.StartedAt).Milliseconds()
+	attempt.Status = status
+	attempt.ClaudeSessionID = task.ClaudeSessionID
+	attempt.TestPassed = task.TestPassed
+	attempt.TestOutput = task.TestOutput
+	attempt.BuildPassed = task.BuildPassed
+	attempt.BuildOutput = task.BuildOutput
+
+	if runErr != nil {
+		attempt.Error = runErr.Error()
+	} else if status == models.TaskStatusFailed {
+		attempt.Error = task.Error
+	}
+
+	if runResult != nil {
+		attempt.ExitCode = runResult.ExitCode
+		attempt.ResultText = runResult.LastText
+		attempt.InputTokens = runResult.Usage.InputTokens
+		attempt.OutputTokens = runResult.Usage.OutputTokens
+		attempt.CacheReadTokens = runResult.Usage.CacheReadInputTokens
+		attempt.CacheCreationTokens = runResult.Usage.CacheCreationInputTokens
+		attempt.CostUSD = runResult.CostUSD
+		attempt.NumTurns = runResult.NumTurns
+	}
+
+	if diffResult != nil {
+		files := make([]string, 0, len(diffResult.Files))
+		for _, f := range diffResult.Files {
+			files = append(files, f.Path)
+		}
+		attempt.FilesChanged = models.StringSlice(files)
+		attempt.DiffSnapshot = diffSnapshot(diffResult)
+	}
+
+	if err := te.attempts.Update(attempt); err != nil {
+		log.Printf("task %s: failed to update attempt %d: %v", attempt.TaskID, attempt.Number, err)
+	}
+}
@@ -2,14 +2,12 @@ package services
 
 import (
 	"agent-workflow/backend/models"
+	"agent-workflow/backend/redact"
 	"agent-workflow/backend/store"
 	"context"
 	"encoding/json"
 	"fmt"
 	"log"
-	"os"
-	"os/exec"
-	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -19,23 +17,34 @@ import (
 
 // TaskEngine orchestrates task execution with dependency resolution and parallel dispatch.
 type TaskEngine struct {
-	tasks      *store.TaskStore
-	sessions   *store.SessionStore
-	agents     *store.AgentStore
-	projects   *store.ProjectStore
-	mcpServers *store.MCPServerStore
-	teams      *store.TeamStore
-	projectMgr *ProjectManager
-	runner     *AgentRunner
-	diffTracker *DiffTracker
-	testRunner *TestRunner
-
-	cancelFuncs    map[string]context.CancelFunc // sessionID -> cancel
-	sessionCtxs    map[string]context.Context    // sessionID -> context (for follow-ups)
-	teamRoundRobin map[string]int                // teamID -> last assigned index
-	taskInFlight   map[string]*sync.Mutex        // per-task mutex for follow-up serialization
-	mu             sync.Mutex
-	wailsCtx       context.Context
+	tasks          *store.TaskStore
+	attempts       *store.TaskAttemptStore
+	messages       *store.TaskMessageStore
+	sessions       *store.SessionStore
+	agents         *store.AgentStore
+	projects       *store.ProjectStore
+	mcpServers     *store.MCPServerStore
+	teams          *store.TeamStore
+	projectMgr     *ProjectManager
+	runner         *AgentRunner
+	diffTracker    *DiffTracker
+	testRunner     *TestRunner
+	builtinMCP     *BuiltinMCP                // optional: Shannon's own MCP server (ask_user)
+	approvals      *ApprovalBroker            // optional: answers permission checks from the built-in server
+	managed        *ManagedFiles              // injects CLAUDE.md / .mcp.json content and restores the originals
+	secrets        SecretStore                // resolves "vault:KEY" references in MCP server env
+	auditLog       *store.AuditStore          // optional: records setup commands
+	comparisons    *store.TaskComparisonStore // optional: enables A/B runs
+	reviewComments *store.ReviewCommentStore  // optional: enables code review
+
+	cancelFuncs       map[string]context.CancelFunc // sessionID -> cancel
+	sessionCtxs       map[string]context.Context    // sessionID -> context (for follow-ups)
+	teamRoundRobin    map[string]int                // teamID -> last assigned index
+	taskInFlight      map[string]*sync.Mutex        // per-task mutex for follow-up serialization
+	comparisonCancels map[string]context.CancelFunc // comparisonID -> cancel, while running
+	guardRuns         map[string]map[*guardRun]bool // workDir -> runs in progress there
+	mu                sync.Mutex
+	wailsCtx          context.Context
 
 	// taskDone is signalled whenever a task finishes execution (completed/failed).
 	// The session loop selects on this instead of polling with time.Sleep.
@@ -44,6 +53,8 @@ type TaskEngine struct {
 
 func NewTaskEngine(
 	tasks *store.TaskStore,
+	attempts *store.TaskAttemptStore,
+	messages *store.TaskMessageStore,
 	sessions *store.SessionStore,
 	agents *store.AgentStore,
 	projects *store.ProjectStore,
@@ -55,21 +66,26 @@ func NewTaskEngine(
 	testRunner *TestRunner,
 ) *TaskEngine {
 	return &TaskEngine{
-		tasks:          tasks,
-		sessions:       sessions,
-		agents:         agents,
-		projects:       projects,
-		mcpServers:     mcpServers,
-		teams:          teams,
-		projectMgr:     projectMgr,
-		runner:         runner,
-		diffTracker:    diffTracker,
-		testRunner:     testRunner,
-		cancelFuncs:    make(map[string]context.CancelFunc),
-		sessionCtxs:    make(map[string]context.Context),
-		teamRoundRobin: make(map[string]int),
-		taskInFlight:   make(map[string]*sync.Mutex),
-		taskDone:       make(chan string, 64),
+		tasks:             tasks,
+		attempts:          attempts,
+		messages:          messages,
+		sessions:          sessions,
+		agents:            agents,
+		projects:          projects,
+		mcpServers:        mcpServers,
+		teams:             teams,
+		projectMgr:        projectMgr,
+		runner:            runner,
+		diffTracker:       diffTracker,
+		testRunner:        testRunner,
+		managed:           NewManagedFiles("", diffTracker),
+		cancelFuncs:       make(map[string]context.CancelFunc),
+		sessionCtxs:       make(map[string]context.Context),
+		teamRoundRobin:    make(map[string]int),
+		taskInFlight:      make(map[string]*sync.Mutex),
+		comparisonCancels: make(map[string]context.CancelFunc),
+		guardRuns:         make(map[string]map[*guardRun]bool),
+		taskDone:          make(chan string, 64),
 	}
 }
 
@@ -78,6 +94,53 @@ func (te *TaskEngine) SetWailsContext(ctx context.Context) {
 	te.wailsCtx = ctx
 }
 
+// SetManagedFiles replaces the injector used for CLAUDE.md and .mcp.json, e.g.
+// with one that keeps crash-recovery manifests on disk.
+func (te *TaskEngine) SetManagedFiles(m *ManagedFiles) {
+	te.managed = m
+}
+
+// SetSecretStore sets the vault that MCP server env references are resolved against.
+func (te *TaskEngine) SetSecretStore(s SecretStore) {
+	te.secrets = s
+}
+
+// SetAuditLog sets the audit log that setup commands are recorded in.
+func (te *TaskEngine) SetAuditLog(a *store.AuditStore) {
+	te.auditLog = a
+}
+
+// SetComparisonStore enables A/B comparisons of tasks.
+func (te *TaskEngine) SetComparisonStore(s *store.TaskComparisonStore) {
+	te.comparisons = s
+}
+
+// SetReviewCommentStore enables code review of task changes.
+func (te *TaskEngine) SetReviewCommentStore(s *store.ReviewCommentStore) {
+	te.reviewComments = s
+}
+
+// snapshotTask records the working tree of a completed task in its
+// SnapshotCommit so sessions can be forked from it. Files other runs still
+// inject content into are left out.
+func (te *TaskEngine) snapshotTask(taskID, workDir string) {
+	task, err := te.tasks.GetByID(taskID)
+	if err != nil || task.Status != models.TaskStatusCompleted {
+		return
+	}
+	commit, err := TakeSnapshot(workDir, taskID, "Snapshot after task: "+task.Title, te.managed.Injected(workDir)...)
+	if err != nil {
+		log.Printf("task %s: failed to snapshot working tree: %v", taskID, err)
+		return
+	}
+	if commit == "" {
+		return
+	}
+	if err := te.tasks.UpdateField(taskID, "snapshot_commit", commit); err != nil {
+		log.Printf("task %s: failed to save snapshot %s: %v", taskID, commit, err)
+	}
+}
+
 // taskMutex returns a per-task mutex, creating one if it doesn't exist.
 // Used to serialize follow-up operations on the same task.
 func (te *TaskEngine) taskMutex(taskID string) *sync.Mutex {
@@ -104,6 +167,20 @@ func (te *TaskEngine) StartSession(sessionID string) error {
 		return fmt.Errorf("project not found: %w", err)
 	}
 
+	// A cloned or forked session starts from its snapshot the first time it
+	// runs. That replaces the project's working tree, so it needs the tree to
+	// itself and without changes of its own.
+	if session.BaseRef != "" && session.StartedAt == nil {
+		if err := te.checkBaseRestore(session, project); err != nil {
+			return err
+		}
+		backup, err := RestoreSnapshot(project.Path, session.BaseRef, preRestoreSnapshotName(sessionID))
+		if err != nil {
+			return fmt.Errorf("restore base snapshot: %w", err)
+		}
+		log.Printf("session %s: restored snapshot %s, previous working tree saved as %s", sessionID, session.BaseRef, backup)
+	}
+
 	// Mark session as running
 	if err := te.sessions.UpdateStatus(sessionID, models.SessionStatusRunning); err != nil {
 		return fmt.Errorf("update session: %w", err)
@@ -123,6 +200,29 @@ func (te *TaskEngine) StartSession(sessionID string) error {
 	return nil
 }
 
+// checkBaseRestore refuses to restore session's base snapshot into the
+// project while another session runs there or the working tree has
+// uncommitted changes, which the restore would replace.
+func (te *TaskEngine) checkBaseRestore(session *models.Session, project *models.Project) error {
+	sessions, err := te.sessions.ListByProject(project.ID)
+	if err != nil {
+		return fmt.Errorf("list sessions: %w", err)
+	}
+	for _, other := range sessions {
+		if other.ID != session.ID && other.Status == models.SessionStatusRunning {
+			return fmt.Errorf("session %q is running in this project; start %q once it has finished, since starting it replaces the working tree with its snapshot", other.Name, session.Name)
+		}
+	}
+	dirty, err := workingTreeDirty(project.Path)
+	if err != nil {
+		return fmt.Errorf("check working tree: %w", err)
+	}
+	if dirty {
+		return fmt.Errorf("%s has uncommitted changes; commit or stash them before starting %q, since starting it replaces the working tree with its snapshot", project.Path, session.Name)
+	}
+	return nil
+}
+
 // StopSession cancels all running tasks in a session.
 // If all tasks are already completed/failed, the session is marked as completed.
 func (te *TaskEngine) StopSession(sessionID string) error {
@@ -144,6 +244,7 @@ func (te *TaskEngine) StopSession(sessionID string) error {
 		switch task.Status {
 		case models.TaskStatusRunning:
 			te.runner.StopTask(task.ID)
+			te.cancelApprovals(task.ID, "The session was stopped.")
 			te.tasks.UpdateStatus(task.ID, models.TaskStatusCancelled)
 			hasActive = true
 		case models.TaskStatusQueued, models.TaskStatusAwaitingInput:
@@ -170,9 +271,13 @@ func (te *TaskEngine) StopSession(sessionID string) error {
 		te.emitSessionStatus(sessionID, "cancelled")
 	}
 
-	// Clean up event buffers for all tasks in this session
+	// Clean up event buffers, built-in MCP configs and injected files for all tasks in this session
 	for _, task := range tasks {
 		te.runner.CleanupTaskEvents(task.ID)
+		if te.builtinMCP != nil {
+			te.builtinMCP.RemoveConfig(task.ID)
+		}
+		te.managed.Release(task.ID)
 	}
 
 	return nil
@@ -205,9 +310,13 @@ func (te *TaskEngine) CompleteSession(sessionID string) error {
 	te.sessions.UpdateStatus(sessionID, status)
 	te.emitSessionStatus(sessionID, string(status))
 
-	// Clean up event buffers for all tasks in this session
+	// Clean up event buffers, built-in MCP configs and injected files for all tasks in this session
 	for _, task := range tasks {
 		te.runner.CleanupTaskEvents(task.ID)
+		if te.builtinMCP != nil {
+			te.builtinMCP.RemoveConfig(task.ID)
+		}
+		te.managed.Release(task.ID)
 	}
 
 	return nil
@@ -360,14 +469,26 @@ func (te *TaskEngine) executeTask(ctx context.Context, task *models.Task, projec
 		te.tasks.Update(task)
 	}
 
+	// A sequential team runs every agent in edge order, starting with the first
+	stages, stageErr := te.teamPipeline(task)
+	if stageErr != nil {
+		te.failTask(task, fmt.Sprintf("team pipeline failed: %v", stageErr))
+		return
+	}
+	if len(stages) > 0 && string(task.AgentID) != stages[0] {
+		task.AgentID = models.OptionalRef(stages[0])
+		te.tasks.Update(task)
+		log.Printf("task %s: running a pipeline of %d agents from team %s", task.ID, len(stages), task.TeamID)
+	}
+
 	// Get agent — resolve from team if team_id is set
 	if task.AgentID == "" && task.TeamID != "" {
-		selectedID, teamErr := te.selectAgentFromTeam(task.TeamID)
+		selectedID, teamErr := te.selectAgentFromTeam(string(task.TeamID))
 		if teamErr != nil {
 			te.failTask(task, fmt.Sprintf("team agent selection failed: %v", teamErr))
 			return
 		}
-		task.AgentID = selectedID
+		task.AgentID = models.OptionalRef(selectedID)
 		te.tasks.Update(task)
 		log.Printf("task %s: assigned agent %s from team %s", task.ID, selectedID, task.TeamID)
 	} else if task.AgentID == "" {
@@ -378,11 +499,11 @@ func (te *TaskEngine) executeTask(ctx context.Context, task *models.Task, projec
 			return
 		}
 		best := matchAgentToTask(agents, task)
-		task.AgentID = best.ID
+		task.AgentID = models.OptionalRef(best.ID)
 		te.tasks.Update(task)
 		log.Printf("task %s: auto-assigned agent %s (%s)", task.ID, best.Name, best.ID)
 	}
-	agent, err := te.agents.GetByID(task.AgentID)
+	agent, err := te.agents.GetByID(string(task.AgentID))
 	if err != nil {
 		te.failTask(task, fmt.Sprintf("agent not found (id=%s): %v", task.AgentID, err))
 		return
@@ -393,16 +514,35 @@ func (te *TaskEngine) executeTask(ctx context.Context, task *models.Task, projec
 	task.WorkspacePath = workDir
 	te.tasks.Update(task)
 
+	// Send the fixes of a rejected acceptance review once this run has fully
+	// ended (deferred calls run in reverse)
+	var reviewFix string
+	defer func() {
+		if reviewFix == "" {
+			return
+		}
+		taskMu := te.taskMutex(task.ID)
+		taskMu.Lock()
+		defer taskMu.Unlock()
+		te.runReviewRounds(ctx, task.ID, project, reviewFix)
+	}()
+
+	// Snapshot once injected files are gone
+	defer te.snapshotTask(task.ID, workDir)
+
+	// Injected CLAUDE.md/.mcp.json content is removed again once the run ends
+	defer te.managed.Release(task.ID)
+
 	// Inject CLAUDE.md if project has persistent context
 	if project.ClaudeMD != "" {
-		if err := te.injectClaudeMD(workDir, project.ClaudeMD); err != nil {
+		if err := te.injectClaudeMD(task.ID, workDir, project.ClaudeMD); err != nil {
 			log.Printf("task %s: warning: failed to inject CLAUDE.md: %v", task.ID, err)
 		}
 	}
 
 	// Inject .mcp.json if agent has MCP servers configured.
 	// injectMCPConfig does NOT modify agent; MCP tool patterns are merged below.
-	mcpConfigPath, mcpServerKeys, mcpErr := te.injectMCPConfig(agent, workDir)
+	mcpConfigPath, mcpServerKeys, mcpErr := te.injectMCPConfig(task.ID, agent, workDir)
 	if mcpErr != nil {
 		log.Printf("task %s: warning: failed to inject .mcp.json: %v", task.ID, mcpErr)
 	}
@@ -420,47 +560,27 @@ func (te *TaskEngine) executeTask(ctx context.Context, task *models.Task, projec
 			continue
 		}
 		log.Printf("task %s: running setup command [%d/%d]: %s", task.ID, i+1, len(project.SetupCommands), cmd)
-		if te.wailsCtx != nil {
-			wailsRuntime.EventsEmit(te.wailsCtx, "task:stream", map[string]any{
-				"task_id": task.ID,
-				"type":    "init",
-				"content": fmt.Sprintf("Running setup command [%d/%d]: %s", i+1, len(project.SetupCommands), cmd),
-			})
-		}
-		setupCmd := exec.Command("sh", "-c", cmd)
-		setupCmd.Dir = workDir
-		setupCmd.Env = append(os.Environ(),
-			"WORKSPACE_PATH="+workDir,
-			"PROJECT_PATH="+project.Path,
-			"TASK_ID="+task.ID,
-			"SESSION_ID="+task.SessionID,
-		)
-		if output, setupErr := setupCmd.CombinedOutput(); setupErr != nil {
+		te.emitStreamEvent(task.ID, "init", fmt.Sprintf("Running setup command [%d/%d]: %s", i+1, len(project.SetupCommands), cmd))
+		output, setupErr := te.runSetupCommand(ctx, cmd, task, project, agent)
+		te.auditSetupCommand(task.ID, i+1, cmd, output, setupErr)
+		if setupErr != nil {
 			log.Printf("task %s: setup command [%d] failed: %v\nOutput: %s", task.ID, i+1, setupErr, string(output))
-			if te.wailsCtx != nil {
-				wailsRuntime.EventsEmit(te.wailsCtx, "task:stream", map[string]any{
-					"task_id": task.ID,
-					"type":    "error",
-					"content": fmt.Sprintf("Setup command [%d] failed: %v\n%s", i+1, setupErr, string(output)),
-				})
-			}
+			te.emitStreamEvent(task.ID, "error", fmt.Sprintf("Setup command [%d] failed: %v\n%s", i+1, setupErr, string(output)))
 			// Don't fail the task — setup command failure is a warning
 		} else {
 			log.Printf("task %s: setup command [%d] completed successfully", task.ID, i+1)
-			if te.wailsCtx != nil && len(output) > 0 {
-				wailsRuntime.EventsEmit(te.wailsCtx, "task:stream", map[string]any{
-					"task_id": task.ID,
-					"type":    "init",
-					"content": strings.TrimSpace(string(output)),
-				})
+			if len(output) > 0 {
+				te.emitStreamEvent(task.ID, "init", strings.TrimSpace(string(output)))
 			}
 		}
 	}
 
-	// Mark as running
+	// Mark as running; each run gets the full number of review rounds
 	now := time.Now()
 	task.StartedAt = &now
 	task.Status = models.TaskStatusRunning
+	task.ReviewRound = 0
+	task.CodeReviewRound = 0
 	te.tasks.Update(task)
 	te.emitTaskStatus(task.ID, "running")
 
@@ -482,15 +602,40 @@ func (te *TaskEngine) executeTask(ctx context.Context, task *models.Task, projec
 		}
 	}
 
+	// Register the built-in Shannon MCP server so the agent can ask the user structured questions
+	builtinConfigs := te.builtinMCPConfigs(task)
+	if len(builtinConfigs) > 0 {
+		agentForRun.AllowedTools = append(agentForRun.AllowedTools, builtinToolPatterns(agent.AllowedTools, te.builtinTools())...)
+		agentForRun.SystemPrompt += askUserInstruction
+	}
+
 	// Run Claude
 	if task.Prompt == "" {
 		close(diffDone)
 		te.failTask(task, "task has no prompt: cannot execute without instructions")
 		return
 	}
+	attemptKind := models.AttemptKindInitial
+	if task.RetryCount > 0 {
+		attemptKind = models.AttemptKindRetry
+	}
+	attempt := te.beginAttempt(task, &agentForRun, attemptKind, task.Prompt)
+	if len(stages) > 0 {
+		attempt.Stage = 1
+	}
+	te.recordMessage(task.ID, attempt, models.MessageRoleUser, "", task.Prompt, false)
+
+	// Snapshot guarded files that are already dirty so only this run's changes are reverted
+	guardRun := te.beginGuardRun(NewPathGuard(agent), project.Path)
+
 	log.Printf("task %s: starting claude (agent=%s, model=%s, prompt_len=%d, workdir=%s)", task.ID, agent.Name, agent.Model, len(task.Prompt), workDir)
+	te.beginApprovalRun(task.ID, agentForRun, workDir)
 	runResult, runErr := te.runner.RunTask(ctx, task, &agentForRun, workDir, RunTaskOptions{
-		MCPConfigPath: mcpConfigPath,
+		MCPConfigPath:        mcpConfigPath,
+		ExtraMCPConfigs:      builtinConfigs,
+		PermissionPromptTool: te.permissionPromptTool(builtinConfigs),
+		Sandbox:              te.sandboxProfile(agent, true),
+		Project:              project,
 		OnSessionID: func(sessionID string) {
 			log.Printf("task %s: captured claude session_id: %s", task.ID, sessionID)
 			task.ClaudeSessionID = sessionID
@@ -498,8 +643,27 @@ func (te *TaskEngine) executeTask(ctx context.Context, task *models.Task, projec
 		},
 	})
 
+	// Revert changes to protected/read-only paths (e.g. made through Bash)
+	// before diffing; pipeline stages are checked by their own agent's rules
+	violations, _ := te.enforcePathGuard(task.ID, guardRun, runResult)
+	violationFailed := violationFails(agent, violations)
+
+	// The rest of a sequential team's pipeline continues in the same workspace
+	task.PipelineStage = 0
+	if len(stages) > 1 && runErr == nil && !violationFailed {
+		if runResult != nil && runResult.NeedsInput {
+			task.PipelineStage = 1
+		} else {
+			var stageViolations []models.PathViolation
+			agent, attempt, runResult, stageViolations, runErr = te.runPipelineStages(ctx, task, project, workDir, stages, 1, agent, attempt, runResult)
+			violations = append(violations, stageViolations...)
+		}
+	}
+	te.endApprovalRun(task.ID)
+
 	// Stop diff watcher
 	close(diffDone)
+	te.recordAgentReply(task.ID, attempt, runResult, runErr)
 
 	if runErr != nil {
 		log.Printf("task %s: claude process error: %v", task.ID, runErr)
@@ -520,38 +684,14 @@ func (te *TaskEngine) executeTask(ctx context.Context, task *models.Task, projec
 
 		// Emit diff to frontend
 		if te.wailsCtx != nil {
-			wailsRuntime.EventsEmit(te.wailsCtx, "task:diff", map[string]any{
+			emitEvent(te.wailsCtx, "task:diff", map[string]any{
 				"task_id": task.ID,
 				"diff":    diffResult,
 			})
 		}
 	}
 
-	// Run tests
-	if testResult := te.testRunner.RunTest(workDir, project.TestCommand); testResult != nil {
-		task.TestPassed = &testResult.Passed
-		task.TestOutput = testResult.Output
-		if te.wailsCtx != nil {
-			wailsRuntime.EventsEmit(te.wailsCtx, "task:test", map[string]any{
-				"task_id":     task.ID,
-				"test_passed": testResult.Passed,
-				"output":      testResult.Output,
-			})
-		}
-	}
-
-	// Run build
-	if buildResult := te.testRunner.RunBuild(workDir, project.BuildCommand); buildResult != nil {
-		task.BuildPassed = &buildResult.Passed
-		task.BuildOutput = buildResult.Output
-		if te.wailsCtx != nil {
-			wailsRuntime.EventsEmit(te.wailsCtx, "task:build", map[string]any{
-				"task_id":      task.ID,
-				"build_passed": buildResult.Passed,
-				"output":       buildResult.Output,
-			})
-		}
-	}
+	te.runChecks(task, project, agent, workDir)
 
 	// Re-read task from DB before final update to avoid overwriting concurrent changes
 	// (e.g. a follow-up may have updated ClaudeSessionID while we were running).
@@ -566,8 +706,11 @@ func (te *TaskEngine) executeTask(ctx context.Context, task *models.Task, projec
 		freshTask.MCPConfigPath = task.MCPConfigPath
 		freshTask.ClaudeSessionID = task.ClaudeSessionID
 		freshTask.OriginalPrompt = task.OriginalPrompt
+		freshTask.PipelineStage = task.PipelineStage
 		task = freshTask
 	}
+	task.PathViolations = encodeViolations(violations)
+	task.SecretFindings = te.scanSecrets(task.ID, project, task.SecretFindings, diffResult)
 
 	// Determine final status
 	completedAt := time.Now()
@@ -576,6 +719,7 @@ func (te *TaskEngine) executeTask(ctx context.Context, task *models.Task, projec
 	if runErr != nil {
 		// Check if we should auto-retry
 		if task.RetryCount < task.MaxRetries {
+			te.finishAttempt(attempt, task, models.TaskStatusFailed, runResult, runErr, diffResult)
 			task.RetryCount++
 			task.Status = models.TaskStatusPending
 			task.Error = fmt.Sprintf("Retry %d/%d: %s", task.RetryCount, task.MaxRetries, runErr.Error())
@@ -603,10 +747,20 @@ func (te *TaskEngine) executeTask(ctx context.Context, task *models.Task, projec
 
 		task.Status = models.TaskStatusFailed
 		task.Error = runErr.Error()
+	} else if violationFails(agent, violations) {
+		// Path violations fail the run whether it completed or asked for input
+		if runResult != nil {
+			task.ExitCode = runResult.ExitCode
+			if task.ResultText == "" && runResult.LastText != "" {
+				task.ResultText = runResult.LastText
+			}
+		}
+		task.Status = models.TaskStatusFailed
+		task.Error = violationSummary(violations)
 	} else if runResult != nil && runResult.NeedsInput {
 		// Agent is asking for user input — mark as awaiting_input
 		task.Status = models.TaskStatusAwaitingInput
-		task.PendingInputData = runResult.LastText
+		task.PendingInputData = runResult.PendingInput.Encode()
 		task.CompletedAt = nil // not truly completed yet
 		log.Printf("task %s: agent needs user input, marking as awaiting_input", task.ID)
 	} else {
@@ -625,10 +779,15 @@ func (te *TaskEngine) executeTask(ctx context.Context, task *models.Task, projec
 			task.Status = models.TaskStatusFailed
 			task.Error = "Build failed"
 		} else {
-			task.Status = models.TaskStatusCompleted
+			reviewFix = te.reviewRun(ctx, task, project, agent, attempt, diffResult, true)
 		}
 	}
 
+	attemptStatus := task.Status
+	if reviewFix != "" {
+		attemptStatus = models.TaskStatusFailed // rejected by the reviewer; the task goes on with a fix round
+	}
+	te.finishAttempt(attempt, task, attemptStatus, runResult, runErr, diffResult)
 	te.tasks.Update(task)
 	te.emitTaskStatus(task.ID, string(task.Status))
 }
@@ -712,17 +871,9 @@ func (te *TaskEngine) selectAgentFromTeam(teamID string) (string, error) {
 
 	switch team.Strategy {
 	case models.TeamStrategySequential:
-		// Follow edge order: find the root agent (agent with no incoming edges)
-		if len(team.Edges) > 0 {
-			targets := make(map[string]bool)
-			for _, edge := range team.Edges {
-				targets[edge.Target] = true
-			}
-			for _, agentID := range team.AgentIDs {
-				if !targets[agentID] {
-					return agentID, nil
-				}
-			}
+		// Follow edge order: the first agent of the pipeline
+		if order, err := pipelineOrder(team); err == nil && len(order) > 0 {
+			return order[0], nil
 		}
 		// Fallback: first agent
 		return team.AgentIDs[0], nil
@@ -751,19 +902,47 @@ func (te *TaskEngine) failTask(task *models.Task, errMsg string) {
 	te.tasks.Update(task)
 
 	// Emit error as a stream event so it shows in Live Output
+	te.emitStreamEvent(task.ID, "error", errMsg)
+	te.emitTaskStatus(task.ID, "failed")
+}
+
+// emitEvent sends an event to the frontend with secrets masked in every
+// string of data, structs included. Every backend event goes through it.
+func emitEvent(ctx context.Context, name string, data any) {
+	if ctx == nil {
+		return
+	}
+	wailsRuntime.EventsEmit(ctx, name, redactEventData(data))
+}
+
+// redactEventData masks data as the frontend will receive it: as JSON.
+func redactEventData(data any) any {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return redact.Value(data)
+	}
+	var generic any
+	if err := json.Unmarshal(raw, &generic); err != nil {
+		return redact.Value(data)
+	}
+	return redact.Value(generic)
+}
+
+// emitStreamEvent sends an engine-generated line (setup output, errors) to the
+// task's live output, with secrets masked.
+func (te *TaskEngine) emitStreamEvent(taskID, eventType, content string) {
 	if te.wailsCtx != nil {
-		wailsRuntime.EventsEmit(te.wailsCtx, "task:stream", map[string]any{
-			"task_id": task.ID,
-			"type":    "error",
-			"content": errMsg,
+		emitEvent(te.wailsCtx, "task:stream", map[string]any{
+			"task_id": taskID,
+			"type":    eventType,
+			"content": redact.String(content),
 		})
 	}
-	te.emitTaskStatus(task.ID, "failed")
 }
 
 func (te *TaskEngine) emitTaskStatus(taskID string, status string) {
 	if te.wailsCtx != nil {
-		wailsRuntime.EventsEmit(te.wailsCtx, "task:status", map[string]any{
+		emitEvent(te.wailsCtx, "task:status", map[string]any{
 			"task_id": taskID,
 			"status":  status,
 		})
@@ -772,7 +951,7 @@ func (te *TaskEngine) emitTaskStatus(taskID string, status string) {
 
 func (te *TaskEngine) emitSessionStatus(sessionID string, status string) {
 	if te.wailsCtx != nil {
-		wailsRuntime.EventsEmit(te.wailsCtx, "session:status", map[string]any{
+		emitEvent(te.wailsCtx, "session:status", map[string]any{
 			"session_id": sessionID,
 			"status":     status,
 		})
@@ -805,7 +984,7 @@ func (te *TaskEngine) watchDiffs(ctx context.Context, taskID, projectPath string
 			lastHash = currentHash
 
 			if te.wailsCtx != nil {
-				wailsRuntime.EventsEmit(te.wailsCtx, "task:diff", map[string]any{
+				emitEvent(te.wailsCtx, "task:diff", map[string]any{
 					"task_id": taskID,
 					"diff":    diffResult,
 				})
@@ -827,18 +1006,15 @@ func (te *TaskEngine) diffHash(result *DiffResult) string {
 	return sb.String()
 }
 
-// injectClaudeMD writes a .claude/CLAUDE.md file into the workspace directory.
-// Claude Code automatically reads this file for project-level context.
-func (te *TaskEngine) injectClaudeMD(workDir string, content string) error {
-	claudeDir := filepath.Join(workDir, ".claude")
-	if err := os.MkdirAll(claudeDir, 0755); err != nil {
-		return fmt.Errorf("create .claude dir: %w", err)
-	}
-	mdPath := filepath.Join(claudeDir, "CLAUDE.md")
-	if err := os.WriteFile(mdPath, []byte(content), 0644); err != nil {
-		return fmt.Errorf("write CLAUDE.md: %w", err)
+// injectClaudeMD adds the project's persistent context to .claude/CLAUDE.md in
+// the workspace directory, which the Claude CLI reads for project-level context.
+// An existing CLAUDE.md is kept; the content goes in a delimited section that
+// is removed when the task releases its injections.
+func (te *TaskEngine) injectClaudeMD(taskID, workDir string, content string) error {
+	if err := te.managed.InjectClaudeMD(workDir, taskID, content); err != nil {
+		return err
 	}
-	log.Printf("task: injected CLAUDE.md (%d bytes) into %s", len(content), workDir)
+	log.Printf("task %s: injected CLAUDE.md section (%d bytes) into %s", taskID, len(content), workDir)
 	return nil
 }
 
@@ -879,15 +1055,16 @@ func mcpToolPatterns(agentAllowedTools []string, serverKeys []string) []string {
 	return patterns
 }
 
-// injectMCPConfig writes a .mcp.json file into the workspace directory
-// based on the agent's configured MCP server IDs. Claude Code reads this
-// file at startup to discover available MCP servers.
+// injectMCPConfig merges the agent's configured MCP servers into the
+// workspace's .mcp.json, which the Claude CLI reads at startup to discover
+// available MCP servers. Servers the project already defines are kept and the
+// file is restored when the task releases its injections.
 //
 // IMPORTANT: This function does NOT modify the agent object. MCP tool patterns
 // are returned separately via mcpToolPatterns and must be merged by the caller.
 //
 // Returns the path to the written .mcp.json file, and the server keys that were included.
-func (te *TaskEngine) injectMCPConfig(agent *models.Agent, workDir string) (string, []string, error) {
+func (te *TaskEngine) injectMCPConfig(taskID string, agent *models.Agent, workDir string) (string, []string, error) {
 	if len(agent.MCPServerIDs) == 0 {
 		return "", nil, nil
 	}
@@ -910,11 +1087,7 @@ func (te *TaskEngine) injectMCPConfig(agent *models.Agent, workDir string) (stri
 		Env     map[string]string `json:"env"`
 	}
 
-	mcpConfig := struct {
-		MCPServers map[string]mcpServerEntry `json:"mcpServers"`
-	}{
-		MCPServers: make(map[string]mcpServerEntry),
-	}
+	entries := make(map[string]any)
 
 	var serverKeys []string
 	for _, srv := range servers {
@@ -930,11 +1103,12 @@ func (te *TaskEngine) injectMCPConfig(agent *models.Agent, workDir string) (stri
 		if args == nil {
 			args = []string{}
 		}
-		env := srv.Env
-		if env == nil {
-			env = map[string]string{}
+		env, err := ResolveVaultRefs(srv.Env, te.secrets)
+		if err != nil {
+			log.Printf("task %s: skipping MCP server %q (key=%s): %v", taskID, srv.Name, srv.ServerKey, err)
+			continue
 		}
-		mcpConfig.MCPServers[srv.ServerKey] = mcpServerEntry{
+		entries[srv.ServerKey] = mcpServerEntry{
 			Command: srv.Command,
 			Args:    args,
 			Env:     env,
@@ -943,27 +1117,104 @@ func (te *TaskEngine) injectMCPConfig(agent *models.Agent, workDir string) (stri
 		log.Printf("task: adding MCP server %q (key=%s, cmd=%s, args=%v)", srv.Name, srv.ServerKey, srv.Command, srv.Args)
 	}
 
-	if len(mcpConfig.MCPServers) == 0 {
+	if len(entries) == 0 {
 		return "", nil, nil
 	}
 
-	data, err := json.MarshalIndent(mcpConfig, "", "  ")
+	mcpPath, err := te.managed.InjectMCPServers(workDir, taskID, entries)
 	if err != nil {
-		return "", nil, fmt.Errorf("marshal .mcp.json: %w", err)
+		return "", nil, err
 	}
 
-	mcpPath := filepath.Join(workDir, ".mcp.json")
-	if err := os.WriteFile(mcpPath, data, 0600); err != nil {
-		return "", nil, fmt.Errorf("write .mcp.json: %w", err)
+	log.Printf("task %s: merged %d server(s) into .mcp.json in %s (path=%s)", taskID, len(entries), workDir, mcpPath)
+	return mcpPath, serverKeys, nil
+}
+
+// reinjectFiles injects the project's CLAUDE.md section and the agent's MCP
+// servers again for a follow-up run and returns the .mcp.json path to use.
+func (te *TaskEngine) reinjectFiles(task *models.Task, project *models.Project, agent *models.Agent, workDir string) string {
+	if project.ClaudeMD != "" {
+		if err := te.injectClaudeMD(task.ID, workDir, project.ClaudeMD); err != nil {
+			log.Printf("task %s: warning: failed to inject CLAUDE.md: %v", task.ID, err)
+		}
+	}
+	mcpConfigPath, _, err := te.injectMCPConfig(task.ID, agent, workDir)
+	if err != nil {
+		log.Printf("task %s: warning: failed to inject .mcp.json: %v", task.ID, err)
+		return task.MCPConfigPath
 	}
+	return mcpConfigPath
+}
 
-	log.Printf("task: injected .mcp.json with %d server(s) into %s (path=%s)", len(mcpConfig.MCPServers), workDir, mcpPath)
-	return mcpPath, serverKeys, nil
+// taskProject returns the project of the task's session.
+func (te *TaskEngine) taskProject(task *models.Task) (*models.Project, error) {
+	session, err := te.sessions.GetByID(task.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("session not found: %w", err)
+	}
+	project, err := te.projects.GetByID(session.ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("project not found: %w", err)
+	}
+	return project, nil
+}
+
+// maxAuditOutput caps the setup command output kept in the audit log.
+const maxAuditOutput = 4000
+
+// auditSetupCommand records a setup command run and its outcome.
+func (te *TaskEngine) auditSetupCommand(taskID string, index int, command string, output []byte, runErr error) {
+	if te.auditLog == nil {
+		return
+	}
+	after := map[string]any{"index": index, "command": command, "passed": runErr == nil}
+	if runErr != nil {
+		after["error"] = runErr.Error()
+	}
+	out := string(output)
+	if len(out) > maxAuditOutput {
+		out = out[len(out)-maxAuditOutput:]
+	}
+	after["output"] = out
+	summary := fmt.Sprintf("setup command [%d]: %s", index, command)
+	if err := te.auditLog.Record(models.AuditActorSystem, models.AuditActionSetupCommand, models.AuditEntityTask, taskID, summary, nil, after); err != nil {
+		log.Printf("task %s: audit setup command: %v", taskID, err)
+	}
+}
+
+// InspectTaskEnv lists the environment variable names a run of the task would
+// get and the scope (global, project, agent, task) each comes from.
+func (te *TaskEngine) InspectTaskEnv(taskID string) ([]EffectiveEnvVar, error) {
+	task, err := te.tasks.GetByID(taskID)
+	if err != nil {
+		return nil, fmt.Errorf("task not found: %w", err)
+	}
+	project, err := te.taskProject(task)
+	if err != nil {
+		return nil, err
+	}
+	var agent *models.Agent
+	if task.AgentID != "" {
+		if agent, err = te.agents.GetByID(string(task.AgentID)); err != nil {
+			return nil, fmt.Errorf("agent not found: %w", err)
+		}
+	}
+	return te.runner.InspectTaskEnv(project, agent, task), nil
 }
 
 // SendFollowUp sends a follow-up prompt to a completed/failed task using --resume.
 // Uses a per-task mutex to serialize concurrent follow-ups on the same task.
 func (te *TaskEngine) SendFollowUp(taskID string, message string, mode string) error {
+	return te.sendFollowUp(taskID, message, mode, models.AttemptKindFollowUp)
+}
+
+// ResumeTask continues a task's Claude session with the given prompt.
+// It behaves like a "code" follow-up but is recorded as a resume attempt.
+func (te *TaskEngine) ResumeTask(taskID string, prompt string) error {
+	return te.sendFollowUp(taskID, prompt, "code", models.AttemptKindResume)
+}
+
+func (te *TaskEngine) sendFollowUp(taskID string, message string, mode string, kind models.AttemptKind) error {
 	// Acquire per-task mutex to prevent concurrent follow-ups on the same task.
 	// The mutex is released in the background goroutine after the final DB update.
 	taskMu := te.taskMutex(taskID)
@@ -986,61 +1237,24 @@ func (te *TaskEngine) SendFollowUp(taskID string, message string, mode string) e
 		time.Sleep(500 * time.Millisecond) // brief wait for process cleanup
 	}
 
-	agent, err := te.agents.GetByID(task.AgentID)
+	agent, err := te.agents.GetByID(string(task.AgentID))
 	if err != nil {
 		taskMu.Unlock()
 		return fmt.Errorf("agent not found: %w", err)
 	}
 
-	// Apply mode overrides on a copy — never modify the original agent
-	agentCopy := *agent
-	switch mode {
-	case "plan":
-		agentCopy.SystemPrompt = "Describe your planned changes step by step before making any edits. Wait for the user to approve before proceeding.\n\n" + agentCopy.SystemPrompt
-	case "auto":
-		agentCopy.Permissions = "bypassPermissions"
-	}
-
-	// Build effective disallowed tools for follow-up
-	agentCopy.DisallowedTools = models.StringSlice(te.buildEffectivePermissions(&agentCopy))
-
-	// Merge MCP tool patterns into AllowedTools for follow-up (same logic as executeTask).
-	// Resolve server keys from DB since we only have MCPServerIDs (DB IDs) on the agent.
-	if task.MCPConfigPath != "" && len(agent.MCPServerIDs) > 0 {
-		if srvs, srvErr := te.mcpServers.ListByIDs(agent.MCPServerIDs); srvErr == nil {
-			var keys []string
-			for _, s := range srvs {
-				if s.Enabled {
-					keys = append(keys, s.ServerKey)
-				}
-			}
-			if extra := mcpToolPatterns(agent.AllowedTools, keys); len(extra) > 0 {
-				merged := make([]string, len(agent.AllowedTools), len(agent.AllowedTools)+len(extra))
-				copy(merged, agent.AllowedTools)
-				agentCopy.AllowedTools = append(merged, extra...)
-			}
-		}
+	project, err := te.taskProject(task)
+	if err != nil {
+		taskMu.Unlock()
+		return err
 	}
 
 	// Determine working directory
 	workDir := task.WorkspacePath
 	if workDir == "" {
-		session, sErr := te.sessions.GetByID(task.SessionID)
-		if sErr != nil {
-			taskMu.Unlock()
-			return fmt.Errorf("session not found: %w", sErr)
-		}
-		project, pErr := te.projects.GetByID(session.ProjectID)
-		if pErr != nil {
-			taskMu.Unlock()
-			return fmt.Errorf("project not found: %w", pErr)
-		}
 		workDir = project.Path
 	}
 
-	// Capture session ID before marking as running (used in goroutine)
-	claudeSessionID := task.ClaudeSessionID
-
 	// Mark task as running (preserve original StartedAt)
 	task.Status = models.TaskStatusRunning
 	if task.StartedAt == nil {
@@ -1054,7 +1268,7 @@ func (te *TaskEngine) SendFollowUp(taskID string, message string, mode string) e
 		return fmt.Errorf("failed to update task status: %w", err)
 	}
 	te.emitTaskStatus(task.ID, "running")
-	log.Printf("task %s: follow-up started (session=%s, prompt_len=%d)", task.ID, claudeSessionID, len(message))
+	log.Printf("task %s: follow-up started (session=%s, prompt_len=%d)", task.ID, task.ClaudeSessionID, len(message))
 
 	// Use session-scoped context so follow-up is cancelled when session stops.
 	// Copy context reference under lock to avoid race with session cleanup.
@@ -1071,63 +1285,214 @@ func (te *TaskEngine) SendFollowUp(taskID string, message string, mode string) e
 	go func() {
 		defer taskMu.Unlock()
 		defer followUpCancel()
+		fix := te.runFollowUp(followUpCtx, task, project, agent, workDir, message, mode, kind)
+		te.runReviewRounds(followUpCtx, taskID, project, fix)
+	}()
 
-		runResult, runErr := te.runner.RunTask(followUpCtx, task, &agentCopy, workDir, RunTaskOptions{
-			SessionID:     claudeSessionID,
-			Prompt:        message,
-			MCPConfigPath: task.MCPConfigPath,
-			OnSessionID: func(sessionID string) {
-				// Update session ID if it changed
-				if sessionID != claudeSessionID {
-					log.Printf("task %s: follow-up session ID changed: %s -> %s", taskID, claudeSessionID, sessionID)
-					claudeSessionID = sessionID
-					// Persist new session ID immediately
-					te.tasks.UpdateField(taskID, "claude_session_id", sessionID)
-				}
-			},
-		})
+	return nil
+}
 
-		// Re-read task from DB to avoid overwriting concurrent changes
-		freshTask, readErr := te.tasks.GetByID(taskID)
-		if readErr != nil {
-			log.Printf("task %s: failed to re-read task after follow-up: %v", taskID, readErr)
-			return
+// runAgent returns agent with the effective disallowed tools of a run on
+// task, the tools of its MCP servers (when task has an injected .mcp.json)
+// and of the built-in server allowed, and the built-in server configs to
+// pass to the run.
+func (te *TaskEngine) runAgent(task *models.Task, agent models.Agent) (models.Agent, []string) {
+	allowed := agent.AllowedTools
+	agent.DisallowedTools = models.StringSlice(te.buildEffectivePermissions(&agent))
+
+	// Resolve server keys from DB since we only have MCPServerIDs (DB IDs) on the agent.
+	if task.MCPConfigPath != "" && len(agent.MCPServerIDs) > 0 {
+		if srvs, srvErr := te.mcpServers.ListByIDs(agent.MCPServerIDs); srvErr == nil {
+			var keys []string
+			for _, s := range srvs {
+				if s.Enabled {
+					keys = append(keys, s.ServerKey)
+				}
+			}
+			if extra := mcpToolPatterns(allowed, keys); len(extra) > 0 {
+				merged := make([]string, len(allowed), len(allowed)+len(extra))
+				copy(merged, allowed)
+				agent.AllowedTools = append(merged, extra...)
+			}
 		}
+	}
+
+	builtinConfigs := te.builtinMCPConfigs(task)
+	if len(builtinConfigs) > 0 {
+		agent.AllowedTools = append(agent.AllowedTools, builtinToolPatterns(allowed, te.builtinTools())...)
+	}
+	return agent, builtinConfigs
+}
 
-		completedAt := time.Now()
-		freshTask.CompletedAt = &completedAt
-		freshTask.ClaudeSessionID = claudeSessionID
+// runFollowUp sends message to the task's Claude session and records the
+// outcome, blocking until the run ends. The caller must hold the task's
+// mutex and have marked the task running. It returns the follow-up a
+// reviewer asked for, to be sent next, or "".
+func (te *TaskEngine) runFollowUp(ctx context.Context, task *models.Task, project *models.Project, agent *models.Agent, workDir, message, mode string, kind models.AttemptKind) string {
+	taskID := task.ID
+	claudeSessionID := task.ClaudeSessionID
 
-		if runErr != nil {
-			log.Printf("task %s: follow-up failed: %v", taskID, runErr)
-			freshTask.Status = models.TaskStatusFailed
-			freshTask.Error = runErr.Error()
-			// Emit error as stream event so it shows in the UI
-			if te.wailsCtx != nil {
-				wailsRuntime.EventsEmit(te.wailsCtx, "task:stream", map[string]any{
-					"task_id": taskID,
-					"type":    "error",
-					"content": fmt.Sprintf("Follow-up failed: %v", runErr),
-				})
+	// Apply mode overrides on a copy — never modify the original agent
+	modeAgent := *agent
+	switch mode {
+	case "plan":
+		modeAgent.SystemPrompt = "Describe your planned changes step by step before making any edits. Wait for the user to approve before proceeding.\n\n" + modeAgent.SystemPrompt
+	case "auto":
+		modeAgent.Permissions = "bypassPermissions"
+	}
+
+	// Re-register the built-in MCP server; resumed sessions keep their original tool list
+	agentCopy, builtinConfigs := te.runAgent(task, modeAgent)
+
+	attempt := te.beginAttempt(task, &agentCopy, kind, message)
+	te.recordMessage(taskID, attempt, models.MessageRoleUser, mode, message, false)
+
+	defer te.snapshotTask(taskID, workDir)
+
+	// The previous run restored CLAUDE.md/.mcp.json; inject them again for this one
+	defer te.managed.Release(taskID)
+	mcpConfigPath := te.reinjectFiles(task, project, agent, workDir)
+
+	guardRun := te.beginGuardRun(NewPathGuard(agent), workDir)
+
+	// Code review only looks at a follow-up that changed something
+	diffBefore, _ := te.diffTracker.ComputeDiff(workDir)
+
+	te.beginApprovalRun(taskID, agentCopy, workDir)
+	defer te.endApprovalRun(taskID)
+	runResult, runErr := te.runner.RunTask(ctx, task, &agentCopy, workDir, RunTaskOptions{
+		SessionID:            claudeSessionID,
+		Prompt:               message,
+		MCPConfigPath:        mcpConfigPath,
+		ExtraMCPConfigs:      builtinConfigs,
+		PermissionPromptTool: te.permissionPromptTool(builtinConfigs),
+		Sandbox:              te.sandboxProfile(agent, true),
+		Project:              project,
+		OnSessionID: func(sessionID string) {
+			// Update session ID if it changed
+			if sessionID != claudeSessionID {
+				log.Printf("task %s: follow-up session ID changed: %s -> %s", taskID, claudeSessionID, sessionID)
+				claudeSessionID = sessionID
+				// Persist new session ID immediately
+				te.tasks.UpdateField(taskID, "claude_session_id", sessionID)
 			}
-		} else if runResult != nil && runResult.NeedsInput {
-			freshTask.Status = models.TaskStatusAwaitingInput
-			freshTask.PendingInputData = runResult.LastText
-			freshTask.CompletedAt = nil
-			log.Printf("task %s: follow-up needs user input, marking as awaiting_input", taskID)
-		} else {
-			log.Printf("task %s: follow-up completed successfully", taskID)
+		},
+	})
+
+	violations, _ := te.enforcePathGuard(taskID, guardRun, runResult)
+	violationFailed := violationFails(agent, violations)
+
+	// Answering a pipeline stage that was waiting for input lets the rest of
+	// the pipeline run
+	resumed := task.PipelineStage > 0 && mode != "plan" && runErr == nil && !violationFailed && (runResult == nil || !runResult.NeedsInput)
+	if resumed {
+		task.ClaudeSessionID = claudeSessionID
+		var stageViolations []models.PathViolation
+		agent, attempt, runResult, stageViolations, runErr = te.resumePipeline(ctx, task, project, workDir, agent, attempt, runResult)
+		violations = append(violations, stageViolations...)
+		claudeSessionID = task.ClaudeSessionID
+	}
+	te.recordAgentReply(taskID, attempt, runResult, runErr)
+
+	// Re-read task from DB to avoid overwriting concurrent changes
+	freshTask, readErr := te.tasks.GetByID(taskID)
+	if readErr != nil {
+		log.Printf("task %s: failed to re-read task after follow-up: %v", taskID, readErr)
+		return ""
+	}
+
+	completedAt := time.Now()
+	freshTask.CompletedAt = &completedAt
+	freshTask.ClaudeSessionID = claudeSessionID
+	freshTask.PathViolations = encodeViolations(violations)
+	if resumed {
+		freshTask.AgentID = task.AgentID
+		freshTask.MCPConfigPath = task.MCPConfigPath
+		freshTask.PipelineStage = task.PipelineStage
+	}
+
+	// Follow-ups re-run tests and build only for a review, so it judges the
+	// project as it is now rather than an earlier run's results
+	diffResult, _ := te.diffTracker.ComputeDiff(workDir)
+	checks := &models.Task{ClaudeSessionID: claudeSessionID}
+
+	var fix string
+	if runErr != nil {
+		log.Printf("task %s: follow-up failed: %v", taskID, runErr)
+		freshTask.Status = models.TaskStatusFailed
+		freshTask.Error = runErr.Error()
+		freshTask.PipelineStage = 0
+		// Emit error as stream event so it shows in the UI
+		te.emitStreamEvent(taskID, "error", fmt.Sprintf("Follow-up failed: %v", runErr))
+	} else if violationFailed {
+		freshTask.Status = models.TaskStatusFailed
+		freshTask.Error = violationSummary(violations)
+		freshTask.PipelineStage = 0
+		if runResult != nil && runResult.LastText != "" {
+			freshTask.ResultText = runResult.LastText
+		}
+	} else if runResult != nil && runResult.NeedsInput {
+		freshTask.Status = models.TaskStatusAwaitingInput
+		freshTask.PendingInputData = runResult.PendingInput.Encode()
+		freshTask.CompletedAt = nil
+		log.Printf("task %s: follow-up needs user input, marking as awaiting_input", taskID)
+	} else {
+		log.Printf("task %s: follow-up completed successfully", taskID)
+		if runResult != nil && runResult.LastText != "" {
+			freshTask.ResultText = runResult.LastText
+		}
+		switch {
+		case mode == "plan":
+			// A plan changes nothing yet; the agent waits for the user to approve it
+			freshTask.Status = models.TaskStatusCompleted
+		case te.reviewsApply(freshTask):
+			te.runChecks(freshTask, project, agent, workDir)
+			checks.TestPassed, checks.TestOutput = freshTask.TestPassed, freshTask.TestOutput
+			checks.BuildPassed, checks.BuildOutput = freshTask.BuildPassed, freshTask.BuildOutput
+			fix = te.reviewRun(ctx, freshTask, project, agent, attempt, diffResult, diffSnapshot(diffResult) != diffSnapshot(diffBefore))
+		default:
 			freshTask.Status = models.TaskStatusCompleted
-			if runResult != nil && runResult.LastText != "" {
-				freshTask.ResultText = runResult.LastText
-			}
 		}
+	}
 
-		if err := te.tasks.Update(freshTask); err != nil {
-			log.Printf("task %s: failed to update task after follow-up: %v", taskID, err)
-		}
-		te.emitTaskStatus(taskID, string(freshTask.Status))
-	}()
+	attemptStatus := freshTask.Status
+	if fix != "" {
+		attemptStatus = models.TaskStatusFailed // rejected by the reviewer; the task goes on with a fix round
+	}
+	checks.Error = freshTask.Error
+	te.finishAttempt(attempt, checks, attemptStatus, runResult, runErr, diffResult)
+	freshTask.SecretFindings = te.scanSecrets(taskID, project, freshTask.SecretFindings, diffResult)
 
-	return nil
+	if err := te.tasks.Update(freshTask); err != nil {
+		log.Printf("task %s: failed to update task after follow-up: %v", taskID, err)
+	}
+	te.emitTaskStatus(taskID, string(freshTask.Status))
+	return fix
+}
+
+// runChecks runs the project's test and build commands in workDir, stores the
+// results on task and emits them.
+func (te *TaskEngine) runChecks(task *models.Task, project *models.Project, agent *models.Agent, workDir string) {
+	if testResult := te.testRunner.RunTest(workDir, project.TestCommand, te.sandboxProfile(agent, false)); testResult != nil {
+		task.TestPassed = &testResult.Passed
+		task.TestOutput = testResult.Output
+		if te.wailsCtx != nil {
+			emitEvent(te.wailsCtx, "task:test", map[string]any{
+				"task_id":     task.ID,
+				"test_passed": testResult.Passed,
+				"output":      testResult.Output,
+			})
+		}
+	}
+	if buildResult := te.testRunner.RunBuild(workDir, project.BuildCommand, te.sandboxProfile(agent, false)); buildResult != nil {
+		task.BuildPassed = &buildResult.Passed
+		task.BuildOutput = buildResult.Output
+		if te.wailsCtx != nil {
+			emitEvent(te.wailsCtx, "task:build", map[string]any{
+				"task_id":      task.ID,
+				"build_passed": buildResult.Passed,
+				"output":       buildResult.Output,
+			})
+		}
+	}
 }
@@ -1,8 +1,9 @@
 package services
 
 import (
+	"agent-workflow/backend/redact"
+	"agent-workflow/backend/sandbox"
 	"context"
-	"os/exec"
 	"time"
 )
 
@@ -19,34 +20,37 @@ func NewTestRunner() *TestRunner {
 	return &TestRunner{}
 }
 
-// RunTest executes the test command in the given directory.
-func (tr *TestRunner) RunTest(workDir string, command string) *TestResult {
+// RunTest executes the test command in the given directory, inside the sandbox if one is given.
+func (tr *TestRunner) RunTest(workDir string, command string, profile *sandbox.Profile) *TestResult {
 	if command == "" {
 		return nil
 	}
-	return tr.runCommand(workDir, command)
+	return tr.runCommand(workDir, command, profile)
 }
 
-// RunBuild executes the build command in the given directory.
-func (tr *TestRunner) RunBuild(workDir string, command string) *TestResult {
+// RunBuild executes the build command in the given directory, inside the sandbox if one is given.
+func (tr *TestRunner) RunBuild(workDir string, command string, profile *sandbox.Profile) *TestResult {
 	if command == "" {
 		return nil
 	}
-	return tr.runCommand(workDir, command)
+	return tr.runCommand(workDir, command, profile)
 }
 
-func (tr *TestRunner) runCommand(workDir, command string) *TestResult {
+func (tr *TestRunner) runCommand(workDir, command string, profile *sandbox.Profile) *TestResult {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "sh", "-c", command)
-	cmd.Dir = workDir
+	cmd, cleanup, err := sandbox.Command(ctx, profile, workDir, "sh", "-c", command)
+	if err != nil {
+		return &TestResult{Passed: false, Output: err.Error()}
+	}
+	defer cleanup()
 
 	output, err := cmd.CombinedOutput()
 	passed := err == nil
 
 	return &TestResult{
 		Passed: passed,
-		Output: string(output),
+		Output: redact.String(string(output)),
 	}
 }
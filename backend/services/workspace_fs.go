@@ -0,0 +1,237 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// WorkspaceFS is the only way App-level code touches files in a task workspace.
+// Every path is resolved (including symlinks) and must stay inside the root;
+// .git internals and .gitignore'd files are off limits, writes honour the
+// agent's protected/read-only paths, and writes are atomic.
+type WorkspaceFS struct {
+	root  string // symlink-resolved absolute root
+	guard *PathGuard
+	git   bool
+}
+
+// NewWorkspaceFS opens a workspace rooted at dir. guard may be nil.
+func NewWorkspaceFS(dir string, guard *PathGuard) (*WorkspaceFS, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("task has no workspace")
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("resolve workspace: %w", err)
+	}
+	root, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, fmt.Errorf("resolve workspace: %w", err)
+	}
+	return &WorkspaceFS{root: root, guard: guard, git: hasGit(root)}, nil
+}
+
+// Root returns the resolved workspace directory.
+func (w *WorkspaceFS) Root() string {
+	return w.root
+}
+
+// Resolve validates a workspace-relative path and returns its absolute,
+// symlink-resolved location and its clean relative form (slash-separated).
+func (w *WorkspaceFS) Resolve(relPath string) (string, string, error) {
+	if relPath == "" || strings.ContainsRune(relPath, 0) {
+		return "", "", fmt.Errorf("invalid path %q", relPath)
+	}
+	if filepath.IsAbs(relPath) || strings.HasPrefix(relPath, "/") || filepath.VolumeName(relPath) != "" {
+		return "", "", fmt.Errorf("path %q must be relative to the workspace", relPath)
+	}
+	clean := filepath.Clean(filepath.FromSlash(relPath))
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", "", fmt.Errorf("path %q is outside the workspace", relPath)
+	}
+
+	resolved, err := resolveExisting(filepath.Join(w.root, clean))
+	if err != nil {
+		return "", "", fmt.Errorf("resolve %s: %w", relPath, err)
+	}
+	rel, err := filepath.Rel(w.root, resolved)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", "", fmt.Errorf("path %q is outside the workspace", relPath)
+	}
+	rel = filepath.ToSlash(rel)
+	for _, p := range []string{filepath.ToSlash(clean), rel} {
+		if p == ".git" || strings.HasPrefix(p, ".git/") || strings.Contains(p, "/.git/") {
+			return "", "", fmt.Errorf("path %q is inside the git directory", relPath)
+		}
+	}
+	return resolved, rel, nil
+}
+
+// resolveExisting resolves symlinks in the longest existing prefix of path and
+// appends the remaining (not yet existing) components.
+func resolveExisting(path string) (string, error) {
+	var rest []string
+	cur := path
+	for {
+		resolved, err := filepath.EvalSymlinks(cur)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(cur)
+		if parent == cur {
+			return "", err
+		}
+		rest = append([]string{filepath.Base(cur)}, rest...)
+		cur = parent
+	}
+}
+
+// IsIgnored reports whether git ignores the path (false outside git repos).
+func (w *WorkspaceFS) IsIgnored(relPath string) bool {
+	if !w.git {
+		return false
+	}
+	cmd := exec.Command("git", "check-ignore", "-q", "--", relPath)
+	cmd.Dir = w.root
+	return cmd.Run() == nil
+}
+
+// ReadFile reads a workspace file, returning at most limit bytes (0 = no limit).
+func (w *WorkspaceFS) ReadFile(relPath string, limit int) ([]byte, error) {
+	full, rel, err := w.Resolve(relPath)
+	if err != nil {
+		return nil, err
+	}
+	if w.IsIgnored(rel) {
+		return nil, fmt.Errorf("%s is ignored by .gitignore", rel)
+	}
+	f, err := os.Open(full)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", rel)
+	}
+	size := info.Size()
+	if limit > 0 && size > int64(limit) {
+		size = int64(limit)
+	}
+	buf := make([]byte, size)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	return buf[:n], nil
+}
+
+// WriteFile atomically replaces (or creates) a workspace file: the content is
+// written to a temp file in the same directory, synced and renamed into place.
+func (w *WorkspaceFS) WriteFile(relPath string, data []byte) error {
+	full, rel, err := w.Resolve(relPath)
+	if err != nil {
+		return err
+	}
+	if rule, pattern, ok := w.guard.Match(rel); ok {
+		return fmt.Errorf("%s is %s (%s) for this task's agent", rel, strings.ReplaceAll(rule, "_", "-"), pattern)
+	}
+	if w.IsIgnored(rel) {
+		return fmt.Errorf("%s is ignored by .gitignore", rel)
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(full); err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("%s is a directory", rel)
+		}
+		mode = info.Mode().Perm()
+	}
+	dir := filepath.Dir(full)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(full)+".shannon-*")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
+	if err := os.Chmod(tmpName, mode); err != nil {
+		return fmt.Errorf("chmod: %w", err)
+	}
+	if err := os.Rename(tmpName, full); err != nil {
+		return fmt.Errorf("replace file: %w", err)
+	}
+	return nil
+}
+
+// noiseDirs are skipped when listing a workspace that isn't a git repository.
+var noiseDirs = map[string]bool{".git": true, "node_modules": true, ".next": true, "__pycache__": true, "vendor": true, ".venv": true}
+
+// ListFiles returns the workspace's files (slash-separated, relative). In git
+// repositories this is tracked plus untracked-but-not-ignored files.
+func (w *WorkspaceFS) ListFiles() ([]string, error) {
+	if w.git {
+		cmd := exec.Command("git", "ls-files", "--cached", "--others", "--exclude-standard", "-z")
+		cmd.Dir = w.root
+		out, err := cmd.Output()
+		if err == nil {
+			var files []string
+			seen := make(map[string]bool)
+			for _, f := range bytes.Split(out, []byte{0}) {
+				name := string(f)
+				if name == "" || seen[name] {
+					continue
+				}
+				// Skip index entries whose file was deleted from the working tree
+				if _, err := os.Lstat(filepath.Join(w.root, name)); err != nil {
+					continue
+				}
+				seen[name] = true
+				files = append(files, name)
+			}
+			return files, nil
+		}
+	}
+
+	var files []string
+	err := filepath.Walk(w.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // skip errors
+		}
+		if info.IsDir() && noiseDirs[info.Name()] {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() {
+			rel, _ := filepath.Rel(w.root, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	return files, err
+}
@@ -2,9 +2,11 @@ package store
 
 import (
 	"agent-workflow/backend/models"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type AgentStore struct {
@@ -27,7 +29,8 @@ func (s *AgentStore) Create(a *models.Agent) error {
 	}
 	a.CreatedAt = time.Now()
 	a.UpdatedAt = time.Now()
-	return s.db.Create(a).Error
+	return s.db.auditedSave(models.AuditActionCreate, models.AuditEntityAgent, a.ID, fmt.Sprintf("created agent %q", a.Name),
+		nil, func(tx *gorm.DB) error { return tx.Create(a).Error }, a)
 }
 
 func (s *AgentStore) GetByID(id string) (*models.Agent, error) {
@@ -39,37 +42,33 @@ func (s *AgentStore) GetByID(id string) (*models.Agent, error) {
 }
 
 func (s *AgentStore) List() ([]models.Agent, error) {
-	var agents []models.Agent
-	if err := s.db.Order("created_at DESC").Find(&agents).Error; err != nil {
-		return nil, err
-	}
-	return agents, nil
+	return listAll[models.Agent](s.db, agentList, ListQuery{})
+}
+
+// Query returns a page of agents matching q.
+func (s *AgentStore) Query(q ListQuery) (*ListPage, error) {
+	return listPage(s.db, agentList, q, func(x models.Agent) string { return x.ID })
 }
 
 func (s *AgentStore) ListPaginated(page, pageSize int) (*models.PaginatedResponse, error) {
-	if page < 1 {
-		page = 1
-	}
-	if pageSize < 1 {
-		pageSize = 10
-	}
-	var total int64
-	if err := s.db.Model(&models.Agent{}).Count(&total).Error; err != nil {
-		return nil, err
-	}
-	var agents []models.Agent
-	offset := (page - 1) * pageSize
-	if err := s.db.Order("created_at DESC").Offset(offset).Limit(pageSize).Find(&agents).Error; err != nil {
-		return nil, err
-	}
-	return models.NewPaginatedResponse(agents, total, page, pageSize), nil
+	return listPaginated[models.Agent](s.db, agentList, ListQuery{}, page, pageSize)
 }
 
 func (s *AgentStore) Update(a *models.Agent) error {
 	a.UpdatedAt = time.Now()
-	return s.db.Save(a).Error
+	return s.db.auditedSave(models.AuditActionUpdate, models.AuditEntityAgent, a.ID, fmt.Sprintf("updated agent %q", a.Name),
+		auditLoad[models.Agent](a.ID), func(tx *gorm.DB) error { return tx.Save(a).Error }, a)
 }
 
+// Delete removes the agent and drops it from every team. Its permission rules
+// are deleted and tasks and attempts referencing it are unassigned by the
+// foreign keys; callers decide whether active tasks allow that.
 func (s *AgentStore) Delete(id string) error {
-	return s.db.Delete(&models.Agent{}, "id = ?", id).Error
+	return s.db.auditedSave(models.AuditActionDelete, models.AuditEntityAgent, id, "deleted agent",
+		auditLoad[models.Agent](id), func(tx *gorm.DB) error {
+			if _, err := pruneAgentFromTeams(tx, id); err != nil {
+				return err
+			}
+			return tx.Delete(&models.Agent{}, "id = ?", id).Error
+		}, nil)
 }
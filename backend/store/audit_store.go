@@ -0,0 +1,141 @@
+package store
+
+import (
+	"agent-workflow/backend/models"
+	"agent-workflow/backend/redact"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AuditStore writes and queries the audit log. Entries are never updated or
+// deleted; triggers created with the schema reject both.
+type AuditStore struct {
+	db *DB
+}
+
+func NewAuditStore(db *DB) *AuditStore {
+	return &AuditStore{db: db}
+}
+
+// AuditFilter selects audit events. Zero fields match everything.
+type AuditFilter struct {
+	Actor      string     `json:"actor,omitempty"`
+	Action     string     `json:"action,omitempty"`
+	EntityType string     `json:"entity_type,omitempty"`
+	EntityID   string     `json:"entity_id,omitempty"`
+	Query      string     `json:"query,omitempty"` // substring of the summary
+	Since      *time.Time `json:"since,omitempty"`
+	Until      *time.Time `json:"until,omitempty"`
+	Page       int        `json:"page"`
+	PageSize   int        `json:"page_size"`
+}
+
+// Record appends an event. before and after are snapshotted as JSON (nil for
+// none) with secrets masked.
+func (s *AuditStore) Record(actor, action, entityType, entityID, summary string, before, after any) error {
+	if actor == "" {
+		actor = s.db.AuditActor()
+	}
+	return recordAudit(s.db.DB, actor, action, entityType, entityID, summary, before, after)
+}
+
+// List returns matching events, newest first.
+func (s *AuditStore) List(f AuditFilter) (*models.PaginatedResponse, error) {
+	if f.Page < 1 {
+		f.Page = 1
+	}
+	if f.PageSize < 1 {
+		f.PageSize = 50
+	}
+	q, err := s.db.filtered(auditList, ListQuery{Text: f.Query, Since: f.Since, Until: f.Until})
+	if err != nil {
+		return nil, err
+	}
+	if f.Actor != "" {
+		q = q.Where("actor = ?", f.Actor)
+	}
+	if f.Action != "" {
+		q = q.Where("action = ?", f.Action)
+	}
+	if f.EntityType != "" {
+		q = q.Where("entity_type = ?", f.EntityType)
+	}
+	if f.EntityID != "" {
+		q = q.Where("entity_id = ?", f.EntityID)
+	}
+	return paginate[models.AuditEvent](q, "created_at DESC, rowid DESC", f.Page, f.PageSize)
+}
+
+// recordAudit appends an event using tx, so stores can log a change in the
+// same transaction that makes it.
+func recordAudit(tx *gorm.DB, actor, action, entityType, entityID, summary string, before, after any) error {
+	ev := models.AuditEvent{
+		ID:         uuid.New().String(),
+		Actor:      actor,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Summary:    redact.String(summary),
+		CreatedAt:  time.Now(),
+	}
+	var err error
+	if ev.Before, err = auditSnapshot(before); err != nil {
+		return err
+	}
+	if ev.After, err = auditSnapshot(after); err != nil {
+		return err
+	}
+	if err := tx.Create(&ev).Error; err != nil {
+		return fmt.Errorf("record audit event: %w", err)
+	}
+	return nil
+}
+
+func auditSnapshot(v any) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("audit snapshot: %w", err)
+	}
+	return redact.String(string(data)), nil
+}
+
+// auditedSave runs save and records the change in one transaction. load reads
+// the current row (nil before a create) for the before snapshot.
+func (d *DB) auditedSave(action, entityType, entityID, summary string, load func(tx *gorm.DB) (any, error), save func(tx *gorm.DB) error, after any) error {
+	return d.Transaction(func(tx *gorm.DB) error {
+		var before any
+		if load != nil {
+			current, err := load(tx)
+			switch {
+			case err == nil:
+				before = current
+			case !errors.Is(err, gorm.ErrRecordNotFound):
+				return err
+			}
+		}
+		if err := save(tx); err != nil {
+			return err
+		}
+		return recordAudit(tx, d.AuditActor(), action, entityType, entityID, summary, before, after)
+	})
+}
+
+// auditLoad returns a load function for auditedSave that reads the row of
+// type T with the given ID.
+func auditLoad[T any](id string) func(tx *gorm.DB) (any, error) {
+	return func(tx *gorm.DB) (any, error) {
+		var row T
+		if err := tx.First(&row, "id = ?", id).Error; err != nil {
+			return nil, err
+		}
+		return &row, nil
+	}
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// BackupTo writes a consistent snapshot of the database to path with SQLite's
+// online backup API, which includes committed WAL content and does not block
+// writers for the whole copy.
+func (d *DB) BackupTo(path string) error {
+	ctx := context.Background()
+	sqlDB, err := d.DB.DB()
+	if err != nil {
+		return err
+	}
+	src, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("source connection: %w", err)
+	}
+	defer src.Close()
+
+	dstDB, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("open backup file: %w", err)
+	}
+	defer dstDB.Close()
+	dst, err := dstDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("backup connection: %w", err)
+	}
+	defer dst.Close()
+
+	return dst.Raw(func(dc any) error {
+		return src.Raw(func(sc any) error {
+			dstConn, ok1 := dc.(*sqlite3.SQLiteConn)
+			srcConn, ok2 := sc.(*sqlite3.SQLiteConn)
+			if !ok1 || !ok2 {
+				return errors.New("backup needs the sqlite3 driver")
+			}
+			bk, err := dstConn.Backup("main", srcConn, "main")
+			if err != nil {
+				return fmt.Errorf("start backup: %w", err)
+			}
+			for {
+				done, err := bk.Step(1024)
+				if err != nil {
+					bk.Finish()
+					return fmt.Errorf("backup step: %w", err)
+				}
+				if done {
+					break
+				}
+			}
+			return bk.Finish()
+		})
+	})
+}
+
+// FileSchemaVersion opens the database file at path read-only, checks it,
+// and returns its schema version (0 if it was never migrated).
+func FileSchemaVersion(path string) (int, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var check string
+	if err := db.QueryRow("PRAGMA quick_check").Scan(&check); err != nil {
+		return 0, fmt.Errorf("not a readable database: %w", err)
+	}
+	if check != "ok" {
+		return 0, fmt.Errorf("database is damaged: %s", check)
+	}
+	var hasTable int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'").Scan(&hasTable); err != nil {
+		return 0, err
+	}
+	if hasTable == 0 {
+		return 0, nil
+	}
+	var version int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return version, nil
+}
@@ -0,0 +1,353 @@
+package store
+
+import (
+	"agent-workflow/backend/models"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// IntegrityIssue is one problem found by CheckIntegrity.
+type IntegrityIssue struct {
+	Kind     string `json:"kind"` // "corruption", "foreign_key" or "reference"
+	Table    string `json:"table"`
+	Detail   string `json:"detail"`
+	Count    int64  `json:"count"`
+	Repaired bool   `json:"repaired"`
+}
+
+// IntegrityReport is the result of CheckIntegrity.
+type IntegrityReport struct {
+	OK     bool             `json:"ok"` // no issues left after the check (and repair, if requested)
+	Issues []IntegrityIssue `json:"issues"`
+}
+
+// referenceRepair fixes one kind of dangling row reference.
+type referenceRepair struct {
+	table  string
+	detail string
+	count  string
+	fix    string
+}
+
+// referenceRepairs run in order, so rows orphaned by an earlier delete are
+// caught by the later ones.
+var referenceRepairs = []referenceRepair{
+	{"sessions", "sessions of deleted projects",
+		"SELECT COUNT(*) FROM sessions WHERE project_id IS NULL OR project_id NOT IN (SELECT id FROM projects)",
+		"DELETE FROM sessions WHERE project_id IS NULL OR project_id NOT IN (SELECT id FROM projects)"},
+	{"tasks", "tasks of deleted sessions",
+		"SELECT COUNT(*) FROM tasks WHERE session_id IS NULL OR session_id NOT IN (SELECT id FROM sessions)",
+		"DELETE FROM tasks WHERE session_id IS NULL OR session_id NOT IN (SELECT id FROM sessions)"},
+	{"tasks", "tasks assigned to deleted agents",
+		"SELECT COUNT(*) FROM tasks WHERE agent_id = '' OR agent_id NOT IN (SELECT id FROM agents)",
+		"UPDATE tasks SET agent_id = NULL WHERE agent_id = '' OR agent_id NOT IN (SELECT id FROM agents)"},
+	{"tasks", "tasks assigned to deleted teams",
+		"SELECT COUNT(*) FROM tasks WHERE team_id = '' OR team_id NOT IN (SELECT id FROM teams)",
+		"UPDATE tasks SET team_id = NULL WHERE team_id = '' OR team_id NOT IN (SELECT id FROM teams)"},
+	{"task_attempts", "attempts of deleted tasks",
+		"SELECT COUNT(*) FROM task_attempts WHERE task_id IS NULL OR task_id NOT IN (SELECT id FROM tasks)",
+		"DELETE FROM task_attempts WHERE task_id IS NULL OR task_id NOT IN (SELECT id FROM tasks)"},
+	{"task_attempts", "attempts by deleted agents",
+		"SELECT COUNT(*) FROM task_attempts WHERE agent_id = '' OR agent_id NOT IN (SELECT id FROM agents)",
+		"UPDATE task_attempts SET agent_id = NULL WHERE agent_id = '' OR agent_id NOT IN (SELECT id FROM agents)"},
+	{"task_messages", "messages of deleted tasks",
+		"SELECT COUNT(*) FROM task_messages WHERE task_id IS NULL OR task_id NOT IN (SELECT id FROM tasks)",
+		"DELETE FROM task_messages WHERE task_id IS NULL OR task_id NOT IN (SELECT id FROM tasks)"},
+	{"agent_permission_rules", "permission rules of deleted agents",
+		"SELECT COUNT(*) FROM agent_permission_rules WHERE agent_id IS NULL OR agent_id NOT IN (SELECT id FROM agents)",
+		"DELETE FROM agent_permission_rules WHERE agent_id IS NULL OR agent_id NOT IN (SELECT id FROM agents)"},
+}
+
+// laterReferenceRepairs cover tables added after migration 2, which runs
+// referenceRepairs before those tables exist.
+var laterReferenceRepairs = []referenceRepair{
+	{"task_stream_events", "stream events of deleted tasks",
+		"SELECT COUNT(*) FROM task_stream_events WHERE task_id IS NULL OR task_id NOT IN (SELECT id FROM tasks)",
+		"DELETE FROM task_stream_events WHERE task_id IS NULL OR task_id NOT IN (SELECT id FROM tasks)"},
+	{"task_comparisons", "comparisons of deleted tasks",
+		"SELECT COUNT(*) FROM task_comparisons WHERE task_id IS NULL OR task_id NOT IN (SELECT id FROM tasks)",
+		"DELETE FROM task_comparisons WHERE task_id IS NULL OR task_id NOT IN (SELECT id FROM tasks)"},
+	{"task_comparisons", "comparisons judged by deleted agents",
+		"SELECT COUNT(*) FROM task_comparisons WHERE judge_agent_id IS NOT NULL AND judge_agent_id NOT IN (SELECT id FROM agents)",
+		"UPDATE task_comparisons SET judge_agent_id = NULL WHERE judge_agent_id IS NOT NULL AND judge_agent_id NOT IN (SELECT id FROM agents)"},
+	{"tasks", "tasks reviewed by deleted agents",
+		"SELECT COUNT(*) FROM tasks WHERE reviewer_agent_id IS NOT NULL AND reviewer_agent_id NOT IN (SELECT id FROM agents)",
+		"UPDATE tasks SET reviewer_agent_id = NULL WHERE reviewer_agent_id IS NOT NULL AND reviewer_agent_id NOT IN (SELECT id FROM agents)"},
+	{"review_comments", "review comments of deleted tasks",
+		"SELECT COUNT(*) FROM review_comments WHERE task_id IS NULL OR task_id NOT IN (SELECT id FROM tasks)",
+		"DELETE FROM review_comments WHERE task_id IS NULL OR task_id NOT IN (SELECT id FROM tasks)"},
+	{"review_comments", "review comments by deleted agents",
+		"SELECT COUNT(*) FROM review_comments WHERE reviewer_agent_id IS NOT NULL AND reviewer_agent_id NOT IN (SELECT id FROM agents)",
+		"UPDATE review_comments SET reviewer_agent_id = NULL WHERE reviewer_agent_id IS NOT NULL AND reviewer_agent_id NOT IN (SELECT id FROM agents)"},
+	{"sessions", "sessions reviewed by deleted agents",
+		"SELECT COUNT(*) FROM sessions WHERE code_reviewer_id IS NOT NULL AND code_reviewer_id NOT IN (SELECT id FROM agents)",
+		"UPDATE sessions SET code_reviewer_id = NULL WHERE code_reviewer_id IS NOT NULL AND code_reviewer_id NOT IN (SELECT id FROM agents)"},
+	{"teams", "teams reviewed by deleted agents",
+		"SELECT COUNT(*) FROM teams WHERE code_reviewer_id IS NOT NULL AND code_reviewer_id NOT IN (SELECT id FROM agents)",
+		"UPDATE teams SET code_reviewer_id = NULL WHERE code_reviewer_id IS NOT NULL AND code_reviewer_id NOT IN (SELECT id FROM agents)"},
+	{"task_attempts", "attempts of deleted comparisons",
+		"SELECT COUNT(*) FROM task_attempts WHERE comparison_id IS NOT NULL AND comparison_id NOT IN (SELECT id FROM task_comparisons)",
+		"UPDATE task_attempts SET comparison_id = NULL WHERE comparison_id IS NOT NULL AND comparison_id NOT IN (SELECT id FROM task_comparisons)"},
+}
+
+// repairReferences applies referenceRepairs and returns what it changed.
+func repairReferences(tx *gorm.DB) ([]IntegrityIssue, error) {
+	return applyRepairs(tx, referenceRepairs)
+}
+
+func applyRepairs(tx *gorm.DB, repairs []referenceRepair) ([]IntegrityIssue, error) {
+	var issues []IntegrityIssue
+	for _, r := range repairs {
+		res := tx.Exec(r.fix)
+		if res.Error != nil {
+			return issues, fmt.Errorf("repair %s: %w", r.detail, res.Error)
+		}
+		if res.RowsAffected > 0 {
+			issues = append(issues, IntegrityIssue{Kind: "reference", Table: r.table, Detail: r.detail, Count: res.RowsAffected, Repaired: true})
+		}
+	}
+	return issues, nil
+}
+
+// findReferences counts the rows the repairs would change.
+func findReferences(tx *gorm.DB, repairs []referenceRepair) ([]IntegrityIssue, error) {
+	var issues []IntegrityIssue
+	for _, r := range repairs {
+		var n int64
+		if err := tx.Raw(r.count).Scan(&n).Error; err != nil {
+			return nil, fmt.Errorf("check %s: %w", r.detail, err)
+		}
+		if n > 0 {
+			issues = append(issues, IntegrityIssue{Kind: "reference", Table: r.table, Detail: r.detail, Count: n})
+		}
+	}
+	return issues, nil
+}
+
+// pruneAgentFromTeams removes an agent from every team's agent list, canvas
+// nodes and edges.
+func pruneAgentFromTeams(tx *gorm.DB, agentID string) (int64, error) {
+	return pruneTeams(tx, func(id string) bool { return id == agentID })
+}
+
+// pruneTeams removes agents for which gone returns true from every team and
+// returns the number of teams changed.
+func pruneTeams(tx *gorm.DB, gone func(agentID string) bool) (int64, error) {
+	var teams []models.Team
+	if err := tx.Find(&teams).Error; err != nil {
+		return 0, err
+	}
+	var changed int64
+	for i := range teams {
+		t := &teams[i]
+		ids := models.StringSlice{}
+		for _, id := range t.AgentIDs {
+			if !gone(id) {
+				ids = append(ids, id)
+			}
+		}
+		nodes := models.NodeSlice{}
+		for _, n := range t.Nodes {
+			if !gone(n.AgentID) {
+				nodes = append(nodes, n)
+			}
+		}
+		edges := models.EdgeSlice{}
+		for _, e := range t.Edges {
+			if !gone(e.Source) && !gone(e.Target) {
+				edges = append(edges, e)
+			}
+		}
+		if len(ids) == len(t.AgentIDs) && len(nodes) == len(t.Nodes) && len(edges) == len(t.Edges) {
+			continue
+		}
+		err := tx.Model(&models.Team{}).Where("id = ?", t.ID).
+			Updates(map[string]any{"agent_ids": ids, "nodes": nodes, "edges": edges}).Error
+		if err != nil {
+			return changed, fmt.Errorf("update team %s: %w", t.ID, err)
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// pruneMCPServerFromAgents removes a server from every agent's MCPServerIDs.
+func pruneMCPServerFromAgents(tx *gorm.DB, serverID string) (int64, error) {
+	return pruneAgentServers(tx, func(id string) bool { return id == serverID })
+}
+
+// pruneAgentServers removes servers for which gone returns true from every
+// agent and returns the number of agents changed.
+func pruneAgentServers(tx *gorm.DB, gone func(serverID string) bool) (int64, error) {
+	var agents []models.Agent
+	if err := tx.Select("id", "mcp_server_ids").Find(&agents).Error; err != nil {
+		return 0, err
+	}
+	var changed int64
+	for _, a := range agents {
+		ids := models.StringSlice{}
+		for _, id := range a.MCPServerIDs {
+			if !gone(id) {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == len(a.MCPServerIDs) {
+			continue
+		}
+		if err := tx.Model(&models.Agent{}).Where("id = ?", a.ID).Update("mcp_server_ids", ids).Error; err != nil {
+			return changed, fmt.Errorf("update agent %s: %w", a.ID, err)
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// idSet returns the IDs of all rows of model.
+func idSet(tx *gorm.DB, model any) (map[string]bool, error) {
+	var ids []string
+	if err := tx.Model(model).Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return set, nil
+}
+
+// CheckIntegrity runs SQLite's integrity and foreign key checks and looks for
+// dangling references, including IDs kept in JSON columns (team agents,
+// agent MCP servers). With repair, dangling references are removed in one
+// transaction; corruption is only reported.
+func (d *DB) CheckIntegrity(repair bool) (*IntegrityReport, error) {
+	report := &IntegrityReport{}
+
+	var results []string
+	if err := d.Raw("PRAGMA integrity_check").Scan(&results).Error; err != nil {
+		return nil, fmt.Errorf("integrity check: %w", err)
+	}
+	for _, r := range results {
+		if r != "ok" {
+			report.Issues = append(report.Issues, IntegrityIssue{Kind: "corruption", Detail: r, Count: 1})
+		}
+	}
+
+	err := d.Transaction(func(tx *gorm.DB) error {
+		agentIDs, err := idSet(tx, &models.Agent{})
+		if err != nil {
+			return err
+		}
+		serverIDs, err := idSet(tx, &models.MCPServer{})
+		if err != nil {
+			return err
+		}
+		missingAgent := func(id string) bool { return !agentIDs[id] }
+		missingServer := func(id string) bool { return !serverIDs[id] }
+
+		repairs := append(append([]referenceRepair{}, referenceRepairs...), laterReferenceRepairs...)
+		var issues []IntegrityIssue
+		if repair {
+			if issues, err = applyRepairs(tx, repairs); err != nil {
+				return err
+			}
+			teams, err := pruneTeams(tx, missingAgent)
+			if err != nil {
+				return err
+			}
+			if teams > 0 {
+				issues = append(issues, IntegrityIssue{Kind: "reference", Table: "teams", Detail: "teams listing deleted agents", Count: teams, Repaired: true})
+			}
+			agents, err := pruneAgentServers(tx, missingServer)
+			if err != nil {
+				return err
+			}
+			if agents > 0 {
+				issues = append(issues, IntegrityIssue{Kind: "reference", Table: "agents", Detail: "agents using deleted MCP servers", Count: agents, Repaired: true})
+			}
+		} else {
+			if issues, err = findReferences(tx, repairs); err != nil {
+				return err
+			}
+			if n, err := countTeamsWith(tx, missingAgent); err != nil {
+				return err
+			} else if n > 0 {
+				issues = append(issues, IntegrityIssue{Kind: "reference", Table: "teams", Detail: "teams listing deleted agents", Count: n})
+			}
+			if n, err := countAgentsWith(tx, missingServer); err != nil {
+				return err
+			} else if n > 0 {
+				issues = append(issues, IntegrityIssue{Kind: "reference", Table: "agents", Detail: "agents using deleted MCP servers", Count: n})
+			}
+		}
+		report.Issues = append(report.Issues, issues...)
+
+		var violations []struct {
+			Table  string
+			Parent string
+		}
+		if err := tx.Raw("SELECT `table`, parent FROM pragma_foreign_key_check").Scan(&violations).Error; err != nil {
+			return fmt.Errorf("foreign key check: %w", err)
+		}
+		counts := make(map[string]int64)
+		for _, v := range violations {
+			counts[v.Table+" → "+v.Parent]++
+		}
+		for rel, n := range counts {
+			report.Issues = append(report.Issues, IntegrityIssue{Kind: "foreign_key", Detail: rel, Count: n})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report.OK = true
+	for _, issue := range report.Issues {
+		if !issue.Repaired {
+			report.OK = false
+		}
+	}
+	return report, nil
+}
+
+func countTeamsWith(tx *gorm.DB, gone func(agentID string) bool) (int64, error) {
+	var teams []models.Team
+	if err := tx.Find(&teams).Error; err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, t := range teams {
+		found := false
+		for _, id := range t.AgentIDs {
+			found = found || gone(id)
+		}
+		for _, node := range t.Nodes {
+			found = found || gone(node.AgentID)
+		}
+		for _, e := range t.Edges {
+			found = found || gone(e.Source) || gone(e.Target)
+		}
+		if found {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func countAgentsWith(tx *gorm.DB, gone func(serverID string) bool) (int64, error) {
+	var agents []models.Agent
+	if err := tx.Select("id", "mcp_server_ids").Find(&agents).Error; err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, a := range agents {
+		for _, id := range a.MCPServerIDs {
+			if gone(id) {
+				n++
+				break
+			}
+		}
+	}
+	return n, nil
+}
@@ -2,9 +2,11 @@ package store
 
 import (
 	"agent-workflow/backend/models"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type MCPServerStore struct {
@@ -21,7 +23,8 @@ func (s *MCPServerStore) Create(m *models.MCPServer) error {
 	}
 	m.CreatedAt = time.Now()
 	m.UpdatedAt = time.Now()
-	return s.db.Create(m).Error
+	return s.db.auditedSave(models.AuditActionCreate, models.AuditEntityMCPServer, m.ID, fmt.Sprintf("created MCP server %q", m.ServerKey),
+		nil, func(tx *gorm.DB) error { return tx.Create(m).Error }, m)
 }
 
 func (s *MCPServerStore) GetByID(id string) (*models.MCPServer, error) {
@@ -33,11 +36,12 @@ func (s *MCPServerStore) GetByID(id string) (*models.MCPServer, error) {
 }
 
 func (s *MCPServerStore) List() ([]models.MCPServer, error) {
-	var servers []models.MCPServer
-	if err := s.db.Order("created_at DESC").Find(&servers).Error; err != nil {
-		return nil, err
-	}
-	return servers, nil
+	return listAll[models.MCPServer](s.db, mcpServerList, ListQuery{})
+}
+
+// Query returns a page of servers matching q.
+func (s *MCPServerStore) Query(q ListQuery) (*ListPage, error) {
+	return listPage(s.db, mcpServerList, q, func(x models.MCPServer) string { return x.ID })
 }
 
 func (s *MCPServerStore) ListEnabled() ([]models.MCPServer, error) {
@@ -61,9 +65,17 @@ func (s *MCPServerStore) ListByIDs(ids []string) ([]models.MCPServer, error) {
 
 func (s *MCPServerStore) Update(m *models.MCPServer) error {
 	m.UpdatedAt = time.Now()
-	return s.db.Save(m).Error
+	return s.db.auditedSave(models.AuditActionUpdate, models.AuditEntityMCPServer, m.ID, fmt.Sprintf("updated MCP server %q", m.ServerKey),
+		auditLoad[models.MCPServer](m.ID), func(tx *gorm.DB) error { return tx.Save(m).Error }, m)
 }
 
+// Delete removes the server and drops it from every agent's server list.
 func (s *MCPServerStore) Delete(id string) error {
-	return s.db.Delete(&models.MCPServer{}, "id = ?", id).Error
+	return s.db.auditedSave(models.AuditActionDelete, models.AuditEntityMCPServer, id, "deleted MCP server",
+		auditLoad[models.MCPServer](id), func(tx *gorm.DB) error {
+			if _, err := pruneMCPServerFromAgents(tx, id); err != nil {
+				return err
+			}
+			return tx.Delete(&models.MCPServer{}, "id = ?", id).Error
+		}, nil)
 }
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migration is one numbered schema change. Each runs in its own transaction
+// together with the schema_version row that records it.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB) error
+
+	// rebuildsTables turns foreign key enforcement off while the migration
+	// runs, as SQLite requires for recreating a referenced table. Violations
+	// are checked before the transaction commits.
+	rebuildsTables bool
+}
+
+// migrations are applied in order. Append new ones; never edit or renumber a
+// migration that has shipped.
+var migrations = []migration{
+	{1, "baseline schema", migrateBaseline, false},
+	{2, "foreign keys", migrateForeignKeys, true},
+	{3, "full-text search", migrateSearch, false},
+	{4, "list indexes", migrateListIndexes, false},
+	{5, "session forks", migrateSessionForks, false},
+	{6, "task comparisons", migrateComparisons, false},
+	{7, "acceptance review", migrateAcceptance, false},
+	{8, "code review", migrateCodeReview, false},
+	{9, "pipeline stages", migratePipelineStages, false},
+	{10, "unique attempt numbers", migrateUniqueAttemptNumbers, false},
+	{11, "attempt path violations", migrateAttemptViolations, false},
+	{12, "task pipeline stage", migrateTaskPipelineStage, false},
+	{13, "fts4 search index", migrateSearchFTS4, false},
+}
+
+// ErrSchemaTooNew is returned when the database was migrated by a newer build.
+var ErrSchemaTooNew = errors.New("database schema is newer than this build supports")
+
+// schemaVersionRow records an applied migration.
+type schemaVersionRow struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaVersionRow) TableName() string { return "schema_version" }
+
+// LatestSchemaVersion is the schema version this build migrates to.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaVersion returns the highest migration applied to db (0 for none).
+func SchemaVersion(db *gorm.DB) (int, error) {
+	var version int
+	err := db.Raw("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version).Error
+	if err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// migrate brings db up to LatestSchemaVersion. When migrations are pending on
+// a database that already has data, the file is first copied into
+// backupDir. A database newer than this build is refused.
+func migrate(db *gorm.DB, backupDir string) error {
+	if err := db.Exec("CREATE TABLE IF NOT EXISTS `schema_version` (`version` integer PRIMARY KEY, `name` text, `applied_at` datetime)").Error; err != nil {
+		return fmt.Errorf("create schema_version: %w", err)
+	}
+	current, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+	latest := LatestSchemaVersion()
+	if current > latest {
+		return fmt.Errorf("%w: database is at version %d, this build knows up to %d", ErrSchemaTooNew, current, latest)
+	}
+	if current == latest {
+		return nil
+	}
+
+	hasData, err := hasUserTables(db)
+	if err != nil {
+		return err
+	}
+	if hasData {
+		path, err := backupBeforeMigrate(db, backupDir, current)
+		if err != nil {
+			return fmt.Errorf("back up database before migrating: %w", err)
+		}
+		log.Printf("database backed up to %s before migrating from schema version %d", path, current)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+		}
+		log.Printf("database migrated to schema version %d (%s)", m.version, m.name)
+	}
+	return nil
+}
+
+// applyMigration runs m and records it in one transaction, on a single
+// connection so the foreign key pragma applies to it.
+func applyMigration(db *gorm.DB, m migration) error {
+	return db.Connection(func(conn *gorm.DB) error {
+		if m.rebuildsTables {
+			if err := conn.Exec("PRAGMA foreign_keys=OFF").Error; err != nil {
+				return err
+			}
+			defer conn.Exec("PRAGMA foreign_keys=ON")
+		}
+		return conn.Transaction(func(tx *gorm.DB) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			if m.rebuildsTables {
+				var violations int64
+				if err := tx.Raw("SELECT COUNT(*) FROM pragma_foreign_key_check").Scan(&violations).Error; err != nil {
+					return fmt.Errorf("foreign key check: %w", err)
+				}
+				if violations > 0 {
+					return fmt.Errorf("%d rows violate foreign keys", violations)
+				}
+			}
+			return tx.Create(&schemaVersionRow{Version: m.version, Name: m.name, AppliedAt: time.Now()}).Error
+		})
+	})
+}
+
+// hasUserTables reports whether the database holds any table besides schema_version.
+func hasUserTables(db *gorm.DB) (bool, error) {
+	var n int64
+	err := db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'schema_version'").Scan(&n).Error
+	if err != nil {
+		return false, fmt.Errorf("inspect database: %w", err)
+	}
+	return n > 0, nil
+}
+
+// backupBeforeMigrate writes a consistent copy of the database (including
+// WAL contents) with VACUUM INTO and returns its path.
+func backupBeforeMigrate(db *gorm.DB, backupDir string, version int) (string, error) {
+	if err := os.MkdirAll(backupDir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(backupDir, fmt.Sprintf("agent-workflow-v%d-premigrate-%s.db", version, time.Now().Format("20060102-150405")))
+	if err := db.Exec("VACUUM INTO ?", path).Error; err != nil {
+		return "", err
+	}
+	return path, nil
+}
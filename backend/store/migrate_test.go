@@ -0,0 +1,195 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openRaw opens the database file of dataDir without migrating it.
+func openRaw(t *testing.T, dataDir string) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dataDir, "agent-workflow.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func execAll(t *testing.T, db *gorm.DB, stmts ...string) {
+	t.Helper()
+	for _, stmt := range stmts {
+		if err := db.Exec(stmt).Error; err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+}
+
+// schemaColumns maps every table of db to its sorted column names.
+func schemaColumns(t *testing.T, db *gorm.DB) map[string][]string {
+	t.Helper()
+	var tables []string
+	if err := db.Raw("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name NOT LIKE 'search_index_%'").Scan(&tables).Error; err != nil {
+		t.Fatal(err)
+	}
+	cols := make(map[string][]string, len(tables))
+	for _, table := range tables {
+		var names []string
+		if err := db.Raw("SELECT name FROM pragma_table_info(?)", table).Scan(&names).Error; err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(names)
+		cols[table] = names
+	}
+	return cols
+}
+
+func closeDB(t *testing.T, db *DB) {
+	t.Helper()
+	if sqlDB, err := db.DB.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
+
+func TestMigrateFromBaselineSchema(t *testing.T) {
+	dir := t.TempDir()
+	schema, err := os.ReadFile(filepath.Join("testdata", "baseline_schema.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := openRaw(t, dir)
+	for _, stmt := range strings.Split(string(schema), ";\n") {
+		if strings.TrimSpace(stmt) != "" {
+			execAll(t, raw, stmt)
+		}
+	}
+	// Rows as the baseline build wrote them: empty strings for unset
+	// references, and orphans it never cleaned up
+	execAll(t, raw,
+		"INSERT INTO projects (id, name, path) VALUES ('p1', 'Project', '/tmp/project')",
+		"INSERT INTO agents (id, name, system_prompt) VALUES ('a1', 'Refactorer', 'You rewrite legacy parsers')",
+		"INSERT INTO teams (id, name, agent_ids, edges) VALUES ('team1', 'Team', '[\"a1\"]', '[]')",
+		"INSERT INTO sessions (id, project_id, name) VALUES ('s1', 'p1', 'Session')",
+		"INSERT INTO tasks (id, session_id, title, prompt, agent_id, team_id, dependencies) VALUES ('t1', 's1', 'Fix the tokenizer', 'p', 'a1', 'team1', '[]')",
+		"INSERT INTO tasks (id, session_id, title, prompt, agent_id, team_id, dependencies) VALUES ('t2', 's1', 'Unassigned', 'p', '', '', '[]')",
+		"INSERT INTO tasks (id, session_id, title, prompt, agent_id, team_id, dependencies) VALUES ('t3', 's1', 'Gone agent', 'p', 'deleted', 'deleted', '[]')",
+		"INSERT INTO tasks (id, session_id, title, prompt, agent_id, team_id, dependencies) VALUES ('t4', 'deleted', 'Orphan', 'p', 'a1', '', '[]')",
+	)
+
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("migrate baseline database: %v", err)
+	}
+	defer closeDB(t, db)
+
+	if v, err := SchemaVersion(db.DB); err != nil || v != LatestSchemaVersion() {
+		t.Fatalf("schema version = %d (%v), want %d", v, err, LatestSchemaVersion())
+	}
+	backups, _ := filepath.Glob(filepath.Join(dir, "backups", "*-premigrate-*.db"))
+	if len(backups) != 1 {
+		t.Errorf("expected one pre-migration backup, found %v", backups)
+	}
+
+	// The migrated schema matches a fresh database
+	fresh, err := NewDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeDB(t, fresh)
+	if got, want := schemaColumns(t, db.DB), schemaColumns(t, fresh.DB); !reflect.DeepEqual(got, want) {
+		t.Errorf("migrated schema differs from a fresh one:\n got %v\nwant %v", got, want)
+	}
+
+	// Orphans were repaired: cleared references, deleted rows without a parent
+	type ref struct {
+		ID      string
+		AgentID *string
+		TeamID  *string
+	}
+	var tasks []ref
+	if err := db.Raw("SELECT id, agent_id, team_id FROM tasks ORDER BY id").Scan(&tasks).Error; err != nil {
+		t.Fatal(err)
+	}
+	str := func(p *string) string {
+		if p == nil {
+			return "NULL"
+		}
+		return *p
+	}
+	var got []string
+	for _, r := range tasks {
+		got = append(got, r.ID+":"+str(r.AgentID)+":"+str(r.TeamID))
+	}
+	if want := []string{"t1:a1:team1", "t2:NULL:NULL", "t3:NULL:NULL"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tasks after migration = %v, want %v", got, want)
+	}
+	report, err := db.CheckIntegrity(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) > 0 {
+		t.Errorf("integrity issues after migration: %+v", report.Issues)
+	}
+
+	// Existing rows were indexed for search
+	search := NewSearchStore(db)
+	for query, kind := range map[string]string{"tokenizer": "task", "parsers": "agent"} {
+		hits, err := search.Search(SearchQuery{Query: query})
+		if err != nil {
+			t.Fatalf("search %q: %v", query, err)
+		}
+		if len(hits) != 1 || hits[0].Kind != kind {
+			t.Errorf("search %q = %+v, want one %s", query, hits, kind)
+		}
+	}
+
+	// Foreign keys are enforced: deleting the session takes its tasks along
+	execAll(t, db.DB, "DELETE FROM sessions WHERE id = 's1'")
+	var left int64
+	db.Raw("SELECT COUNT(*) FROM tasks").Scan(&left)
+	if left != 0 {
+		t.Errorf("%d tasks left after deleting their session", left)
+	}
+	closeDB(t, db)
+
+	// Opening again applies nothing
+	again, err := NewDB(dir)
+	if err != nil {
+		t.Fatalf("reopen migrated database: %v", err)
+	}
+	defer closeDB(t, again)
+	backups, _ = filepath.Glob(filepath.Join(dir, "backups", "*-premigrate-*.db"))
+	if len(backups) != 1 {
+		t.Errorf("reopening a migrated database made another backup: %v", backups)
+	}
+}
+
+func TestMigrateRefusesNewerSchema(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeDB(t, db)
+
+	raw := openRaw(t, dir)
+	if err := raw.Exec("INSERT INTO schema_version (version, name) VALUES (?, 'from the future')", LatestSchemaVersion()+1).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDB(dir); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("opening a newer database: err = %v, want ErrSchemaTooNew", err)
+	}
+}
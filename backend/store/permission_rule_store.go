@@ -0,0 +1,36 @@
+package store
+
+import (
+	"agent-workflow/backend/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PermissionRuleStore struct {
+	db *DB
+}
+
+func NewPermissionRuleStore(db *DB) *PermissionRuleStore {
+	return &PermissionRuleStore{db: db}
+}
+
+func (s *PermissionRuleStore) Create(r *models.AgentPermissionRule) error {
+	if r.ID == "" {
+		r.ID = uuid.New().String()
+	}
+	r.CreatedAt = time.Now()
+	return s.db.Create(r).Error
+}
+
+func (s *PermissionRuleStore) ListByAgent(agentID string) ([]models.AgentPermissionRule, error) {
+	return listAll[models.AgentPermissionRule](s.db, permissionRuleList, ListQuery{AgentID: agentID})
+}
+
+func (s *PermissionRuleStore) Delete(id string) error {
+	return s.db.Delete(&models.AgentPermissionRule{}, "id = ?", id).Error
+}
+
+func (s *PermissionRuleStore) DeleteByAgent(agentID string) error {
+	return s.db.Delete(&models.AgentPermissionRule{}, "agent_id = ?", agentID).Error
+}
@@ -2,9 +2,11 @@ package store
 
 import (
 	"agent-workflow/backend/models"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type ProjectStore struct {
@@ -21,7 +23,8 @@ func (s *ProjectStore) Create(p *models.Project) error {
 	}
 	p.CreatedAt = time.Now()
 	p.UpdatedAt = time.Now()
-	return s.db.Create(p).Error
+	return s.db.auditedSave(models.AuditActionCreate, models.AuditEntityProject, p.ID, fmt.Sprintf("created project %q", p.Name),
+		nil, func(tx *gorm.DB) error { return tx.Create(p).Error }, p)
 }
 
 func (s *ProjectStore) GetByID(id string) (*models.Project, error) {
@@ -33,37 +36,25 @@ func (s *ProjectStore) GetByID(id string) (*models.Project, error) {
 }
 
 func (s *ProjectStore) List() ([]models.Project, error) {
-	var projects []models.Project
-	if err := s.db.Order("created_at DESC").Find(&projects).Error; err != nil {
-		return nil, err
-	}
-	return projects, nil
+	return listAll[models.Project](s.db, projectList, ListQuery{})
+}
+
+// Query returns a page of projects matching q.
+func (s *ProjectStore) Query(q ListQuery) (*ListPage, error) {
+	return listPage(s.db, projectList, q, func(x models.Project) string { return x.ID })
 }
 
 func (s *ProjectStore) ListPaginated(page, pageSize int) (*models.PaginatedResponse, error) {
-	if page < 1 {
-		page = 1
-	}
-	if pageSize < 1 {
-		pageSize = 10
-	}
-	var total int64
-	if err := s.db.Model(&models.Project{}).Count(&total).Error; err != nil {
-		return nil, err
-	}
-	var projects []models.Project
-	offset := (page - 1) * pageSize
-	if err := s.db.Order("created_at DESC").Offset(offset).Limit(pageSize).Find(&projects).Error; err != nil {
-		return nil, err
-	}
-	return models.NewPaginatedResponse(projects, total, page, pageSize), nil
+	return listPaginated[models.Project](s.db, projectList, ListQuery{}, page, pageSize)
 }
 
 func (s *ProjectStore) Update(p *models.Project) error {
 	p.UpdatedAt = time.Now()
-	return s.db.Save(p).Error
+	return s.db.auditedSave(models.AuditActionUpdate, models.AuditEntityProject, p.ID, fmt.Sprintf("updated project %q", p.Name),
+		auditLoad[models.Project](p.ID), func(tx *gorm.DB) error { return tx.Save(p).Error }, p)
 }
 
 func (s *ProjectStore) Delete(id string) error {
-	return s.db.Delete(&models.Project{}, "id = ?", id).Error
+	return s.db.auditedSave(models.AuditActionDelete, models.AuditEntityProject, id, "deleted project",
+		auditLoad[models.Project](id), func(tx *gorm.DB) error { return tx.Delete(&models.Project{}, "id = ?", id).Error }, nil)
 }
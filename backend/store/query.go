@@ -0,0 +1,389 @@
+package store
+
+import (
+	"agent-workflow/backend/models"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ListQuery is the filter, sort and pagination spec shared by the list APIs.
+// Zero fields match everything. Filtering on something the listed entity
+// does not have (e.g. agents by project) is an error.
+type ListQuery struct {
+	Status       []string   `json:"status,omitempty"`
+	ProjectID    string     `json:"project_id,omitempty"`
+	SessionID    string     `json:"session_id,omitempty"`
+	TaskID       string     `json:"task_id,omitempty"`
+	AgentID      string     `json:"agent_id,omitempty"`
+	TeamID       string     `json:"team_id,omitempty"`
+	ComparisonID string     `json:"comparison_id,omitempty"`
+	Since        *time.Time `json:"since,omitempty"`      // created at or after
+	Until        *time.Time `json:"until,omitempty"`      // created before
+	Text         string     `json:"text,omitempty"`       // case-insensitive substring of the entity's text fields
+	Sort         string     `json:"sort,omitempty"`       // one of the entity's sortable fields; empty for its default
+	Order        string     `json:"order,omitempty"`      // "asc" or "desc"; empty for the entity's default
+	Cursor       string     `json:"cursor,omitempty"`     // NextCursor of the previous page; the other fields must not change
+	Limit        int        `json:"limit,omitempty"`      // page size, default 50, at most 500
+	WithTotal    bool       `json:"with_total,omitempty"` // also count all matches, which costs a query
+}
+
+// ListPage is one page of a cursor-paginated list.
+type ListPage struct {
+	Items      any    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"` // empty on the last page
+	TotalCount int64  `json:"total_count"`           // -1 unless WithTotal was set
+}
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// List query filters, keys of listSpec.filters.
+const (
+	filterStatus     = "status"
+	filterProject    = "project"
+	filterSession    = "session"
+	filterTask       = "task"
+	filterAgent      = "agent"
+	filterTeam       = "team"
+	filterComparison = "comparison"
+)
+
+// listSpec describes how ListQuery applies to one table.
+type listSpec struct {
+	table   string
+	filters map[string]string // filter -> condition with one placeholder
+	text    []string          // columns searched by Text
+	sorts   map[string]string // sort field -> column; columns must not be NULL
+	sort    string            // default sort field
+	desc    bool              // default order
+	dated   bool              // Since/Until apply to created_at
+}
+
+var (
+	projectList = listSpec{
+		table: "projects",
+		text:  []string{"name", "path"},
+		sorts: map[string]string{"created_at": "created_at", "updated_at": "updated_at", "name": "name"},
+		sort:  "created_at", desc: true, dated: true,
+	}
+	agentList = listSpec{
+		table: "agents",
+		text:  []string{"name", "description", "model"},
+		sorts: map[string]string{"created_at": "created_at", "updated_at": "updated_at", "name": "name", "model": "model"},
+		sort:  "created_at", desc: true, dated: true,
+	}
+	teamList = listSpec{
+		table:   "teams",
+		filters: map[string]string{filterAgent: "EXISTS (SELECT 1 FROM json_each(teams.agent_ids) WHERE json_each.value = ?)"},
+		text:    []string{"name", "description"},
+		sorts:   map[string]string{"created_at": "created_at", "updated_at": "updated_at", "name": "name"},
+		sort:    "created_at", desc: true, dated: true,
+	}
+	mcpServerList = listSpec{
+		table:   "mcp_servers",
+		filters: map[string]string{filterAgent: "id IN (SELECT json_each.value FROM agents, json_each(agents.mcp_server_ids) WHERE agents.id = ?)"},
+		text:    []string{"name", "server_key", "description", "command"},
+		sorts:   map[string]string{"created_at": "created_at", "updated_at": "updated_at", "name": "name", "server_key": "server_key"},
+		sort:    "created_at", desc: true, dated: true,
+	}
+	sessionList = listSpec{
+		table: "sessions",
+		filters: map[string]string{
+			filterStatus:  "status IN ?",
+			filterProject: "project_id = ?",
+		},
+		text:  []string{"name"},
+		sorts: map[string]string{"created_at": "created_at", "name": "name", "status": "status"},
+		sort:  "created_at", desc: true, dated: true,
+	}
+	taskList = listSpec{
+		table: "tasks",
+		filters: map[string]string{
+			filterStatus:  "status IN ?",
+			filterProject: "session_id IN (SELECT id FROM sessions WHERE project_id = ?)",
+			filterSession: "session_id = ?",
+			filterAgent:   "agent_id = ?",
+			filterTeam:    "team_id = ?",
+		},
+		text:  []string{"title", "prompt", "result_text", "error"},
+		sorts: map[string]string{"created_at": "created_at", "title": "title", "status": "status"},
+		sort:  "created_at", desc: true, dated: true,
+	}
+	attemptList = listSpec{
+		table: "task_attempts",
+		filters: map[string]string{
+			filterStatus:     "status IN ?",
+			filterTask:       "task_id = ?",
+			filterAgent:      "agent_id = ?",
+			filterComparison: "comparison_id = ?",
+		},
+		text:  []string{"prompt", "result_text", "error"},
+		sorts: map[string]string{"number": "number", "started_at": "started_at"},
+		sort:  "number",
+	}
+	messageList = listSpec{
+		table:   "task_messages",
+		filters: map[string]string{filterTask: "task_id = ?"},
+		text:    []string{"content"},
+		sorts:   map[string]string{"created_at": "created_at"},
+		sort:    "created_at", dated: true,
+	}
+	permissionRuleList = listSpec{
+		table:   "agent_permission_rules",
+		filters: map[string]string{filterAgent: "agent_id = ?"},
+		text:    []string{"pattern"},
+		sorts:   map[string]string{"created_at": "created_at", "pattern": "pattern"},
+		sort:    "created_at", dated: true,
+	}
+	auditList = listSpec{
+		table: "audit_events",
+		text:  []string{"summary"},
+		sorts: map[string]string{"created_at": "created_at"},
+		sort:  "created_at", desc: true, dated: true,
+	}
+)
+
+// sortable returns the fields ListQuery.Sort accepts, for error messages.
+func (s listSpec) sortable() []string {
+	names := make([]string, 0, len(s.sorts))
+	for name := range s.sorts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// filtered applies q's filters (not the cursor) to a query on spec.table.
+func (d *DB) filtered(spec listSpec, q ListQuery) (*gorm.DB, error) {
+	tx := d.Table(spec.table)
+	values := map[string]any{
+		filterProject:    q.ProjectID,
+		filterSession:    q.SessionID,
+		filterTask:       q.TaskID,
+		filterAgent:      q.AgentID,
+		filterTeam:       q.TeamID,
+		filterComparison: q.ComparisonID,
+	}
+	if len(q.Status) > 0 {
+		values[filterStatus] = q.Status
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := values[name]
+		if s, ok := v.(string); ok && s == "" {
+			continue
+		}
+		cond, ok := spec.filters[name]
+		if !ok {
+			return nil, fmt.Errorf("%s cannot be filtered by %s", spec.table, name)
+		}
+		tx = tx.Where(cond, v)
+	}
+
+	if q.Since != nil || q.Until != nil {
+		if !spec.dated {
+			return nil, fmt.Errorf("%s cannot be filtered by date", spec.table)
+		}
+		if q.Since != nil {
+			tx = tx.Where("created_at >= ?", *q.Since)
+		}
+		if q.Until != nil {
+			tx = tx.Where("created_at < ?", *q.Until)
+		}
+	}
+
+	if text := strings.TrimSpace(q.Text); text != "" {
+		pattern := "%" + escapeLike(text) + "%"
+		conds := make([]string, len(spec.text))
+		args := make([]any, len(spec.text))
+		for i, col := range spec.text {
+			conds[i] = fmt.Sprintf("`%s` LIKE ? ESCAPE '\\'", col)
+			args[i] = pattern
+		}
+		tx = tx.Where("("+strings.Join(conds, " OR ")+")", args...)
+	}
+	return tx, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// ordering resolves q's sort field and direction against spec.
+func ordering(spec listSpec, q ListQuery) (column string, desc bool, err error) {
+	field := q.Sort
+	if field == "" {
+		field = spec.sort
+	}
+	column, ok := spec.sorts[field]
+	if !ok {
+		return "", false, fmt.Errorf("%s cannot be sorted by %q (use one of %s)", spec.table, field, strings.Join(spec.sortable(), ", "))
+	}
+	switch strings.ToLower(q.Order) {
+	case "":
+		desc = spec.desc
+	case "asc":
+	case "desc":
+		desc = true
+	default:
+		return "", false, fmt.Errorf("unknown sort order %q", q.Order)
+	}
+	return column, desc, nil
+}
+
+func orderClause(column string, desc bool) string {
+	dir := "ASC"
+	if desc {
+		dir = "DESC"
+	}
+	return fmt.Sprintf("`%s` %s, `id` %s", column, dir, dir)
+}
+
+// listCursor marks the last row of a page: its sort value, as stored, and ID.
+type listCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+func encodeCursor(c listCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (listCursor, error) {
+	var c listCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	return c, nil
+}
+
+// listAll returns every row matching q, in q's order, without paging.
+func listAll[T any](d *DB, spec listSpec, q ListQuery) ([]T, error) {
+	tx, err := d.filtered(spec, q)
+	if err != nil {
+		return nil, err
+	}
+	column, desc, err := ordering(spec, q)
+	if err != nil {
+		return nil, err
+	}
+	var items []T
+	if err := tx.Order(orderClause(column, desc)).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// listPage returns one cursor-paginated page. The cursor holds the last row's
+// sort column as stored text, so the keyset comparison matches SQLite's own
+// ordering and can use the column's index.
+func listPage[T any](d *DB, spec listSpec, q ListQuery, id func(T) string) (*ListPage, error) {
+	tx, err := d.filtered(spec, q)
+	if err != nil {
+		return nil, err
+	}
+	column, desc, err := ordering(spec, q)
+	if err != nil {
+		return nil, err
+	}
+	limit := q.Limit
+	if limit < 1 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	page := &ListPage{TotalCount: -1}
+	if q.WithTotal {
+		if err := tx.Session(&gorm.Session{}).Count(&page.TotalCount).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if c.Sort != column {
+			return nil, fmt.Errorf("cursor is for a different sort order")
+		}
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		tx = tx.Where(fmt.Sprintf("(`%[1]s` %[2]s ? OR (`%[1]s` = ? AND `id` %[2]s ?))", column, op), c.Value, c.Value, c.ID)
+	}
+
+	var items []T
+	if err := tx.Order(orderClause(column, desc)).Limit(limit + 1).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	if len(items) > limit {
+		items = items[:limit]
+		lastID := id(items[len(items)-1])
+		var value string
+		err := d.Table(spec.table).Select(fmt.Sprintf("CAST(`%s` AS TEXT)", column)).Where("id = ?", lastID).Scan(&value).Error
+		if err != nil {
+			return nil, err
+		}
+		page.NextCursor = encodeCursor(listCursor{Sort: column, Value: value, ID: lastID})
+	}
+	if items == nil {
+		items = []T{}
+	}
+	page.Items = items
+	return page, nil
+}
+
+// listPaginated returns a page by number, for the older page-based APIs.
+func listPaginated[T any](d *DB, spec listSpec, q ListQuery, page, pageSize int) (*models.PaginatedResponse, error) {
+	tx, err := d.filtered(spec, q)
+	if err != nil {
+		return nil, err
+	}
+	column, desc, err := ordering(spec, q)
+	if err != nil {
+		return nil, err
+	}
+	return paginate[T](tx, orderClause(column, desc), page, pageSize)
+}
+
+// paginate counts the rows of tx and returns the requested page.
+func paginate[T any](tx *gorm.DB, order string, page, pageSize int) (*models.PaginatedResponse, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 {
+		pageSize = 10
+	}
+	var total int64
+	if err := tx.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, err
+	}
+	var items []T
+	offset := (page - 1) * pageSize
+	if err := tx.Order(order).Offset(offset).Limit(pageSize).Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return models.NewPaginatedResponse(items, total, page, pageSize), nil
+}
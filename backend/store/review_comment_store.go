@@ -0,0 +1,38 @@
+package store
+
+import (
+	"agent-workflow/backend/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ReviewCommentStore struct {
+	db *DB
+}
+
+func NewReviewCommentStore(db *DB) *ReviewCommentStore {
+	return &ReviewCommentStore{db: db}
+}
+
+// CreateBatch stores the comments of one review.
+func (s *ReviewCommentStore) CreateBatch(comments []models.ReviewComment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+	now := time.Now()
+	for i := range comments {
+		if comments[i].ID == "" {
+			comments[i].ID = uuid.New().String()
+		}
+		comments[i].CreatedAt = now
+	}
+	return s.db.CreateInBatches(comments, 100).Error
+}
+
+// ListByTask returns a task's review comments by round, file and hunk.
+func (s *ReviewCommentStore) ListByTask(taskID string) ([]models.ReviewComment, error) {
+	var comments []models.ReviewComment
+	err := s.db.Where("task_id = ?", taskID).Order("round, file_path, hunk_index, rowid").Find(&comments).Error
+	return comments, err
+}
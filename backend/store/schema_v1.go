@@ -0,0 +1,203 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// columnSpec is one column of a baseline table.
+type columnSpec struct {
+	name string
+	def  string // type and default, as in CREATE TABLE
+}
+
+// tableSpec is a table of the baseline schema.
+type tableSpec struct {
+	name    string
+	columns []columnSpec
+}
+
+// baselineTables is the schema as AutoMigrate left it before versioned
+// migrations existed. Migration 1 creates it on a new database and adds any
+// missing columns to a database made by an older build. Later schema changes
+// belong in their own migration, never here.
+var baselineTables = []tableSpec{
+	{"projects", []columnSpec{
+		{"id", "text"}, {"name", "text"}, {"path", "text"}, {"test_command", "text"}, {"build_command", "text"},
+		{"setup_commands", "text"}, {"claude_md", "text"}, {"env", "text"}, {"secret_rules", "text"},
+		{"created_at", "datetime"}, {"updated_at", "datetime"},
+	}},
+	{"agents", []columnSpec{
+		{"id", "text"}, {"name", "text"}, {"description", "text"}, {"model", "text"}, {"system_prompt", "text"},
+		{"allowed_tools", "text"}, {"disallowed_tools", "text"}, {"mcp_server_ids", "text"}, {"permissions", "text"},
+		{"protected_paths", "text"}, {"read_only_paths", "text"}, {"max_retries", "integer DEFAULT 0"},
+		{"violation_action", "text DEFAULT 'fail'"}, {"sandbox", "text"}, {"env", "text"},
+		{"created_at", "datetime"}, {"updated_at", "datetime"},
+	}},
+	{"teams", []columnSpec{
+		{"id", "text"}, {"name", "text"}, {"description", "text"}, {"agent_ids", "text"}, {"strategy", "text"},
+		{"nodes", "text"}, {"edges", "text"}, {"created_at", "datetime"}, {"updated_at", "datetime"},
+	}},
+	{"sessions", []columnSpec{
+		{"id", "text"}, {"project_id", "text"}, {"name", "text"}, {"status", "text DEFAULT 'planning'"},
+		{"created_at", "datetime"}, {"started_at", "datetime"}, {"completed_at", "datetime"},
+	}},
+	{"tasks", []columnSpec{
+		{"id", "text"}, {"session_id", "text"}, {"title", "text"}, {"prompt", "text"}, {"original_prompt", "text"},
+		{"status", "text DEFAULT 'pending'"}, {"agent_id", "text"}, {"team_id", "text"}, {"dependencies", "text"},
+		{"workspace_path", "text"}, {"mcp_config_path", "text"}, {"env", "text"}, {"claude_session_id", "text"},
+		{"max_retries", "integer DEFAULT 0"}, {"retry_count", "integer DEFAULT 0"}, {"resume_count", "integer DEFAULT 0"},
+		{"exit_code", "integer"}, {"result_text", "text"}, {"files_changed", "text"}, {"pending_input_data", "text"},
+		{"path_violations", "text"}, {"secret_findings", "text"}, {"test_passed", "numeric"}, {"test_output", "text"},
+		{"build_passed", "numeric"}, {"build_output", "text"}, {"created_at", "datetime"}, {"started_at", "datetime"},
+		{"completed_at", "datetime"}, {"error", "text"},
+	}},
+	{"mcp_servers", []columnSpec{
+		{"id", "text"}, {"name", "text"}, {"server_key", "text"}, {"description", "text"}, {"command", "text"},
+		{"args", "text"}, {"env", "text"}, {"enabled", "numeric DEFAULT true"},
+		{"created_at", "datetime"}, {"updated_at", "datetime"},
+	}},
+	{"task_attempts", []columnSpec{
+		{"id", "text"}, {"task_id", "text"}, {"number", "integer"}, {"kind", "text"}, {"prompt", "text"},
+		{"agent_id", "text"}, {"agent_name", "text"}, {"model", "text"}, {"claude_session_id", "text"},
+		{"status", "text"}, {"exit_code", "integer"}, {"result_text", "text"}, {"error", "text"},
+		{"files_changed", "text"}, {"diff_snapshot", "text"}, {"test_passed", "numeric"}, {"test_output", "text"},
+		{"build_passed", "numeric"}, {"build_output", "text"}, {"input_tokens", "integer"}, {"output_tokens", "integer"},
+		{"cache_read_tokens", "integer"}, {"cache_creation_tokens", "integer"}, {"cost_usd", "real"},
+		{"num_turns", "integer"}, {"started_at", "datetime"}, {"completed_at", "datetime"}, {"duration_ms", "integer"},
+	}},
+	{"task_messages", []columnSpec{
+		{"id", "text"}, {"task_id", "text"}, {"attempt_id", "text"}, {"role", "text"}, {"mode", "text"},
+		{"content", "text"}, {"is_error", "numeric"}, {"created_at", "datetime"},
+	}},
+	{"agent_permission_rules", []columnSpec{
+		{"id", "text"}, {"agent_id", "text"}, {"pattern", "text"}, {"decision", "text"}, {"created_at", "datetime"},
+	}},
+	{"audit_events", []columnSpec{
+		{"id", "text"}, {"actor", "text"}, {"action", "text"}, {"entity_type", "text"}, {"entity_id", "text"},
+		{"summary", "text"}, {"before", "text"}, {"after", "text"}, {"created_at", "datetime"},
+	}},
+}
+
+// baselineIndexes are the indexes declared in GORM tags plus the dashboard
+// indexes earlier builds created with raw SQL.
+var baselineIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS `idx_sessions_project_id` ON `sessions`(`project_id`)",
+	"CREATE INDEX IF NOT EXISTS `idx_session_project_created` ON `sessions`(`project_id`,`created_at`)",
+	"CREATE INDEX IF NOT EXISTS `idx_session_status` ON `sessions`(`status`)",
+	"CREATE INDEX IF NOT EXISTS `idx_session_created` ON `sessions`(`created_at`)",
+	"CREATE INDEX IF NOT EXISTS `idx_tasks_session_id` ON `tasks`(`session_id`)",
+	"CREATE INDEX IF NOT EXISTS `idx_tasks_status` ON `tasks`(`status`)",
+	"CREATE INDEX IF NOT EXISTS `idx_task_session_status` ON `tasks`(`session_id`,`status`)",
+	"CREATE INDEX IF NOT EXISTS `idx_task_agent_id` ON `tasks`(`agent_id`)",
+	"CREATE INDEX IF NOT EXISTS `idx_task_team_id` ON `tasks`(`team_id`)",
+	"CREATE INDEX IF NOT EXISTS `idx_task_completed_at` ON `tasks`(`completed_at`)",
+	"CREATE INDEX IF NOT EXISTS `idx_task_test_passed` ON `tasks`(`test_passed`)",
+	"CREATE INDEX IF NOT EXISTS `idx_task_build_passed` ON `tasks`(`build_passed`)",
+	"CREATE INDEX IF NOT EXISTS `idx_task_session_test_build` ON `tasks`(`session_id`,`test_passed`,`build_passed`)",
+	"CREATE INDEX IF NOT EXISTS `idx_task_agent_status` ON `tasks`(`agent_id`,`status`)",
+	"CREATE INDEX IF NOT EXISTS `idx_task_completed_status` ON `tasks`(`completed_at`,`status`)",
+	"CREATE INDEX IF NOT EXISTS `idx_agent_model` ON `agents`(`model`)",
+	"CREATE INDEX IF NOT EXISTS `idx_task_attempts_task_id` ON `task_attempts`(`task_id`)",
+	"CREATE INDEX IF NOT EXISTS `idx_attempt_task_number` ON `task_attempts`(`task_id`,`number`)",
+	"CREATE INDEX IF NOT EXISTS `idx_task_messages_task_id` ON `task_messages`(`task_id`)",
+	"CREATE INDEX IF NOT EXISTS `idx_agent_permission_rules_agent_id` ON `agent_permission_rules`(`agent_id`)",
+	"CREATE INDEX IF NOT EXISTS `idx_audit_events_actor` ON `audit_events`(`actor`)",
+	"CREATE INDEX IF NOT EXISTS `idx_audit_events_action` ON `audit_events`(`action`)",
+	"CREATE INDEX IF NOT EXISTS `idx_audit_events_entity_type` ON `audit_events`(`entity_type`)",
+	"CREATE INDEX IF NOT EXISTS `idx_audit_events_entity_id` ON `audit_events`(`entity_id`)",
+	"CREATE INDEX IF NOT EXISTS `idx_audit_events_created_at` ON `audit_events`(`created_at`)",
+}
+
+// legacyIndexes were created by earlier builds in forms (partial, unquoted)
+// that are replaced by baselineIndexes.
+var legacyIndexes = []string{
+	"idx_task_trend_covering",
+	"idx_task_agent_perf",
+	"idx_task_running",
+	"idx_task_files_changed",
+	"idx_task_team_count",
+	"idx_task_session_status_cover",
+	"idx_task_session_test_build",
+	"idx_task_agent_status",
+	"idx_task_completed_status",
+	"idx_task_agent_id",
+	"idx_task_team_id",
+	"idx_task_completed_at",
+	"idx_task_test_passed",
+	"idx_task_build_passed",
+	"idx_session_status",
+	"idx_session_created",
+	"idx_agent_model",
+	"idx_task_session_created",
+	"idx_task_status_completed",
+}
+
+// auditTriggers make the audit log append-only.
+var auditTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END`,
+	`CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events BEGIN SELECT RAISE(ABORT, 'audit log is append-only'); END`,
+}
+
+// migrateBaseline is migration 1.
+func migrateBaseline(tx *gorm.DB) error {
+	for _, t := range baselineTables {
+		if err := ensureTable(tx, t); err != nil {
+			return err
+		}
+	}
+	for _, name := range legacyIndexes {
+		if err := tx.Exec("DROP INDEX IF EXISTS " + name).Error; err != nil {
+			return fmt.Errorf("drop index %s: %w", name, err)
+		}
+	}
+	for _, ddl := range append(append([]string{}, baselineIndexes...), auditTriggers...) {
+		if err := tx.Exec(ddl).Error; err != nil {
+			return fmt.Errorf("%s: %w", ddl, err)
+		}
+	}
+	return nil
+}
+
+// ensureTable creates t, or adds the columns an existing table lacks.
+func ensureTable(tx *gorm.DB, t tableSpec) error {
+	existing, err := tableColumns(tx, t.name)
+	if err != nil {
+		return err
+	}
+	if len(existing) == 0 {
+		defs := make([]string, 0, len(t.columns)+1)
+		for _, c := range t.columns {
+			defs = append(defs, fmt.Sprintf("`%s` %s", c.name, c.def))
+		}
+		defs = append(defs, "PRIMARY KEY (`id`)")
+		if err := tx.Exec(fmt.Sprintf("CREATE TABLE `%s` (%s)", t.name, strings.Join(defs, ","))).Error; err != nil {
+			return fmt.Errorf("create table %s: %w", t.name, err)
+		}
+		return nil
+	}
+	for _, c := range t.columns {
+		if existing[c.name] {
+			continue
+		}
+		if err := tx.Exec(fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `%s` %s", t.name, c.name, c.def)).Error; err != nil {
+			return fmt.Errorf("add column %s.%s: %w", t.name, c.name, err)
+		}
+	}
+	return nil
+}
+
+// tableColumns returns the column names of a table (empty if it does not exist).
+func tableColumns(tx *gorm.DB, table string) (map[string]bool, error) {
+	var cols []struct{ Name string }
+	if err := tx.Raw("SELECT name FROM pragma_table_info(?)", table).Scan(&cols).Error; err != nil {
+		return nil, fmt.Errorf("inspect table %s: %w", table, err)
+	}
+	names := make(map[string]bool, len(cols))
+	for _, c := range cols {
+		names[c.Name] = true
+	}
+	return names, nil
+}
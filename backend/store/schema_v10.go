@@ -0,0 +1,27 @@
+package store
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// migrateUniqueAttemptNumbers is migration 10: attempt numbers are unique per
+// task. Attempts that were given the same number by concurrent runs are
+// renumbered in the order they started first.
+func migrateUniqueAttemptNumbers(tx *gorm.DB) error {
+	ddl := []string{
+		"UPDATE `task_attempts` SET `number` = (SELECT r.rn FROM (SELECT `id`, ROW_NUMBER() OVER " +
+			"(PARTITION BY `task_id` ORDER BY `number`, `started_at`, `id`) AS rn FROM `task_attempts`) r " +
+			"WHERE r.`id` = `task_attempts`.`id`) " +
+			"WHERE `task_id` IN (SELECT `task_id` FROM `task_attempts` GROUP BY `task_id`, `number` HAVING COUNT(*) > 1)",
+		"DROP INDEX IF EXISTS `idx_attempt_task_number`",
+		"CREATE UNIQUE INDEX IF NOT EXISTS `idx_attempt_task_number` ON `task_attempts`(`task_id`,`number`)",
+	}
+	for _, stmt := range ddl {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+package store
+
+import "gorm.io/gorm"
+
+// migrateAttemptViolations is migration 11: the guarded paths a comparison
+// variant changed.
+func migrateAttemptViolations(tx *gorm.DB) error {
+	return ensureTable(tx, tableSpec{"task_attempts", []columnSpec{{"path_violations", "text"}}})
+}
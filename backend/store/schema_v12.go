@@ -0,0 +1,9 @@
+package store
+
+import "gorm.io/gorm"
+
+// migrateTaskPipelineStage is migration 12: the pipeline stage a task is
+// waiting for input in.
+func migrateTaskPipelineStage(tx *gorm.DB) error {
+	return ensureTable(tx, tableSpec{"tasks", []columnSpec{{"pipeline_stage", "integer DEFAULT 0"}}})
+}
@@ -0,0 +1,40 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// migrateSearchFTS4 is migration 13: every build indexes with FTS4, so an
+// index an FTS5 build created is dropped and rebuilt from search_docs.
+// Dropping it needs the FTS5 module; a build without it asks for the
+// database to be opened once by one that has it.
+func migrateSearchFTS4(tx *gorm.DB) error {
+	var ddl string
+	if err := tx.Raw("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'search_index'").Scan(&ddl).Error; err != nil {
+		return fmt.Errorf("inspect search index: %w", err)
+	}
+	if !strings.Contains(strings.ToLower(ddl), "fts5") {
+		return nil
+	}
+	if err := tx.Exec("DROP TABLE `search_index`").Error; err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			return fmt.Errorf("the search index was built with FTS5, which this build lacks; open the database once with a build tagged sqlite_fts5, such as the desktop app, to convert it: %w", err)
+		}
+		return fmt.Errorf("drop search index: %w", err)
+	}
+	stmts := []string{createSearchIndex}
+	for _, src := range searchSourcesV3 {
+		for _, f := range src.fields {
+			stmts = append(stmts, searchBackfill(src, f))
+		}
+	}
+	for _, stmt := range stmts {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("rebuild search index: %w", err)
+		}
+	}
+	return nil
+}
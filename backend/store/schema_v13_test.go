@@ -0,0 +1,60 @@
+package store
+
+import (
+	"strings"
+	"testing"
+
+	"agent-workflow/backend/models"
+)
+
+func TestMigrateSearchFTS4ConvertsFTS5Index(t *testing.T) {
+	dir := t.TempDir()
+	db, err := NewDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent := &models.Agent{Name: "Refactorer", SystemPrompt: "You rewrite legacy parsers"}
+	if err := NewAgentStore(db).Create(agent); err != nil {
+		t.Fatal(err)
+	}
+
+	// Put the database back the way an FTS5 build left it before migration 13
+	if err := db.Exec("DROP TABLE `search_index`").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("CREATE VIRTUAL TABLE `search_index` USING fts5(body, tokenize = 'porter unicode61')").Error; err != nil {
+		closeDB(t, db)
+		if strings.Contains(err.Error(), "no such module") {
+			t.Skip("this build has no FTS5; run with -tags sqlite_fts5")
+		}
+		t.Fatal(err)
+	}
+	src := searchSourcesV3[1]
+	execAll(t, db.DB,
+		searchBackfill(src, "name"),
+		searchBackfill(src, "system_prompt"),
+		"DELETE FROM schema_version WHERE version = 13",
+	)
+	closeDB(t, db)
+
+	db, err = NewDB(dir)
+	if err != nil {
+		t.Fatalf("convert FTS5 index: %v", err)
+	}
+	defer closeDB(t, db)
+
+	var ddl string
+	if err := db.Raw("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'search_index'").Scan(&ddl).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(strings.ToLower(ddl), "fts4") {
+		t.Fatalf("search index after migration: %s", ddl)
+	}
+	hits, err := NewSearchStore(db).Search(SearchQuery{Query: "parsers"})
+	if err != nil {
+		t.Fatalf("search converted index: %v", err)
+	}
+	if len(hits) != 1 || hits[0].ID != agent.ID {
+		t.Errorf("search after conversion = %+v, want agent %s", hits, agent.ID)
+	}
+}
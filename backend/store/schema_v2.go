@@ -0,0 +1,108 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// foreignKeys are the relationships declared by migration 2, per child table.
+// Rows owned by a parent are deleted with it; optional references (a task's
+// agent or team) are cleared instead.
+var foreignKeys = map[string][]string{
+	"sessions": {
+		"FOREIGN KEY (`project_id`) REFERENCES `projects`(`id`) ON DELETE CASCADE",
+	},
+	"tasks": {
+		"FOREIGN KEY (`session_id`) REFERENCES `sessions`(`id`) ON DELETE CASCADE",
+		"FOREIGN KEY (`agent_id`) REFERENCES `agents`(`id`) ON DELETE SET NULL",
+		"FOREIGN KEY (`team_id`) REFERENCES `teams`(`id`) ON DELETE SET NULL",
+	},
+	"task_attempts": {
+		"FOREIGN KEY (`task_id`) REFERENCES `tasks`(`id`) ON DELETE CASCADE",
+		"FOREIGN KEY (`agent_id`) REFERENCES `agents`(`id`) ON DELETE SET NULL",
+	},
+	"task_messages": {
+		"FOREIGN KEY (`task_id`) REFERENCES `tasks`(`id`) ON DELETE CASCADE",
+	},
+	"agent_permission_rules": {
+		"FOREIGN KEY (`agent_id`) REFERENCES `agents`(`id`) ON DELETE CASCADE",
+	},
+}
+
+// foreignKeyOrder rebuilds parents before children.
+var foreignKeyOrder = []string{"sessions", "tasks", "task_attempts", "task_messages", "agent_permission_rules"}
+
+// foreignKeyIndexes cover child columns that had no index yet, so cascades
+// and SET NULL do not scan the table.
+var foreignKeyIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS `idx_task_attempts_agent_id` ON `task_attempts`(`agent_id`)",
+}
+
+// migrateForeignKeys is migration 2. SQLite cannot add constraints to an
+// existing table, so each child table is rebuilt; orphans left by earlier
+// builds are repaired first or the copy would violate the new keys.
+func migrateForeignKeys(tx *gorm.DB) error {
+	if _, err := repairReferences(tx); err != nil {
+		return err
+	}
+	for _, name := range foreignKeyOrder {
+		spec, ok := baselineTable(name)
+		if !ok {
+			return fmt.Errorf("no baseline for table %s", name)
+		}
+		if err := rebuildTable(tx, spec, foreignKeys[name]); err != nil {
+			return err
+		}
+	}
+	for _, ddl := range foreignKeyIndexes {
+		if err := tx.Exec(ddl).Error; err != nil {
+			return fmt.Errorf("%s: %w", ddl, err)
+		}
+	}
+	return nil
+}
+
+func baselineTable(name string) (tableSpec, bool) {
+	for _, t := range baselineTables {
+		if t.name == name {
+			return t, true
+		}
+	}
+	return tableSpec{}, false
+}
+
+// rebuildTable recreates t with the given constraints, copies its rows and
+// restores its baseline indexes. Foreign key enforcement must be off.
+func rebuildTable(tx *gorm.DB, t tableSpec, constraints []string) error {
+	cols := make([]string, 0, len(t.columns))
+	defs := make([]string, 0, len(t.columns)+1+len(constraints))
+	for _, c := range t.columns {
+		cols = append(cols, "`"+c.name+"`")
+		defs = append(defs, fmt.Sprintf("`%s` %s", c.name, c.def))
+	}
+	defs = append(defs, "PRIMARY KEY (`id`)")
+	defs = append(defs, constraints...)
+	colList := strings.Join(cols, ",")
+
+	tmp := t.name + "__new"
+	steps := []string{
+		fmt.Sprintf("DROP TABLE IF EXISTS `%s`", tmp),
+		fmt.Sprintf("CREATE TABLE `%s` (%s)", tmp, strings.Join(defs, ",")),
+		fmt.Sprintf("INSERT INTO `%s` (%s) SELECT %s FROM `%s`", tmp, colList, colList, t.name),
+		fmt.Sprintf("DROP TABLE `%s`", t.name),
+		fmt.Sprintf("ALTER TABLE `%s` RENAME TO `%s`", tmp, t.name),
+	}
+	for _, ddl := range baselineIndexes {
+		if strings.Contains(ddl, " ON `"+t.name+"`(") {
+			steps = append(steps, ddl)
+		}
+	}
+	for _, sql := range steps {
+		if err := tx.Exec(sql).Error; err != nil {
+			return fmt.Errorf("rebuild %s: %w", t.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// searchSource is a table whose text columns are full-text indexed. Every
+// column is a separate document in search_docs, so hits can say which field
+// matched and a change re-indexes only that field.
+type searchSource struct {
+	kind    string
+	table   string
+	fields  []string
+	taskID  string // SQL expression over the row alias, NULL if none
+	session string // likewise for the session ID
+}
+
+// searchSourcesV3 are the sources created by migration 3. Like the migration
+// itself, they must not change; index more columns in a new migration.
+var searchSourcesV3 = []searchSource{
+	{"task", "tasks", []string{"title", "prompt", "result_text", "error", "test_output"},
+		"%[1]s.id", "%[1]s.session_id"},
+	{"agent", "agents", []string{"name", "description", "system_prompt"},
+		"NULL", "NULL"},
+	{"stream_event", "task_stream_events", []string{"content"},
+		"%[1]s.task_id", "(SELECT session_id FROM tasks WHERE id = %[1]s.task_id)"},
+}
+
+// migrateSearch is migration 3: persisted stream events and the full-text
+// index. The index uses FTS4, which every mattn/go-sqlite3 build includes
+// (FTS5 needs the sqlite_fts5 build tag), so the database opens with any
+// build. Triggers keep it current for every write path, including UpdateField
+// and Updates.
+func migrateSearch(tx *gorm.DB) error {
+	ddl := []string{
+		"CREATE TABLE IF NOT EXISTS `task_stream_events` (`id` integer PRIMARY KEY AUTOINCREMENT, `task_id` text, `type` text, `content` text, `created_at` datetime, " +
+			"FOREIGN KEY (`task_id`) REFERENCES `tasks`(`id`) ON DELETE CASCADE)",
+		"CREATE INDEX IF NOT EXISTS `idx_task_stream_events_task_id` ON `task_stream_events`(`task_id`)",
+		"CREATE TABLE IF NOT EXISTS `search_docs` (`id` integer PRIMARY KEY AUTOINCREMENT, `kind` text NOT NULL, `entity_id` text NOT NULL, `field` text NOT NULL, `task_id` text, `session_id` text, " +
+			"UNIQUE (`kind`, `entity_id`, `field`))",
+		"CREATE INDEX IF NOT EXISTS `idx_search_docs_session_id` ON `search_docs`(`session_id`)",
+	}
+	for _, stmt := range ddl {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+
+	if err := tx.Exec(createSearchIndex).Error; err != nil {
+		return fmt.Errorf("create search index: %w", err)
+	}
+
+	for _, src := range searchSourcesV3 {
+		for _, stmt := range searchStatements(src) {
+			if err := tx.Exec(stmt).Error; err != nil {
+				return fmt.Errorf("index %s: %w", src.table, err)
+			}
+		}
+	}
+	return nil
+}
+
+// createSearchIndex creates the full-text index over search_docs.
+const createSearchIndex = "CREATE VIRTUAL TABLE `search_index` USING fts4(body, tokenize=porter)"
+
+// searchStatements returns the statements that index the existing rows of
+// src and the triggers that keep the index in step with it.
+func searchStatements(src searchSource) []string {
+	var stmts []string
+	ref := func(expr, alias string) string { return strings.ReplaceAll(expr, "%[1]s", alias) }
+
+	// Backfill
+	for _, f := range src.fields {
+		stmts = append(stmts,
+			fmt.Sprintf("INSERT INTO search_docs (kind, entity_id, field, task_id, session_id) SELECT '%s', CAST(t.id AS TEXT), '%s', %s, %s FROM `%s` t",
+				src.kind, f, ref(src.taskID, "t"), ref(src.session, "t"), src.table),
+			searchBackfill(src, f))
+	}
+
+	// Insert: one document per field
+	var body []string
+	for _, f := range src.fields {
+		body = append(body,
+			fmt.Sprintf("INSERT INTO search_docs (kind, entity_id, field, task_id, session_id) VALUES ('%s', CAST(new.id AS TEXT), '%s', %s, %s);",
+				src.kind, f, ref(src.taskID, "new"), ref(src.session, "new")),
+			fmt.Sprintf("INSERT INTO search_index (rowid, body) VALUES (last_insert_rowid(), COALESCE(new.`%s`, ''));", f))
+	}
+	stmts = append(stmts, fmt.Sprintf("CREATE TRIGGER `search_%s_insert` AFTER INSERT ON `%s` BEGIN %s END",
+		src.table, src.table, strings.Join(body, " ")))
+
+	// Update: re-index only changed fields
+	for _, f := range src.fields {
+		stmts = append(stmts, fmt.Sprintf("CREATE TRIGGER `search_%s_update_%s` AFTER UPDATE OF `%s` ON `%s` WHEN new.`%s` IS NOT old.`%s` BEGIN "+
+			"UPDATE search_index SET body = COALESCE(new.`%s`, '') WHERE rowid = (SELECT id FROM search_docs WHERE kind = '%s' AND entity_id = CAST(new.id AS TEXT) AND field = '%s'); END",
+			src.table, f, f, src.table, f, f, f, src.kind, f))
+	}
+
+	// Delete (including foreign key cascades)
+	stmts = append(stmts, fmt.Sprintf("CREATE TRIGGER `search_%s_delete` AFTER DELETE ON `%s` BEGIN "+
+		"DELETE FROM search_index WHERE rowid IN (SELECT id FROM search_docs WHERE kind = '%s' AND entity_id = CAST(old.id AS TEXT)); "+
+		"DELETE FROM search_docs WHERE kind = '%s' AND entity_id = CAST(old.id AS TEXT); END",
+		src.table, src.table, src.kind, src.kind))
+	return stmts
+}
+
+// searchBackfill returns the statement that indexes field f of every row of
+// src that has a search_docs entry.
+func searchBackfill(src searchSource, f string) string {
+	return fmt.Sprintf("INSERT INTO search_index (rowid, body) SELECT d.id, COALESCE(t.`%s`, '') FROM `%s` t JOIN search_docs d ON d.kind = '%s' AND d.entity_id = CAST(t.id AS TEXT) AND d.field = '%s'",
+		f, src.table, src.kind, f)
+}
@@ -0,0 +1,30 @@
+package store
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// listIndexes back the default sort of each list query (see query.go) and the
+// session and task filters combined with it, so a page is read from an index
+// instead of sorting the whole table.
+var listIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS `idx_projects_created_at` ON `projects`(`created_at`)",
+	"CREATE INDEX IF NOT EXISTS `idx_agents_created_at` ON `agents`(`created_at`)",
+	"CREATE INDEX IF NOT EXISTS `idx_teams_created_at` ON `teams`(`created_at`)",
+	"CREATE INDEX IF NOT EXISTS `idx_mcp_servers_created_at` ON `mcp_servers`(`created_at`)",
+	"CREATE INDEX IF NOT EXISTS `idx_tasks_created_at` ON `tasks`(`created_at`)",
+	"CREATE INDEX IF NOT EXISTS `idx_tasks_session_created` ON `tasks`(`session_id`,`created_at`)",
+	"CREATE INDEX IF NOT EXISTS `idx_task_messages_task_created` ON `task_messages`(`task_id`,`created_at`)",
+}
+
+// migrateListIndexes is migration 4.
+func migrateListIndexes(tx *gorm.DB) error {
+	for _, stmt := range listIndexes {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package store
+
+import "gorm.io/gorm"
+
+// sessionForkColumns record where a cloned or forked session came from and
+// the task snapshots forks start from.
+var sessionForkColumns = []tableSpec{
+	{"sessions", []columnSpec{{"source_session_id", "text"}, {"forked_from_task_id", "text"}, {"base_ref", "text"}}},
+	{"tasks", []columnSpec{{"snapshot_commit", "text"}}},
+}
+
+// migrateSessionForks is migration 5. The source columns are not foreign
+// keys: a fork outlives the session and task it was made from.
+func migrateSessionForks(tx *gorm.DB) error {
+	for _, t := range sessionForkColumns {
+		if err := ensureTable(tx, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// migrateComparisons is migration 6: A/B comparisons of a task and the
+// columns that tie variant attempts to them.
+func migrateComparisons(tx *gorm.DB) error {
+	ddl := []string{
+		"CREATE TABLE IF NOT EXISTS `task_comparisons` (`id` text, `task_id` text, `prompt` text, `base_commit` text, `status` text, " +
+			"`judge_agent_id` text, `judge_summary` text, `error` text, `suggested_winner` text, `winner_attempt_id` text, " +
+			"`created_at` datetime, `completed_at` datetime, PRIMARY KEY (`id`), " +
+			"FOREIGN KEY (`task_id`) REFERENCES `tasks`(`id`) ON DELETE CASCADE, " +
+			"FOREIGN KEY (`judge_agent_id`) REFERENCES `agents`(`id`) ON DELETE SET NULL)",
+		"CREATE INDEX IF NOT EXISTS `idx_task_comparisons_task_id` ON `task_comparisons`(`task_id`)",
+		"CREATE INDEX IF NOT EXISTS `idx_task_comparisons_judge_agent_id` ON `task_comparisons`(`judge_agent_id`)",
+	}
+	for _, stmt := range ddl {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	err := ensureTable(tx, tableSpec{"task_attempts", []columnSpec{
+		{"comparison_id", "text REFERENCES `task_comparisons`(`id`) ON DELETE SET NULL"},
+		{"workspace", "text"}, {"judge_score", "real"}, {"judge_reason", "text"},
+	}})
+	if err != nil {
+		return err
+	}
+	return tx.Exec("CREATE INDEX IF NOT EXISTS `idx_task_attempts_comparison_id` ON `task_attempts`(`comparison_id`)").Error
+}
@@ -0,0 +1,26 @@
+package store
+
+import "gorm.io/gorm"
+
+// acceptanceColumns hold a task's acceptance criteria and the reviewer's
+// verdicts on it and on each attempt.
+var acceptanceColumns = []tableSpec{
+	{"tasks", []columnSpec{
+		{"acceptance_criteria", "text"},
+		{"reviewer_agent_id", "text REFERENCES `agents`(`id`) ON DELETE SET NULL"},
+		{"max_review_rounds", "integer DEFAULT 0"},
+		{"review_round", "integer DEFAULT 0"},
+		{"acceptance_verdict", "text"},
+	}},
+	{"task_attempts", []columnSpec{{"acceptance_verdict", "text"}}},
+}
+
+// migrateAcceptance is migration 7.
+func migrateAcceptance(tx *gorm.DB) error {
+	for _, t := range acceptanceColumns {
+		if err := ensureTable(tx, t); err != nil {
+			return err
+		}
+	}
+	return tx.Exec("CREATE INDEX IF NOT EXISTS `idx_tasks_reviewer_agent_id` ON `tasks`(`reviewer_agent_id`)").Error
+}
@@ -0,0 +1,40 @@
+package store
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// migrateCodeReview is migration 8: review comments and the session and team
+// options that enable code review.
+func migrateCodeReview(tx *gorm.DB) error {
+	ddl := []string{
+		"CREATE TABLE IF NOT EXISTS `review_comments` (`id` text, `task_id` text, `attempt_id` text, `round` integer, " +
+			"`reviewer_agent_id` text, `file_path` text, `hunk_index` integer, `hunk_header` text, `severity` text, `body` text, " +
+			"`created_at` datetime, PRIMARY KEY (`id`), " +
+			"FOREIGN KEY (`task_id`) REFERENCES `tasks`(`id`) ON DELETE CASCADE, " +
+			"FOREIGN KEY (`reviewer_agent_id`) REFERENCES `agents`(`id`) ON DELETE SET NULL)",
+		"CREATE INDEX IF NOT EXISTS `idx_review_comments_task_id` ON `review_comments`(`task_id`)",
+		"CREATE INDEX IF NOT EXISTS `idx_review_comments_reviewer_agent_id` ON `review_comments`(`reviewer_agent_id`)",
+	}
+	for _, stmt := range ddl {
+		if err := tx.Exec(stmt).Error; err != nil {
+			return fmt.Errorf("%s: %w", stmt, err)
+		}
+	}
+	reviewer := []columnSpec{
+		{"code_reviewer_id", "text REFERENCES `agents`(`id`) ON DELETE SET NULL"},
+		{"code_review_rounds", "integer DEFAULT 0"},
+	}
+	for _, t := range []tableSpec{
+		{"sessions", reviewer},
+		{"teams", reviewer},
+		{"tasks", []columnSpec{{"code_review_round", "integer DEFAULT 0"}}},
+	} {
+		if err := ensureTable(tx, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
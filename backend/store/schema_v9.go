@@ -0,0 +1,9 @@
+package store
+
+import "gorm.io/gorm"
+
+// migratePipelineStages is migration 9: the pipeline stage of an attempt of
+// a sequential team's task.
+func migratePipelineStages(tx *gorm.DB) error {
+	return ensureTable(tx, tableSpec{"task_attempts", []columnSpec{{"stage", "integer DEFAULT 0"}}})
+}
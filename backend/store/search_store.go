@@ -0,0 +1,214 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Snippet markers around matched terms.
+const (
+	SnippetMatchStart = "«"
+	SnippetMatchEnd   = "»"
+)
+
+// searchCandidates caps the documents ranked per query.
+const searchCandidates = 2000
+
+// fieldWeights favour matches in names and titles over long bodies.
+var fieldWeights = map[string]float64{"title": 2, "name": 2}
+
+// SearchQuery is a full-text search request.
+type SearchQuery struct {
+	Query     string   `json:"query"`
+	Kinds     []string `json:"kinds,omitempty"` // "task", "agent", "stream_event"; empty for all
+	SessionID string   `json:"session_id,omitempty"`
+	Limit     int      `json:"limit,omitempty"` // default 50
+}
+
+// SearchHit is one task or agent matching a query, with its best match.
+// Stream event matches count towards their task.
+type SearchHit struct {
+	Kind      string  `json:"kind"` // "task" or "agent"
+	ID        string  `json:"id"`   // task or agent ID
+	Title     string  `json:"title"`
+	SessionID string  `json:"session_id,omitempty"`
+	TaskID    string  `json:"task_id,omitempty"`
+	Field     string  `json:"field"` // best matching field; "stream" for stream events
+	Snippet   string  `json:"snippet"`
+	Score     float64 `json:"score"` // higher is better
+	Matches   int     `json:"matches"`
+}
+
+type SearchStore struct {
+	db *DB
+}
+
+func NewSearchStore(db *DB) *SearchStore {
+	return &SearchStore{db: db}
+}
+
+// ftsQuery turns free text into an FTS query that matches documents
+// containing every word. Quoting each word keeps FTS syntax characters in
+// user input from causing errors.
+func ftsQuery(text string) string {
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		word = strings.ReplaceAll(word, `"`, `""`)
+		terms = append(terms, `"`+word+`"`)
+	}
+	return strings.Join(terms, " ")
+}
+
+type searchRow struct {
+	Kind      string
+	EntityID  string
+	Field     string
+	TaskID    string
+	SessionID string
+	Snippet   string
+	Info      []byte
+}
+
+// Search returns tasks and agents matching q.Query, best first.
+func (s *SearchStore) Search(q SearchQuery) ([]SearchHit, error) {
+	match := ftsQuery(q.Query)
+	if match == "" {
+		return []SearchHit{}, nil
+	}
+	if q.Limit <= 0 {
+		q.Limit = 50
+	}
+	query := "SELECT d.kind, d.entity_id, d.field, COALESCE(d.task_id, '') AS task_id, COALESCE(d.session_id, '') AS session_id, " +
+		"snippet(search_index, ?, ?, '…', -1, 16) AS snippet, matchinfo(search_index, 'pcnalx') AS info" +
+		" FROM search_index JOIN search_docs d ON d.id = search_index.rowid WHERE search_index MATCH ?"
+	args := []any{SnippetMatchStart, SnippetMatchEnd, match}
+	if len(q.Kinds) > 0 {
+		query += " AND d.kind IN ?"
+		args = append(args, q.Kinds)
+	}
+	if q.SessionID != "" {
+		query += " AND d.session_id = ?"
+		args = append(args, q.SessionID)
+	}
+	query += fmt.Sprintf(" ORDER BY search_index.rowid DESC LIMIT %d", searchCandidates) // newest first when capped
+
+	var rows []searchRow
+	if err := s.db.Raw(query, args...).Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+
+	// Collapse documents into one hit per task or agent, keeping the best match
+	hits := make(map[string]*SearchHit)
+	var order []string
+	for _, r := range rows {
+		score := bm25FromMatchinfo(r.Info)
+		if w, ok := fieldWeights[r.Field]; ok {
+			score *= w
+		}
+		kind, id, field := r.Kind, r.EntityID, r.Field
+		if kind == "stream_event" {
+			kind, id, field = "task", r.TaskID, "stream"
+		}
+		key := kind + ":" + id
+		hit, ok := hits[key]
+		if !ok {
+			hit = &SearchHit{Kind: kind, ID: id, SessionID: r.SessionID}
+			if kind == "task" {
+				hit.TaskID = id
+			}
+			hits[key] = hit
+			order = append(order, key)
+		}
+		hit.Matches++
+		if score > hit.Score || hit.Snippet == "" {
+			hit.Score, hit.Field, hit.Snippet = score, field, r.Snippet
+		}
+	}
+
+	result := make([]SearchHit, 0, len(order))
+	for _, key := range order {
+		result = append(result, *hits[key])
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Score > result[j].Score })
+	if len(result) > q.Limit {
+		result = result[:q.Limit]
+	}
+	if err := s.fillTitles(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *SearchStore) fillTitles(hits []SearchHit) error {
+	var taskIDs, agentIDs []string
+	for _, h := range hits {
+		if h.Kind == "task" {
+			taskIDs = append(taskIDs, h.ID)
+		} else {
+			agentIDs = append(agentIDs, h.ID)
+		}
+	}
+	titles := make(map[string]string)
+	var rows []struct{ ID, Title string }
+	if len(taskIDs) > 0 {
+		if err := s.db.Raw("SELECT id, title FROM tasks WHERE id IN ?", taskIDs).Scan(&rows).Error; err != nil {
+			return err
+		}
+		for _, r := range rows {
+			titles["task:"+r.ID] = r.Title
+		}
+	}
+	if len(agentIDs) > 0 {
+		rows = nil
+		if err := s.db.Raw("SELECT id, name AS title FROM agents WHERE id IN ?", agentIDs).Scan(&rows).Error; err != nil {
+			return err
+		}
+		for _, r := range rows {
+			titles["agent:"+r.ID] = r.Title
+		}
+	}
+	for i := range hits {
+		hits[i].Title = titles[hits[i].Kind+":"+hits[i].ID]
+	}
+	return nil
+}
+
+// bm25FromMatchinfo scores a FTS4 row from matchinfo(..., 'pcnalx'), which
+// FTS4 provides instead of a ranking function.
+func bm25FromMatchinfo(info []byte) float64 {
+	const k1, b = 1.2, 0.75
+	if len(info) < 12 || len(info)%4 != 0 {
+		return 0
+	}
+	v := make([]float64, len(info)/4)
+	for i := range v {
+		v[i] = float64(binary.NativeEndian.Uint32(info[i*4:]))
+	}
+	phrases, cols, docs := int(v[0]), int(v[1]), v[2]
+	avg := v[3 : 3+cols]
+	length := v[3+cols : 3+2*cols]
+	x := v[3+2*cols:]
+	if len(x) < 3*phrases*cols {
+		return 0
+	}
+	var score float64
+	for p := 0; p < phrases; p++ {
+		for c := 0; c < cols; c++ {
+			i := 3 * (c + p*cols)
+			tf, withHits := x[i], x[i+2]
+			if tf == 0 {
+				continue
+			}
+			idf := math.Log((docs-withHits+0.5)/(withHits+0.5) + 1)
+			norm := 1.0
+			if avg[c] > 0 {
+				norm = 1 - b + b*length[c]/avg[c]
+			}
+			score += idf * tf * (k1 + 1) / (tf + k1*norm)
+		}
+	}
+	return score
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type SessionStore struct {
@@ -26,6 +27,38 @@ func (s *SessionStore) Create(sess *models.Session) error {
 	return s.db.Create(sess).Error
 }
 
+// CreateWithTasks creates a session and its tasks in one transaction. Task
+// IDs must be set so dependencies can refer to them.
+func (s *SessionStore) CreateWithTasks(sess *models.Session, tasks []models.Task) error {
+	if sess.ID == "" {
+		sess.ID = uuid.New().String()
+	}
+	if sess.Status == "" {
+		sess.Status = models.SessionStatusPlanning
+	}
+	now := time.Now()
+	sess.CreatedAt = now
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(sess).Error; err != nil {
+			return err
+		}
+		for i := range tasks {
+			t := &tasks[i]
+			t.SessionID = sess.ID
+			if t.Status == "" {
+				t.Status = models.TaskStatusPending
+			}
+			// Keep the DAG's order for ListBySession
+			t.CreatedAt = now.Add(time.Duration(i) * time.Microsecond)
+			redactTask(t)
+			if err := tx.Create(t).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (s *SessionStore) GetByID(id string) (*models.Session, error) {
 	var sess models.Session
 	if err := s.db.First(&sess, "id = ?", id).Error; err != nil {
@@ -35,38 +68,20 @@ func (s *SessionStore) GetByID(id string) (*models.Session, error) {
 }
 
 func (s *SessionStore) List() ([]models.Session, error) {
-	var sessions []models.Session
-	if err := s.db.Order("created_at DESC").Find(&sessions).Error; err != nil {
-		return nil, err
-	}
-	return sessions, nil
+	return listAll[models.Session](s.db, sessionList, ListQuery{})
+}
+
+// Query returns a page of sessions matching q.
+func (s *SessionStore) Query(q ListQuery) (*ListPage, error) {
+	return listPage(s.db, sessionList, q, func(x models.Session) string { return x.ID })
 }
 
 func (s *SessionStore) ListPaginated(page, pageSize int) (*models.PaginatedResponse, error) {
-	if page < 1 {
-		page = 1
-	}
-	if pageSize < 1 {
-		pageSize = 10
-	}
-	var total int64
-	if err := s.db.Model(&models.Session{}).Count(&total).Error; err != nil {
-		return nil, err
-	}
-	var sessions []models.Session
-	offset := (page - 1) * pageSize
-	if err := s.db.Order("created_at DESC").Offset(offset).Limit(pageSize).Find(&sessions).Error; err != nil {
-		return nil, err
-	}
-	return models.NewPaginatedResponse(sessions, total, page, pageSize), nil
+	return listPaginated[models.Session](s.db, sessionList, ListQuery{}, page, pageSize)
 }
 
 func (s *SessionStore) ListByProject(projectID string) ([]models.Session, error) {
-	var sessions []models.Session
-	if err := s.db.Where("project_id = ?", projectID).Order("created_at DESC").Find(&sessions).Error; err != nil {
-		return nil, err
-	}
-	return sessions, nil
+	return listAll[models.Session](s.db, sessionList, ListQuery{ProjectID: projectID})
 }
 
 func (s *SessionStore) Update(sess *models.Session) error {
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"agent-workflow/backend/models"
+	"time"
+)
+
+type StreamEventStore struct {
+	db *DB
+}
+
+func NewStreamEventStore(db *DB) *StreamEventStore {
+	return &StreamEventStore{db: db}
+}
+
+// CreateBatch stores events in one transaction. Events of tasks deleted in
+// the meantime are dropped. Content is expected to be redacted already
+// (AgentRunner masks it before publishing).
+func (s *StreamEventStore) CreateBatch(events []models.StreamEvent) error {
+	ids := make([]string, 0, len(events))
+	for _, e := range events {
+		ids = append(ids, e.TaskID)
+	}
+	var existing []string
+	if err := s.db.Model(&models.Task{}).Where("id IN ?", ids).Pluck("id", &existing).Error; err != nil {
+		return err
+	}
+	live := make(map[string]bool, len(existing))
+	for _, id := range existing {
+		live[id] = true
+	}
+
+	now := time.Now()
+	kept := make([]models.StreamEvent, 0, len(events))
+	for _, e := range events {
+		if !live[e.TaskID] {
+			continue
+		}
+		if e.CreatedAt.IsZero() {
+			e.CreatedAt = now
+		}
+		kept = append(kept, e)
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	return s.db.CreateInBatches(kept, 100).Error
+}
+
+// ListByTask returns a task's persisted events in order.
+func (s *StreamEventStore) ListByTask(taskID string) ([]models.StreamEvent, error) {
+	var events []models.StreamEvent
+	if err := s.db.Where("task_id = ?", taskID).Order("id ASC").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
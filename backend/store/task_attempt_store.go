@@ -0,0 +1,99 @@
+package store
+
+import (
+	"agent-workflow/backend/models"
+	"agent-workflow/backend/redact"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type TaskAttemptStore struct {
+	db *DB
+}
+
+func NewTaskAttemptStore(db *DB) *TaskAttemptStore {
+	return &TaskAttemptStore{db: db}
+}
+
+// maxNumberRetries bounds how often Create picks a new attempt number after
+// a concurrent run of the same task took it first.
+const maxNumberRetries = 5
+
+// Create inserts a new attempt, assigning the next per-task attempt number.
+// Numbers are unique per task, so an insert that loses the race for a number
+// is retried with the next one.
+func (s *TaskAttemptStore) Create(a *models.TaskAttempt) error {
+	if a.ID == "" {
+		a.ID = uuid.New().String()
+	}
+	if a.StartedAt.IsZero() {
+		a.StartedAt = time.Now()
+	}
+	redactAttempt(a)
+	if a.Number != 0 {
+		return s.db.Create(a).Error
+	}
+	var err error
+	for i := 0; i < maxNumberRetries; i++ {
+		var maxNumber int
+		if err = s.db.Model(&models.TaskAttempt{}).
+			Where("task_id = ?", a.TaskID).
+			Select("COALESCE(MAX(number), 0)").
+			Scan(&maxNumber).Error; err != nil {
+			return err
+		}
+		a.Number = maxNumber + 1
+		if err = s.db.Create(a).Error; err == nil || !isUniqueViolation(err) {
+			return err
+		}
+	}
+	return fmt.Errorf("assign attempt number: %w", err)
+}
+
+func (s *TaskAttemptStore) GetByID(id string) (*models.TaskAttempt, error) {
+	var a models.TaskAttempt
+	if err := s.db.First(&a, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// ListByTask returns all attempts for a task, oldest first.
+func (s *TaskAttemptStore) ListByTask(taskID string) ([]models.TaskAttempt, error) {
+	return listAll[models.TaskAttempt](s.db, attemptList, ListQuery{TaskID: taskID})
+}
+
+// ListByComparison returns the variant attempts of a comparison, in launch order.
+func (s *TaskAttemptStore) ListByComparison(comparisonID string) ([]models.TaskAttempt, error) {
+	return listAll[models.TaskAttempt](s.db, attemptList, ListQuery{ComparisonID: comparisonID})
+}
+
+// Query returns a page of attempts matching q.
+func (s *TaskAttemptStore) Query(q ListQuery) (*ListPage, error) {
+	return listPage(s.db, attemptList, q, func(a models.TaskAttempt) string { return a.ID })
+}
+
+func (s *TaskAttemptStore) Update(a *models.TaskAttempt) error {
+	redactAttempt(a)
+	return s.db.Save(a).Error
+}
+
+func (s *TaskAttemptStore) DeleteByTask(taskID string) error {
+	return s.db.Delete(&models.TaskAttempt{}, "task_id = ?", taskID).Error
+}
+
+// redactAttempt masks secrets in the attempt's output fields before they are written.
+func redactAttempt(a *models.TaskAttempt) {
+	a.ResultText = redact.String(a.ResultText)
+	a.Error = redact.String(a.Error)
+	a.TestOutput = redact.String(a.TestOutput)
+	a.BuildOutput = redact.String(a.BuildOutput)
+}
+
+// isUniqueViolation reports whether err is SQLite rejecting a duplicate key.
+func isUniqueViolation(err error) bool {
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
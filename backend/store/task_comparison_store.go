@@ -0,0 +1,62 @@
+package store
+
+import (
+	"agent-workflow/backend/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type TaskComparisonStore struct {
+	db *DB
+}
+
+func NewTaskComparisonStore(db *DB) *TaskComparisonStore {
+	return &TaskComparisonStore{db: db}
+}
+
+func (s *TaskComparisonStore) Create(c *models.TaskComparison) error {
+	if c.ID == "" {
+		c.ID = uuid.New().String()
+	}
+	if c.Status == "" {
+		c.Status = models.ComparisonStatusRunning
+	}
+	c.CreatedAt = time.Now()
+	return s.db.Create(c).Error
+}
+
+func (s *TaskComparisonStore) GetByID(id string) (*models.TaskComparison, error) {
+	var c models.TaskComparison
+	if err := s.db.First(&c, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// ListByTask returns a task's comparisons, newest first.
+func (s *TaskComparisonStore) ListByTask(taskID string) ([]models.TaskComparison, error) {
+	var comparisons []models.TaskComparison
+	if err := s.db.Where("task_id = ?", taskID).Order("created_at DESC").Find(&comparisons).Error; err != nil {
+		return nil, err
+	}
+	return comparisons, nil
+}
+
+// ListActive returns comparisons whose worktrees still exist.
+func (s *TaskComparisonStore) ListActive() ([]models.TaskComparison, error) {
+	var comparisons []models.TaskComparison
+	err := s.db.Where("status IN ?", []models.ComparisonStatus{models.ComparisonStatusRunning, models.ComparisonStatusReady}).Find(&comparisons).Error
+	return comparisons, err
+}
+
+func (s *TaskComparisonStore) Update(c *models.TaskComparison) error {
+	return s.db.Save(c).Error
+}
+
+// UpdateStatusIf moves a comparison from one status to another and reports
+// whether it was still in the first one, so only one caller wins a race.
+func (s *TaskComparisonStore) UpdateStatusIf(id string, from, to models.ComparisonStatus) (bool, error) {
+	res := s.db.Model(&models.TaskComparison{}).Where("id = ? AND status = ?", id, from).Update("status", to)
+	return res.RowsAffected > 0, res.Error
+}
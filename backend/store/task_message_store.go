@@ -0,0 +1,35 @@
+package store
+
+import (
+	"agent-workflow/backend/models"
+	"agent-workflow/backend/redact"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type TaskMessageStore struct {
+	db *DB
+}
+
+func NewTaskMessageStore(db *DB) *TaskMessageStore {
+	return &TaskMessageStore{db: db}
+}
+
+func (s *TaskMessageStore) Create(m *models.TaskMessage) error {
+	if m.ID == "" {
+		m.ID = uuid.New().String()
+	}
+	m.CreatedAt = time.Now()
+	m.Content = redact.String(m.Content)
+	return s.db.Create(m).Error
+}
+
+// ListByTask returns the conversation for a task in chronological order.
+func (s *TaskMessageStore) ListByTask(taskID string) ([]models.TaskMessage, error) {
+	return listAll[models.TaskMessage](s.db, messageList, ListQuery{TaskID: taskID})
+}
+
+func (s *TaskMessageStore) DeleteByTask(taskID string) error {
+	return s.db.Delete(&models.TaskMessage{}, "task_id = ?", taskID).Error
+}
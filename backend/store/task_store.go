@@ -2,6 +2,7 @@ package store
 
 import (
 	"agent-workflow/backend/models"
+	"agent-workflow/backend/redact"
 	"time"
 
 	"github.com/google/uuid"
@@ -23,6 +24,7 @@ func (s *TaskStore) Create(t *models.Task) error {
 		t.Status = models.TaskStatusPending
 	}
 	t.CreatedAt = time.Now()
+	redactTask(t)
 	return s.db.Create(t).Error
 }
 
@@ -35,14 +37,16 @@ func (s *TaskStore) GetByID(id string) (*models.Task, error) {
 }
 
 func (s *TaskStore) ListBySession(sessionID string) ([]models.Task, error) {
-	var tasks []models.Task
-	if err := s.db.Where("session_id = ?", sessionID).Order("created_at ASC").Find(&tasks).Error; err != nil {
-		return nil, err
-	}
-	return tasks, nil
+	return listAll[models.Task](s.db, taskList, ListQuery{SessionID: sessionID, Order: "asc"})
+}
+
+// Query returns a page of tasks matching q.
+func (s *TaskStore) Query(q ListQuery) (*ListPage, error) {
+	return listPage(s.db, taskList, q, func(t models.Task) string { return t.ID })
 }
 
 func (s *TaskStore) Update(t *models.Task) error {
+	redactTask(t)
 	return s.db.Save(t).Error
 }
 
@@ -52,9 +56,22 @@ func (s *TaskStore) Delete(id string) error {
 
 // UpdateField updates a single field on a task by ID.
 func (s *TaskStore) UpdateField(id string, field string, value any) error {
+	if str, ok := value.(string); ok {
+		value = redact.String(str)
+	}
 	return s.db.Model(&models.Task{}).Where("id = ?", id).Update(field, value).Error
 }
 
+// CountActiveByAgent counts the agent's tasks that have not finished
+// (pending, running or awaiting input).
+func (s *TaskStore) CountActiveByAgent(agentID string) (int64, error) {
+	var n int64
+	err := s.db.Model(&models.Task{}).
+		Where("agent_id = ? AND status IN ?", agentID, []models.TaskStatus{models.TaskStatusPending, models.TaskStatusRunning, models.TaskStatusAwaitingInput}).
+		Count(&n).Error
+	return n, err
+}
+
 func (s *TaskStore) UpdateStatus(id string, status models.TaskStatus) error {
 	updates := map[string]any{"status": status}
 	now := time.Now()
@@ -66,3 +83,13 @@ func (s *TaskStore) UpdateStatus(id string, status models.TaskStatus) error {
 	}
 	return s.db.Model(&models.Task{}).Where("id = ?", id).Updates(updates).Error
 }
+
+// redactTask masks secrets in the fields that hold agent or command output
+// before they are written.
+func redactTask(t *models.Task) {
+	t.ResultText = redact.String(t.ResultText)
+	t.TestOutput = redact.String(t.TestOutput)
+	t.BuildOutput = redact.String(t.BuildOutput)
+	t.Error = redact.String(t.Error)
+	t.PendingInputData = redact.String(t.PendingInputData)
+}
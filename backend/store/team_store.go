@@ -2,9 +2,11 @@ package store
 
 import (
 	"agent-workflow/backend/models"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type TeamStore struct {
@@ -24,7 +26,8 @@ func (s *TeamStore) Create(t *models.Team) error {
 	}
 	t.CreatedAt = time.Now()
 	t.UpdatedAt = time.Now()
-	return s.db.Create(t).Error
+	return s.db.auditedSave(models.AuditActionCreate, models.AuditEntityTeam, t.ID, fmt.Sprintf("created team %q", t.Name),
+		nil, func(tx *gorm.DB) error { return tx.Create(t).Error }, t)
 }
 
 func (s *TeamStore) GetByID(id string) (*models.Team, error) {
@@ -36,37 +39,25 @@ func (s *TeamStore) GetByID(id string) (*models.Team, error) {
 }
 
 func (s *TeamStore) List() ([]models.Team, error) {
-	var teams []models.Team
-	if err := s.db.Order("created_at DESC").Find(&teams).Error; err != nil {
-		return nil, err
-	}
-	return teams, nil
+	return listAll[models.Team](s.db, teamList, ListQuery{})
+}
+
+// Query returns a page of teams matching q.
+func (s *TeamStore) Query(q ListQuery) (*ListPage, error) {
+	return listPage(s.db, teamList, q, func(x models.Team) string { return x.ID })
 }
 
 func (s *TeamStore) ListPaginated(page, pageSize int) (*models.PaginatedResponse, error) {
-	if page < 1 {
-		page = 1
-	}
-	if pageSize < 1 {
-		pageSize = 10
-	}
-	var total int64
-	if err := s.db.Model(&models.Team{}).Count(&total).Error; err != nil {
-		return nil, err
-	}
-	var teams []models.Team
-	offset := (page - 1) * pageSize
-	if err := s.db.Order("created_at DESC").Offset(offset).Limit(pageSize).Find(&teams).Error; err != nil {
-		return nil, err
-	}
-	return models.NewPaginatedResponse(teams, total, page, pageSize), nil
+	return listPaginated[models.Team](s.db, teamList, ListQuery{}, page, pageSize)
 }
 
 func (s *TeamStore) Update(t *models.Team) error {
 	t.UpdatedAt = time.Now()
-	return s.db.Save(t).Error
+	return s.db.auditedSave(models.AuditActionUpdate, models.AuditEntityTeam, t.ID, fmt.Sprintf("updated team %q", t.Name),
+		auditLoad[models.Team](t.ID), func(tx *gorm.DB) error { return tx.Save(t).Error }, t)
 }
 
 func (s *TeamStore) Delete(id string) error {
-	return s.db.Delete(&models.Team{}, "id = ?", id).Error
+	return s.db.auditedSave(models.AuditActionDelete, models.AuditEntityTeam, id, "deleted team",
+		auditLoad[models.Team](id), func(tx *gorm.DB) error { return tx.Delete(&models.Team{}, "id = ?", id).Error }, nil)
 }
@@ -0,0 +1,23 @@
+CREATE TABLE `agents` (`id` text,`name` text,`description` text,`model` text,`system_prompt` text,`allowed_tools` text,`disallowed_tools` text,`mcp_server_ids` text,`permissions` text,`protected_paths` text,`read_only_paths` text,`max_retries` integer DEFAULT 0,`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`id`));
+CREATE TABLE `mcp_servers` (`id` text,`name` text,`server_key` text,`description` text,`command` text,`args` text,`env` text,`enabled` numeric DEFAULT true,`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`id`));
+CREATE TABLE `projects` (`id` text,`name` text,`path` text,`test_command` text,`build_command` text,`setup_commands` text,`claude_md` text,`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`id`));
+CREATE TABLE `sessions` (`id` text,`project_id` text,`name` text,`status` text DEFAULT "planning",`created_at` datetime,`started_at` datetime,`completed_at` datetime,PRIMARY KEY (`id`));
+CREATE TABLE `tasks` (`id` text,`session_id` text,`title` text,`prompt` text,`original_prompt` text,`status` text DEFAULT "pending",`agent_id` text,`team_id` text,`dependencies` text,`workspace_path` text,`mcp_config_path` text,`claude_session_id` text,`max_retries` integer DEFAULT 0,`retry_count` integer DEFAULT 0,`resume_count` integer DEFAULT 0,`exit_code` integer,`result_text` text,`files_changed` text,`pending_input_data` text,`test_passed` numeric,`test_output` text,`build_passed` numeric,`build_output` text,`created_at` datetime,`started_at` datetime,`completed_at` datetime,`error` text,PRIMARY KEY (`id`));
+CREATE TABLE `teams` (`id` text,`name` text,`description` text,`agent_ids` text,`strategy` text,`nodes` text,`edges` text,`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`id`));
+CREATE INDEX idx_agent_model ON agents(model);
+CREATE INDEX idx_session_created ON sessions(created_at);
+CREATE INDEX `idx_session_project_created` ON `sessions`(`project_id`,`created_at`);
+CREATE INDEX idx_session_status ON sessions(status);
+CREATE INDEX `idx_sessions_project_id` ON `sessions`(`project_id`);
+CREATE INDEX idx_task_agent_id ON tasks(agent_id);
+CREATE INDEX idx_task_agent_status ON tasks(agent_id, status);
+CREATE INDEX idx_task_build_passed ON tasks(build_passed);
+CREATE INDEX idx_task_completed_at ON tasks(completed_at);
+CREATE INDEX idx_task_completed_status ON tasks(completed_at, status);
+CREATE INDEX `idx_task_session_status` ON `tasks`(`session_id`,`status`);
+CREATE INDEX idx_task_session_status_cover ON tasks(session_id, status);
+CREATE INDEX idx_task_session_test_build ON tasks(session_id, test_passed, build_passed);
+CREATE INDEX idx_task_team_id ON tasks(team_id);
+CREATE INDEX idx_task_test_passed ON tasks(test_passed);
+CREATE INDEX `idx_tasks_session_id` ON `tasks`(`session_id`);
+CREATE INDEX `idx_tasks_status` ON `tasks`(`status`);
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"agent-workflow/backend/config"
+	"agent-workflow/backend/services"
+	"agent-workflow/backend/store"
+)
+
+// runCheckDB implements "shannon check-db [--repair]": it checks the database
+// in the configured data directory and optionally repairs dangling references.
+func runCheckDB(args []string) int {
+	fs := flag.NewFlagSet("check-db", flag.ContinueOnError)
+	repair := fs.Bool("repair", false, "remove dangling references")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		cfg = config.DefaultConfig()
+	}
+	db, err := store.NewDB(cfg.DataDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "check-db:", err)
+		return 1
+	}
+	defer db.Close()
+
+	report, err := db.CheckIntegrity(*repair)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "check-db:", err)
+		return 1
+	}
+	for _, issue := range report.Issues {
+		state := "found"
+		if issue.Repaired {
+			state = "repaired"
+		}
+		fmt.Printf("%-9s %-11s %-24s %d × %s\n", state, issue.Kind, issue.Table, issue.Count, issue.Detail)
+	}
+	if report.OK {
+		fmt.Println("database OK")
+		return 0
+	}
+	if !*repair {
+		fmt.Println("run with --repair to fix dangling references")
+	}
+	return 1
+}
+
+// runBackup implements "shannon backup [-o file]": it writes the database,
+// config and vault to an archive, by default in the backups directory.
+func runBackup(args []string) int {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	out := fs.String("o", "", "archive to write")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		cfg = config.DefaultConfig()
+	}
+	db, err := store.NewDB(cfg.DataDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "backup:", err)
+		return 1
+	}
+	defer db.Close()
+
+	backups := services.NewBackupManager(db, cfg.DataDir, config.FilePath())
+	path := *out
+	if path == "" {
+		path = backups.ManualBackupPath()
+	}
+	manifest, err := backups.Create(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "backup:", err)
+		return 1
+	}
+	fmt.Printf("backup written to %s (schema version %d, %s)\n", path, manifest.SchemaVersion, strings.Join(manifest.Files, ", "))
+	return 0
+}
+
+// runRestore implements "shannon restore <file>": it validates the archive
+// and replaces the database, config and vault right away. It refuses while
+// the app runs on the same data directory; the replaced files are kept in
+// the backups directory.
+func runRestore(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: restore <backup.tar.gz>")
+		return 2
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		cfg = config.DefaultConfig()
+	}
+	lock, err := services.LockDataDir(cfg.DataDir)
+	if err != nil {
+		if errors.Is(err, services.ErrDataDirInUse) {
+			err = fmt.Errorf("%w; quit Shannon first, or restore from the app", err)
+		}
+		fmt.Fprintln(os.Stderr, "restore:", err)
+		return 1
+	}
+	defer lock.Release()
+	manifest, err := services.StageRestore(args[0], cfg.DataDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "restore:", err)
+		return 1
+	}
+	if _, err := services.ApplyPendingRestore(cfg.DataDir, config.FilePath()); err != nil {
+		fmt.Fprintln(os.Stderr, "restore:", err)
+		return 1
+	}
+	fmt.Printf("restored backup from %s (schema version %d)\n", manifest.CreatedAt.Format("2006-01-02 15:04:05"), manifest.SchemaVersion)
+	return 0
+}
@@ -1,6 +1,7 @@
 import { ReactNode } from 'react'
 import { Sidebar } from './Sidebar'
 import { Titlebar } from './Titlebar'
+import { ApprovalPrompts } from './ApprovalPrompts'
 
 interface AppShellProps {
   children: ReactNode
@@ -16,6 +17,7 @@ export function AppShell({ children }: AppShellProps) {
           {children}
         </main>
       </div>
+      <ApprovalPrompts />
     </div>
   )
 }
@@ -0,0 +1,120 @@
+import { useEffect, useState } from 'react'
+import { ShieldQuestion, Check, X, ChevronDown, ChevronRight } from 'lucide-react'
+import { useWailsEvent } from '../../hooks/useWailsEvent'
+import type { ApprovalRequest } from '../../types'
+
+// Tool calls the approval broker couldn't decide from the agent's policy wait
+// here for the user; a remembered decision becomes a rule for the agent.
+export function ApprovalPrompts() {
+  const [requests, setRequests] = useState<ApprovalRequest[]>([])
+
+  useEffect(() => {
+    window.go.main.App.ListPendingApprovals()
+      .then((pending) => setRequests(pending || []))
+      .catch((err) => console.error('Failed to load pending approvals:', err))
+  }, [])
+
+  useWailsEvent<ApprovalRequest>('task:approval', (req) => {
+    setRequests((prev) => (prev.some((r) => r.id === req.id) ? prev : [...prev, req]))
+  })
+
+  useWailsEvent<{ id: string }>('task:approval_resolved', ({ id }) => {
+    setRequests((prev) => prev.filter((r) => r.id !== id))
+  })
+
+  if (requests.length === 0) return null
+
+  const dismiss = (id: string) => setRequests((prev) => prev.filter((r) => r.id !== id))
+
+  return (
+    <div className="fixed bottom-4 right-4 z-50 w-[420px] max-h-[80vh] overflow-y-auto space-y-2">
+      {requests.map((req) => (
+        <ApprovalCard key={req.id} request={req} onDone={() => dismiss(req.id)} />
+      ))}
+    </div>
+  )
+}
+
+function ApprovalCard({ request, onDone }: { request: ApprovalRequest; onDone: () => void }) {
+  const [remember, setRemember] = useState(false)
+  const [showInput, setShowInput] = useState(false)
+  const [busy, setBusy] = useState(false)
+  const [error, setError] = useState('')
+
+  const respond = async (allow: boolean) => {
+    setBusy(true)
+    setError('')
+    try {
+      await window.go.main.App.RespondToApproval(request.id, allow, remember, '')
+      onDone()
+    } catch (err) {
+      // Most likely answered elsewhere, timed out or cancelled with its task
+      setError(String(err))
+      setBusy(false)
+    }
+  }
+
+  return (
+    <div className="bg-[#111114] border border-amber-500/30 rounded-xl p-3 shadow-brand-lg space-y-2">
+      <div className="flex items-center gap-2">
+        <ShieldQuestion size={14} className="text-amber-400 flex-shrink-0" />
+        <span className="text-xs text-zinc-200 truncate">
+          <span className="font-medium">{request.agent_name || 'Agent'}</span> wants to use{' '}
+          <span className="font-mono text-amber-300">{request.tool_name}</span>
+        </span>
+      </div>
+
+      {request.summary && (
+        <pre className="text-[11px] font-mono text-zinc-300 whitespace-pre-wrap break-all p-2 bg-white/[0.03] rounded-lg max-h-32 overflow-y-auto">
+          {request.summary}
+        </pre>
+      )}
+
+      <button
+        onClick={() => setShowInput((v) => !v)}
+        className="flex items-center gap-1 text-[10px] text-zinc-500 hover:text-zinc-300 transition-colors"
+      >
+        {showInput ? <ChevronDown size={10} /> : <ChevronRight size={10} />} Tool input
+      </button>
+      {showInput && (
+        <pre className="text-[10px] font-mono text-zinc-400 whitespace-pre-wrap break-all p-2 bg-white/[0.03] rounded-lg max-h-48 overflow-y-auto">
+          {JSON.stringify(request.input, null, 2)}
+        </pre>
+      )}
+
+      <label className="flex items-center gap-2 text-[11px] text-zinc-400">
+        <input type="checkbox" checked={remember} onChange={(e) => setRemember(e.target.checked)} />
+        <span className="truncate">
+          Remember for this agent: <span className="font-mono text-zinc-300">{request.suggested_rule}</span>
+        </span>
+      </label>
+
+      {error && <div className="text-[11px] text-red-400">{error}</div>}
+
+      <div className="flex items-center justify-end gap-1.5">
+        {error && (
+          <button
+            onClick={onDone}
+            className="px-2 py-1 text-[11px] text-zinc-500 hover:text-zinc-300 hover:bg-white/[0.06] rounded transition-colors"
+          >
+            Dismiss
+          </button>
+        )}
+        <button
+          onClick={() => respond(false)}
+          disabled={busy}
+          className="flex items-center gap-1 px-2 py-1 text-[11px] text-red-400 hover:bg-red-900/30 rounded transition-colors disabled:opacity-50"
+        >
+          <X size={12} /> Deny
+        </button>
+        <button
+          onClick={() => respond(true)}
+          disabled={busy}
+          className="flex items-center gap-1 px-2 py-1 text-[11px] text-emerald-400 hover:bg-emerald-900/30 rounded transition-colors disabled:opacity-50"
+        >
+          <Check size={12} /> Allow
+        </button>
+      </div>
+    </div>
+  )
+}
@@ -1,12 +1,13 @@
 import { lazy, memo, Suspense, useState, useEffect, useMemo, useCallback } from 'react'
 import {
   FileCode, FilePlus, FileX, Check, X, Pencil,
-  Loader2, ChevronRight, CheckCircle, XCircle, Undo2,
+  Loader2, ChevronRight, CheckCircle, XCircle, Undo2, MessageSquare,
 } from 'lucide-react'
 import { useSessionStore } from '../../stores/sessionStore'
+import { useWailsEvent } from '../../hooks/useWailsEvent'
 
 const Editor = lazy(() => import('@monaco-editor/react'))
-import type { Task, DiffResult, FileDiff, DiffHunk } from '../../types'
+import type { Task, DiffResult, FileDiff, DiffHunk, ReviewComment } from '../../types'
 
 interface ChangesPanelProps {
   task: Task | null
@@ -21,6 +22,7 @@ export function ChangesPanel({ task, diff }: ChangesPanelProps) {
   const [rejectTarget, setRejectTarget] = useState<{ type: 'hunk' | 'file'; file: string; hunkIndex?: number } | null>(null)
   const [rejectReason, setRejectReason] = useState('')
   const [actionLoading, setActionLoading] = useState(false)
+  const [comments, setComments] = useState<ReviewComment[]>([])
 
   const { acceptHunk, rejectHunk, acceptFile, rejectFile, saveWorkspaceFile } = useSessionStore()
 
@@ -38,6 +40,7 @@ export function ChangesPanel({ task, diff }: ChangesPanelProps) {
     setSelectedFile(null)
     setEditingFile(null)
     setRejectTarget(null)
+    setComments([])
   }, [task?.id])
 
   const selectedFileDiff = useMemo(
@@ -45,6 +48,32 @@ export function ChangesPanel({ task, diff }: ChangesPanelProps) {
     [diff, selectedFile]
   )
 
+  // Code review comments, reloaded when a review round finishes
+  const loadComments = useCallback(async (taskID: string) => {
+    try {
+      setComments((await window.go.main.App.ListTaskReviewComments(taskID)) || [])
+    } catch (e) {
+      console.error('Failed to load review comments:', e)
+    }
+  }, [])
+
+  useEffect(() => {
+    if (task) loadComments(task.id)
+  }, [task?.id, task?.status, loadComments])
+
+  useWailsEvent<{ task_id: string }>('task:review', (event) => {
+    if (task && event.task_id === task.id) loadComments(task.id)
+  })
+
+  const commentsByFile = useMemo(() => {
+    const byFile: Record<string, ReviewComment[]> = {}
+    for (const c of comments) {
+      if (!byFile[c.file_path]) byFile[c.file_path] = []
+      byFile[c.file_path].push(c)
+    }
+    return byFile
+  }, [comments])
+
   const handleAcceptHunk = async (filePath: string, hunkIndex: number) => {
     if (!task) return
     setActionLoading(true)
@@ -147,6 +176,12 @@ export function ChangesPanel({ task, diff }: ChangesPanelProps) {
           <span className="text-xs font-medium text-zinc-400">
             {diff.total} file{diff.total !== 1 ? 's' : ''} changed
           </span>
+          {comments.length > 0 && (
+            <span className="flex items-center gap-1 text-[10px] text-zinc-500" title="Code review comments">
+              <MessageSquare size={10} />
+              {comments.length}
+            </span>
+          )}
           {isRunning && (
             <span className="flex items-center gap-1 text-[10px] text-blue-400">
               <Loader2 size={10} className="animate-spin" />
@@ -174,6 +209,11 @@ export function ChangesPanel({ task, diff }: ChangesPanelProps) {
               <span className="truncate flex-1" title={f.path}>
                 {f.path.split('/').pop()}
               </span>
+              {commentsByFile[f.path] && (
+                <span className="text-[10px] text-zinc-500 flex-shrink-0" title="Code review comments">
+                  {commentsByFile[f.path].length}
+                </span>
+              )}
             </button>
           ))}
         </div>
@@ -193,6 +233,7 @@ export function ChangesPanel({ task, diff }: ChangesPanelProps) {
           ) : selectedFileDiff ? (
             <DiffViewer
               file={selectedFileDiff}
+              comments={commentsByFile[selectedFileDiff.path] || []}
               disabled={actionLoading}
               onAcceptHunk={(idx) => handleAcceptHunk(selectedFileDiff.path, idx)}
               onRejectHunk={(idx) => {
@@ -268,6 +309,7 @@ function FileStatusIcon({ status }: { status: string }) {
 
 interface DiffViewerProps {
   file: FileDiff
+  comments: ReviewComment[]
   disabled: boolean
   onAcceptHunk: (hunkIndex: number) => void
   onRejectHunk: (hunkIndex: number) => void
@@ -276,7 +318,15 @@ interface DiffViewerProps {
   onEditFile: () => void
 }
 
-function DiffViewer({ file, disabled, onAcceptHunk, onRejectHunk, onAcceptFile, onRejectFile, onEditFile }: DiffViewerProps) {
+function DiffViewer({ file, comments, disabled, onAcceptHunk, onRejectHunk, onAcceptFile, onRejectFile, onEditFile }: DiffViewerProps) {
+  // Comments follow their hunk by header, since indices shift as hunks are
+  // accepted or rejected; the rest apply to the file as a whole
+  const hunkComments = (hunk: DiffHunk) =>
+    comments.filter((c) => c.hunk_index >= 0 && (c.hunk_header ? c.hunk_header === hunk.header : c.hunk_index === hunk.index))
+  const fileComments = comments.filter(
+    (c) => c.hunk_index < 0 || !file.hunks?.some((h) => (c.hunk_header ? c.hunk_header === h.header : c.hunk_index === h.index))
+  )
+
   return (
     <div className="p-2 space-y-2">
       {/* File header */}
@@ -314,16 +364,20 @@ function DiffViewer({ file, disabled, onAcceptHunk, onRejectHunk, onAcceptFile,
         </div>
       </div>
 
+      {fileComments.length > 0 && <ReviewCommentList comments={fileComments} />}
+
       {/* Hunks */}
       {file.hunks && file.hunks.length > 0 ? (
         file.hunks.map((hunk) => (
-          <HunkBlock
-            key={hunk.index}
-            hunk={hunk}
-            disabled={disabled}
-            onAccept={() => onAcceptHunk(hunk.index)}
-            onReject={() => onRejectHunk(hunk.index)}
-          />
+          <div key={hunk.index} className="space-y-1">
+            <HunkBlock
+              hunk={hunk}
+              disabled={disabled}
+              onAccept={() => onAcceptHunk(hunk.index)}
+              onReject={() => onRejectHunk(hunk.index)}
+            />
+            <ReviewCommentList comments={hunkComments(hunk)} />
+          </div>
         ))
       ) : file.diff ? (
         // Fallback: show raw diff if no hunks parsed
@@ -403,6 +457,35 @@ const HunkBlock = memo(function HunkBlock({ hunk, disabled, onAccept, onReject }
   )
 })
 
+const severityStyles: Record<string, string> = {
+  info: 'text-zinc-400 bg-white/[0.06]',
+  minor: 'text-sky-400 bg-sky-900/30',
+  major: 'text-amber-400 bg-amber-900/30',
+  critical: 'text-red-400 bg-red-900/30',
+}
+
+function ReviewCommentList({ comments }: { comments: ReviewComment[] }) {
+  if (comments.length === 0) return null
+  return (
+    <div className="space-y-1">
+      {comments.map((c) => (
+        <div key={c.id} className="flex gap-2 px-2 py-1.5 bg-white/[0.03] border border-white/[0.06] rounded-lg">
+          <MessageSquare size={12} className="text-zinc-500 flex-shrink-0 mt-0.5" />
+          <div className="min-w-0 flex-1">
+            <div className="flex items-center gap-1.5 mb-0.5">
+              <span className={`px-1.5 py-0.5 rounded text-[10px] ${severityStyles[c.severity] || severityStyles.info}`}>
+                {c.severity}
+              </span>
+              <span className="text-[10px] text-zinc-600">Round {c.round}</span>
+            </div>
+            <p className="text-xs text-zinc-300 whitespace-pre-wrap break-words">{c.body}</p>
+          </div>
+        </div>
+      ))}
+    </div>
+  )
+}
+
 function StatusBadge({ status }: { status: string }) {
   const styles: Record<string, string> = {
     added: 'text-emerald-400 bg-emerald-900/30',
@@ -151,6 +151,7 @@ export interface Task {
   workspace_path?: string
   claude_session_id?: string
   pending_input_data?: string
+  pipeline_stage?: number
   max_retries: number
   retry_count: number
   resume_count: number
@@ -225,6 +226,36 @@ export interface DiffResult {
 
 export type HunkStatus = 'pending' | 'accepted' | 'rejected'
 
+// A tool call waiting for the user to allow or deny it
+export interface ApprovalRequest {
+  id: string
+  task_id: string
+  agent_id: string
+  agent_name: string
+  tool_name: string
+  input: unknown
+  summary: string // e.g. the Bash command or file path
+  suggested_rule: string // pattern stored when the decision is remembered
+  created_at: string
+}
+
+export type ReviewSeverity = 'info' | 'minor' | 'major' | 'critical'
+
+// A code reviewer's comment on a hunk, as the diff was when the review ran
+export interface ReviewComment {
+  id: string
+  task_id: string
+  attempt_id?: string
+  round: number
+  reviewer_agent_id?: string
+  file_path: string
+  hunk_index: number // -1 for the file as a whole
+  hunk_header?: string
+  severity: ReviewSeverity
+  body: string
+  created_at: string
+}
+
 export interface Config {
   claude_cli_path: string
   workspace_path: string
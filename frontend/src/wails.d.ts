@@ -1,4 +1,4 @@
-import type { Project, Agent, Team, Task, Session, DashboardStats, DashboardDetails, SessionStats, Config, DiffResult, PlanResult, PromptImproveResult, TaskStreamEvent, MCPServer, MCPCatalogResponse, MCPInstallConfig, MCPHealthResult, MCPJsonImportEntry, PaginatedResponse } from './types'
+import type { Project, Agent, Team, Task, Session, DashboardStats, DashboardDetails, SessionStats, Config, DiffResult, PlanResult, PromptImproveResult, TaskStreamEvent, MCPServer, MCPCatalogResponse, MCPInstallConfig, MCPHealthResult, MCPJsonImportEntry, PaginatedResponse, ReviewComment, ApprovalRequest } from './types'
 
 declare global {
   interface Window {
@@ -95,6 +95,13 @@ declare global {
           RejectFile(taskID: string, filePath: string, reason: string): Promise<void>
           SaveWorkspaceFile(taskID: string, filePath: string, content: string): Promise<void>
 
+          // Code Review
+          ListTaskReviewComments(taskID: string): Promise<ReviewComment[]>
+
+          // Tool Approvals
+          ListPendingApprovals(): Promise<ApprovalRequest[]>
+          RespondToApproval(requestID: string, allow: boolean, remember: boolean, message: string): Promise<void>
+
           // Follow-up & Chat
           SendFollowUp(taskID: string, message: string, mode: string): Promise<void>
           ReadProjectFile(taskID: string, filePath: string): Promise<string>
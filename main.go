@@ -2,9 +2,15 @@ package main
 
 import (
 	"embed"
+	"fmt"
+	"log"
 	"os"
 	"runtime"
 
+	"agent-workflow/backend/mcpserver"
+	"agent-workflow/backend/redact"
+	"agent-workflow/backend/sandbox"
+
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
 	"github.com/wailsapp/wails/v2/pkg/options/assetserver"
@@ -14,11 +20,42 @@ import (
 var assets embed.FS
 
 func main() {
+	// Built-in MCP server: Claude Code spawns the Shannon binary itself with
+	// this argument (see services.BuiltinMCP), so it must run before Wails starts.
+	if len(os.Args) > 1 && os.Args[1] == "mcp-server" {
+		if err := mcpserver.Run(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "mcp-server:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Sandbox helpers: the Shannon binary is re-run inside the agent sandbox as
+	// namespace init (sandbox-init) or network bridge (sandbox-bridge).
+	if len(os.Args) > 1 && (os.Args[1] == "sandbox-init" || os.Args[1] == "sandbox-bridge") {
+		os.Exit(sandbox.Main(os.Args[1:]))
+	}
+
+	// Maintenance commands that run without the UI
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check-db":
+			os.Exit(runCheckDB(os.Args[2:]))
+		case "backup":
+			os.Exit(runBackup(os.Args[2:]))
+		case "restore":
+			os.Exit(runRestore(os.Args[2:]))
+		}
+	}
+
 	// Wails v2 frameless mode requires X11; force XWayland on Linux
 	if runtime.GOOS == "linux" {
 		os.Setenv("GDK_BACKEND", "x11")
 	}
 
+	// Mask vault values and known token formats in everything the app logs
+	log.SetOutput(redact.Writer(os.Stderr))
+
 	app := NewApp()
 
 	err := wails.Run(&options.App{